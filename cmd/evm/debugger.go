@@ -0,0 +1,560 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/cmd/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/core/vm/runtime"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/params"
+	cli "gopkg.in/urfave/cli.v1"
+)
+
+var (
+	ChainDataFlag = cli.StringFlag{
+		Name:  "chaindata",
+		Usage: "Chain database to load the prestate from (node must not be running)",
+	}
+	BlockFlag = cli.Uint64Flag{
+		Name:  "block",
+		Usage: "Block whose post state to load from the chain database (default = head)",
+	}
+	BreakFlag = cli.StringSliceFlag{
+		Name:  "break",
+		Usage: "Initial breakpoint (pc:<n>[@<address>], op:<opcode> or depth:<n>)",
+	}
+)
+
+var debugCommand = cli.Command{
+	Action:    debugCmd,
+	Name:      "debug",
+	Usage:     "step through the execution of arbitrary evm binary",
+	ArgsUsage: "<code>",
+	Flags: []cli.Flag{
+		ChainDataFlag,
+		AncientFlag,
+		BlockFlag,
+		BreakFlag,
+	},
+	Description: `
+The debug command executes EVM code like the run command, pausing before the
+first opcode and on every breakpoint hit to accept commands from stdin. The
+prestate is loaded either from a genesis file (--prestate) or from the state
+of a block in a chain database (--chaindata, --block), in which case --receiver
+may refer to a deployed contract without any code being specified.
+
+Type 'help' at the prompt for the available commands.`,
+}
+
+// debugHelp is the usage of the debugger commands.
+const debugHelp = `Commands:
+  step, s [n]           execute the next n opcodes (default 1)
+  continue, c           run until the next breakpoint
+  break, b <spec>       add a breakpoint: pc:<n>[@<address>], op:<opcode> or depth:<n>
+  breakpoints, bl       list the breakpoints
+  delete, d <index>     remove a breakpoint
+  where, w              show the current position
+  stack                 show the stack, top first
+  memory [offset size]  dump the memory
+  storage <slot> [addr] show a storage slot of the current (or given) account
+  returndata            show the return data of the last call of the frame
+  quit, q               abort the execution
+An empty line repeats the last command. Numbers may be decimal or 0x-prefixed.`
+
+// breakpoint is a condition pausing the execution when met before an opcode.
+type breakpoint struct {
+	pc    *uint64         // Program counter to break at
+	code  *common.Address // Code address the program counter refers to (nil = any)
+	op    *vm.OpCode      // Opcode to break at
+	depth int             // Call depth to break at on frame entry (0 = unset)
+}
+
+// parseBreakpoint parses a breakpoint specification.
+func parseBreakpoint(spec string) (*breakpoint, error) {
+	parts := strings.SplitN(spec, ":", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid breakpoint %q", spec)
+	}
+	switch parts[0] {
+	case "pc":
+		pos := strings.SplitN(parts[1], "@", 2)
+		pc, err := strconv.ParseUint(pos[0], 0, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid program counter %q", pos[0])
+		}
+		bp := &breakpoint{pc: &pc}
+		if len(pos) == 2 {
+			if !common.IsHexAddress(pos[1]) {
+				return nil, fmt.Errorf("invalid address %q", pos[1])
+			}
+			addr := common.HexToAddress(pos[1])
+			bp.code = &addr
+		}
+		return bp, nil
+
+	case "op":
+		name := strings.ToUpper(parts[1])
+		op := vm.StringToOp(name)
+		if op.String() != name {
+			return nil, fmt.Errorf("unknown opcode %q", parts[1])
+		}
+		return &breakpoint{op: &op}, nil
+
+	case "depth":
+		depth, err := strconv.Atoi(parts[1])
+		if err != nil || depth < 1 {
+			return nil, fmt.Errorf("invalid call depth %q", parts[1])
+		}
+		return &breakpoint{depth: depth}, nil
+	}
+	return nil, fmt.Errorf("unknown breakpoint type %q", parts[0])
+}
+
+// String implements fmt.Stringer, returning the specification of the breakpoint.
+func (bp *breakpoint) String() string {
+	switch {
+	case bp.pc != nil && bp.code != nil:
+		return fmt.Sprintf("pc:%d@%s", *bp.pc, bp.code.Hex())
+	case bp.pc != nil:
+		return fmt.Sprintf("pc:%d", *bp.pc)
+	case bp.op != nil:
+		return fmt.Sprintf("op:%v", *bp.op)
+	default:
+		return fmt.Sprintf("depth:%d", bp.depth)
+	}
+}
+
+// matches reports whether the breakpoint is hit by the given opcode, entered is
+// set for the first opcode executed by a call frame.
+func (bp *breakpoint) matches(pc uint64, code common.Address, op vm.OpCode, depth int, entered bool) bool {
+	switch {
+	case bp.pc != nil:
+		return *bp.pc == pc && (bp.code == nil || *bp.code == code)
+	case bp.op != nil:
+		return *bp.op == op
+	default:
+		return bp.depth == depth && entered
+	}
+}
+
+// debugger is an interactive EVM tracer, pausing the execution to let the user
+// inspect it on the requested steps and breakpoints.
+type debugger struct {
+	in  *bufio.Scanner // Source of the user commands
+	out io.Writer      // Destination of the command outputs
+
+	breakpoints []*breakpoint
+	steps       int    // Number of opcodes to execute before pausing (0 = until a breakpoint)
+	detached    bool   // Whether the user input ended or the user quit
+	aborted     bool   // Whether the user quit, aborting the execution
+	last        string // Last command, repeated on empty input
+
+	frames  []vm.OpCode // Types of the call frames being executed
+	returns [][]byte    // Return data of the last call made by each frame
+	entered bool        // Whether a call frame was entered without executing opcodes yet
+}
+
+// newDebugger creates a debugger reading the user commands from in, pausing
+// before the first opcode.
+func newDebugger(in io.Reader, out io.Writer, breakpoints []*breakpoint) *debugger {
+	return &debugger{
+		in:          bufio.NewScanner(in),
+		out:         out,
+		breakpoints: breakpoints,
+		steps:       1,
+	}
+}
+
+// CaptureStart implements the Tracer interface to track the outermost frame.
+func (d *debugger) CaptureStart(from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) error {
+	typ := vm.CALL
+	if create {
+		typ = vm.CREATE
+	}
+	d.frames, d.returns, d.entered = []vm.OpCode{typ}, [][]byte{nil}, true
+	return nil
+}
+
+// CaptureEnter implements the Tracer interface to track the entered frames.
+func (d *debugger) CaptureEnter(typ vm.OpCode, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) error {
+	d.frames, d.returns, d.entered = append(d.frames, typ), append(d.returns, nil), true
+	return nil
+}
+
+// CaptureExit implements the Tracer interface, storing the output of the frame
+// as the return data of its caller.
+func (d *debugger) CaptureExit(output []byte, gasUsed uint64, err error) error {
+	typ := d.frames[len(d.frames)-1]
+	d.frames, d.returns = d.frames[:len(d.frames)-1], d.returns[:len(d.returns)-1]
+	d.entered = false
+
+	// Contract creations only return data if reverted, same as the interpreter
+	if (typ == vm.CREATE || typ == vm.CREATE2) && (err == nil || err.Error() != "execution reverted") {
+		output = nil
+	}
+	d.returns[len(d.returns)-1] = output
+	return nil
+}
+
+// CaptureState implements the Tracer interface, pausing the execution if a step
+// or a breakpoint requests it.
+func (d *debugger) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	if err != nil {
+		fmt.Fprintf(d.out, "fault at pc %d (%v): %v\n", pc, op, err)
+		return nil
+	}
+	entered := d.entered
+	d.entered = false
+
+	if d.detached {
+		return nil
+	}
+	code := contract.Address()
+	if contract.CodeAddr != nil {
+		code = *contract.CodeAddr
+	}
+	pause := false
+	if d.steps > 0 {
+		d.steps--
+		pause = d.steps == 0
+	}
+	for i, bp := range d.breakpoints {
+		if bp != nil && bp.matches(pc, code, op, depth, entered) {
+			fmt.Fprintf(d.out, "breakpoint #%d hit: %v\n", i, bp)
+			pause = true
+		}
+	}
+	if !pause {
+		return nil
+	}
+	d.steps = 0
+	d.where(pc, code, op, gas, cost, depth)
+
+	for {
+		fmt.Fprint(d.out, "> ")
+		if !d.in.Scan() {
+			// Run to completion if the user input is exhausted
+			fmt.Fprintln(d.out)
+			d.detached = true
+			return nil
+		}
+		line := strings.TrimSpace(d.in.Text())
+		if line == "" {
+			line = d.last
+		}
+		d.last = line
+
+		args := strings.Fields(line)
+		if len(args) == 0 {
+			continue
+		}
+		switch args[0] {
+		case "step", "s":
+			d.steps = 1
+			if len(args) > 1 {
+				n, err := strconv.Atoi(args[1])
+				if err != nil || n < 1 {
+					fmt.Fprintf(d.out, "invalid step count %q\n", args[1])
+					continue
+				}
+				d.steps = n
+			}
+			return nil
+
+		case "continue", "c":
+			return nil
+
+		case "quit", "q":
+			d.detached, d.aborted = true, true
+			env.Cancel()
+			return nil
+
+		case "break", "b":
+			if len(args) != 2 {
+				fmt.Fprintln(d.out, "usage: break <spec>")
+				continue
+			}
+			bp, err := parseBreakpoint(args[1])
+			if err != nil {
+				fmt.Fprintln(d.out, err)
+				continue
+			}
+			d.breakpoints = append(d.breakpoints, bp)
+			fmt.Fprintf(d.out, "breakpoint #%d: %v\n", len(d.breakpoints)-1, bp)
+
+		case "breakpoints", "bl":
+			for i, bp := range d.breakpoints {
+				if bp != nil {
+					fmt.Fprintf(d.out, "#%d: %v\n", i, bp)
+				}
+			}
+
+		case "delete", "d":
+			if len(args) != 2 {
+				fmt.Fprintln(d.out, "usage: delete <index>")
+				continue
+			}
+			// Deleted breakpoints are cleared in place to keep the indices stable
+			i, err := strconv.Atoi(args[1])
+			if err != nil || i < 0 || i >= len(d.breakpoints) || d.breakpoints[i] == nil {
+				fmt.Fprintf(d.out, "unknown breakpoint %q\n", args[1])
+				continue
+			}
+			d.breakpoints[i] = nil
+
+		case "where", "w":
+			d.where(pc, code, op, gas, cost, depth)
+
+		case "stack":
+			data := stack.Data()
+			for i := len(data) - 1; i >= 0; i-- {
+				fmt.Fprintf(d.out, "%4d: %#x\n", len(data)-1-i, data[i])
+			}
+
+		case "memory":
+			offset, size := uint64(0), uint64(memory.Len())
+			if len(args) == 3 {
+				var err1, err2 error
+				offset, err1 = strconv.ParseUint(args[1], 0, 64)
+				size, err2 = strconv.ParseUint(args[2], 0, 64)
+				if err1 != nil || err2 != nil {
+					fmt.Fprintln(d.out, "usage: memory [offset size]")
+					continue
+				}
+				if offset+size < offset {
+					fmt.Fprintln(d.out, "invalid memory range")
+					continue
+				}
+			}
+			// Don't dump past the end of the memory, it may be huge
+			if length := uint64(memory.Len()); offset >= length {
+				size = 0
+			} else if offset+size > length {
+				size = length - offset
+			}
+			d.dump(memory.Data(), offset, size)
+
+		case "storage":
+			if len(args) < 2 || len(args) > 3 {
+				fmt.Fprintln(d.out, "usage: storage <slot> [address]")
+				continue
+			}
+			slot, ok := new(big.Int).SetString(args[1], 0)
+			if !ok {
+				fmt.Fprintf(d.out, "invalid slot %q\n", args[1])
+				continue
+			}
+			owner := contract.Address()
+			if len(args) == 3 {
+				if !common.IsHexAddress(args[2]) {
+					fmt.Fprintf(d.out, "invalid address %q\n", args[2])
+					continue
+				}
+				owner = common.HexToAddress(args[2])
+			}
+			fmt.Fprintln(d.out, env.StateDB.GetState(owner, common.BigToHash(slot)).Hex())
+
+		case "returndata":
+			d.dump(d.returns[len(d.returns)-1], 0, uint64(len(d.returns[len(d.returns)-1])))
+
+		case "help", "h":
+			fmt.Fprintln(d.out, debugHelp)
+
+		default:
+			fmt.Fprintf(d.out, "unknown command %q, try 'help'\n", args[0])
+		}
+	}
+}
+
+// where prints the position of the execution.
+func (d *debugger) where(pc uint64, code common.Address, op vm.OpCode, gas, cost uint64, depth int) {
+	fmt.Fprintf(d.out, "[%d] %s pc %d: %v (gas %d, cost %d)\n", depth, code.Hex(), pc, op, gas, cost)
+}
+
+// dump prints the given range of data in 32 byte rows, zero padded.
+func (d *debugger) dump(data []byte, offset, size uint64) {
+	if size == 0 {
+		fmt.Fprintln(d.out, "empty")
+		return
+	}
+	for pos := offset; pos < offset+size; pos += 32 {
+		row := make([]byte, 32)
+		if pos < uint64(len(data)) {
+			copy(row, data[pos:])
+		}
+		if n := offset + size - pos; n < 32 {
+			row = row[:n]
+		}
+		fmt.Fprintf(d.out, "%#06x: %x\n", pos, row)
+	}
+}
+
+// CaptureFault implements the Tracer interface to report execution failures.
+func (d *debugger) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	fmt.Fprintf(d.out, "fault at pc %d (%v): %v\n", pc, op, err)
+	return nil
+}
+
+// CaptureEnd implements the Tracer interface.
+func (d *debugger) CaptureEnd(output []byte, gasUsed uint64, t time.Duration, err error) error {
+	return nil
+}
+
+// CaptureSelfDestruct implements the Tracer interface.
+func (d *debugger) CaptureSelfDestruct(from common.Address, to common.Address, value *big.Int) error {
+	return nil
+}
+
+// CaptureRefund implements the Tracer interface.
+func (d *debugger) CaptureRefund(old uint64, new uint64) error {
+	return nil
+}
+
+// loadChainState opens the post state of a block in the chain database at the
+// given path, along with the chain configuration and the block header. The
+// head block is used if number is nil.
+func loadChainState(path string, ancient string, number *uint64) (ethdb.Database, *state.StateDB, *params.ChainConfig, *types.Header, error) {
+	db, config, err := openChainDatabase(path, ancient)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	var header *types.Header
+	if number != nil {
+		header = rawdb.ReadHeader(db, rawdb.ReadCanonicalHash(db, *number), *number)
+	} else if hash := rawdb.ReadHeadBlockHash(db); hash != (common.Hash{}) {
+		if n := rawdb.ReadHeaderNumber(db, hash); n != nil {
+			header = rawdb.ReadHeader(db, hash, *n)
+		}
+	}
+	if header == nil {
+		db.Close()
+		return nil, nil, nil, nil, errors.New("block not found")
+	}
+	statedb, err := state.New(header.Root, state.NewDatabase(db))
+	if err != nil {
+		db.Close()
+		return nil, nil, nil, nil, fmt.Errorf("state of block #%d unavailable: %v", header.Number, err)
+	}
+	return db, statedb, config, header, nil
+}
+
+func debugCmd(ctx *cli.Context) error {
+	if ctx.GlobalString(CodeFileFlag.Name) == "-" {
+		return errors.New("code cannot be read from stdin while debugging")
+	}
+	var breakpoints []*breakpoint
+	for _, spec := range ctx.StringSlice(BreakFlag.Name) {
+		bp, err := parseBreakpoint(spec)
+		if err != nil {
+			return err
+		}
+		breakpoints = append(breakpoints, bp)
+	}
+	code, err := loadCode(ctx)
+	if err != nil {
+		return err
+	}
+	dbg := newDebugger(os.Stdin, os.Stdout, breakpoints)
+
+	cfg := runtime.Config{
+		Origin:    common.BytesToAddress([]byte("sender")),
+		GasLimit:  ctx.GlobalUint64(GasFlag.Name),
+		GasPrice:  utils.GlobalBig(ctx, PriceFlag.Name),
+		Value:     utils.GlobalBig(ctx, ValueFlag.Name),
+		EVMConfig: vm.Config{Debug: true, Tracer: dbg},
+	}
+	// Load the prestate from the chain database or the genesis file, if requested
+	switch {
+	case ctx.String(ChainDataFlag.Name) != "":
+		var number *uint64
+		if ctx.IsSet(BlockFlag.Name) {
+			n := ctx.Uint64(BlockFlag.Name)
+			number = &n
+		}
+		db, statedb, config, header, err := loadChainState(ctx.String(ChainDataFlag.Name), ctx.String(AncientFlag.Name), number)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		cfg.State, cfg.ChainConfig = statedb, config
+		cfg.BlockNumber, cfg.Time, cfg.Difficulty, cfg.Coinbase = header.Number, new(big.Int).SetUint64(header.Time), header.Difficulty, header.Coinbase
+		cfg.GetHashFn = func(n uint64) common.Hash { return rawdb.ReadCanonicalHash(db, n) }
+
+	case ctx.GlobalString(GenesisFlag.Name) != "":
+		gen := readGenesis(ctx.GlobalString(GenesisFlag.Name))
+		db := rawdb.NewMemoryDatabase()
+		cfg.State, _ = state.New(gen.ToBlock(db).Root(), state.NewDatabase(db))
+		cfg.ChainConfig = gen.Config
+		cfg.BlockNumber, cfg.Time, cfg.Difficulty, cfg.Coinbase = new(big.Int).SetUint64(gen.Number), new(big.Int).SetUint64(gen.Timestamp), gen.Difficulty, gen.Coinbase
+		if gen.GasLimit != 0 {
+			cfg.GasLimit = gen.GasLimit
+		}
+	default:
+		cfg.State, _ = state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()))
+	}
+	if ctx.GlobalString(SenderFlag.Name) != "" {
+		cfg.Origin = common.HexToAddress(ctx.GlobalString(SenderFlag.Name))
+	}
+	if !cfg.State.Exist(cfg.Origin) {
+		cfg.State.CreateAccount(cfg.Origin)
+	}
+	receiver := common.BytesToAddress([]byte("receiver"))
+	if ctx.GlobalString(ReceiverFlag.Name) != "" {
+		receiver = common.HexToAddress(ctx.GlobalString(ReceiverFlag.Name))
+	}
+	var (
+		ret      []byte
+		leftover uint64
+		input    = common.Hex2Bytes(ctx.GlobalString(InputFlag.Name))
+	)
+	if ctx.GlobalBool(CreateFlag.Name) {
+		ret, _, leftover, err = runtime.Create(append(code, input...), &cfg)
+	} else {
+		if len(code) > 0 {
+			cfg.State.SetCode(receiver, code)
+		}
+		if len(cfg.State.GetCode(receiver)) == 0 {
+			return fmt.Errorf("no code to execute at %s", receiver.Hex())
+		}
+		ret, leftover, err = runtime.Call(receiver, input, &cfg)
+	}
+	if dbg.aborted {
+		fmt.Println("execution aborted")
+		return nil
+	}
+	fmt.Printf("output: 0x%x\ngas used: %d\n", ret, cfg.GasLimit-leftover)
+	if err != nil {
+		fmt.Printf("error: %v\n", err)
+	}
+	return nil
+}
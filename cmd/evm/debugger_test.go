@@ -0,0 +1,135 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/core/vm/runtime"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// Tests that the debugger pauses on the requested breakpoints and shows the
+// state of the execution at them.
+func TestDebugger(t *testing.T) {
+	var (
+		caller = common.HexToAddress("0xaa")
+		callee = common.HexToAddress("0xbb")
+	)
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()))
+	// PUSH1 0 PUSH1 0 PUSH1 0 PUSH1 0 PUSH1 0 PUSH1 0xbb GAS CALL RETURNDATASIZE STOP
+	statedb.SetCode(caller, common.FromHex("0x6000600060006000600060bb5af13d00"))
+	// PUSH1 42 PUSH1 0 MSTORE PUSH1 32 PUSH1 0 RETURN
+	statedb.SetCode(callee, common.FromHex("0x602a60005260206000f3"))
+
+	script := strings.Join([]string{
+		"break depth:2",
+		"continue",
+		"break op:RETURNDATASIZE",
+		"continue",
+		"returndata",
+		"stack",
+		"breakpoints",
+		"quit",
+	}, "\n")
+	out := new(bytes.Buffer)
+	dbg := newDebugger(strings.NewReader(script), out, nil)
+
+	_, _, err := runtime.Call(caller, nil, &runtime.Config{ChainConfig: params.AllEthashProtocolChanges, State: statedb, EVMConfig: vm.Config{Debug: true, Tracer: dbg}})
+	if err != nil {
+		t.Fatalf("call failed: %v", err)
+	}
+	for _, want := range []string{
+		"[1] " + caller.Hex() + " pc 0: PUSH1",
+		"breakpoint #0 hit: depth:2\n[2] " + callee.Hex() + " pc 0: PUSH1",
+		"breakpoint #1 hit: op:RETURNDATASIZE\n[1] " + caller.Hex() + " pc 14: RETURNDATASIZE",
+		"0x000000: 000000000000000000000000000000000000000000000000000000000000002a\n",
+		"   0: 0x1\n> ",
+		"#0: depth:2\n#1: op:RETURNDATASIZE\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output missing %q:\n%s", want, out)
+		}
+	}
+	if !dbg.aborted {
+		t.Errorf("execution not aborted")
+	}
+}
+
+// Tests that memory dumps are clamped to the memory size and that overflowing
+// ranges are rejected.
+func TestDebuggerMemory(t *testing.T) {
+	contract := common.HexToAddress("0xaa")
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()))
+	// PUSH1 42 PUSH1 0 MSTORE STOP
+	statedb.SetCode(contract, common.FromHex("0x602a60005200"))
+
+	script := strings.Join([]string{
+		"break op:STOP",
+		"continue",
+		"memory 0 0xffffffffffffffff",
+		"memory 1 0xffffffffffffffff",
+		"memory 16 32",
+		"memory 64 32",
+		"quit",
+	}, "\n")
+	out := new(bytes.Buffer)
+	dbg := newDebugger(strings.NewReader(script), out, nil)
+
+	_, _, err := runtime.Call(contract, nil, &runtime.Config{ChainConfig: params.AllEthashProtocolChanges, State: statedb, EVMConfig: vm.Config{Debug: true, Tracer: dbg}})
+	if err != nil {
+		t.Fatalf("call failed: %v", err)
+	}
+	for _, want := range []string{
+		"> 0x000000: 000000000000000000000000000000000000000000000000000000000000002a\n> ",
+		"> invalid memory range\n> ",
+		"> 0x000010: 0000000000000000000000000000002a\n> ",
+		"> empty\n> ",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output missing %q:\n%s", want, out)
+		}
+	}
+}
+
+// Tests that breakpoint specifications are parsed and rejected as expected.
+func TestParseBreakpoint(t *testing.T) {
+	for _, spec := range []string{"pc:10", "pc:0x0a@0x00000000000000000000000000000000000000bb", "op:SSTORE", "depth:3"} {
+		bp, err := parseBreakpoint(spec)
+		if err != nil {
+			t.Errorf("%s: failed to parse: %v", spec, err)
+			continue
+		}
+		if spec == "pc:0x0a@0x00000000000000000000000000000000000000bb" {
+			spec = "pc:10@0x00000000000000000000000000000000000000bb"
+		}
+		if bp.String() != spec {
+			t.Errorf("%s: specification mismatch: have %s", spec, bp)
+		}
+	}
+	for _, spec := range []string{"pc", "pc:x", "pc:1@0x12", "op:PUSH99", "depth:0", "gas:1"} {
+		if _, err := parseBreakpoint(spec); err == nil {
+			t.Errorf("%s: expected failure", spec)
+		}
+	}
+}
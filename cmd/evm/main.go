@@ -142,7 +142,9 @@ func init() {
 	}
 	app.Commands = []cli.Command{
 		compileCommand,
+		debugCommand,
 		disasmCommand,
+		replayCommand,
 		runCommand,
 		stateTestCommand,
 	}
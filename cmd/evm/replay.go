@@ -0,0 +1,385 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/ethereum/go-ethereum/cmd/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/consensus/clique"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/consensus/misc"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/eth/tracers"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/internal/ethapi"
+	"github.com/ethereum/go-ethereum/params"
+	cli "gopkg.in/urfave/cli.v1"
+)
+
+var (
+	DataDirFlag = cli.StringFlag{
+		Name:  "datadir",
+		Usage: "Data directory of the node, or its chain database directly",
+	}
+	AncientFlag = cli.StringFlag{
+		Name:  "datadir.ancient",
+		Usage: "Ancient chain segment directory (default = inside chaindata)",
+	}
+	TxFlag = cli.StringFlag{
+		Name:  "tx",
+		Usage: "Hash of the transaction to replay",
+	}
+	TracerFlag = cli.StringFlag{
+		Name:  "tracer",
+		Usage: "Name or JavaScript code of the tracer to use (default = opcode logger)",
+	}
+	ReexecFlag = cli.Uint64Flag{
+		Name:  "reexec",
+		Usage: "Number of blocks to re-execute to regenerate a missing starting state",
+		Value: 128,
+	}
+)
+
+var replayCommand = cli.Command{
+	Action:    replayCmd,
+	Name:      "replay",
+	Usage:     "replay a transaction stored in a chain database",
+	ArgsUsage: "",
+	Flags: []cli.Flag{
+		DataDirFlag,
+		AncientFlag,
+		TxFlag,
+		TracerFlag,
+		ReexecFlag,
+		utils.TraceStorageFlag,
+		utils.TraceLogsFlag,
+	},
+	Description: `
+    evm replay --datadir <dir> --tx <hash>
+
+re-executes a transaction of the local chain on top of the state it originally
+ran on, without starting a node. The chain database is opened read only along
+with its ancient chain segment (--datadir.ancient, default inside chaindata),
+so the node owning it must not be running. If the state of the parent block is
+missing, it's regenerated by re-executing up to --reexec blocks.
+
+The transaction is traced with the given JavaScript or native tracer (e.g. the
+vandalTracer returning the recorded opcode trace), or with the opcode logger
+producing the same output as debug_traceTransaction. The --json flag streams
+the opcode logs instead.`,
+}
+
+// openChainDatabase opens the chain database at the given path read only and
+// retrieves the chain configuration stored in it. If the path is the data
+// directory of a node, its chain database is opened. The ancient chain segment
+// is opened along with it from the given directory, or if empty, from within
+// the chain database if it was ever frozen.
+func openChainDatabase(path string, ancient string) (ethdb.Database, *params.ChainConfig, error) {
+	if chaindata := filepath.Join(path, "geth", "chaindata"); common.FileExist(chaindata) {
+		path = chaindata
+	}
+	if ancient == "" {
+		if dir := filepath.Join(path, "ancient"); common.FileExist(dir) {
+			ancient = dir
+		}
+	}
+	var (
+		db  ethdb.Database
+		err error
+	)
+	if ancient != "" {
+		db, err = rawdb.NewPersistentDatabaseWithFreezerReadOnly("", path, 16, 16, ancient, "")
+	} else {
+		db, err = rawdb.NewPersistentDatabaseReadOnly("", path, 16, 16, "")
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	config := rawdb.ReadChainConfig(db, rawdb.ReadCanonicalHash(db, 0))
+	if config == nil {
+		db.Close()
+		return nil, nil, errors.New("chain configuration not found")
+	}
+	return db, config, nil
+}
+
+// chainReader is a read only view of the chain stored in a database, providing
+// what's needed to process its blocks without a core.BlockChain.
+type chainReader struct {
+	db     ethdb.Database
+	config *params.ChainConfig
+	engine consensus.Engine
+}
+
+// newChainReader creates a view of the chain stored in the database. Only the
+// block rewards and authors of the consensus engine are needed, so seals are
+// not verified.
+func newChainReader(db ethdb.Database, config *params.ChainConfig) *chainReader {
+	var engine consensus.Engine
+	if config.Clique != nil {
+		engine = clique.New(config.Clique, db)
+	} else {
+		engine = ethash.NewFaker()
+	}
+	return &chainReader{db: db, config: config, engine: engine}
+}
+
+// Config implements consensus.ChainReader.
+func (c *chainReader) Config() *params.ChainConfig { return c.config }
+
+// Engine implements core.ChainContext.
+func (c *chainReader) Engine() consensus.Engine { return c.engine }
+
+// CurrentHeader implements consensus.ChainReader.
+func (c *chainReader) CurrentHeader() *types.Header {
+	return c.GetHeaderByHash(rawdb.ReadHeadHeaderHash(c.db))
+}
+
+// GetHeader implements consensus.ChainReader.
+func (c *chainReader) GetHeader(hash common.Hash, number uint64) *types.Header {
+	return rawdb.ReadHeader(c.db, hash, number)
+}
+
+// GetHeaderByNumber implements consensus.ChainReader.
+func (c *chainReader) GetHeaderByNumber(number uint64) *types.Header {
+	return rawdb.ReadHeader(c.db, rawdb.ReadCanonicalHash(c.db, number), number)
+}
+
+// GetHeaderByHash implements consensus.ChainReader.
+func (c *chainReader) GetHeaderByHash(hash common.Hash) *types.Header {
+	number := rawdb.ReadHeaderNumber(c.db, hash)
+	if number == nil {
+		return nil
+	}
+	return rawdb.ReadHeader(c.db, hash, *number)
+}
+
+// GetBlock implements consensus.ChainReader.
+func (c *chainReader) GetBlock(hash common.Hash, number uint64) *types.Block {
+	return rawdb.ReadBlock(c.db, hash, number)
+}
+
+// stateAt opens the post state of the given block, regenerating it from the
+// closest ancestor within reexec blocks that has its state available.
+func (c *chainReader) stateAt(block *types.Block, reexec uint64) (*state.StateDB, error) {
+	database := state.NewDatabaseWithCache(c.db, 16)
+
+	// Find the most recent block that has the state available
+	var (
+		origin  = block
+		statedb *state.StateDB
+		err     error
+	)
+	for i := uint64(0); ; i++ {
+		if statedb, err = state.New(origin.Root(), database); err == nil {
+			break
+		}
+		if i == reexec || origin.NumberU64() == 0 {
+			return nil, fmt.Errorf("state of block #%d unavailable within %d blocks", block.NumberU64(), reexec)
+		}
+		if origin = c.GetBlock(origin.ParentHash(), origin.NumberU64()-1); origin == nil {
+			return nil, fmt.Errorf("ancestor of block #%d missing", block.NumberU64())
+		}
+	}
+	// Re-execute the blocks on top of it up until the requested one
+	for number := origin.NumberU64() + 1; number <= block.NumberU64(); number++ {
+		next := c.GetBlock(rawdb.ReadCanonicalHash(c.db, number), number)
+		if number == block.NumberU64() {
+			next = block
+		}
+		if next == nil {
+			return nil, fmt.Errorf("block #%d not found", number)
+		}
+		if err := c.process(next, statedb); err != nil {
+			return nil, fmt.Errorf("processing block #%d failed: %v", number, err)
+		}
+		root, err := statedb.Commit(c.config.IsEIP158(next.Number()))
+		if err != nil {
+			return nil, err
+		}
+		if root != next.Root() {
+			return nil, fmt.Errorf("state root mismatch at block #%d: have %x, want %x", number, root, next.Root())
+		}
+		if statedb, err = state.New(root, database); err != nil {
+			return nil, err
+		}
+	}
+	return statedb, nil
+}
+
+// process applies the transactions and the rewards of a block to the state, the
+// same way core.StateProcessor does.
+func (c *chainReader) process(block *types.Block, statedb *state.StateDB) error {
+	var (
+		receipts types.Receipts
+		usedGas  = new(uint64)
+		header   = block.Header()
+		gp       = new(core.GasPool).AddGas(block.GasLimit())
+	)
+	// Mutate the block and state according to any hard-fork specs
+	if c.config.DAOForkSupport && c.config.DAOForkBlock != nil && c.config.DAOForkBlock.Cmp(block.Number()) == 0 {
+		misc.ApplyDAOHardFork(statedb)
+	}
+	for i, tx := range block.Transactions() {
+		statedb.Prepare(tx.Hash(), block.Hash(), i)
+		receipt, _, err := core.ApplyTransaction(c.config, c, nil, gp, statedb, header, tx, usedGas, vm.Config{})
+		if err != nil {
+			return err
+		}
+		receipts = append(receipts, receipt)
+	}
+	_, err := c.engine.Finalize(c, header, statedb, block.Transactions(), block.Uncles(), receipts)
+	return err
+}
+
+// replay re-executes the transaction with the given hash on top of the state it
+// originally ran on, with the given VM configuration. The tracer is connected to
+// the state and the trace recorder if it needs them.
+func replay(chain *chainReader, hash common.Hash, reexec uint64, vmconf vm.Config) ([]byte, uint64, bool, error) {
+	// Find the transaction and rebuild the state it was executed on
+	tx, blockHash, number, index := rawdb.ReadTransaction(chain.db, hash)
+	if tx == nil {
+		return nil, 0, false, fmt.Errorf("transaction %s not found", hash.Hex())
+	}
+	block := chain.GetBlock(blockHash, number)
+	if block == nil {
+		return nil, 0, false, fmt.Errorf("block %s not found", blockHash.Hex())
+	}
+	parent := chain.GetBlock(block.ParentHash(), number-1)
+	if parent == nil {
+		return nil, 0, false, fmt.Errorf("parent %s not found", block.ParentHash().Hex())
+	}
+	statedb, err := chain.stateAt(parent, reexec)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	config := chain.config
+	if config.DAOForkSupport && config.DAOForkBlock != nil && config.DAOForkBlock.Cmp(block.Number()) == 0 {
+		misc.ApplyDAOHardFork(statedb)
+	}
+	signer := types.MakeSigner(config, block.Number())
+
+	for i, prev := range block.Transactions()[:index] {
+		msg, _ := prev.AsMessage(signer)
+		vmctx := core.NewEVMContext(msg, block.Header(), chain, nil)
+
+		statedb.Prepare(prev.Hash(), blockHash, i)
+		if _, _, _, err := core.ApplyMessage(vm.NewEVM(vmctx, statedb, config, vm.Config{}), msg, new(core.GasPool).AddGas(prev.Gas())); err != nil {
+			return nil, 0, false, fmt.Errorf("transaction %s failed: %v", prev.Hash().Hex(), err)
+		}
+		statedb.Finalise(config.IsEIP158(block.Number()))
+	}
+	// Execute the transaction itself with the requested configuration
+	if tracer, ok := vmconf.Tracer.(tracers.StateTracer); ok {
+		tracer.SetStateDB(statedb)
+	}
+	msg, _ := tx.AsMessage(signer)
+	vmenv := vm.NewEVM(core.NewEVMContext(msg, block.Header(), chain, nil), statedb, config, vmconf)
+	if recording, ok := vmconf.Tracer.(tracers.RecordingTracer); ok {
+		vmenv.Recorder = recording.Recorder()
+	}
+	statedb.Prepare(tx.Hash(), blockHash, int(index))
+
+	ret, gas, failed, err := core.ApplyMessage(vmenv, msg, new(core.GasPool).AddGas(tx.Gas()))
+	if err != nil {
+		return nil, 0, false, fmt.Errorf("replay failed: %v", err)
+	}
+	return ret, gas, failed, nil
+}
+
+func replayCmd(ctx *cli.Context) error {
+	if !ctx.IsSet(DataDirFlag.Name) {
+		return errors.New("chain database required (--datadir)")
+	}
+	blob, err := hexutil.Decode(ctx.String(TxFlag.Name))
+	if err != nil || len(blob) != common.HashLength {
+		return fmt.Errorf("invalid transaction hash %q", ctx.String(TxFlag.Name))
+	}
+	db, config, err := openChainDatabase(ctx.String(DataDirFlag.Name), ctx.String(AncientFlag.Name))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// Trace the transaction with the requested tracer
+	logconfig := &vm.LogConfig{
+		DisableMemory: ctx.GlobalBool(DisableMemoryFlag.Name),
+		DisableStack:  ctx.GlobalBool(DisableStackFlag.Name),
+	}
+	var tracer vm.Tracer
+	switch {
+	case ctx.IsSet(TracerFlag.Name):
+		if native, ok := tracers.NewNative(ctx.String(TracerFlag.Name)); ok {
+			tracer = native
+		} else if tracer, err = tracers.New(ctx.String(TracerFlag.Name)); err != nil {
+			return err
+		}
+	case ctx.GlobalBool(MachineFlag.Name):
+		tracer = vm.NewJSONLogger(logconfig, os.Stdout)
+	default:
+		tracer = vm.NewStructLogger(logconfig)
+	}
+	vmconf := vm.Config{
+		Debug:        true,
+		Tracer:       tracer,
+		TraceStorage: ctx.Bool(utils.TraceStorageFlag.Name),
+		TraceLogs:    ctx.Bool(utils.TraceLogsFlag.Name),
+	}
+	ret, gas, failed, err := replay(newChainReader(db, config), common.BytesToHash(blob), ctx.Uint64(ReexecFlag.Name), vmconf)
+	if err != nil {
+		return err
+	}
+	var result interface{}
+	switch tracer := tracer.(type) {
+	case *vm.JSONLogger:
+		return nil
+
+	case *vm.StructLogger:
+		result = &ethapi.ExecutionResult{
+			Gas:         gas,
+			Failed:      failed,
+			ReturnValue: fmt.Sprintf("%x", ret),
+			StructLogs:  ethapi.FormatLogs(tracer.StructLogs()),
+		}
+	case *tracers.Tracer:
+		if result, err = tracer.GetResult(); err != nil {
+			return err
+		}
+	case tracers.NativeTracer:
+		if result, err = tracer.GetResult(); err != nil {
+			return err
+		}
+	}
+	out, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(out))
+	return nil
+}
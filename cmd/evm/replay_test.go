@@ -0,0 +1,196 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/eth/tracers"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// Tests that transactions can be replayed from a chain database, regenerating
+// the missing states and executing the preceding transactions of the block.
+func TestReplay(t *testing.T) {
+	var (
+		key, _  = crypto.GenerateKey()
+		sender  = crypto.PubkeyToAddress(key.PublicKey)
+		counter = common.HexToAddress("0xc0de")
+		db      = rawdb.NewMemoryDatabase()
+		gspec   = &core.Genesis{
+			Config: params.TestChainConfig,
+			Alloc: core.GenesisAlloc{
+				sender: {Balance: big.NewInt(1000000000000000)},
+				// PUSH1 0 SLOAD PUSH1 1 ADD DUP1 PUSH1 0 SSTORE PUSH1 0 MSTORE PUSH1 32 PUSH1 0 RETURN
+				counter: {Balance: big.NewInt(0), Code: common.FromHex("0x6000546001018060005560005260206000f3")},
+			},
+		}
+		gendb   = rawdb.NewMemoryDatabase()
+		genesis = gspec.MustCommit(gendb)
+		signer  = types.NewEIP155Signer(gspec.Config.ChainID)
+	)
+	// Every transaction increments the counter, the third block holding two. The
+	// chain is generated into a separate database, which gets all states written
+	blocks, _ := core.GenerateChain(gspec.Config, genesis, ethash.NewFaker(), gendb, 3, func(i int, block *core.BlockGen) {
+		for j := 0; j <= i/2; j++ {
+			tx, _ := types.SignTx(types.NewTransaction(block.TxNonce(sender), counter, big.NewInt(0), 100000, big.NewInt(1), nil), signer, key)
+			block.AddTx(tx)
+		}
+	})
+	// Import the chain without flushing the intermediate states to the database
+	gspec.MustCommit(db)
+	chain, _ := core.NewBlockChain(db, nil, gspec.Config, ethash.NewFaker(), vm.Config{}, nil)
+	if _, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	defer chain.Stop()
+
+	reader := newChainReader(db, gspec.Config)
+	target := blocks[2].Transactions()[1].Hash()
+
+	if _, _, _, err := replay(reader, target, 1, vm.Config{}); err == nil {
+		t.Errorf("expected failure with the state out of reach")
+	}
+	logger := vm.NewStructLogger(nil)
+	ret, _, failed, err := replay(reader, target, 2, vm.Config{Debug: true, Tracer: logger})
+	if err != nil {
+		t.Fatalf("failed to replay transaction: %v", err)
+	}
+	if failed || new(big.Int).SetBytes(ret).Uint64() != 4 {
+		t.Errorf("result mismatch: have %x (failed %v), want 4", ret, failed)
+	}
+	if len(logger.StructLogs()) == 0 {
+		t.Errorf("no opcodes logged")
+	}
+	// The opcode trace is recorded if a recording tracer is used
+	tracer, _ := tracers.NewNative("vandalTracer")
+	if _, _, _, err := replay(reader, target, 2, vm.Config{Debug: true, Tracer: tracer, TraceStorage: true}); err != nil {
+		t.Fatalf("failed to replay transaction: %v", err)
+	}
+	res, err := tracer.GetResult()
+	if err != nil {
+		t.Fatalf("failed to retrieve trace: %v", err)
+	}
+	var result struct {
+		Trace []struct {
+			Op      string
+			Storage *struct{ New common.Hash }
+		}
+	}
+	if err := json.Unmarshal(res, &result); err != nil {
+		t.Fatalf("failed to decode trace: %v", err)
+	}
+	if len(result.Trace) != len(logger.StructLogs()) {
+		t.Errorf("trace length mismatch: have %d, want %d", len(result.Trace), len(logger.StructLogs()))
+	}
+	for _, step := range result.Trace {
+		if step.Op == "SSTORE" && (step.Storage == nil || step.Storage.New != common.BigToHash(big.NewInt(4))) {
+			t.Errorf("storage access mismatch: have %+v", step.Storage)
+		}
+	}
+}
+
+// Tests that transactions can be replayed from a chain database whose older
+// blocks were moved into the ancient store.
+func TestReplayAncient(t *testing.T) {
+	var (
+		key, _  = crypto.GenerateKey()
+		sender  = crypto.PubkeyToAddress(key.PublicKey)
+		counter = common.HexToAddress("0xc0de")
+		gspec   = &core.Genesis{
+			Config: params.TestChainConfig,
+			Alloc: core.GenesisAlloc{
+				sender: {Balance: big.NewInt(1000000000000000)},
+				// PUSH1 0 SLOAD PUSH1 1 ADD DUP1 PUSH1 0 SSTORE PUSH1 0 MSTORE PUSH1 32 PUSH1 0 RETURN
+				counter: {Balance: big.NewInt(0), Code: common.FromHex("0x6000546001018060005560005260206000f3")},
+			},
+		}
+		gendb   = rawdb.NewMemoryDatabase()
+		genesis = gspec.MustCommit(gendb)
+		signer  = types.NewEIP155Signer(gspec.Config.ChainID)
+	)
+	blocks, _ := core.GenerateChain(gspec.Config, genesis, ethash.NewFaker(), gendb, 3, func(i int, block *core.BlockGen) {
+		tx, _ := types.SignTx(types.NewTransaction(block.TxNonce(sender), counter, big.NewInt(0), 100000, big.NewInt(1), nil), signer, key)
+		block.AddTx(tx)
+	})
+	// Import the chain into a persistent database and freeze the first two blocks
+	// the same way the blockchain does, wiping them from the key-value store
+	dir, err := ioutil.TempDir("", "evm-replay-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := rawdb.NewLevelDBDatabaseWithFreezer(dir, 16, 16, filepath.Join(dir, "ancient"), "")
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	gspec.MustCommit(db)
+	chain, _ := core.NewBlockChain(db, nil, gspec.Config, ethash.NewFaker(), vm.Config{}, nil)
+	if _, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	chain.Stop()
+
+	for number := uint64(0); number < 2; number++ {
+		hash := rawdb.ReadCanonicalHash(db, number)
+		if err := db.AppendAncient(number, hash.Bytes(), rawdb.ReadHeaderRLP(db, hash, number), rawdb.ReadBodyRLP(db, hash, number), rawdb.ReadReceiptsRLP(db, hash, number), rawdb.ReadTdRLP(db, hash, number)); err != nil {
+			t.Fatalf("failed to freeze block %d: %v", number, err)
+		}
+		if number > 0 {
+			rawdb.DeleteCanonicalHash(db, number)
+			rawdb.DeleteBlockWithoutNumber(db, hash, number)
+		}
+	}
+	db.Close()
+
+	// Replay the transaction of the frozen block and the one regenerating its
+	// state from the frozen blocks
+	db, config, err := openChainDatabase(dir, "")
+	if err != nil {
+		t.Fatalf("failed to open chain database: %v", err)
+	}
+	defer db.Close()
+
+	if frozen, _ := db.Ancients(); frozen != 2 {
+		t.Fatalf("ancient item count mismatch: have %d, want 2", frozen)
+	}
+	reader := newChainReader(db, config)
+	for i, want := range []uint64{1, 3} {
+		target := blocks[2*i].Transactions()[0].Hash()
+
+		ret, _, failed, err := replay(reader, target, 3, vm.Config{})
+		if err != nil {
+			t.Fatalf("tx %d: failed to replay transaction: %v", i, err)
+		}
+		if failed || new(big.Int).SetBytes(ret).Uint64() != want {
+			t.Errorf("tx %d: result mismatch: have %x (failed %v), want %d", i, ret, failed, want)
+		}
+	}
+}
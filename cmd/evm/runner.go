@@ -69,6 +69,46 @@ func readGenesis(genesisPath string) *core.Genesis {
 	return genesis
 }
 
+// loadCode retrieves the EVM code to execute from the command line, either as hex
+// from the '--code' or '--codefile' flags, or by compiling the EASM file given
+// as argument. Nil is returned if no code was specified.
+func loadCode(ctx *cli.Context) ([]byte, error) {
+	// The '--code' or '--codefile' flag overrides code in state
+	if ctx.GlobalString(CodeFileFlag.Name) != "" {
+		var hexcode []byte
+		var err error
+		// If - is specified, it means that code comes from stdin
+		if ctx.GlobalString(CodeFileFlag.Name) == "-" {
+			//Try reading from stdin
+			if hexcode, err = ioutil.ReadAll(os.Stdin); err != nil {
+				return nil, fmt.Errorf("could not load code from stdin: %v", err)
+			}
+		} else {
+			// Codefile with hex assembly
+			if hexcode, err = ioutil.ReadFile(ctx.GlobalString(CodeFileFlag.Name)); err != nil {
+				return nil, fmt.Errorf("could not load code from file: %v", err)
+			}
+		}
+		return common.Hex2Bytes(string(bytes.TrimRight(hexcode, "\n"))), nil
+
+	} else if ctx.GlobalString(CodeFlag.Name) != "" {
+		return common.Hex2Bytes(ctx.GlobalString(CodeFlag.Name)), nil
+
+	} else if fn := ctx.Args().First(); len(fn) > 0 {
+		// EASM-file to compile
+		src, err := ioutil.ReadFile(fn)
+		if err != nil {
+			return nil, err
+		}
+		bin, err := compiler.Compile(fn, src, false)
+		if err != nil {
+			return nil, err
+		}
+		return common.Hex2Bytes(bin), nil
+	}
+	return nil, nil
+}
+
 func runCmd(ctx *cli.Context) error {
 	glogger := log.NewGlogHandler(log.StreamHandler(os.Stderr, log.TerminalFormat(false)))
 	glogger.Verbosity(log.Lvl(ctx.GlobalInt(VerbosityFlag.Name)))
@@ -117,43 +157,12 @@ func runCmd(ctx *cli.Context) error {
 	}
 
 	var (
-		code []byte
-		ret  []byte
-		err  error
+		ret []byte
+		err error
 	)
-	// The '--code' or '--codefile' flag overrides code in state
-	if ctx.GlobalString(CodeFileFlag.Name) != "" {
-		var hexcode []byte
-		var err error
-		// If - is specified, it means that code comes from stdin
-		if ctx.GlobalString(CodeFileFlag.Name) == "-" {
-			//Try reading from stdin
-			if hexcode, err = ioutil.ReadAll(os.Stdin); err != nil {
-				fmt.Printf("Could not load code from stdin: %v\n", err)
-				os.Exit(1)
-			}
-		} else {
-			// Codefile with hex assembly
-			if hexcode, err = ioutil.ReadFile(ctx.GlobalString(CodeFileFlag.Name)); err != nil {
-				fmt.Printf("Could not load code from file: %v\n", err)
-				os.Exit(1)
-			}
-		}
-		code = common.Hex2Bytes(string(bytes.TrimRight(hexcode, "\n")))
-
-	} else if ctx.GlobalString(CodeFlag.Name) != "" {
-		code = common.Hex2Bytes(ctx.GlobalString(CodeFlag.Name))
-	} else if fn := ctx.Args().First(); len(fn) > 0 {
-		// EASM-file to compile
-		src, err := ioutil.ReadFile(fn)
-		if err != nil {
-			return err
-		}
-		bin, err := compiler.Compile(fn, src, false)
-		if err != nil {
-			return err
-		}
-		code = common.Hex2Bytes(bin)
+	code, err := loadCode(ctx)
+	if err != nil {
+		return err
 	}
 
 	initialGas := ctx.GlobalUint64(GasFlag.Name)
@@ -209,7 +218,7 @@ func runCmd(ctx *cli.Context) error {
 	if ctx.GlobalBool(DumpFlag.Name) {
 		statedb.Commit(true)
 		statedb.IntermediateRoot(true)
-		fmt.Println(string(statedb.Dump()))
+		fmt.Println(string(statedb.Dump(nil)))
 	}
 
 	if memProfilePath := ctx.GlobalString(MemProfileFlag.Name); memProfilePath != "" {
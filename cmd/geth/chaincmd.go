@@ -20,6 +20,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
 	"runtime"
 	"strconv"
 	"sync/atomic"
@@ -27,18 +28,42 @@ import (
 
 	"github.com/ethereum/go-ethereum/cmd/utils"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/console"
 	"github.com/ethereum/go-ethereum/core"
 	"github.com/ethereum/go-ethereum/core/rawdb"
 	"github.com/ethereum/go-ethereum/core/state"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/eth/downloader"
+	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/event"
 	"github.com/ethereum/go-ethereum/log"
 	"gopkg.in/urfave/cli.v1"
 )
 
 var (
+	dumpNoCodeFlag = cli.BoolFlag{
+		Name:  "nocode",
+		Usage: "Exclude contract code from the dump",
+	}
+	dumpNoStorageFlag = cli.BoolFlag{
+		Name:  "nostorage",
+		Usage: "Exclude contract storage from the dump",
+	}
+	dumpIncompletesFlag = cli.BoolFlag{
+		Name:  "incompletes",
+		Usage: "Include accounts for which the address preimage is unknown",
+	}
+	dumpStartFlag = cli.StringFlag{
+		Name:  "start",
+		Usage: "Account address or secure trie key (hash of the address) to start dumping from",
+	}
+	dumpLimitFlag = cli.Uint64Flag{
+		Name:  "limit",
+		Usage: "Maximum number of accounts to dump (default = all)",
+	}
+
 	initCommand = cli.Command{
 		Action:    utils.MigrateFlags(initGenesis),
 		Name:      "init",
@@ -46,6 +71,8 @@ var (
 		ArgsUsage: "<genesisPath>",
 		Flags: []cli.Flag{
 			utils.DataDirFlag,
+			utils.AncientFlag,
+			utils.DBEngineFlag,
 		},
 		Category: "BLOCKCHAIN COMMANDS",
 		Description: `
@@ -62,11 +89,26 @@ It expects the genesis file as argument.`,
 		ArgsUsage: "<filename> (<filename 2> ... <filename N>) ",
 		Flags: []cli.Flag{
 			utils.DataDirFlag,
+			utils.AncientFlag,
+			utils.DBEngineFlag,
 			utils.CacheFlag,
 			utils.SyncModeFlag,
 			utils.GCModeFlag,
 			utils.CacheDatabaseFlag,
 			utils.CacheGCFlag,
+			utils.TraceSinkFlag,
+			utils.TraceFileFlag,
+			utils.TraceMongoURLFlag,
+			utils.TraceMongoUserFlag,
+			utils.TraceMongoPasswordFlag,
+			utils.TraceMongoAuthSourceFlag,
+			utils.TraceMongoDatabaseFlag,
+			utils.TraceMongoCollectionFlag,
+			utils.TraceMongoBatchSizeFlag,
+			utils.TraceMongoFlushIntervalFlag,
+			utils.TraceMongoQueueSizeFlag,
+			utils.TraceMongoJournalFlag,
+			utils.TraceMongoErrorLogFlag,
 		},
 		Category: "BLOCKCHAIN COMMANDS",
 		Description: `
@@ -83,6 +125,8 @@ processing will proceed even if an individual RLP-file import failure occurs.`,
 		ArgsUsage: "<filename> [<blockNumFirst> <blockNumLast>]",
 		Flags: []cli.Flag{
 			utils.DataDirFlag,
+			utils.AncientFlag,
+			utils.DBEngineFlag,
 			utils.CacheFlag,
 			utils.SyncModeFlag,
 		},
@@ -101,6 +145,8 @@ be gzipped.`,
 		ArgsUsage: "<datafile>",
 		Flags: []cli.Flag{
 			utils.DataDirFlag,
+			utils.AncientFlag,
+			utils.DBEngineFlag,
 			utils.CacheFlag,
 			utils.SyncModeFlag,
 		},
@@ -115,6 +161,8 @@ be gzipped.`,
 		ArgsUsage: "<dumpfile>",
 		Flags: []cli.Flag{
 			utils.DataDirFlag,
+			utils.AncientFlag,
+			utils.DBEngineFlag,
 			utils.CacheFlag,
 			utils.SyncModeFlag,
 		},
@@ -129,6 +177,8 @@ The export-preimages command export hash preimages to an RLP encoded stream`,
 		ArgsUsage: "<sourceChaindataDir>",
 		Flags: []cli.Flag{
 			utils.DataDirFlag,
+			utils.AncientFlag,
+			utils.DBEngineFlag,
 			utils.CacheFlag,
 			utils.SyncModeFlag,
 			utils.FakePoWFlag,
@@ -146,10 +196,12 @@ The first argument must be the directory containing the blockchain to download f
 		ArgsUsage: " ",
 		Flags: []cli.Flag{
 			utils.DataDirFlag,
+			utils.AncientFlag,
 		},
 		Category: "BLOCKCHAIN COMMANDS",
 		Description: `
-Remove blockchain and state databases`,
+Remove blockchain and state databases, including the ancient chain segments if
+they are stored outside of the data directory (--datadir.ancient).`,
 	}
 	dumpCommand = cli.Command{
 		Action:    utils.MigrateFlags(dump),
@@ -158,13 +210,24 @@ Remove blockchain and state databases`,
 		ArgsUsage: "[<blockHash> | <blockNum>]...",
 		Flags: []cli.Flag{
 			utils.DataDirFlag,
+			utils.AncientFlag,
+			utils.DBEngineFlag,
 			utils.CacheFlag,
 			utils.SyncModeFlag,
+			dumpNoCodeFlag,
+			dumpNoStorageFlag,
+			dumpIncompletesFlag,
+			dumpStartFlag,
+			dumpLimitFlag,
 		},
 		Category: "BLOCKCHAIN COMMANDS",
 		Description: `
 The arguments are interpreted as block numbers or hashes.
-Use "ethereum dump 0" to dump the genesis block.`,
+Use "ethereum dump 0" to dump the genesis block.
+
+The state is streamed as one JSON object per line: the state root first, then
+the accounts in the order of their secure trie keys (hashes of the addresses).
+Use --start and --limit to dump a range of accounts only.`,
 	}
 )
 
@@ -191,7 +254,15 @@ func initGenesis(ctx *cli.Context) error {
 	defer stack.Close()
 
 	for _, name := range []string{"chaindata", "lightchaindata"} {
-		chaindb, err := stack.OpenDatabase(name, 0, 0, "")
+		var (
+			chaindb ethdb.Database
+			err     error
+		)
+		if name == "chaindata" {
+			chaindb, err = stack.OpenDatabaseWithFreezer(name, 0, 0, ctx.GlobalString(utils.AncientFlag.Name), "")
+		} else {
+			chaindb, err = stack.OpenDatabase(name, 0, 0, "")
+		}
 		if err != nil {
 			utils.Fatalf("Failed to open database: %v", err)
 		}
@@ -245,20 +316,15 @@ func importChain(ctx *cli.Context) error {
 		}
 	}
 	chain.Stop()
+	if sink := chain.GetVMConfig().TraceSink; sink != nil {
+		if err := sink.Close(); err != nil {
+			log.Error("Failed to close trace sink", "err", err)
+		}
+	}
 	fmt.Printf("Import done in %v.\n\n", time.Since(start))
 
 	// Output pre-compaction stats mostly to see the import trashing
-	stats, err := db.Stat("leveldb.stats")
-	if err != nil {
-		utils.Fatalf("Failed to read database stats: %v", err)
-	}
-	fmt.Println(stats)
-
-	ioStats, err := db.Stat("leveldb.iostats")
-	if err != nil {
-		utils.Fatalf("Failed to read database iostats: %v", err)
-	}
-	fmt.Println(ioStats)
+	showDatabaseStats(db)
 
 	// Print the memory statistics used by the importing
 	mem := new(runtime.MemStats)
@@ -276,23 +342,23 @@ func importChain(ctx *cli.Context) error {
 	// Compact the entire database to more accurately measure disk io and print the stats
 	start = time.Now()
 	fmt.Println("Compacting entire database...")
-	if err = db.Compact(nil, nil); err != nil {
+	if err := db.Compact(nil, nil); err != nil {
 		utils.Fatalf("Compaction failed: %v", err)
 	}
 	fmt.Printf("Compaction done in %v.\n\n", time.Since(start))
 
-	stats, err = db.Stat("leveldb.stats")
-	if err != nil {
-		utils.Fatalf("Failed to read database stats: %v", err)
-	}
-	fmt.Println(stats)
+	showDatabaseStats(db)
+	return nil
+}
 
-	ioStats, err = db.Stat("leveldb.iostats")
-	if err != nil {
-		utils.Fatalf("Failed to read database iostats: %v", err)
+// showDatabaseStats prints the internal statistics of the chain database that
+// its backend provides.
+func showDatabaseStats(db ethdb.Database) {
+	for _, property := range []string{"leveldb.stats", "leveldb.iostats", "bolt.stats"} {
+		if stats, err := db.Stat(property); err == nil {
+			fmt.Println(stats)
+		}
 	}
-	fmt.Println(ioStats)
-	return nil
 }
 
 func exportChain(ctx *cli.Context) error {
@@ -379,7 +445,7 @@ func copyDb(ctx *cli.Context) error {
 	dl := downloader.New(syncmode, chainDb, new(event.TypeMux), chain, nil, nil)
 
 	// Create a source peer to satisfy downloader requests from
-	db, err := rawdb.NewLevelDBDatabase(ctx.Args().First(), ctx.GlobalInt(utils.CacheFlag.Name), 256, "")
+	db, err := rawdb.NewPersistentDatabase("", ctx.Args().First(), ctx.GlobalInt(utils.CacheFlag.Name), 256, "")
 	if err != nil {
 		return err
 	}
@@ -416,11 +482,17 @@ func copyDb(ctx *cli.Context) error {
 func removeDB(ctx *cli.Context) error {
 	stack, _ := makeConfigNode(ctx)
 
-	for _, name := range []string{"chaindata", "lightchaindata"} {
+	dbdirs := []string{stack.ResolvePath("chaindata"), stack.ResolvePath("lightchaindata")}
+	if ancient := ctx.GlobalString(utils.AncientFlag.Name); ancient != "" {
+		if !filepath.IsAbs(ancient) {
+			ancient = stack.ResolvePath(ancient)
+		}
+		dbdirs = append(dbdirs, ancient)
+	}
+	for _, dbdir := range dbdirs {
 		// Ensure the database exists in the first place
-		logger := log.New("database", name)
+		logger := log.New("database", filepath.Base(dbdir))
 
-		dbdir := stack.ResolvePath(name)
 		if !common.FileExist(dbdir) {
 			logger.Info("Database doesn't exist, skipping", "path", dbdir)
 			continue
@@ -446,6 +518,26 @@ func dump(ctx *cli.Context) error {
 	stack := makeFullNode(ctx)
 	defer stack.Close()
 
+	conf := &state.DumpConfig{
+		SkipCode:          ctx.Bool(dumpNoCodeFlag.Name),
+		SkipStorage:       ctx.Bool(dumpNoStorageFlag.Name),
+		OnlyWithAddresses: !ctx.Bool(dumpIncompletesFlag.Name),
+		Max:               ctx.Uint64(dumpLimitFlag.Name),
+	}
+	if start := ctx.String(dumpStartFlag.Name); start != "" {
+		key, err := hexutil.Decode(start)
+		if err != nil {
+			utils.Fatalf("Invalid start key %q: %v", start, err)
+		}
+		switch len(key) {
+		case common.AddressLength:
+			conf.Start = crypto.Keccak256(key)
+		case common.HashLength:
+			conf.Start = key
+		default:
+			utils.Fatalf("Invalid start key %q: must be an address or a hash", start)
+		}
+	}
 	chain, chainDb := utils.MakeChain(ctx, stack)
 	for _, arg := range ctx.Args() {
 		var block *types.Block
@@ -463,7 +555,7 @@ func dump(ctx *cli.Context) error {
 			if err != nil {
 				utils.Fatalf("could not create new state: %v", err)
 			}
-			fmt.Printf("%s\n", state.Dump())
+			state.IterativeDump(conf, json.NewEncoder(os.Stdout))
 		}
 	}
 	chainDb.Close()
@@ -64,6 +64,8 @@ var (
 		utils.BootnodesV4Flag,
 		utils.BootnodesV5Flag,
 		utils.DataDirFlag,
+		utils.AncientFlag,
+		utils.DBEngineFlag,
 		utils.KeyStoreDirFlag,
 		utils.ExternalSignerFlag,
 		utils.NoUSBFlag,
@@ -95,6 +97,7 @@ var (
 		utils.SyncModeFlag,
 		utils.ExitWhenSyncedFlag,
 		utils.GCModeFlag,
+		utils.SnapshotFlag,
 		utils.LightServFlag,
 		utils.LightBandwidthInFlag,
 		utils.LightBandwidthOutFlag,
@@ -147,6 +150,28 @@ var (
 		utils.GpoPercentileFlag,
 		utils.EWASMInterpreterFlag,
 		utils.EVMInterpreterFlag,
+		utils.TraceSinkFlag,
+		utils.TraceFileFlag,
+		utils.TraceStorageFlag,
+		utils.TraceLogsFlag,
+		utils.TraceFilterAllowFlag,
+		utils.TraceFilterDenyFlag,
+		utils.TraceFilterSelectorsFlag,
+		utils.TraceFilterCreatesFlag,
+		utils.TraceFilterFailedFlag,
+		utils.TraceFilterFromFlag,
+		utils.TraceFilterToFlag,
+		utils.TraceMongoURLFlag,
+		utils.TraceMongoUserFlag,
+		utils.TraceMongoPasswordFlag,
+		utils.TraceMongoAuthSourceFlag,
+		utils.TraceMongoDatabaseFlag,
+		utils.TraceMongoCollectionFlag,
+		utils.TraceMongoBatchSizeFlag,
+		utils.TraceMongoFlushIntervalFlag,
+		utils.TraceMongoQueueSizeFlag,
+		utils.TraceMongoJournalFlag,
+		utils.TraceMongoErrorLogFlag,
 		configFileFlag,
 	}
 
@@ -203,6 +228,11 @@ func init() {
 		copydbCommand,
 		removedbCommand,
 		dumpCommand,
+		// See tracecmd.go:
+		traceCommand,
+		retraceCommand,
+		// See snapshot.go:
+		snapshotCommand,
 		// See monitorcmd.go:
 		monitorCommand,
 		// See accountcmd.go:
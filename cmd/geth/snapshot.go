@@ -0,0 +1,106 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"time"
+
+	"github.com/ethereum/go-ethereum/cmd/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/state/pruner"
+	"github.com/ethereum/go-ethereum/log"
+	"gopkg.in/urfave/cli.v1"
+)
+
+var (
+	pruneBloomSizeFlag = cli.Uint64Flag{
+		Name:  "bloomfilter.size",
+		Usage: "Megabytes of memory allocated to the bloom filter of live state nodes",
+		Value: 2048,
+	}
+	pruneKeepFlag = cli.Uint64Flag{
+		Name:  "keep",
+		Usage: "Number of recent block states to keep",
+		Value: 128,
+	}
+
+	snapshotCommand = cli.Command{
+		Name:      "snapshot",
+		Usage:     "Manage the state of the local chain",
+		ArgsUsage: "",
+		Category:  "BLOCKCHAIN COMMANDS",
+		Description: `
+Manage the state tries stored in the local chain database.`,
+		Subcommands: []cli.Command{
+			{
+				Name:      "prune-state",
+				Usage:     "Delete the stale state trie nodes",
+				ArgsUsage: "",
+				Action:    utils.MigrateFlags(pruneState),
+				Category:  "BLOCKCHAIN COMMANDS",
+				Flags: []cli.Flag{
+					utils.DataDirFlag,
+					utils.AncientFlag,
+					utils.DBEngineFlag,
+					utils.CacheFlag,
+					utils.CacheDatabaseFlag,
+					utils.TestnetFlag,
+					utils.RinkebyFlag,
+					utils.GoerliFlag,
+					pruneBloomSizeFlag,
+					pruneKeepFlag,
+				},
+				Description: `
+    geth snapshot prune-state --keep N
+
+deletes the state trie nodes and contract codes which are not reachable from
+the states of the last N blocks nor from the genesis state. Only the states
+actually stored on disk are kept: a non-archive node flushes its state every
+now and then, so the node may need to re-execute a few blocks on restart.
+
+All the nodes of the states to keep are recorded into a bloom filter, whose
+size is set by --bloomfilter.size. A larger filter lets fewer stale nodes slip
+through. The node must be stopped while pruning. An interrupted pruning can be
+simply run again.`,
+			},
+		},
+	}
+)
+
+// pruneState deletes the state trie nodes not reachable from the recent states
+// of the local chain.
+func pruneState(ctx *cli.Context) error {
+	stack, _ := makeConfigNode(ctx)
+	defer stack.Close()
+
+	db := utils.MakeChainDatabase(ctx, stack)
+	defer db.Close()
+
+	config := pruner.Config{
+		BloomSize: ctx.Uint64(pruneBloomSizeFlag.Name),
+		Keep:      ctx.Uint64(pruneKeepFlag.Name),
+	}
+	if config.Keep == 0 {
+		utils.Fatalf("At least one state must be kept")
+	}
+	start := time.Now()
+	if err := pruner.NewPruner(db, config).Prune(); err != nil {
+		utils.Fatalf("State pruning failed: %v", err)
+	}
+	log.Info("Pruned state", "elapsed", common.PrettyDuration(time.Since(start)))
+	return nil
+}
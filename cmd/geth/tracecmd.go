@@ -0,0 +1,225 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/ethereum/go-ethereum/cmd/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/eth"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/mongo"
+	"gopkg.in/urfave/cli.v1"
+)
+
+var (
+	traceMongoFlags = []cli.Flag{
+		utils.DataDirFlag,
+		configFileFlag,
+		utils.TraceMongoURLFlag,
+		utils.TraceMongoUserFlag,
+		utils.TraceMongoPasswordFlag,
+		utils.TraceMongoAuthSourceFlag,
+		utils.TraceMongoDatabaseFlag,
+		utils.TraceMongoCollectionFlag,
+		utils.TraceMongoErrorLogFlag,
+	}
+
+	retraceFromFlag = cli.Uint64Flag{
+		Name:  "from",
+		Usage: "First block to retrace",
+	}
+	retraceToFlag = cli.Uint64Flag{
+		Name:  "to",
+		Usage: "Last block to retrace (default = current head)",
+	}
+	retraceWorkersFlag = cli.IntFlag{
+		Name:  "workers",
+		Usage: "Number of blocks to re-execute concurrently (default = number of CPUs)",
+	}
+	retraceReexecFlag = cli.Uint64Flag{
+		Name:  "reexec",
+		Usage: "Number of blocks to re-execute to regenerate a missing starting state",
+		Value: 128,
+	}
+
+	retraceCommand = cli.Command{
+		Action:    utils.MigrateFlags(retrace),
+		Name:      "retrace",
+		Usage:     "Re-execute a range of stored blocks into the trace store",
+		ArgsUsage: "",
+		Category:  "BLOCKCHAIN COMMANDS",
+		Flags: []cli.Flag{
+			utils.DataDirFlag,
+			utils.AncientFlag,
+			utils.DBEngineFlag,
+			utils.CacheFlag,
+			utils.SyncModeFlag,
+			utils.GCModeFlag,
+			utils.CacheDatabaseFlag,
+			utils.CacheGCFlag,
+			retraceFromFlag,
+			retraceToFlag,
+			retraceWorkersFlag,
+			retraceReexecFlag,
+			utils.TraceSinkFlag,
+			utils.TraceFileFlag,
+			utils.TraceStorageFlag,
+			utils.TraceLogsFlag,
+			utils.TraceFilterAllowFlag,
+			utils.TraceFilterDenyFlag,
+			utils.TraceFilterSelectorsFlag,
+			utils.TraceFilterCreatesFlag,
+			utils.TraceFilterFailedFlag,
+			utils.TraceFilterFromFlag,
+			utils.TraceFilterToFlag,
+			utils.TraceMongoURLFlag,
+			utils.TraceMongoUserFlag,
+			utils.TraceMongoPasswordFlag,
+			utils.TraceMongoAuthSourceFlag,
+			utils.TraceMongoDatabaseFlag,
+			utils.TraceMongoCollectionFlag,
+			utils.TraceMongoBatchSizeFlag,
+			utils.TraceMongoFlushIntervalFlag,
+			utils.TraceMongoQueueSizeFlag,
+			utils.TraceMongoJournalFlag,
+			utils.TraceMongoErrorLogFlag,
+		},
+		Description: `
+    geth retrace --from N --to M
+
+re-executes the canonical blocks N to M (both inclusive) of the local chain and
+records their transaction traces into the configured trace sink, replacing any
+previously recorded ones. It's meant to backfill the trace store without having
+to resync, e.g. after changing the trace format or the recording options.
+
+The state of block N-1 is regenerated by re-executing up to --reexec blocks if
+it's not available locally. The blocks themselves are re-executed concurrently.`,
+	}
+
+	traceCommand = cli.Command{
+		Name:      "traces",
+		Usage:     "Manage recorded transaction traces",
+		ArgsUsage: "",
+		Category:  "BLOCKCHAIN COMMANDS",
+		Description: `
+Manage the transaction trace records stored in MongoDB.`,
+		Subcommands: []cli.Command{
+			{
+				Name:      "migrate",
+				Usage:     "Convert legacy trace records to the current schema",
+				ArgsUsage: "",
+				Action:    utils.MigrateFlags(migrateTraces),
+				Category:  "BLOCKCHAIN COMMANDS",
+				Flags:     traceMongoFlags,
+				Description: `
+    geth traces migrate
+
+converts the string encoded trace records written by older versions into the
+current typed schema, in place. Duplicate records of the same transaction are
+dropped, while records that cannot be converted are dumped into the error log
+and left untouched. The migration may be interrupted and rerun at any time.`,
+			},
+		},
+	}
+)
+
+// migrateTraces converts the legacy trace records in the configured collection
+// to the current schema.
+func migrateTraces(ctx *cli.Context) error {
+	stack, cfg := makeConfigNode(ctx)
+	defer stack.Close()
+
+	config := cfg.Eth.Trace
+	config.ErrorLog = stack.ResolvePath(config.ErrorLog)
+
+	writer, err := mongo.NewMongoWriter(config)
+	if err != nil {
+		utils.Fatalf("Failed to connect to MongoDB: %v", err)
+	}
+	defer writer.Close()
+
+	log.Info("Migrating trace records", "database", config.Database, "collection", config.Collection, "version", mongo.SchemaVersion)
+	start := time.Now()
+
+	stats, err := writer.Migrate()
+	if err != nil {
+		utils.Fatalf("Migration failed: %v", err)
+	}
+	log.Info("Migrated trace records", "migrated", stats.Migrated, "merged", stats.Merged, "failed", stats.Failed, "elapsed", common.PrettyDuration(time.Since(start)))
+	if stats.Failed > 0 {
+		log.Warn("Some trace records could not be migrated", "errorlog", config.ErrorLog)
+	}
+	return nil
+}
+
+// retrace re-executes a range of stored blocks, recording their transaction
+// traces into the configured trace sink.
+func retrace(ctx *cli.Context) error {
+	stack, _ := makeConfigNode(ctx)
+	defer stack.Close()
+
+	chain, db := utils.MakeChain(ctx, stack)
+	defer db.Close()
+
+	vmcfg := *chain.GetVMConfig()
+	if vmcfg.TraceSink == nil {
+		utils.SetTraceVMConfig(ctx, stack, &vmcfg)
+	}
+	if vmcfg.TraceSink == nil {
+		utils.Fatalf("Trace recording is disabled, select a trace sink with --%s", utils.TraceSinkFlag.Name)
+	}
+	var (
+		from    = ctx.Uint64(retraceFromFlag.Name)
+		to      = chain.CurrentBlock().NumberU64()
+		workers = ctx.Int(retraceWorkersFlag.Name)
+		reexec  = ctx.Uint64(retraceReexecFlag.Name)
+	)
+	if ctx.IsSet(retraceToFlag.Name) {
+		to = ctx.Uint64(retraceToFlag.Name)
+	}
+	// Stop retracing at the next block if interrupted
+	abort, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(interrupt)
+	go func() {
+		if _, ok := <-interrupt; ok {
+			log.Info("Interrupted during retrace, stopping")
+			cancel()
+		}
+	}()
+	start := time.Now()
+	result, err := eth.Retrace(abort, chain, db, from, to, vmcfg, &eth.RetraceConfig{Reexec: &reexec, Workers: &workers})
+
+	chain.Stop()
+	if err := vmcfg.TraceSink.Close(); err != nil {
+		log.Error("Failed to close trace sink", "err", err)
+	}
+	if err != nil {
+		utils.Fatalf("Retrace failed: %v", err)
+	}
+	log.Info("Retraced chain segment", "from", from, "to", to, "blocks", uint64(result.Blocks), "transactions", uint64(result.Transactions), "elapsed", common.PrettyDuration(time.Since(start)))
+	return nil
+}
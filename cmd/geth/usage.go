@@ -69,6 +69,8 @@ var AppHelpFlagGroups = []flagGroup{
 		Flags: []cli.Flag{
 			configFileFlag,
 			utils.DataDirFlag,
+			utils.AncientFlag,
+			utils.DBEngineFlag,
 			utils.KeyStoreDirFlag,
 			utils.NoUSBFlag,
 			utils.NetworkIdFlag,
@@ -78,6 +80,7 @@ var AppHelpFlagGroups = []flagGroup{
 			utils.SyncModeFlag,
 			utils.ExitWhenSyncedFlag,
 			utils.GCModeFlag,
+			utils.SnapshotFlag,
 			utils.EthStatsURLFlag,
 			utils.IdentityFlag,
 			utils.LightServFlag,
@@ -132,6 +135,33 @@ var AppHelpFlagGroups = []flagGroup{
 			utils.TxPoolLifetimeFlag,
 		},
 	},
+	{
+		Name: "TRANSACTION TRACING",
+		Flags: []cli.Flag{
+			utils.TraceSinkFlag,
+			utils.TraceFileFlag,
+			utils.TraceStorageFlag,
+			utils.TraceLogsFlag,
+			utils.TraceFilterAllowFlag,
+			utils.TraceFilterDenyFlag,
+			utils.TraceFilterSelectorsFlag,
+			utils.TraceFilterCreatesFlag,
+			utils.TraceFilterFailedFlag,
+			utils.TraceFilterFromFlag,
+			utils.TraceFilterToFlag,
+			utils.TraceMongoURLFlag,
+			utils.TraceMongoUserFlag,
+			utils.TraceMongoPasswordFlag,
+			utils.TraceMongoAuthSourceFlag,
+			utils.TraceMongoDatabaseFlag,
+			utils.TraceMongoCollectionFlag,
+			utils.TraceMongoBatchSizeFlag,
+			utils.TraceMongoFlushIntervalFlag,
+			utils.TraceMongoQueueSizeFlag,
+			utils.TraceMongoJournalFlag,
+			utils.TraceMongoErrorLogFlag,
+		},
+	},
 	{
 		Name: "PERFORMANCE TUNING",
 		Flags: []cli.Flag{
@@ -33,6 +33,7 @@ import (
 	"github.com/ethereum/go-ethereum/accounts/keystore"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/fdlimit"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/consensus"
 	"github.com/ethereum/go-ethereum/consensus/clique"
 	"github.com/ethereum/go-ethereum/consensus/ethash"
@@ -49,6 +50,7 @@ import (
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/metrics"
 	"github.com/ethereum/go-ethereum/metrics/influxdb"
+	"github.com/ethereum/go-ethereum/mongo"
 	"github.com/ethereum/go-ethereum/node"
 	"github.com/ethereum/go-ethereum/p2p"
 	"github.com/ethereum/go-ethereum/p2p/discv5"
@@ -119,6 +121,14 @@ var (
 		Usage: "Data directory for the databases and keystore",
 		Value: DirectoryString{node.DefaultDataDir()},
 	}
+	AncientFlag = DirectoryFlag{
+		Name:  "datadir.ancient",
+		Usage: "Data directory for ancient chain segments (default = inside chaindata)",
+	}
+	DBEngineFlag = cli.StringFlag{
+		Name:  "db.engine",
+		Usage: `Backing database implementation to use ("leveldb" or "bolt", default = the one of the existing database or "leveldb"). Bolt syncs every single write to disk and its file never shrinks, so pruning frees no disk space`,
+	}
 	KeyStoreDirFlag = DirectoryFlag{
 		Name:  "keystore",
 		Usage: "Directory for the keystore (default = inside the datadir)",
@@ -196,6 +206,10 @@ var (
 		Usage: `Blockchain garbage collection mode ("full", "archive")`,
 		Value: "full",
 	}
+	SnapshotFlag = cli.BoolFlag{
+		Name:  "snapshot",
+		Usage: "Maintain a flat snapshot of the state for faster state reads (experimental)",
+	}
 	LightServFlag = cli.IntFlag{
 		Name:  "lightserv",
 		Usage: "Maximum percentage of time allowed for serving LES requests (multi-threaded processing allows values over 100)",
@@ -699,6 +713,109 @@ var (
 		Usage: "External EVM configuration (default = built-in interpreter)",
 		Value: "",
 	}
+
+	// Transaction trace recording settings
+	TraceSinkFlag = cli.StringFlag{
+		Name:  "trace.sink",
+		Usage: `Destination of the transaction trace records ("mongo", "file", "memory" or "none")`,
+		Value: eth.DefaultConfig.Trace.Sink,
+	}
+	TraceFileFlag = cli.StringFlag{
+		Name:  "trace.file",
+		Usage: "JSON-lines file to write the transaction trace records into (file sink)",
+		Value: eth.DefaultConfig.Trace.File,
+	}
+	TraceStorageFlag = cli.BoolFlag{
+		Name:  "trace.storage",
+		Usage: "Record the storage slots read and written by SLOAD and SSTORE into the traces",
+	}
+	TraceLogsFlag = cli.BoolFlag{
+		Name:  "trace.logs",
+		Usage: "Record the logs emitted by LOG0-LOG4 into the traces",
+	}
+	TraceFilterAllowFlag = cli.StringFlag{
+		Name:  "trace.filter.allow",
+		Usage: "Comma separated accounts to record only the transactions from or to of",
+	}
+	TraceFilterDenyFlag = cli.StringFlag{
+		Name:  "trace.filter.deny",
+		Usage: "Comma separated accounts to never record the transactions from or to of",
+	}
+	TraceFilterSelectorsFlag = cli.StringFlag{
+		Name:  "trace.filter.selectors",
+		Usage: "Comma separated 4-byte method selectors to record only the calls of (e.g. 0xa9059cbb)",
+	}
+	TraceFilterCreatesFlag = cli.BoolFlag{
+		Name:  "trace.filter.creates",
+		Usage: "Record only contract creation transactions",
+	}
+	TraceFilterFailedFlag = cli.BoolFlag{
+		Name:  "trace.filter.failed",
+		Usage: "Record only transactions failing execution",
+	}
+	TraceFilterFromFlag = cli.Uint64Flag{
+		Name:  "trace.filter.from",
+		Usage: "First block to record the transactions of",
+	}
+	TraceFilterToFlag = cli.Uint64Flag{
+		Name:  "trace.filter.to",
+		Usage: "Last block to record the transactions of (0 = no limit)",
+	}
+	TraceMongoURLFlag = cli.StringFlag{
+		Name:  "trace.mongo.url",
+		Usage: "MongoDB connection string to store the trace records at (mongo sink)",
+		Value: eth.DefaultConfig.Trace.URL,
+	}
+	TraceMongoUserFlag = cli.StringFlag{
+		Name:  "trace.mongo.user",
+		Usage: "Username to authenticate to MongoDB with (mongo sink)",
+		Value: eth.DefaultConfig.Trace.Username,
+	}
+	TraceMongoPasswordFlag = cli.StringFlag{
+		Name:  "trace.mongo.password",
+		Usage: "Password to authenticate to MongoDB with (mongo sink)",
+		Value: eth.DefaultConfig.Trace.Password,
+	}
+	TraceMongoAuthSourceFlag = cli.StringFlag{
+		Name:  "trace.mongo.authsource",
+		Usage: "MongoDB database holding the user credentials (mongo sink)",
+		Value: eth.DefaultConfig.Trace.AuthSource,
+	}
+	TraceMongoDatabaseFlag = cli.StringFlag{
+		Name:  "trace.mongo.database",
+		Usage: "MongoDB database to store the trace records in (mongo sink)",
+		Value: eth.DefaultConfig.Trace.Database,
+	}
+	TraceMongoCollectionFlag = cli.StringFlag{
+		Name:  "trace.mongo.collection",
+		Usage: "MongoDB collection to store the trace records in (mongo sink)",
+		Value: eth.DefaultConfig.Trace.Collection,
+	}
+	TraceMongoBatchSizeFlag = cli.IntFlag{
+		Name:  "trace.mongo.batchsize",
+		Usage: "Number of trace records to accumulate before inserting them (mongo sink)",
+		Value: eth.DefaultConfig.Trace.BatchSize,
+	}
+	TraceMongoFlushIntervalFlag = cli.DurationFlag{
+		Name:  "trace.mongo.flushinterval",
+		Usage: "Maximum time trace records may linger before being inserted (mongo sink)",
+		Value: eth.DefaultConfig.Trace.FlushInterval,
+	}
+	TraceMongoQueueSizeFlag = cli.IntFlag{
+		Name:  "trace.mongo.queuesize",
+		Usage: "Number of trace records allowed to wait for the background writer (mongo sink)",
+		Value: eth.DefaultConfig.Trace.QueueSize,
+	}
+	TraceMongoJournalFlag = cli.StringFlag{
+		Name:  "trace.mongo.journal",
+		Usage: "Disk journal for trace records to survive database outages and node restarts (mongo sink)",
+		Value: eth.DefaultConfig.Trace.Journal,
+	}
+	TraceMongoErrorLogFlag = cli.StringFlag{
+		Name:  "trace.mongo.errorlog",
+		Usage: "File to dump the trace records into that were rejected by the database (mongo sink)",
+		Value: eth.DefaultConfig.Trace.ErrorLog,
+	}
 )
 
 // MakeDataDir retrieves the currently requested data directory, terminating
@@ -1117,6 +1234,9 @@ func SetNodeConfig(ctx *cli.Context, cfg *node.Config) {
 	setNodeUserIdent(ctx, cfg)
 	setDataDir(ctx, cfg)
 
+	if ctx.GlobalIsSet(DBEngineFlag.Name) {
+		cfg.DBEngine = ctx.GlobalString(DBEngineFlag.Name)
+	}
 	if ctx.GlobalIsSet(ExternalSignerFlag.Name) {
 		cfg.ExternalSigner = ctx.GlobalString(ExternalSignerFlag.Name)
 	}
@@ -1199,6 +1319,94 @@ func setTxPool(ctx *cli.Context, cfg *core.TxPoolConfig) {
 	}
 }
 
+func setTrace(ctx *cli.Context, cfg *mongo.Config) {
+	if ctx.GlobalIsSet(TraceSinkFlag.Name) {
+		cfg.Sink = ctx.GlobalString(TraceSinkFlag.Name)
+	}
+	if ctx.GlobalIsSet(TraceFileFlag.Name) {
+		cfg.File = ctx.GlobalString(TraceFileFlag.Name)
+	}
+	if ctx.GlobalIsSet(TraceStorageFlag.Name) {
+		cfg.Storage = ctx.GlobalBool(TraceStorageFlag.Name)
+	}
+	if ctx.GlobalIsSet(TraceLogsFlag.Name) {
+		cfg.Logs = ctx.GlobalBool(TraceLogsFlag.Name)
+	}
+	if ctx.GlobalIsSet(TraceFilterAllowFlag.Name) {
+		cfg.Filter.Allow = parseTraceAccounts(ctx, TraceFilterAllowFlag.Name)
+	}
+	if ctx.GlobalIsSet(TraceFilterDenyFlag.Name) {
+		cfg.Filter.Deny = parseTraceAccounts(ctx, TraceFilterDenyFlag.Name)
+	}
+	if ctx.GlobalIsSet(TraceFilterSelectorsFlag.Name) {
+		cfg.Filter.Selectors = nil
+		for _, selector := range splitAndTrim(ctx.GlobalString(TraceFilterSelectorsFlag.Name)) {
+			id, err := hexutil.Decode(selector)
+			if err != nil || len(id) != 4 {
+				Fatalf("Invalid method selector in --%s: %s", TraceFilterSelectorsFlag.Name, selector)
+			}
+			cfg.Filter.Selectors = append(cfg.Filter.Selectors, id)
+		}
+	}
+	if ctx.GlobalIsSet(TraceFilterCreatesFlag.Name) {
+		cfg.Filter.CreatesOnly = ctx.GlobalBool(TraceFilterCreatesFlag.Name)
+	}
+	if ctx.GlobalIsSet(TraceFilterFailedFlag.Name) {
+		cfg.Filter.FailedOnly = ctx.GlobalBool(TraceFilterFailedFlag.Name)
+	}
+	if ctx.GlobalIsSet(TraceFilterFromFlag.Name) {
+		cfg.Filter.FromBlock = ctx.GlobalUint64(TraceFilterFromFlag.Name)
+	}
+	if ctx.GlobalIsSet(TraceFilterToFlag.Name) {
+		cfg.Filter.ToBlock = ctx.GlobalUint64(TraceFilterToFlag.Name)
+	}
+	if ctx.GlobalIsSet(TraceMongoURLFlag.Name) {
+		cfg.URL = ctx.GlobalString(TraceMongoURLFlag.Name)
+	}
+	if ctx.GlobalIsSet(TraceMongoUserFlag.Name) {
+		cfg.Username = ctx.GlobalString(TraceMongoUserFlag.Name)
+	}
+	if ctx.GlobalIsSet(TraceMongoPasswordFlag.Name) {
+		cfg.Password = ctx.GlobalString(TraceMongoPasswordFlag.Name)
+	}
+	if ctx.GlobalIsSet(TraceMongoAuthSourceFlag.Name) {
+		cfg.AuthSource = ctx.GlobalString(TraceMongoAuthSourceFlag.Name)
+	}
+	if ctx.GlobalIsSet(TraceMongoDatabaseFlag.Name) {
+		cfg.Database = ctx.GlobalString(TraceMongoDatabaseFlag.Name)
+	}
+	if ctx.GlobalIsSet(TraceMongoCollectionFlag.Name) {
+		cfg.Collection = ctx.GlobalString(TraceMongoCollectionFlag.Name)
+	}
+	if ctx.GlobalIsSet(TraceMongoBatchSizeFlag.Name) {
+		cfg.BatchSize = ctx.GlobalInt(TraceMongoBatchSizeFlag.Name)
+	}
+	if ctx.GlobalIsSet(TraceMongoFlushIntervalFlag.Name) {
+		cfg.FlushInterval = ctx.GlobalDuration(TraceMongoFlushIntervalFlag.Name)
+	}
+	if ctx.GlobalIsSet(TraceMongoQueueSizeFlag.Name) {
+		cfg.QueueSize = ctx.GlobalInt(TraceMongoQueueSizeFlag.Name)
+	}
+	if ctx.GlobalIsSet(TraceMongoJournalFlag.Name) {
+		cfg.Journal = ctx.GlobalString(TraceMongoJournalFlag.Name)
+	}
+	if ctx.GlobalIsSet(TraceMongoErrorLogFlag.Name) {
+		cfg.ErrorLog = ctx.GlobalString(TraceMongoErrorLogFlag.Name)
+	}
+}
+
+// parseTraceAccounts parses the comma separated accounts of a trace filter flag.
+func parseTraceAccounts(ctx *cli.Context, name string) []common.Address {
+	var accounts []common.Address
+	for _, account := range splitAndTrim(ctx.GlobalString(name)) {
+		if !common.IsHexAddress(account) {
+			Fatalf("Invalid account in --%s: %s", name, account)
+		}
+		accounts = append(accounts, common.HexToAddress(account))
+	}
+	return accounts
+}
+
 func setEthash(ctx *cli.Context, cfg *eth.Config) {
 	if ctx.GlobalIsSet(EthashCacheDirFlag.Name) {
 		cfg.Ethash.CacheDir = ctx.GlobalString(EthashCacheDirFlag.Name)
@@ -1311,6 +1519,7 @@ func SetEthConfig(ctx *cli.Context, stack *node.Node, cfg *eth.Config) {
 	setEtherbase(ctx, ks, cfg)
 	setGPO(ctx, &cfg.GPO)
 	setTxPool(ctx, &cfg.TxPool)
+	setTrace(ctx, &cfg.Trace)
 	setEthash(ctx, cfg)
 	setWhitelist(ctx, cfg)
 
@@ -1335,12 +1544,16 @@ func SetEthConfig(ctx *cli.Context, stack *node.Node, cfg *eth.Config) {
 		cfg.DatabaseCache = ctx.GlobalInt(CacheFlag.Name) * ctx.GlobalInt(CacheDatabaseFlag.Name) / 100
 	}
 	cfg.DatabaseHandles = makeDatabaseHandles()
+	if ctx.GlobalIsSet(AncientFlag.Name) {
+		cfg.DatabaseFreezer = ctx.GlobalString(AncientFlag.Name)
+	}
 
 	if gcmode := ctx.GlobalString(GCModeFlag.Name); gcmode != "full" && gcmode != "archive" {
 		Fatalf("--%s must be either 'full' or 'archive'", GCModeFlag.Name)
 	}
 	cfg.NoPruning = ctx.GlobalString(GCModeFlag.Name) == "archive"
 	cfg.NoPrefetch = ctx.GlobalBool(CacheNoPrefetchFlag.Name)
+	cfg.Snapshot = ctx.GlobalBool(SnapshotFlag.Name)
 
 	if ctx.GlobalIsSet(CacheFlag.Name) || ctx.GlobalIsSet(CacheTrieFlag.Name) {
 		cfg.TrieCleanCache = ctx.GlobalInt(CacheFlag.Name) * ctx.GlobalInt(CacheTrieFlag.Name) / 100
@@ -1546,11 +1759,15 @@ func MakeChainDatabase(ctx *cli.Context, stack *node.Node) ethdb.Database {
 		cache   = ctx.GlobalInt(CacheFlag.Name) * ctx.GlobalInt(CacheDatabaseFlag.Name) / 100
 		handles = makeDatabaseHandles()
 	)
-	name := "chaindata"
+	var (
+		chainDb ethdb.Database
+		err     error
+	)
 	if ctx.GlobalString(SyncModeFlag.Name) == "light" {
-		name = "lightchaindata"
+		chainDb, err = stack.OpenDatabase("lightchaindata", cache, handles, "")
+	} else {
+		chainDb, err = stack.OpenDatabaseWithFreezer("chaindata", cache, handles, ctx.GlobalString(AncientFlag.Name), "")
 	}
-	chainDb, err := stack.OpenDatabase(name, cache, handles, "")
 	if err != nil {
 		Fatalf("Could not open database: %v", err)
 	}
@@ -1572,6 +1789,26 @@ func MakeGenesis(ctx *cli.Context) *core.Genesis {
 	return genesis
 }
 
+// SetTraceVMConfig opens the trace sink configured by the command line flags and
+// sets it, along with the trace recording options, into the VM configuration.
+func SetTraceVMConfig(ctx *cli.Context, stack *node.Node, cfg *vm.Config) {
+	trace := eth.DefaultConfig.Trace
+	setTrace(ctx, &trace)
+	trace.File = stack.ResolvePath(trace.File)
+	trace.ErrorLog = stack.ResolvePath(trace.ErrorLog)
+	if trace.Journal != "" {
+		trace.Journal = stack.ResolvePath(trace.Journal)
+	}
+	var err error
+	if cfg.TraceSink, err = mongo.New(trace); err != nil {
+		Fatalf("Can't open trace sink: %v", err)
+	}
+	if cfg.TraceFilter, err = mongo.NewFilter(trace.Filter); err != nil {
+		Fatalf("Invalid trace filter: %v", err)
+	}
+	cfg.TraceStorage, cfg.TraceLogs = trace.Storage, trace.Logs
+}
+
 // MakeChain creates a chain manager from set command line flags.
 func MakeChain(ctx *cli.Context, stack *node.Node) (chain *core.BlockChain, chainDb ethdb.Database) {
 	var err error
@@ -1605,6 +1842,7 @@ func MakeChain(ctx *cli.Context, stack *node.Node) (chain *core.BlockChain, chai
 		TrieDirtyLimit:      eth.DefaultConfig.TrieDirtyCache,
 		TrieDirtyDisabled:   ctx.GlobalString(GCModeFlag.Name) == "archive",
 		TrieTimeLimit:       eth.DefaultConfig.TrieTimeout,
+		Snapshot:            ctx.GlobalBool(SnapshotFlag.Name),
 	}
 	if ctx.GlobalIsSet(CacheFlag.Name) || ctx.GlobalIsSet(CacheTrieFlag.Name) {
 		cache.TrieCleanLimit = ctx.GlobalInt(CacheFlag.Name) * ctx.GlobalInt(CacheTrieFlag.Name) / 100
@@ -1613,6 +1851,9 @@ func MakeChain(ctx *cli.Context, stack *node.Node) (chain *core.BlockChain, chai
 		cache.TrieDirtyLimit = ctx.GlobalInt(CacheFlag.Name) * ctx.GlobalInt(CacheGCFlag.Name) / 100
 	}
 	vmcfg := vm.Config{EnablePreimageRecording: ctx.GlobalBool(VMEnableDebugFlag.Name)}
+	if ctx.GlobalIsSet(TraceSinkFlag.Name) {
+		SetTraceVMConfig(ctx, stack, &vmcfg)
+	}
 	chain, err = core.NewBlockChain(chainDb, cache, config, engine, vmcfg, nil)
 	if err != nil {
 		Fatalf("Can't create BlockChain: %v", err)
@@ -33,6 +33,7 @@ import (
 	"github.com/ethereum/go-ethereum/consensus"
 	"github.com/ethereum/go-ethereum/core/rawdb"
 	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/state/snapshot"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/core/vm"
 	"github.com/ethereum/go-ethereum/crypto"
@@ -44,9 +45,6 @@ import (
 	"github.com/ethereum/go-ethereum/rlp"
 	"github.com/ethereum/go-ethereum/trie"
 	lru "github.com/hashicorp/golang-lru"
-
-	"github.com/ethereum/go-ethereum/mongo"
-	// "gopkg.in/mgo.v2/bson"
 )
 
 var (
@@ -80,6 +78,14 @@ const (
 	badBlockLimit       = 10
 	triesInMemory       = 128
 
+	// freezerRecheckInterval is the frequency to check whether the chain has grown
+	// enough for more blocks to be moved into the ancient store.
+	freezerRecheckInterval = time.Minute
+
+	// freezerBatchLimit is the maximum number of blocks to move into the ancient
+	// store in one go, before releasing the lock and checking for shutdown.
+	freezerBatchLimit = 30000
+
 	// BlockChainVersion ensures that an incompatible database forces a resync from scratch.
 	//
 	// During the process of upgrading the database version from 3 to 4,
@@ -98,6 +104,7 @@ type CacheConfig struct {
 	TrieDirtyLimit      int           // Memory limit (MB) at which to start flushing dirty trie nodes to disk
 	TrieDirtyDisabled   bool          // Whether to disable trie write caching and GC altogether (archive node)
 	TrieTimeLimit       time.Duration // Time limit after which to flush the current in-memory trie to disk
+	Snapshot            bool          // Whether to maintain a flat snapshot of the state for direct reads
 }
 
 // BlockChain represents the canonical chain given a database with a genesis
@@ -132,13 +139,15 @@ type BlockChain struct {
 	scope         event.SubscriptionScope
 	genesisBlock  *types.Block
 
-	chainmu sync.RWMutex // blockchain insertion lock
+	chainmu  sync.RWMutex // blockchain insertion lock
+	freezemu sync.Mutex   // ancient store migration lock
 
 	checkpoint       int          // checkpoint counts towards the new checkpoint
 	currentBlock     atomic.Value // Current head of the block chain
 	currentFastBlock atomic.Value // Current head of the fast-sync chain (may be above the block chain!)
 
 	stateCache    state.Database // State database to reuse between imports (contains state cache)
+	snaps         *snapshot.Tree // Snapshot tree for fast trie leaf access
 	bodyCache     *lru.Cache     // Cache for the most recent block bodies
 	bodyRLPCache  *lru.Cache     // Cache for the most recent block bodies in RLP encoded format
 	receiptsCache *lru.Cache     // Cache for the most recent receipts per block
@@ -165,8 +174,6 @@ type BlockChain struct {
 // available in the database. It initialises the default Ethereum Validator and
 // Processor.
 func NewBlockChain(db ethdb.Database, cacheConfig *CacheConfig, chainConfig *params.ChainConfig, engine consensus.Engine, vmConfig vm.Config, shouldPreserve func(block *types.Block) bool) (*BlockChain, error) {
-	mongo.InitMongoDb()
-
 	if cacheConfig == nil {
 		cacheConfig = &CacheConfig{
 			TrieCleanLimit: 256,
@@ -214,6 +221,10 @@ func NewBlockChain(db ethdb.Database, cacheConfig *CacheConfig, chainConfig *par
 	if err := bc.loadLastState(); err != nil {
 		return nil, err
 	}
+	// Load any existing snapshot, regenerating it if loading failed
+	if bc.cacheConfig.Snapshot {
+		bc.snaps = snapshot.New(bc.db, bc.stateCache.TrieDB(), bc.CurrentBlock().Root())
+	}
 	// Check the current state of the block hashes and make sure that we do not have any of the bad blocks in our chain
 	for hash := range BadHashes {
 		if header := bc.GetHeaderByHash(hash); header != nil {
@@ -227,6 +238,15 @@ func NewBlockChain(db ethdb.Database, cacheConfig *CacheConfig, chainConfig *par
 			}
 		}
 	}
+	// Keep the canonical flag of the recorded transaction traces up to date
+	if vmConfig.TraceSink != nil {
+		startTraceMarker(bc, vmConfig.TraceSink)
+	}
+	// Move the immutable part of the chain into the ancient store, if there's one
+	if _, err := bc.db.Ancients(); err == nil {
+		bc.wg.Add(1)
+		go bc.freezeLoop()
+	}
 	// Take ownership of this particular state
 	go bc.update()
 	return bc, nil
@@ -311,12 +331,23 @@ func (bc *BlockChain) SetHead(head uint64) error {
 	defer bc.chainmu.Unlock()
 
 	// Rewind the header chain, deleting all block bodies until then
-	delFn := func(db ethdb.Writer, hash common.Hash, num uint64) {
+	delFn := func(db ethdb.KeyValueWriter, hash common.Hash, num uint64) {
 		rawdb.DeleteBody(db, hash, num)
 	}
 	bc.hc.SetHead(head, delFn)
 	currentHeader := bc.hc.CurrentHeader()
 
+	// Drop the frozen blocks above the new head, as the ancient store can only hold
+	// the canonical chain
+	bc.freezemu.Lock()
+	if frozen, err := bc.db.Ancients(); err == nil && frozen > head+1 {
+		if err := bc.db.TruncateAncients(head + 1); err != nil {
+			bc.freezemu.Unlock()
+			return err
+		}
+	}
+	bc.freezemu.Unlock()
+
 	// Clear out any stale content from the caches
 	bc.bodyCache.Purge()
 	bc.bodyRLPCache.Purge()
@@ -408,7 +439,7 @@ func (bc *BlockChain) State() (*state.StateDB, error) {
 
 // StateAt returns a new mutable state based on a particular point in time.
 func (bc *BlockChain) StateAt(root common.Hash) (*state.StateDB, error) {
-	return state.New(root, bc.stateCache)
+	return state.NewWithSnapshot(root, bc.stateCache, bc.snaps)
 }
 
 // StateCache returns the caching database underpinning the blockchain instance.
@@ -709,6 +740,13 @@ func (bc *BlockChain) Stop() {
 
 	bc.wg.Wait()
 
+	// Flush the snapshot to disk, so it can be reused on restart. The diff layers
+	// are not journalled, so the snapshot is flattened into the head state.
+	if bc.snaps != nil {
+		if err := bc.snaps.Persist(bc.CurrentBlock().Root()); err != nil {
+			log.Error("Failed to persist state snapshot", "err", err)
+		}
+	}
 	// Ensure the state of a recent block is also stored to disk before exiting.
 	// We're writing three different states to catch different restart scenarios:
 	//  - HEAD:     So we don't need to reprocess any blocks in the general case
@@ -1070,11 +1108,30 @@ func (bc *BlockChain) writeBlockWithState(block *types.Block, receipts []*types.
 	// Set new head.
 	if status == CanonStatTy {
 		bc.insert(block)
+		bc.updateSnapshot(block)
 	}
 	bc.futureBlocks.Remove(block.Hash())
 	return status, nil
 }
 
+// updateSnapshot flattens the snapshot diff layers too deep below the new head
+// block into the disk layer. If the head state isn't covered by the snapshot,
+// e.g. after a rewind, a deep reorg or a fast sync, it's regenerated from scratch.
+func (bc *BlockChain) updateSnapshot(head *types.Block) {
+	if bc.snaps == nil {
+		return
+	}
+	if bc.snaps.Snapshot(head.Root()) == nil {
+		bc.snaps.Rebuild(head.Root())
+		return
+	}
+	// The disk layer may still be generated from the tries, so keep it at a state
+	// which won't be garbage collected when the next block is written
+	if err := bc.snaps.Cap(head.Root(), triesInMemory-2); err != nil {
+		log.Warn("Failed to cap snapshot tree", "root", head.Root(), "err", err)
+	}
+}
+
 // addFutureBlock checks if the block is within the max allowed window to get
 // accepted for future processing, and returns an error if the block is too far
 // ahead and was not added.
@@ -1251,7 +1308,7 @@ func (bc *BlockChain) insertChain(chain types.Blocks, verifySeals bool) (int, []
 		if parent == nil {
 			parent = bc.GetHeader(block.ParentHash(), block.NumberU64()-1)
 		}
-		statedb, err := state.New(parent.Root, bc.stateCache)
+		statedb, err := state.NewWithSnapshot(parent.Root, bc.stateCache, bc.snaps)
 		if err != nil {
 			return it.index, events, coalescedLogs, err
 		}
@@ -1672,6 +1729,133 @@ func (bc *BlockChain) update() {
 	}
 }
 
+// freezeLoop periodically moves the canonical blocks that became immutable out
+// of the key-value store into the ancient store.
+func (bc *BlockChain) freezeLoop() {
+	defer bc.wg.Done()
+
+	for {
+		var (
+			head   = bc.CurrentBlock().NumberU64()
+			frozen int
+			err    error
+		)
+		if head >= params.ImmutabilityThreshold {
+			if frozen, err = bc.freeze(head + 1 - params.ImmutabilityThreshold); err != nil {
+				log.Error("Failed to freeze ancient blocks", "err", err)
+			}
+		}
+		// Keep going right away if there are more blocks to freeze
+		if frozen == freezerBatchLimit {
+			select {
+			case <-bc.quit:
+				return
+			default:
+				continue
+			}
+		}
+		select {
+		case <-time.After(freezerRecheckInterval):
+		case <-bc.quit:
+			return
+		}
+	}
+}
+
+// freeze moves the canonical blocks below the given number out of the key-value
+// store into the ancient store, at most freezerBatchLimit of them at a time. The
+// number of blocks moved is returned.
+//
+// Blocks are first appended to the ancient store and flushed to disk, and only then
+// deleted from the key-value store, so that they're always available from either.
+// The genesis is kept in the key-value store too, as it's used to check that the
+// two stores belong to the same chain.
+func (bc *BlockChain) freeze(limit uint64) (int, error) {
+	bc.freezemu.Lock()
+	defer bc.freezemu.Unlock()
+
+	first, err := bc.db.Ancients()
+	if err != nil {
+		return 0, err
+	}
+	if limit > first+freezerBatchLimit {
+		limit = first + freezerBatchLimit
+	}
+	if first >= limit {
+		return 0, nil
+	}
+	var (
+		start  = time.Now()
+		hashes []common.Hash
+	)
+	for number := first; number < limit && !bc.getProcInterrupt(); number++ {
+		hash := rawdb.ReadCanonicalHash(bc.db, number)
+		if hash == (common.Hash{}) {
+			err = fmt.Errorf("canonical hash missing, can't freeze block %d", number)
+			break
+		}
+		header := rawdb.ReadHeaderRLP(bc.db, hash, number)
+		if len(header) == 0 {
+			err = fmt.Errorf("block header missing, can't freeze block %d", number)
+			break
+		}
+		body := rawdb.ReadBodyRLP(bc.db, hash, number)
+		if len(body) == 0 {
+			err = fmt.Errorf("block body missing, can't freeze block %d", number)
+			break
+		}
+		receipts := rawdb.ReadReceiptsRLP(bc.db, hash, number)
+		if len(receipts) == 0 {
+			err = fmt.Errorf("block receipts missing, can't freeze block %d", number)
+			break
+		}
+		td := rawdb.ReadTdRLP(bc.db, hash, number)
+		if len(td) == 0 {
+			err = fmt.Errorf("total difficulty missing, can't freeze block %d", number)
+			break
+		}
+		if err = bc.db.AppendAncient(number, hash.Bytes(), header, body, receipts, td); err != nil {
+			break
+		}
+		hashes = append(hashes, hash)
+	}
+	if len(hashes) == 0 {
+		return 0, err
+	}
+	if err := bc.db.Sync(); err != nil {
+		return 0, err
+	}
+	// Wipe the frozen blocks from the key-value store, along with the side chains
+	// at the same heights which can't become canonical anymore. The hash to number
+	// mappings are kept for lookups by hash.
+	batch := bc.db.NewBatch()
+	for i, hash := range hashes {
+		number := first + uint64(i)
+		if number == 0 {
+			continue
+		}
+		rawdb.DeleteCanonicalHash(batch, number)
+		rawdb.DeleteBlockWithoutNumber(batch, hash, number)
+
+		for _, side := range rawdb.ReadAllHashes(bc.db, number) {
+			if side != hash {
+				rawdb.DeleteBlock(batch, side, number)
+			}
+		}
+		if batch.ValueSize() > ethdb.IdealBatchSize {
+			if err := batch.Write(); err != nil {
+				return 0, err
+			}
+			batch.Reset()
+		}
+	}
+	if err := batch.Write(); err != nil {
+		return 0, err
+	}
+	log.Info("Moved blocks into the ancient store", "count", len(hashes), "number", first+uint64(len(hashes))-1, "hash", hashes[len(hashes)-1], "elapsed", common.PrettyDuration(time.Since(start)))
+	return len(hashes), err
+}
+
 // BadBlocks returns a list of the last 'bad blocks' that the client has seen on the network
 func (bc *BlockChain) BadBlocks() []*types.Block {
 	blocks := make([]*types.Block, 0, bc.badBlocks.Len())
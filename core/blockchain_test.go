@@ -17,8 +17,10 @@
 package core
 
 import (
+	"io/ioutil"
 	"math/big"
 	"math/rand"
+	"os"
 	"sync"
 	"testing"
 	"time"
@@ -32,6 +34,7 @@ import (
 	"github.com/ethereum/go-ethereum/core/vm"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/ethdb/memorydb"
 	"github.com/ethereum/go-ethereum/params"
 )
 
@@ -1603,3 +1606,182 @@ func TestPrunedImportSide(t *testing.T) {
 	testSideImport(t, 1, 10)
 	testSideImport(t, 1, -10)
 }
+
+// Tests that old canonical blocks are moved into the ancient store, remaining
+// accessible through the usual accessors, while side chains at the same heights
+// are dropped.
+func TestFreezeAncients(t *testing.T) {
+	var (
+		key, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		address = crypto.PubkeyToAddress(key.PublicKey)
+		gspec   = &Genesis{
+			Config: params.TestChainConfig,
+			Alloc:  GenesisAlloc{address: {Balance: big.NewInt(1000000000)}},
+		}
+		gendb   = rawdb.NewMemoryDatabase()
+		genesis = gspec.MustCommit(gendb)
+		signer  = types.NewEIP155Signer(gspec.Config.ChainID)
+	)
+	blocks, receipts := GenerateChain(gspec.Config, genesis, ethash.NewFaker(), gendb, 64, func(i int, block *BlockGen) {
+		if i%2 == 0 {
+			tx, err := types.SignTx(types.NewTransaction(block.TxNonce(address), common.Address{0x00}, big.NewInt(1000), params.TxGas, nil, nil), signer, key)
+			if err != nil {
+				panic(err)
+			}
+			block.AddTx(tx)
+		}
+	})
+	forks, _ := GenerateChain(gspec.Config, blocks[8], ethash.NewFaker(), gendb, 1, func(i int, block *BlockGen) {
+		block.SetCoinbase(common.Address{0x01})
+	})
+	// Import the chain into a database with a freezer
+	dir, err := ioutil.TempDir("", "ancient")
+	if err != nil {
+		t.Fatalf("failed to create temp freezer dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	kvdb := memorydb.New()
+	db, err := rawdb.NewDatabaseWithFreezer(kvdb, dir, "")
+	if err != nil {
+		t.Fatalf("failed to create database with freezer: %v", err)
+	}
+	defer db.Close()
+
+	gspec.MustCommit(db)
+	chain, err := NewBlockChain(db, nil, gspec.Config, ethash.NewFaker(), vm.Config{}, nil)
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	defer chain.Stop()
+
+	if n, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert block %d: %v", n, err)
+	}
+	if n, err := chain.InsertChain(forks); err != nil {
+		t.Fatalf("failed to insert fork block %d: %v", n, err)
+	}
+	// Freeze the first half of the chain and make sure it's gone from the key-value
+	// store, genesis excepted
+	if n, err := chain.freeze(33); n != 33 || err != nil {
+		t.Fatalf("frozen blocks mismatch: have %d (%v), want 33", n, err)
+	}
+	if frozen, _ := db.Ancients(); frozen != 33 {
+		t.Fatalf("ancients mismatch: have %d, want 33", frozen)
+	}
+	kvonly := rawdb.NewDatabase(kvdb)
+	if hash := rawdb.ReadCanonicalHash(kvonly, 0); hash != genesis.Hash() {
+		t.Errorf("genesis hash mismatch: have %x, want %x", hash, genesis.Hash())
+	}
+	for _, block := range blocks[:32] {
+		if hash := rawdb.ReadCanonicalHash(kvonly, block.NumberU64()); hash != (common.Hash{}) {
+			t.Errorf("block %d: frozen canonical hash still in the key-value store", block.NumberU64())
+		}
+		if rawdb.HasHeader(kvonly, block.Hash(), block.NumberU64()) || rawdb.HasBody(kvonly, block.Hash(), block.NumberU64()) {
+			t.Errorf("block %d: frozen block still in the key-value store", block.NumberU64())
+		}
+	}
+	if rawdb.HasHeader(db, forks[0].Hash(), forks[0].NumberU64()) {
+		t.Errorf("side chain block not dropped")
+	}
+	// Ensure the frozen blocks are still accessible through the usual accessors
+	for i, block := range blocks {
+		num, hash := block.NumberU64(), block.Hash()
+
+		if canon := rawdb.ReadCanonicalHash(db, num); canon != hash {
+			t.Errorf("block %d: canonical hash mismatch: have %x, want %x", num, canon, hash)
+		}
+		if stored := rawdb.ReadBlock(db, hash, num); stored == nil || stored.Hash() != hash {
+			t.Errorf("block %d: block mismatch: have %v", num, stored)
+		}
+		if stored := rawdb.ReadReceipts(db, hash, num); types.DeriveSha(stored) != types.DeriveSha(receipts[i]) {
+			t.Errorf("block %d: receipts mismatch: have %v, want %v", num, stored, receipts[i])
+		}
+		if td := rawdb.ReadTd(db, hash, num); td == nil || td.Cmp(chain.GetTd(hash, num)) != 0 {
+			t.Errorf("block %d: total difficulty mismatch: have %v", num, td)
+		}
+		for _, tx := range block.Transactions() {
+			if stored, blockHash, _, _ := rawdb.ReadTransaction(db, tx.Hash()); stored == nil || blockHash != hash {
+				t.Errorf("block %d: transaction %x not found", num, tx.Hash())
+			}
+		}
+	}
+	// Rewind the chain below the frozen blocks and make sure they're dropped too
+	if err := chain.SetHead(20); err != nil {
+		t.Fatalf("failed to rewind chain: %v", err)
+	}
+	if frozen, _ := db.Ancients(); frozen != 21 {
+		t.Fatalf("ancients mismatch after rewind: have %d, want 21", frozen)
+	}
+	if hash := rawdb.ReadCanonicalHash(db, 25); hash != (common.Hash{}) {
+		t.Errorf("rewound block still canonical: %x", hash)
+	}
+}
+
+// Tests that the state snapshot follows the chain head, flattening the deep diff
+// layers into the disk and surviving a restart.
+func TestSnapshotFollowsHead(t *testing.T) {
+	var (
+		key, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		address = crypto.PubkeyToAddress(key.PublicKey)
+		gspec   = &Genesis{
+			Config: params.TestChainConfig,
+			Alloc:  GenesisAlloc{address: {Balance: big.NewInt(1000000000)}},
+		}
+		gendb   = rawdb.NewMemoryDatabase()
+		genesis = gspec.MustCommit(gendb)
+		signer  = types.NewEIP155Signer(gspec.Config.ChainID)
+		config  = &CacheConfig{TrieCleanLimit: 256, TrieDirtyLimit: 256, TrieTimeLimit: 5 * time.Minute, Snapshot: true}
+	)
+	blocks, _ := GenerateChain(gspec.Config, genesis, ethash.NewFaker(), gendb, 140, func(i int, block *BlockGen) {
+		tx, err := types.SignTx(types.NewTransaction(block.TxNonce(address), common.Address{byte(i % 10)}, big.NewInt(1000), params.TxGas, nil, nil), signer, key)
+		if err != nil {
+			panic(err)
+		}
+		block.AddTx(tx)
+	})
+	db := rawdb.NewMemoryDatabase()
+	gspec.MustCommit(db)
+
+	chain, err := NewBlockChain(db, config, gspec.Config, ethash.NewFaker(), vm.Config{}, nil)
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	if n, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert block %d: %v", n, err)
+	}
+	// The layers too deep below the head should have been flattened
+	if root, want := rawdb.ReadSnapshotRoot(db), blocks[len(blocks)-triesInMemory+1].Root(); root != want {
+		t.Fatalf("disk layer root mismatch: have %x, want %x", root, want)
+	}
+	check := func(chain *BlockChain) {
+		t.Helper()
+
+		head := chain.CurrentBlock().Root()
+		if chain.snaps.Snapshot(head) == nil {
+			t.Fatalf("head snapshot missing")
+		}
+		snapState, _ := chain.StateAt(head)
+		trieState, _ := state.New(head, chain.stateCache)
+		for _, addr := range []common.Address{address, {0x00}, {0x09}, {0x0a}} {
+			if have, want := snapState.GetBalance(addr), trieState.GetBalance(addr); have.Cmp(want) != 0 {
+				t.Fatalf("account %x: balance mismatch: have %v, want %v", addr, have, want)
+			}
+		}
+	}
+	check(chain)
+	chain.Stop()
+
+	// Stopping the chain flattens the snapshot into the head state, which is then
+	// reused on restart
+	if root := rawdb.ReadSnapshotRoot(db); root != blocks[len(blocks)-1].Root() {
+		t.Fatalf("persisted root mismatch: have %x, want %x", root, blocks[len(blocks)-1].Root())
+	}
+	chain, err = NewBlockChain(db, config, gspec.Config, ethash.NewFaker(), vm.Config{}, nil)
+	if err != nil {
+		t.Fatalf("failed to recreate chain: %v", err)
+	}
+	defer chain.Stop()
+
+	check(chain)
+}
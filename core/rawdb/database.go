@@ -17,15 +17,136 @@
 package rawdb
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/ethdb/bolt"
 	"github.com/ethereum/go-ethereum/ethdb/leveldb"
 	"github.com/ethereum/go-ethereum/ethdb/memorydb"
 )
 
+// freezerdb is a database wrapper that enables freezer data retrievals.
+type freezerdb struct {
+	ethdb.KeyValueStore
+	ethdb.AncientStore
+}
+
+// Close implements io.Closer, closing both the fast key-value store as well as
+// the slow ancient tables.
+func (frdb *freezerdb) Close() error {
+	var errs []error
+	if err := frdb.KeyValueStore.Close(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := frdb.AncientStore.Close(); err != nil {
+		errs = append(errs, err)
+	}
+	if errs != nil {
+		return fmt.Errorf("%v", errs)
+	}
+	return nil
+}
+
+// nofreezedb is a database wrapper that disables freezer data retrievals.
+type nofreezedb struct {
+	ethdb.KeyValueStore
+}
+
+// errNotSupported is returned if a freezer operation is requested from a
+// database without a freezer.
+var errNotSupported = errors.New("this operation is not supported")
+
+// HasAncient returns an error as we don't have a backing chain freezer.
+func (db *nofreezedb) HasAncient(kind string, number uint64) (bool, error) {
+	return false, errNotSupported
+}
+
+// Ancient returns an error as we don't have a backing chain freezer.
+func (db *nofreezedb) Ancient(kind string, number uint64) ([]byte, error) {
+	return nil, errNotSupported
+}
+
+// Ancients returns an error as we don't have a backing chain freezer.
+func (db *nofreezedb) Ancients() (uint64, error) {
+	return 0, errNotSupported
+}
+
+// AncientSize returns an error as we don't have a backing chain freezer.
+func (db *nofreezedb) AncientSize(kind string) (uint64, error) {
+	return 0, errNotSupported
+}
+
+// AppendAncient returns an error as we don't have a backing chain freezer.
+func (db *nofreezedb) AppendAncient(number uint64, hash, header, body, receipts, td []byte) error {
+	return errNotSupported
+}
+
+// TruncateAncients returns an error as we don't have a backing chain freezer.
+func (db *nofreezedb) TruncateAncients(items uint64) error {
+	return errNotSupported
+}
+
+// Sync returns an error as we don't have a backing chain freezer.
+func (db *nofreezedb) Sync() error {
+	return errNotSupported
+}
+
 // NewDatabase creates a high level database on top of a given key-value data
 // store without a freezer moving immutable chain segments into cold storage.
 func NewDatabase(db ethdb.KeyValueStore) ethdb.Database {
-	return db
+	return &nofreezedb{
+		KeyValueStore: db,
+	}
+}
+
+// NewDatabaseWithFreezer creates a high level database on top of a given key-
+// value data store with a freezer moving immutable chain segments into cold
+// storage.
+func NewDatabaseWithFreezer(db ethdb.KeyValueStore, freezer string, namespace string) (ethdb.Database, error) {
+	return newDatabaseWithFreezer(db, freezer, namespace, false)
+}
+
+// newDatabaseWithFreezer creates a high level database on top of a given key-
+// value data store and the freezer in the given directory, optionally opening
+// the freezer in read only mode.
+func newDatabaseWithFreezer(db ethdb.KeyValueStore, freezer string, namespace string, readonly bool) (ethdb.Database, error) {
+	frdb, err := newFreezer(freezer, namespace, readonly)
+	if err != nil {
+		return nil, err
+	}
+	// Refuse to pair the key-value store with the ancients of another chain. The
+	// genesis is never removed from the key-value store, so it can be compared.
+	frozen, _ := frdb.Ancients()
+	if frozen > 0 {
+		kvgenesis, _ := db.Get(headerHashKey(0))
+		ancgenesis, err := frdb.Ancient(freezerHashTable, 0)
+		if err != nil {
+			frdb.Close()
+			return nil, err
+		}
+		if len(kvgenesis) > 0 && !bytes.Equal(kvgenesis, ancgenesis) {
+			frdb.Close()
+			return nil, fmt.Errorf("genesis mismatch: %s (key-value store) != %s (ancients)", common.BytesToHash(kvgenesis).Hex(), common.BytesToHash(ancgenesis).Hex())
+		}
+	} else {
+		// Without any ancients, the key-value store must hold the chain from the
+		// genesis. If it doesn't, the blocks were frozen into another directory.
+		if head := ReadHeaderNumber(db, ReadHeadHeaderHash(db)); head != nil && *head > 0 {
+			if canon, _ := db.Get(headerHashKey(1)); len(canon) == 0 {
+				frdb.Close()
+				return nil, errors.New("gap in the chain between ancients and key-value store")
+			}
+		}
+	}
+	return &freezerdb{
+		KeyValueStore: db,
+		AncientStore:  frdb,
+	}, nil
 }
 
 // NewMemoryDatabase creates an ephemeral in-memory key-value database without a
@@ -41,12 +162,150 @@ func NewMemoryDatabaseWithCap(size int) ethdb.Database {
 	return NewDatabase(memorydb.NewWithCap(size))
 }
 
-// NewLevelDBDatabase creates a persistent key-value database without a freezer
-// moving immutable chain segments into cold storage.
-func NewLevelDBDatabase(file string, cache int, handles int, namespace string) (ethdb.Database, error) {
-	db, err := leveldb.New(file, cache, handles, namespace)
+// Identifiers of the supported persistent key-value store backends.
+const (
+	DBLeveldb = "leveldb" // LevelDB, the default backend
+	DBBolt    = "bolt"    // Bolt, a pure Go B+tree database
+)
+
+// leveldbMarker is a file every LevelDB database directory contains.
+const leveldbMarker = "CURRENT"
+
+// PreexistingDatabase checks the given directory for an existing persistent
+// key-value database and returns the backend it was created with, or an empty
+// string if there is no database in the directory yet.
+func PreexistingDatabase(file string) string {
+	if _, err := os.Stat(filepath.Join(file, bolt.FileName)); err == nil {
+		return DBBolt
+	}
+	if _, err := os.Stat(filepath.Join(file, leveldbMarker)); err == nil {
+		return DBLeveldb
+	}
+	return ""
+}
+
+// openKeyValueDatabase opens a persistent key-value database in the given
+// directory with the requested backend. An empty backend selects the one of the
+// existing database, or LevelDB for new ones. Opening a database with another
+// backend than the one it was created with is refused.
+func openKeyValueDatabase(engine string, file string, cache int, handles int, namespace string, readonly bool) (ethdb.KeyValueStore, error) {
+	existing := PreexistingDatabase(file)
+	if engine == "" {
+		engine = existing
+	}
+	if engine == "" {
+		engine = DBLeveldb
+	}
+	if existing != "" && existing != engine {
+		return nil, fmt.Errorf("database %s was created with %s, cannot open it with %s", file, existing, engine)
+	}
+	switch engine {
+	case DBLeveldb:
+		var (
+			db  *leveldb.Database
+			err error
+		)
+		if readonly {
+			db, err = leveldb.NewReadOnly(file, cache, handles, namespace)
+		} else {
+			db, err = leveldb.New(file, cache, handles, namespace)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return db, nil
+
+	case DBBolt:
+		var (
+			db  *bolt.Database
+			err error
+		)
+		if readonly {
+			db, err = bolt.NewReadOnly(file)
+		} else {
+			db, err = bolt.New(file)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return db, nil
+
+	default:
+		return nil, fmt.Errorf("unknown database engine %q, want %s or %s", engine, DBLeveldb, DBBolt)
+	}
+}
+
+// NewPersistentDatabase creates a persistent key-value database with the given
+// backend, without a freezer moving immutable chain segments into cold storage.
+// An empty backend selects the one of the existing database, or LevelDB for new
+// ones.
+func NewPersistentDatabase(engine string, file string, cache int, handles int, namespace string) (ethdb.Database, error) {
+	db, err := openKeyValueDatabase(engine, file, cache, handles, namespace, false)
+	if err != nil {
+		return nil, err
+	}
+	return NewDatabase(db), nil
+}
+
+// NewPersistentDatabaseWithFreezer creates a persistent key-value database with
+// the given backend and a freezer moving immutable chain segments into cold
+// storage. An empty backend selects the one of the existing database, or LevelDB
+// for new ones.
+func NewPersistentDatabaseWithFreezer(engine string, file string, cache int, handles int, freezer string, namespace string) (ethdb.Database, error) {
+	kvdb, err := openKeyValueDatabase(engine, file, cache, handles, namespace, false)
+	if err != nil {
+		return nil, err
+	}
+	frdb, err := NewDatabaseWithFreezer(kvdb, freezer, namespace)
+	if err != nil {
+		kvdb.Close()
+		return nil, err
+	}
+	return frdb, nil
+}
+
+// NewPersistentDatabaseReadOnly creates a persistent key-value database with the
+// given backend, without a freezer, opened in read only mode. An empty backend
+// selects the one of the existing database.
+func NewPersistentDatabaseReadOnly(engine string, file string, cache int, handles int, namespace string) (ethdb.Database, error) {
+	db, err := openKeyValueDatabase(engine, file, cache, handles, namespace, true)
 	if err != nil {
 		return nil, err
 	}
 	return NewDatabase(db), nil
 }
+
+// NewPersistentDatabaseWithFreezerReadOnly creates a persistent key-value
+// database with the given backend and the freezer holding its immutable chain
+// segments, both opened in read only mode. An empty backend selects the one of
+// the existing database.
+func NewPersistentDatabaseWithFreezerReadOnly(engine string, file string, cache int, handles int, freezer string, namespace string) (ethdb.Database, error) {
+	kvdb, err := openKeyValueDatabase(engine, file, cache, handles, namespace, true)
+	if err != nil {
+		return nil, err
+	}
+	frdb, err := newDatabaseWithFreezer(kvdb, freezer, namespace, true)
+	if err != nil {
+		kvdb.Close()
+		return nil, err
+	}
+	return frdb, nil
+}
+
+// NewLevelDBDatabase creates a persistent key-value database without a freezer
+// moving immutable chain segments into cold storage.
+func NewLevelDBDatabase(file string, cache int, handles int, namespace string) (ethdb.Database, error) {
+	return NewPersistentDatabase(DBLeveldb, file, cache, handles, namespace)
+}
+
+// NewLevelDBDatabaseWithFreezer creates a persistent key-value database with a
+// freezer moving immutable chain segments into cold storage.
+func NewLevelDBDatabaseWithFreezer(file string, cache int, handles int, freezer string, namespace string) (ethdb.Database, error) {
+	return NewPersistentDatabaseWithFreezer(DBLeveldb, file, cache, handles, freezer, namespace)
+}
+
+// NewLevelDBDatabaseReadOnly creates a persistent key-value database without a
+// freezer, opened in read only mode.
+func NewLevelDBDatabaseReadOnly(file string, cache int, handles int, namespace string) (ethdb.Database, error) {
+	return NewPersistentDatabaseReadOnly(DBLeveldb, file, cache, handles, namespace)
+}
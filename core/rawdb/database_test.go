@@ -0,0 +1,106 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"io/ioutil"
+	"math/big"
+	"os"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethdb/memorydb"
+)
+
+// Tests that persistent databases record the backend they were created with and
+// refuse to be opened with another one.
+func TestDatabaseEngineMarker(t *testing.T) {
+	for _, engine := range []string{DBLeveldb, DBBolt} {
+		dir, err := ioutil.TempDir("", "database")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		if have := PreexistingDatabase(dir); have != "" {
+			t.Fatalf("%s: empty directory detected as %q", engine, have)
+		}
+		db, err := NewPersistentDatabase(engine, dir, 0, 0, "")
+		if err != nil {
+			t.Fatalf("%s: failed to create database: %v", engine, err)
+		}
+		if err := db.Put([]byte("key"), []byte("value")); err != nil {
+			t.Fatalf("%s: failed to insert item: %v", engine, err)
+		}
+		db.Close()
+
+		if have := PreexistingDatabase(dir); have != engine {
+			t.Fatalf("%s: database detected as %q", engine, have)
+		}
+		// Opening the database with another backend must fail
+		for _, other := range []string{DBLeveldb, DBBolt} {
+			if other == engine {
+				continue
+			}
+			if db, err := NewPersistentDatabase(other, dir, 0, 0, ""); err == nil {
+				db.Close()
+				t.Fatalf("%s: database opened with %s", engine, other)
+			}
+		}
+		// Opening the database without an explicit backend must pick the right one
+		if db, err = NewPersistentDatabase("", dir, 0, 0, ""); err != nil {
+			t.Fatalf("%s: failed to reopen database: %v", engine, err)
+		}
+		if val, err := db.Get([]byte("key")); err != nil || string(val) != "value" {
+			t.Fatalf("%s: value mismatch: have %q (%v), want %q", engine, val, err, "value")
+		}
+		db.Close()
+	}
+	if _, err := NewPersistentDatabase("unknown", "", 0, 0, ""); err == nil {
+		t.Fatalf("unknown engine accepted")
+	}
+}
+
+// Tests that a key-value store missing the start of its chain isn't paired with
+// an empty ancient store.
+func TestDatabaseFreezerGap(t *testing.T) {
+	dir, err := ioutil.TempDir("", "database")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Store a head header without the canonical chain leading up to it
+	kvdb := memorydb.New()
+	header := &types.Header{Number: big.NewInt(2), Extra: []byte("test header")}
+	WriteHeader(kvdb, header)
+	WriteHeadHeaderHash(kvdb, header.Hash())
+
+	if db, err := NewDatabaseWithFreezer(kvdb, dir, ""); err == nil {
+		db.Close()
+		t.Fatalf("chain gap not detected")
+	}
+	// Store the first canonical block and ensure the gap is gone
+	WriteCanonicalHash(kvdb, common.Hash{0x01}, 1)
+
+	db, err := NewDatabaseWithFreezer(kvdb, dir, "")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	db.Close()
+}
@@ -0,0 +1,265 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/prometheus/prometheus/util/flock"
+)
+
+var (
+	// errUnknownTable is returned if the user attempts to read from a table that is
+	// not tracked by the freezer.
+	errUnknownTable = errors.New("unknown table")
+
+	// errOutOrderInsertion is returned if the user attempts to inject out-of-order
+	// binary blobs into the freezer.
+	errOutOrderInsertion = errors.New("the append operation is out-order")
+
+	// errReadOnly is returned if the user attempts to modify a freezer opened in
+	// read only mode.
+	errReadOnly = errors.New("read only")
+)
+
+// freezer is an append-only database to store immutable chain data into flat
+// files:
+//
+//   - The append only nature ensures that disk writes are minimized.
+//   - The in-order nature of the data allows the files to be stored on a separate,
+//     slower and cheaper disk, as lookups need a single seek each.
+//
+// The freezer only stores canonical blocks, moved over from the key-value store
+// by the blockchain once they're deep enough not to be reorged anymore.
+type freezer struct {
+	frozen uint64 // Number of blocks already frozen (atomically accessed)
+
+	readonly     bool                     // Whether the freezer was opened for reading only
+	tables       map[string]*freezerTable // Data tables for storing everything
+	instanceLock flock.Releaser           // File-system lock to prevent double opens (nil if read only)
+}
+
+// newFreezer creates a chain freezer that moves ancient chain data into
+// append-only flat file containers.
+//
+// In read only mode the freezer must already exist. It neither takes the file
+// lock nor repairs the tables, but only exposes the blocks complete in all of
+// them.
+func newFreezer(datadir string, namespace string, readonly bool) (*freezer, error) {
+	var (
+		readMeter  = metrics.NewRegisteredMeter(namespace+"ancient/read", nil)
+		writeMeter = metrics.NewRegisteredMeter(namespace+"ancient/write", nil)
+	)
+	freezer := &freezer{
+		readonly: readonly,
+		tables:   make(map[string]*freezerTable),
+	}
+	if !readonly {
+		if err := os.MkdirAll(datadir, 0755); err != nil {
+			return nil, err
+		}
+		lock, _, err := flock.New(filepath.Join(datadir, "FLOCK"))
+		if err != nil {
+			return nil, err
+		}
+		freezer.instanceLock = lock
+	}
+	for name, disableSnappy := range freezerNoSnappy {
+		table, err := newTable(datadir, name, readMeter, writeMeter, disableSnappy, readonly)
+		if err != nil {
+			for _, table := range freezer.tables {
+				table.Close()
+			}
+			if freezer.instanceLock != nil {
+				freezer.instanceLock.Release()
+			}
+			return nil, err
+		}
+		freezer.tables[name] = table
+	}
+	if err := freezer.repair(); err != nil {
+		freezer.Close()
+		return nil, err
+	}
+	log.Info("Opened ancient database", "database", datadir, "frozen", freezer.frozen, "readonly", readonly)
+	return freezer, nil
+}
+
+// Close terminates the chain freezer, closing all the data files.
+func (f *freezer) Close() error {
+	var errs []error
+	for _, table := range f.tables {
+		if err := table.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if f.instanceLock != nil {
+		if err := f.instanceLock.Release(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if errs != nil {
+		return fmt.Errorf("%v", errs)
+	}
+	return nil
+}
+
+// HasAncient returns an indicator whether the specified ancient data exists
+// in the freezer.
+func (f *freezer) HasAncient(kind string, number uint64) (bool, error) {
+	if table := f.tables[kind]; table != nil {
+		return table.has(number), nil
+	}
+	return false, nil
+}
+
+// Ancient retrieves an ancient binary blob from the append-only immutable files.
+func (f *freezer) Ancient(kind string, number uint64) ([]byte, error) {
+	if table := f.tables[kind]; table != nil {
+		return table.Retrieve(number)
+	}
+	return nil, errUnknownTable
+}
+
+// Ancients returns the length of the frozen items.
+func (f *freezer) Ancients() (uint64, error) {
+	return atomic.LoadUint64(&f.frozen), nil
+}
+
+// AncientSize returns the ancient size of the specified category.
+func (f *freezer) AncientSize(kind string) (uint64, error) {
+	if table := f.tables[kind]; table != nil {
+		return table.size()
+	}
+	return 0, errUnknownTable
+}
+
+// AppendAncient injects all binary blobs belong to block at the end of the
+// append-only immutable table files.
+//
+// Out-of-order injections are rejected, but the method is not safe for concurrent
+// use: the blockchain is the only writer, appending blocks one after the other.
+func (f *freezer) AppendAncient(number uint64, hash, header, body, receipts, td []byte) (err error) {
+	if f.readonly {
+		return errReadOnly
+	}
+	// Ensure the binary blobs we are appending are continuous with the freezer
+	if atomic.LoadUint64(&f.frozen) != number {
+		return errOutOrderInsertion
+	}
+	// Roll back all the inserted data if any insertion below fails, to keep the
+	// tables in sync
+	defer func() {
+		if err != nil {
+			if rerr := f.repair(); rerr != nil {
+				log.Crit("Failed to repair freezer", "err", rerr)
+			}
+			log.Info("Append ancient failed", "number", number, "err", err)
+		}
+	}()
+	// Inject all the components into the relevant data tables
+	if err := f.tables[freezerHashTable].Append(number, hash); err != nil {
+		log.Error("Failed to append ancient hash", "number", number, "hash", common.BytesToHash(hash), "err", err)
+		return err
+	}
+	if err := f.tables[freezerHeaderTable].Append(number, header); err != nil {
+		log.Error("Failed to append ancient header", "number", number, "hash", common.BytesToHash(hash), "err", err)
+		return err
+	}
+	if err := f.tables[freezerBodiesTable].Append(number, body); err != nil {
+		log.Error("Failed to append ancient body", "number", number, "hash", common.BytesToHash(hash), "err", err)
+		return err
+	}
+	if err := f.tables[freezerReceiptTable].Append(number, receipts); err != nil {
+		log.Error("Failed to append ancient receipts", "number", number, "hash", common.BytesToHash(hash), "err", err)
+		return err
+	}
+	if err := f.tables[freezerDifficultyTable].Append(number, td); err != nil {
+		log.Error("Failed to append ancient difficulty", "number", number, "hash", common.BytesToHash(hash), "err", err)
+		return err
+	}
+	atomic.AddUint64(&f.frozen, 1) // Only modify atomically
+	return nil
+}
+
+// TruncateAncients discards any recent data above the provided threshold number.
+func (f *freezer) TruncateAncients(items uint64) error {
+	if f.readonly {
+		return errReadOnly
+	}
+	if atomic.LoadUint64(&f.frozen) <= items {
+		return nil
+	}
+	for _, table := range f.tables {
+		if err := table.truncate(items); err != nil {
+			return err
+		}
+	}
+	atomic.StoreUint64(&f.frozen, items)
+	return nil
+}
+
+// Sync flushes all data tables to disk.
+func (f *freezer) Sync() error {
+	if f.readonly {
+		return errReadOnly
+	}
+	var errs []error
+	for _, table := range f.tables {
+		if err := table.Sync(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if errs != nil {
+		return fmt.Errorf("%v", errs)
+	}
+	return nil
+}
+
+// repair truncates all data tables to the same length, dropping the parts of a
+// block left behind by a crash in the middle of an append. In read only mode the
+// tables are only limited to the common length in memory.
+func (f *freezer) repair() error {
+	min := uint64(math.MaxUint64)
+	for _, table := range f.tables {
+		items := atomic.LoadUint64(&table.items)
+		if min > items {
+			min = items
+		}
+	}
+	for _, table := range f.tables {
+		if f.readonly {
+			if atomic.LoadUint64(&table.items) > min {
+				atomic.StoreUint64(&table.items, min)
+			}
+			continue
+		}
+		if err := table.truncate(min); err != nil {
+			return err
+		}
+	}
+	atomic.StoreUint64(&f.frozen, min)
+	return nil
+}
@@ -0,0 +1,481 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/golang/snappy"
+)
+
+var (
+	// errClosed is returned if an operation attempts to read from or write to the
+	// freezer table after it has already been closed.
+	errClosed = errors.New("closed")
+
+	// errOutOfBounds is returned if the item requested is not contained within the
+	// freezer table.
+	errOutOfBounds = errors.New("out of bounds")
+)
+
+// indexEntrySize is the size of a serialized index entry: a 2 byte file number
+// followed by a 4 byte offset.
+const indexEntrySize = 6
+
+// indexEntry contains the number of the data file an item resides in, as well as
+// the offset within that file to the end of the item.
+type indexEntry struct {
+	filenum uint32 // stored as uint16 (2 bytes)
+	offset  uint32 // stored as uint32 (4 bytes)
+}
+
+// unmarshalBinary deserializes a binary blob into an index entry.
+func (i *indexEntry) unmarshalBinary(b []byte) {
+	i.filenum = uint32(binary.BigEndian.Uint16(b[:2]))
+	i.offset = binary.BigEndian.Uint32(b[2:6])
+}
+
+// marshallBinary serializes an index entry into a binary blob.
+func (i *indexEntry) marshallBinary() []byte {
+	b := make([]byte, indexEntrySize)
+	binary.BigEndian.PutUint16(b[:2], uint16(i.filenum))
+	binary.BigEndian.PutUint32(b[2:6], i.offset)
+	return b
+}
+
+// freezerTable is a single append-only data table of the freezer (e.g. headers).
+// Items are stored back to back in a sequence of data files of bounded size, and
+// an index file holds the position of the end of each item. The first index entry
+// is a sentinel marking the start of the first item.
+type freezerTable struct {
+	items uint64 // Number of items stored in the table (atomically accessed)
+
+	noCompression bool   // Whether to disable snappy compression of the items
+	readonly      bool   // Whether the table files are opened for reading only
+	maxFileSize   uint32 // Maximum size of a data file before starting a new one
+	name          string // Name of the table, used for file naming and logging
+	path          string // Folder containing the table files
+
+	head    *os.File            // Data file currently being appended to
+	headId  uint32              // Number of the head data file
+	headLen uint32              // Number of bytes already in the head data file
+	files   map[uint32]*os.File // All the data files of the table, including the head
+	index   *os.File            // Index file with the item end positions
+
+	readMeter  metrics.Meter // Meter for measuring the effective amount of data read
+	writeMeter metrics.Meter // Meter for measuring the effective amount of data written
+
+	logger log.Logger   // Logger with the table path embedded
+	lock   sync.RWMutex // Mutex protecting the data files from concurrent access
+}
+
+// newTable opens a freezer table with the default maximum data file size, or
+// creates a new one if it doesn't exist yet.
+func newTable(path string, name string, readMeter metrics.Meter, writeMeter metrics.Meter, disableSnappy bool, readonly bool) (*freezerTable, error) {
+	return newCustomTable(path, name, readMeter, writeMeter, 2*1000*1000*1000, disableSnappy, readonly)
+}
+
+// newCustomTable opens a freezer table, creating it if it doesn't exist yet, and
+// repairs any inconsistency between its index and data files left by a crash.
+//
+// In read only mode the table must already exist, and inconsistencies are worked
+// around by ignoring the partially written items instead of truncating them.
+func newCustomTable(path string, name string, readMeter metrics.Meter, writeMeter metrics.Meter, maxFilesize uint32, noCompression bool, readonly bool) (*freezerTable, error) {
+	flags := os.O_RDONLY
+	if !readonly {
+		if err := os.MkdirAll(path, 0755); err != nil {
+			return nil, err
+		}
+		flags = os.O_RDWR | os.O_CREATE | os.O_APPEND
+	}
+	idxName := fmt.Sprintf("%s.ridx", name)
+	if !noCompression {
+		idxName = fmt.Sprintf("%s.cidx", name)
+	}
+	index, err := os.OpenFile(filepath.Join(path, idxName), flags, 0644)
+	if err != nil {
+		return nil, err
+	}
+	tab := &freezerTable{
+		noCompression: noCompression,
+		readonly:      readonly,
+		maxFileSize:   maxFilesize,
+		name:          name,
+		path:          path,
+		files:         make(map[uint32]*os.File),
+		index:         index,
+		readMeter:     readMeter,
+		writeMeter:    writeMeter,
+		logger:        log.New("database", path, "table", name),
+	}
+	if err := tab.repair(); err != nil {
+		tab.Close()
+		return nil, err
+	}
+	return tab, nil
+}
+
+// repair cross checks the index and the head data file, truncating whichever is
+// ahead of the other to the last item fully written to both.
+func (t *freezerTable) repair() error {
+	stat, err := t.index.Stat()
+	if err != nil {
+		return err
+	}
+	// Write the sentinel entry into a new index and drop any partial entry
+	if stat.Size() == 0 {
+		if t.readonly {
+			return fmt.Errorf("freezer table %s not initialized", t.name)
+		}
+		if _, err := t.index.Write((&indexEntry{}).marshallBinary()); err != nil {
+			return err
+		}
+		stat, err = t.index.Stat()
+		if err != nil {
+			return err
+		}
+	}
+	offsetsSize := stat.Size()
+	if overflow := offsetsSize % indexEntrySize; overflow != 0 {
+		offsetsSize -= overflow
+		if !t.readonly {
+			if err := t.index.Truncate(offsetsSize); err != nil {
+				return err
+			}
+		}
+	}
+	// Open all the data files referenced by the index
+	var first, last indexEntry
+	if err := t.readEntry(0, &first); err != nil {
+		return err
+	}
+	if err := t.readEntry(uint64(offsetsSize/indexEntrySize-1), &last); err != nil {
+		return err
+	}
+	for id := first.filenum; id <= last.filenum; id++ {
+		if _, err := t.openFile(id); err != nil {
+			return err
+		}
+	}
+	t.headId, t.head = last.filenum, t.files[last.filenum]
+
+	stat, err = t.head.Stat()
+	if err != nil {
+		return err
+	}
+	contentSize := stat.Size()
+
+	// Drop the items missing from the head data file, and the data not referenced
+	// by the index yet
+	for contentSize != int64(last.offset) {
+		if contentSize > int64(last.offset) {
+			if t.readonly {
+				break
+			}
+			t.logger.Warn("Truncating dangling head", "indexed", common.StorageSize(last.offset), "stored", common.StorageSize(contentSize))
+			if err := t.head.Truncate(int64(last.offset)); err != nil {
+				return err
+			}
+			contentSize = int64(last.offset)
+			continue
+		}
+		offsetsSize -= indexEntrySize
+		if !t.readonly {
+			t.logger.Warn("Truncating dangling indexes", "indexed", common.StorageSize(last.offset), "stored", common.StorageSize(contentSize))
+			if err := t.index.Truncate(offsetsSize); err != nil {
+				return err
+			}
+		}
+		if err := t.readEntry(uint64(offsetsSize/indexEntrySize-1), &last); err != nil {
+			return err
+		}
+		// Step back to the previous data file if the head one became empty
+		if last.filenum != t.headId {
+			if err := t.releaseFilesAfter(last.filenum, !t.readonly); err != nil {
+				return err
+			}
+			t.headId, t.head = last.filenum, t.files[last.filenum]
+			if stat, err = t.head.Stat(); err != nil {
+				return err
+			}
+			contentSize = stat.Size()
+		}
+	}
+	if !t.readonly {
+		if err := t.index.Sync(); err != nil {
+			return err
+		}
+		if err := t.head.Sync(); err != nil {
+			return err
+		}
+	}
+	t.items = uint64(offsetsSize/indexEntrySize - 1)
+	t.headLen = last.offset
+
+	t.logger.Debug("Chain freezer table opened", "items", t.items, "size", common.StorageSize(t.headLen))
+	return nil
+}
+
+// readEntry reads the index entry at the given position.
+func (t *freezerTable) readEntry(n uint64, entry *indexEntry) error {
+	buffer := make([]byte, indexEntrySize)
+	if _, err := t.index.ReadAt(buffer, int64(n*indexEntrySize)); err != nil {
+		return err
+	}
+	entry.unmarshalBinary(buffer)
+	return nil
+}
+
+// openFile opens the data file with the given number, creating it if needed and
+// not in read only mode.
+func (t *freezerTable) openFile(num uint32) (*os.File, error) {
+	if f, ok := t.files[num]; ok {
+		return f, nil
+	}
+	name := fmt.Sprintf("%s.%04d.rdat", t.name, num)
+	if !t.noCompression {
+		name = fmt.Sprintf("%s.%04d.cdat", t.name, num)
+	}
+	flags := os.O_RDWR | os.O_CREATE | os.O_APPEND
+	if t.readonly {
+		flags = os.O_RDONLY
+	}
+	f, err := os.OpenFile(filepath.Join(t.path, name), flags, 0644)
+	if err != nil {
+		return nil, err
+	}
+	t.files[num] = f
+	return f, nil
+}
+
+// releaseFilesAfter closes all the data files with a number greater than the one
+// given, optionally removing them from disk.
+func (t *freezerTable) releaseFilesAfter(num uint32, remove bool) error {
+	for id, f := range t.files {
+		if id <= num {
+			continue
+		}
+		delete(t.files, id)
+		if err := f.Close(); err != nil {
+			return err
+		}
+		if remove {
+			if err := os.Remove(f.Name()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// truncate discards any recent data above the provided threshold number.
+func (t *freezerTable) truncate(items uint64) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.index == nil {
+		return errClosed
+	}
+	existing := atomic.LoadUint64(&t.items)
+	if existing <= items {
+		return nil
+	}
+	t.logger.Warn("Truncating freezer table", "items", existing, "limit", items)
+	if err := t.index.Truncate(int64(items+1) * indexEntrySize); err != nil {
+		return err
+	}
+	var expected indexEntry
+	if err := t.readEntry(items, &expected); err != nil {
+		return err
+	}
+	// Drop the data files after the new head, and cut the head itself
+	if expected.filenum != t.headId {
+		if err := t.releaseFilesAfter(expected.filenum, true); err != nil {
+			return err
+		}
+		t.headId, t.head = expected.filenum, t.files[expected.filenum]
+	}
+	if err := t.head.Truncate(int64(expected.offset)); err != nil {
+		return err
+	}
+	t.headLen = expected.offset
+	atomic.StoreUint64(&t.items, items)
+	return nil
+}
+
+// Close closes all the open files of the table.
+func (t *freezerTable) Close() error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	var errs []error
+	if t.index != nil {
+		if err := t.index.Close(); err != nil {
+			errs = append(errs, err)
+		}
+		t.index = nil
+	}
+	for id, f := range t.files {
+		if err := f.Close(); err != nil {
+			errs = append(errs, err)
+		}
+		delete(t.files, id)
+	}
+	t.head = nil
+
+	if errs != nil {
+		return fmt.Errorf("%v", errs)
+	}
+	return nil
+}
+
+// Append injects a binary blob at the end of the freezer table. The item number
+// is a precautionary parameter to ensure data correctness, but the table will
+// reject already existing data.
+//
+// Note, this method will *not* flush any data to disk so be sure to explicitly
+// fsync before irreversibly deleting data from the database.
+func (t *freezerTable) Append(item uint64, blob []byte) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.index == nil || t.head == nil {
+		return errClosed
+	}
+	if items := atomic.LoadUint64(&t.items); items != item {
+		return fmt.Errorf("appending unexpected item: want %d, have %d", items, item)
+	}
+	if !t.noCompression {
+		blob = snappy.Encode(nil, blob)
+	}
+	// Start a new data file if the item doesn't fit into the head one
+	bLen := uint32(len(blob))
+	if t.headLen+bLen < bLen || t.headLen+bLen > t.maxFileSize {
+		head, err := t.openFile(t.headId + 1)
+		if err != nil {
+			return err
+		}
+		if err := t.head.Sync(); err != nil {
+			return err
+		}
+		t.head, t.headId, t.headLen = head, t.headId+1, 0
+	}
+	if _, err := t.head.Write(blob); err != nil {
+		return err
+	}
+	t.headLen += bLen
+
+	entry := indexEntry{filenum: t.headId, offset: t.headLen}
+	if _, err := t.index.Write(entry.marshallBinary()); err != nil {
+		return err
+	}
+	t.writeMeter.Mark(int64(bLen + indexEntrySize))
+	atomic.AddUint64(&t.items, 1)
+	return nil
+}
+
+// Retrieve looks up the data offset of an item with the given number and retrieves
+// the raw binary blob from the data file.
+func (t *freezerTable) Retrieve(item uint64) ([]byte, error) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	if t.index == nil || t.head == nil {
+		return nil, errClosed
+	}
+	if atomic.LoadUint64(&t.items) <= item {
+		return nil, errOutOfBounds
+	}
+	var start, end indexEntry
+	if err := t.readEntry(item, &start); err != nil {
+		return nil, err
+	}
+	if err := t.readEntry(item+1, &end); err != nil {
+		return nil, err
+	}
+	// Items starting a new data file are located at its beginning
+	if start.filenum != end.filenum {
+		start.offset = 0
+	}
+	f, ok := t.files[end.filenum]
+	if !ok {
+		return nil, fmt.Errorf("missing data file %d", end.filenum)
+	}
+	blob := make([]byte, end.offset-start.offset)
+	if _, err := f.ReadAt(blob, int64(start.offset)); err != nil {
+		return nil, err
+	}
+	t.readMeter.Mark(int64(len(blob) + 2*indexEntrySize))
+
+	if t.noCompression {
+		return blob, nil
+	}
+	return snappy.Decode(nil, blob)
+}
+
+// has returns an indicator whether the specified number data exists in the
+// freezer table.
+func (t *freezerTable) has(number uint64) bool {
+	return atomic.LoadUint64(&t.items) > number
+}
+
+// size returns the total data size in the freezer table.
+func (t *freezerTable) size() (uint64, error) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	if t.index == nil {
+		return 0, errClosed
+	}
+	stat, err := t.index.Stat()
+	if err != nil {
+		return 0, err
+	}
+	total := uint64(stat.Size())
+	for _, f := range t.files {
+		stat, err := f.Stat()
+		if err != nil {
+			return 0, err
+		}
+		total += uint64(stat.Size())
+	}
+	return total, nil
+}
+
+// Sync pushes any pending data from memory out to disk. This is an expensive
+// operation, so use it with care.
+func (t *freezerTable) Sync() error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.index == nil || t.head == nil {
+		return errClosed
+	}
+	if err := t.index.Sync(); err != nil {
+		return err
+	}
+	return t.head.Sync()
+}
@@ -0,0 +1,219 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+// getChunk returns a chunk of data of the given size, filled with the byte b.
+func getChunk(size int, b int) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(b)
+	}
+	return data
+}
+
+// openTestTable opens a freezer table in the given folder with small data files,
+// so that the items are spread over several of them.
+func openTestTable(t *testing.T, dir string, noCompression bool) *freezerTable {
+	table, err := newCustomTable(dir, "test", metrics.NewMeter(), metrics.NewMeter(), 50, noCompression, false)
+	if err != nil {
+		t.Fatalf("failed to open table: %v", err)
+	}
+	return table
+}
+
+// checkItems verifies that the table contains exactly the first n items written
+// by fillTable.
+func checkItems(t *testing.T, table *freezerTable, n int) {
+	t.Helper()
+
+	for i := 0; i < n; i++ {
+		blob, err := table.Retrieve(uint64(i))
+		if err != nil {
+			t.Fatalf("item %d: failed to retrieve: %v", i, err)
+		}
+		if want := getChunk(15, i); !bytes.Equal(blob, want) {
+			t.Fatalf("item %d: content mismatch: have %x, want %x", i, blob, want)
+		}
+	}
+	if _, err := table.Retrieve(uint64(n)); err != errOutOfBounds {
+		t.Fatalf("item %d: error mismatch: have %v, want %v", n, err, errOutOfBounds)
+	}
+}
+
+// fillTable appends n 15 byte items to the table, three fitting into a data file.
+func fillTable(t *testing.T, table *freezerTable, n int) {
+	for i := 0; i < n; i++ {
+		if err := table.Append(uint64(i), getChunk(15, i)); err != nil {
+			t.Fatalf("item %d: failed to append: %v", i, err)
+		}
+	}
+}
+
+// Tests that items can be appended and retrieved across data files, both before
+// and after reopening the table.
+func TestFreezerBasics(t *testing.T) {
+	for _, noCompression := range []bool{false, true} {
+		t.Run(fmt.Sprintf("nocompression-%v", noCompression), func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "freezer")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			table := openTestTable(t, dir, noCompression)
+			fillTable(t, table, 255)
+			checkItems(t, table, 255)
+
+			if err := table.Append(300, getChunk(15, 0)); err == nil {
+				t.Fatalf("out of order append succeeded")
+			}
+			table.Close()
+
+			table = openTestTable(t, dir, noCompression)
+			defer table.Close()
+			checkItems(t, table, 255)
+		})
+	}
+}
+
+// Tests that a table with a partially written item, in either the index or the
+// data files, is repaired to the last complete item on reopen.
+func TestFreezerRepair(t *testing.T) {
+	dir, err := ioutil.TempDir("", "freezer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	table := openTestTable(t, dir, true)
+	fillTable(t, table, 10)
+	table.Close()
+
+	// Cut the last data file in half, dropping the last item
+	data := filepath.Join(dir, "test.0003.rdat")
+	if err := os.Truncate(data, 8); err != nil {
+		t.Fatal(err)
+	}
+	table = openTestTable(t, dir, true)
+	checkItems(t, table, 9)
+	table.Close()
+
+	// Cut the last index entry in half, dropping another item and leaving its data
+	// dangling
+	index := filepath.Join(dir, "test.ridx")
+	stat, err := os.Stat(index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(index, stat.Size()-3); err != nil {
+		t.Fatal(err)
+	}
+	table = openTestTable(t, dir, true)
+	checkItems(t, table, 8)
+
+	// Make sure the table can keep on growing after the repair
+	if err := table.Append(8, getChunk(15, 8)); err != nil {
+		t.Fatalf("failed to append after repair: %v", err)
+	}
+	checkItems(t, table, 9)
+	table.Close()
+}
+
+// Tests that truncating a table drops the items and data files above the limit,
+// and that new items can be appended afterwards.
+func TestFreezerTruncate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "freezer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	table := openTestTable(t, dir, true)
+	defer table.Close()
+
+	fillTable(t, table, 30)
+	if err := table.truncate(4); err != nil {
+		t.Fatalf("failed to truncate: %v", err)
+	}
+	checkItems(t, table, 4)
+
+	if _, err := os.Stat(filepath.Join(dir, "test.0002.rdat")); !os.IsNotExist(err) {
+		t.Fatalf("data file above the limit not removed: %v", err)
+	}
+	for i := 4; i < 12; i++ {
+		if err := table.Append(uint64(i), getChunk(15, i)); err != nil {
+			t.Fatalf("item %d: failed to append: %v", i, err)
+		}
+	}
+	checkItems(t, table, 12)
+}
+
+// Tests that the freezer keeps its tables in sync and rejects gaps.
+func TestFreezerAppendAncient(t *testing.T) {
+	dir, err := ioutil.TempDir("", "freezer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	f, err := newFreezer(dir, "", false)
+	if err != nil {
+		t.Fatalf("failed to open freezer: %v", err)
+	}
+	for i := uint64(0); i < 5; i++ {
+		blob := []byte{byte(i)}
+		if err := f.AppendAncient(i, blob, blob, blob, blob, blob); err != nil {
+			t.Fatalf("block %d: failed to append: %v", i, err)
+		}
+	}
+	if err := f.AppendAncient(6, nil, nil, nil, nil, nil); err != errOutOrderInsertion {
+		t.Fatalf("gap error mismatch: have %v, want %v", err, errOutOrderInsertion)
+	}
+	// Drop the last block from a single table and ensure reopening realigns them
+	if err := f.tables[freezerBodiesTable].truncate(4); err != nil {
+		t.Fatalf("failed to truncate bodies: %v", err)
+	}
+	f.Close()
+
+	if f, err = newFreezer(dir, "", false); err != nil {
+		t.Fatalf("failed to reopen freezer: %v", err)
+	}
+	defer f.Close()
+
+	if frozen, _ := f.Ancients(); frozen != 4 {
+		t.Fatalf("frozen blocks mismatch: have %d, want 4", frozen)
+	}
+	for kind := range freezerNoSnappy {
+		if blob, err := f.Ancient(kind, 3); err != nil || !bytes.Equal(blob, []byte{3}) {
+			t.Errorf("%s: item mismatch: have %x (%v), want 03", kind, blob, err)
+		}
+		if has, _ := f.HasAncient(kind, 4); has {
+			t.Errorf("%s: dropped item still present", kind)
+		}
+	}
+}
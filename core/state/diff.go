@@ -0,0 +1,187 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package state
+
+import (
+	"bytes"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// AccountDiff is the change of a single account made since the state was last
+// finalised, with the values before and after. Unchanged fields are nil.
+type AccountDiff struct {
+	Created   bool `json:"created,omitempty"`   // Account did not exist before
+	Destroyed bool `json:"destroyed,omitempty"` // Account self destructed
+
+	Balance *BalanceDiff                 `json:"balance,omitempty"`
+	Nonce   *NonceDiff                   `json:"nonce,omitempty"`
+	Code    *CodeDiff                    `json:"code,omitempty"`
+	Storage map[common.Hash]*StorageDiff `json:"storage,omitempty"`
+}
+
+// BalanceDiff is the change of an account balance.
+type BalanceDiff struct {
+	From *hexutil.Big `json:"from"`
+	To   *hexutil.Big `json:"to"`
+}
+
+// NonceDiff is the change of an account nonce.
+type NonceDiff struct {
+	From hexutil.Uint64 `json:"from"`
+	To   hexutil.Uint64 `json:"to"`
+}
+
+// CodeDiff is the change of an account code.
+type CodeDiff struct {
+	From hexutil.Bytes `json:"from"`
+	To   hexutil.Bytes `json:"to"`
+}
+
+// StorageDiff is the change of a storage slot.
+type StorageDiff struct {
+	From common.Hash `json:"from"`
+	To   common.Hash `json:"to"`
+}
+
+// accountOrigin is the original content of an account, as far as it was
+// modified according to the journal.
+type accountOrigin struct {
+	created bool
+	balance *big.Int
+	nonce   *uint64
+	code    []byte
+	codeSet bool
+	storage map[common.Hash]common.Hash
+}
+
+// Diff returns the changes made to the state since it was last finalised, that
+// is the changes of the transaction being executed, derived from the journal.
+// Reverted changes are not included, nor are accounts only touched. Destroyed
+// accounts only report the storage slots modified before their destruction.
+//
+// Diff must be called before Finalise, which discards the journal.
+func (self *StateDB) Diff() map[common.Address]*AccountDiff {
+	// Gather the original values from the first journal entry changing them
+	origins := make(map[common.Address]*accountOrigin)
+	origin := func(addr common.Address) *accountOrigin {
+		if origins[addr] == nil {
+			origins[addr] = &accountOrigin{storage: make(map[common.Hash]common.Hash)}
+		}
+		return origins[addr]
+	}
+	for _, entry := range self.journal.entries {
+		switch entry := entry.(type) {
+		case createObjectChange:
+			acc := origin(*entry.account)
+			acc.created = true
+			if acc.balance == nil {
+				acc.balance = new(big.Int)
+			}
+			if acc.nonce == nil {
+				acc.nonce = new(uint64)
+			}
+			acc.codeSet = true
+
+		case resetObjectChange:
+			acc := origin(entry.prev.address)
+			if acc.balance == nil {
+				acc.balance = new(big.Int).Set(entry.prev.data.Balance)
+			}
+			if acc.nonce == nil {
+				nonce := entry.prev.data.Nonce
+				acc.nonce = &nonce
+			}
+			if !acc.codeSet {
+				acc.code, acc.codeSet = entry.prev.Code(self.db), true
+			}
+
+		case suicideChange:
+			if acc := origin(*entry.account); acc.balance == nil {
+				acc.balance = entry.prevbalance
+			}
+
+		case balanceChange:
+			if acc := origin(*entry.account); acc.balance == nil {
+				acc.balance = entry.prev
+			}
+
+		case nonceChange:
+			if acc := origin(*entry.account); acc.nonce == nil {
+				nonce := entry.prev
+				acc.nonce = &nonce
+			}
+
+		case codeChange:
+			if acc := origin(*entry.account); !acc.codeSet {
+				acc.code, acc.codeSet = entry.prevcode, true
+			}
+
+		case storageChange:
+			acc := origin(*entry.account)
+			if _, ok := acc.storage[entry.key]; !ok {
+				acc.storage[entry.key] = entry.prevalue
+			}
+		}
+	}
+	// Compare the original values with the current ones
+	diffs := make(map[common.Address]*AccountDiff)
+	for addr, acc := range origins {
+		diff := &AccountDiff{
+			Created:   acc.created,
+			Destroyed: self.HasSuicided(addr),
+		}
+		var (
+			balance = new(big.Int).Set(self.GetBalance(addr))
+			nonce   = self.GetNonce(addr)
+			code    = self.GetCode(addr)
+		)
+		if diff.Destroyed {
+			nonce, code = 0, nil
+		}
+		if acc.balance != nil && acc.balance.Cmp(balance) != 0 {
+			diff.Balance = &BalanceDiff{From: (*hexutil.Big)(acc.balance), To: (*hexutil.Big)(balance)}
+		}
+		if acc.nonce != nil && *acc.nonce != nonce {
+			diff.Nonce = &NonceDiff{From: hexutil.Uint64(*acc.nonce), To: hexutil.Uint64(nonce)}
+		}
+		if acc.codeSet && !bytes.Equal(acc.code, code) {
+			diff.Code = &CodeDiff{From: acc.code, To: code}
+		}
+		for key, prev := range acc.storage {
+			var value common.Hash
+			if !diff.Destroyed {
+				value = self.GetState(addr, key)
+			}
+			if value != prev {
+				if diff.Storage == nil {
+					diff.Storage = make(map[common.Hash]*StorageDiff)
+				}
+				diff.Storage[key] = &StorageDiff{From: prev, To: value}
+			}
+		}
+		// Skip accounts without any effective change, including empty ones
+		// created and deleted again
+		if diff.Balance == nil && diff.Nonce == nil && diff.Code == nil && diff.Storage == nil && !diff.Destroyed {
+			continue
+		}
+		diffs[addr] = diff
+	}
+	return diffs
+}
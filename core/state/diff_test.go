@@ -0,0 +1,111 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package state
+
+import (
+	"encoding/json"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+)
+
+// Tests that the state diff is derived from the surviving journal entries,
+// reporting the values before and after for changed fields only.
+func TestDiff(t *testing.T) {
+	db := NewDatabase(rawdb.NewMemoryDatabase())
+	state, _ := New(common.Hash{}, db)
+
+	var (
+		changed   = common.HexToAddress("0x01")
+		created   = common.HexToAddress("0x02")
+		destroyed = common.HexToAddress("0x03")
+		touched   = common.HexToAddress("0x04")
+		restored  = common.HexToAddress("0x05")
+	)
+	state.SetBalance(changed, big.NewInt(10))
+	state.SetNonce(changed, 1)
+	state.SetState(changed, common.HexToHash("0x01"), common.HexToHash("0x01"))
+	state.SetBalance(destroyed, big.NewInt(3))
+	state.SetState(destroyed, common.HexToHash("0x01"), common.HexToHash("0x01"))
+	state.SetBalance(restored, big.NewInt(1))
+	state.SetState(restored, common.HexToHash("0x01"), common.HexToHash("0x01"))
+
+	root, _ := state.Commit(false)
+	state, _ = New(root, db)
+
+	// Modify the state as a transaction would, reverting some of the changes
+	state.AddBalance(changed, big.NewInt(5))
+	state.SetNonce(changed, 2)
+	state.SetState(changed, common.HexToHash("0x01"), common.HexToHash("0x02"))
+	state.SetState(changed, common.HexToHash("0x02"), common.HexToHash("0x03"))
+
+	snapshot := state.Snapshot()
+	state.SetState(changed, common.HexToHash("0x03"), common.HexToHash("0x04"))
+	state.SetBalance(restored, big.NewInt(100))
+	state.RevertToSnapshot(snapshot)
+
+	state.CreateAccount(created)
+	state.SetBalance(created, big.NewInt(7))
+	state.SetCode(created, []byte{0x60, 0x00})
+
+	state.SetState(destroyed, common.HexToHash("0x01"), common.HexToHash("0x02"))
+	state.Suicide(destroyed)
+
+	state.AddBalance(touched, new(big.Int))
+
+	state.SetState(restored, common.HexToHash("0x01"), common.HexToHash("0x02"))
+	state.SetState(restored, common.HexToHash("0x01"), common.HexToHash("0x01"))
+
+	bal := func(n int64) *hexutil.Big { return (*hexutil.Big)(new(big.Int).SetInt64(n)) }
+	want := map[common.Address]*AccountDiff{
+		changed: {
+			Balance: &BalanceDiff{From: bal(10), To: bal(15)},
+			Nonce:   &NonceDiff{From: 1, To: 2},
+			Storage: map[common.Hash]*StorageDiff{
+				common.HexToHash("0x01"): {From: common.HexToHash("0x01"), To: common.HexToHash("0x02")},
+				common.HexToHash("0x02"): {From: common.Hash{}, To: common.HexToHash("0x03")},
+			},
+		},
+		created: {
+			Created: true,
+			Balance: &BalanceDiff{From: bal(0), To: bal(7)},
+			Code:    &CodeDiff{To: []byte{0x60, 0x00}},
+		},
+		destroyed: {
+			Destroyed: true,
+			Balance:   &BalanceDiff{From: bal(3), To: bal(0)},
+			Storage: map[common.Hash]*StorageDiff{
+				common.HexToHash("0x01"): {From: common.HexToHash("0x01"), To: common.Hash{}},
+			},
+		},
+	}
+	have := state.Diff()
+	if !reflect.DeepEqual(have, want) {
+		haveJSON, _ := json.MarshalIndent(have, "", "  ")
+		wantJSON, _ := json.MarshalIndent(want, "", "  ")
+		t.Errorf("diff mismatch:\nhave %s\nwant %s", haveJSON, wantJSON)
+	}
+	// Finalising the state discards the journal, and with it the diff
+	state.Finalise(true)
+	if diff := state.Diff(); len(diff) != 0 {
+		t.Errorf("diff not reset after finalisation: %v", diff)
+	}
+}
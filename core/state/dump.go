@@ -21,61 +21,231 @@ import (
 	"fmt"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/rlp"
 	"github.com/ethereum/go-ethereum/trie"
 )
 
+// DumpConfig is a set of options to control what portions of the state will be
+// iterated and collected.
+type DumpConfig struct {
+	SkipCode          bool   // Whether to leave out the contract code
+	SkipStorage       bool   // Whether to leave out the contract storage
+	OnlyWithAddresses bool   // Whether to leave out the accounts with unknown address preimages
+	Start             []byte // Secure trie key to start iterating from, nil for the first account
+	Max               uint64 // Maximum number of accounts to collect, 0 for all of them
+}
+
+// collector receives the state root and the accounts iterated by a state dump.
+type collector interface {
+	onRoot(common.Hash)
+	onAccount(common.Address, DumpAccount)
+}
+
+// DumpAccount represents an account in the state.
 type DumpAccount struct {
-	Balance  string            `json:"balance"`
-	Nonce    uint64            `json:"nonce"`
-	Root     string            `json:"root"`
-	CodeHash string            `json:"codeHash"`
-	Code     string            `json:"code"`
-	Storage  map[string]string `json:"storage"`
+	Balance   string            `json:"balance"`
+	Nonce     uint64            `json:"nonce"`
+	Root      string            `json:"root"`
+	CodeHash  string            `json:"codeHash"`
+	Code      string            `json:"code"`
+	Storage   map[string]string `json:"storage"`
+	Address   *common.Address   `json:"address,omitempty"` // Address only present in iterative (line-by-line) mode
+	SecureKey hexutil.Bytes     `json:"key,omitempty"`     // If we don't have address, we can output the key
+
+	skipCode    bool // Whether the code was left out of the dump
+	skipStorage bool // Whether the storage was left out of the dump
 }
 
+// MarshalJSON implements json.Marshaler, leaving the code and the storage out of
+// the encoding if they were skipped by the dump configuration.
+func (a DumpAccount) MarshalJSON() ([]byte, error) {
+	enc := struct {
+		Balance   string             `json:"balance"`
+		Nonce     uint64             `json:"nonce"`
+		Root      string             `json:"root"`
+		CodeHash  string             `json:"codeHash"`
+		Code      *string            `json:"code,omitempty"`
+		Storage   *map[string]string `json:"storage,omitempty"`
+		Address   *common.Address    `json:"address,omitempty"`
+		SecureKey hexutil.Bytes      `json:"key,omitempty"`
+	}{
+		Balance:   a.Balance,
+		Nonce:     a.Nonce,
+		Root:      a.Root,
+		CodeHash:  a.CodeHash,
+		Address:   a.Address,
+		SecureKey: a.SecureKey,
+	}
+	if !a.skipCode {
+		enc.Code = &a.Code
+	}
+	if !a.skipStorage {
+		enc.Storage = &a.Storage
+	}
+	return json.Marshal(enc)
+}
+
+// Dump represents the full dump in a collected format, as one large map.
 type Dump struct {
 	Root     string                 `json:"root"`
 	Accounts map[string]DumpAccount `json:"accounts"`
 }
 
-func (self *StateDB) RawDump() Dump {
-	dump := Dump{
-		Root:     fmt.Sprintf("%x", self.trie.Hash()),
-		Accounts: make(map[string]DumpAccount),
+// onRoot implements collector, setting the root of the dump.
+func (d *Dump) onRoot(root common.Hash) {
+	d.Root = fmt.Sprintf("%x", root)
+}
+
+// onAccount implements collector, adding an account to the dump. Accounts with
+// an unknown address are keyed by their secure trie key.
+func (d *Dump) onAccount(addr common.Address, account DumpAccount) {
+	if account.SecureKey != nil {
+		d.Accounts[common.Bytes2Hex(account.SecureKey)] = account
+		return
 	}
+	d.Accounts[common.Bytes2Hex(addr[:])] = account
+}
+
+// IteratorDump is an implementation for iterating over data, collecting a page
+// of accounts together with the key to continue the iteration from.
+type IteratorDump struct {
+	Root     string                 `json:"root"`
+	Accounts map[string]DumpAccount `json:"accounts"`
+	Next     hexutil.Bytes          `json:"next,omitempty"` // nil if no more accounts
+}
+
+// onRoot implements collector, setting the root of the dump.
+func (d *IteratorDump) onRoot(root common.Hash) {
+	d.Root = fmt.Sprintf("%x", root)
+}
+
+// onAccount implements collector, adding an account to the dump.
+func (d *IteratorDump) onAccount(addr common.Address, account DumpAccount) {
+	if account.SecureKey != nil {
+		d.Accounts[common.Bytes2Hex(account.SecureKey)] = account
+		return
+	}
+	d.Accounts[common.Bytes2Hex(addr[:])] = account
+}
+
+// iterativeDump is a collector writing the dump one JSON object per line, the
+// root first and the accounts afterwards.
+type iterativeDump struct {
+	*json.Encoder
+}
 
-	it := trie.NewIterator(self.trie.NodeIterator(nil))
+// onRoot implements collector, writing the root of the dump.
+func (d iterativeDump) onRoot(root common.Hash) {
+	d.Encode(struct {
+		Root common.Hash `json:"root"`
+	}{root})
+}
+
+// onAccount implements collector, writing an account along with its address.
+func (d iterativeDump) onAccount(addr common.Address, account DumpAccount) {
+	if account.SecureKey == nil {
+		account.Address = &addr
+	}
+	d.Encode(account)
+}
+
+// dump iterates over the accounts of the state trie, passing them to the given
+// collector. The key of the next account is returned if the iteration stopped
+// because of the configured maximum, nil otherwise.
+func (self *StateDB) dump(c collector, conf *DumpConfig) (nextKey []byte) {
+	if conf == nil {
+		conf = new(DumpConfig)
+	}
+	var (
+		missingPreimages int
+		accounts         uint64
+	)
+	c.onRoot(self.trie.Hash())
+
+	it := trie.NewIterator(self.trie.NodeIterator(conf.Start))
 	for it.Next() {
-		addr := self.trie.GetKey(it.Key)
 		var data Account
 		if err := rlp.DecodeBytes(it.Value, &data); err != nil {
 			panic(err)
 		}
-
-		obj := newObject(nil, common.BytesToAddress(addr), data)
 		account := DumpAccount{
-			Balance:  data.Balance.String(),
-			Nonce:    data.Nonce,
-			Root:     common.Bytes2Hex(data.Root[:]),
-			CodeHash: common.Bytes2Hex(data.CodeHash),
-			Code:     common.Bytes2Hex(obj.Code(self.db)),
-			Storage:  make(map[string]string),
+			Balance:     data.Balance.String(),
+			Nonce:       data.Nonce,
+			Root:        common.Bytes2Hex(data.Root[:]),
+			CodeHash:    common.Bytes2Hex(data.CodeHash),
+			skipCode:    conf.SkipCode,
+			skipStorage: conf.SkipStorage,
+		}
+		addrBytes := self.trie.GetKey(it.Key)
+		if addrBytes == nil {
+			// Preimage missing
+			missingPreimages++
+			if conf.OnlyWithAddresses {
+				continue
+			}
+			account.SecureKey = it.Key
 		}
-		storageIt := trie.NewIterator(obj.getTrie(self.db).NodeIterator(nil))
-		for storageIt.Next() {
-			account.Storage[common.Bytes2Hex(self.trie.GetKey(storageIt.Key))] = common.Bytes2Hex(storageIt.Value)
+		addr := common.BytesToAddress(addrBytes)
+		obj := newObject(nil, addr, data)
+		if !conf.SkipCode {
+			account.Code = common.Bytes2Hex(obj.Code(self.db))
+		}
+		if !conf.SkipStorage {
+			account.Storage = make(map[string]string)
+			storageIt := trie.NewIterator(obj.getTrie(self.db).NodeIterator(nil))
+			for storageIt.Next() {
+				account.Storage[common.Bytes2Hex(self.trie.GetKey(storageIt.Key))] = common.Bytes2Hex(storageIt.Value)
+			}
+		}
+		c.onAccount(addr, account)
+		accounts++
+
+		if conf.Max > 0 && accounts >= conf.Max {
+			if it.Next() {
+				nextKey = it.Key
+			}
+			break
 		}
-		dump.Accounts[common.Bytes2Hex(addr)] = account
 	}
-	return dump
+	if missingPreimages > 0 {
+		log.Warn("Dump incomplete due to missing preimages", "missing", missingPreimages)
+	}
+	return nextKey
+}
+
+// RawDump returns the entire state as a single large object.
+func (self *StateDB) RawDump(conf *DumpConfig) Dump {
+	dump := &Dump{
+		Accounts: make(map[string]DumpAccount),
+	}
+	self.dump(dump, conf)
+	return *dump
 }
 
-func (self *StateDB) Dump() []byte {
-	json, err := json.MarshalIndent(self.RawDump(), "", "    ")
+// Dump returns a JSON string representing the entire state as a single json-object.
+func (self *StateDB) Dump(conf *DumpConfig) []byte {
+	dump := self.RawDump(conf)
+	json, err := json.MarshalIndent(dump, "", "    ")
 	if err != nil {
 		fmt.Println("dump err", err)
 	}
-
 	return json
 }
+
+// IterativeDump writes the accounts into the given encoder one json-object per
+// line, without ever holding more than one account in memory.
+func (self *StateDB) IterativeDump(conf *DumpConfig, output *json.Encoder) {
+	self.dump(iterativeDump{output}, conf)
+}
+
+// IteratorDump returns a page of accounts starting at the configured start key,
+// along with the key of the next page, if any.
+func (self *StateDB) IteratorDump(conf *DumpConfig) IteratorDump {
+	iterator := &IteratorDump{
+		Accounts: make(map[string]DumpAccount),
+	}
+	iterator.Next = self.dump(iterator, conf)
+	return *iterator
+}
@@ -0,0 +1,83 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package pruner
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// stateBloomHashes is the number of bits set in the filter for each item.
+const stateBloomHashes = 4
+
+// stateBloom is a bloom filter of the trie nodes and contract codes to keep. As
+// the items are keccak hashes, which are uniformly distributed already, the bit
+// positions are taken straight from their bytes instead of hashing them again.
+//
+// False positives only cause some stale items to survive the pruning, but false
+// negatives are impossible, so the live state is never deleted.
+type stateBloom struct {
+	bits  []uint64 // Bit vector of the filter
+	items uint64   // Number of items added, for estimating the false positive rate
+}
+
+// newStateBloom creates a bloom filter of the given size in bytes.
+func newStateBloom(size uint64) *stateBloom {
+	if size < 8 {
+		size = 8
+	}
+	return &stateBloom{bits: make([]uint64, size/8)}
+}
+
+// positions returns the bits to set or check for an item.
+func (b *stateBloom) positions(hash common.Hash) [stateBloomHashes]uint64 {
+	var (
+		pos   [stateBloomHashes]uint64
+		nbits = uint64(len(b.bits)) * 64
+	)
+	for i := range pos {
+		pos[i] = binary.BigEndian.Uint64(hash[i*8:]) % nbits
+	}
+	return pos
+}
+
+// add inserts an item into the filter.
+func (b *stateBloom) add(hash common.Hash) {
+	for _, pos := range b.positions(hash) {
+		b.bits[pos/64] |= 1 << (pos % 64)
+	}
+	b.items++
+}
+
+// contains checks whether an item might have been added to the filter.
+func (b *stateBloom) contains(hash common.Hash) bool {
+	for _, pos := range b.positions(hash) {
+		if b.bits[pos/64]&(1<<(pos%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// falsePositiveRate estimates the probability of an item not added to the filter
+// being reported as contained in it.
+func (b *stateBloom) falsePositiveRate() float64 {
+	nbits := float64(len(b.bits) * 64)
+	return math.Pow(1-math.Exp(-stateBloomHashes*float64(b.items)/nbits), stateBloomHashes)
+}
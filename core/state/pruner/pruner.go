@@ -0,0 +1,221 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package pruner implements the offline pruning of stale state trie nodes.
+package pruner
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// errNoState is returned if none of the states to keep is available on disk, in
+// which case pruning would delete every state in the database.
+var errNoState = errors.New("no recent state available on disk")
+
+// Config includes all the configurations for pruning.
+type Config struct {
+	BloomSize uint64 // Size of the bloom filter of live nodes in megabytes
+	Keep      uint64 // Number of recent block states to keep
+}
+
+// Pruner deletes the state trie nodes and contract codes which are not reachable
+// from the recent block states anymore.
+//
+// Trie nodes are shared between states and only addressed by their hash, so the
+// pruner can't tell whether a node is stale by looking at it. Instead it walks
+// all the states to keep, recording every node reached into a bloom filter, then
+// sweeps the database, deleting whatever is not in the filter. The filter keeps
+// the memory use fixed, at the price of some stale nodes surviving the pruning.
+//
+// The pruner needs exclusive access to the database, so it's meant to be run on
+// a stopped node. An interrupted pruning leaves the kept states intact and can be
+// simply run again.
+type Pruner struct {
+	db     ethdb.Database
+	config Config
+}
+
+// NewPruner creates a state pruner operating on the given database.
+func NewPruner(db ethdb.Database, config Config) *Pruner {
+	return &Pruner{db: db, config: config}
+}
+
+// Prune deletes all the state trie nodes and contract codes which are not part
+// of the last config.Keep block states or of the genesis state.
+func (p *Pruner) Prune() error {
+	roots, err := p.roots()
+	if err != nil {
+		return err
+	}
+	bloom := newStateBloom(p.config.BloomSize * 1024 * 1024)
+	for _, root := range roots {
+		if err := p.mark(bloom, root); err != nil {
+			return err
+		}
+	}
+	log.Info("Marked live state nodes", "roots", len(roots), "nodes", bloom.items, "falsepositive", fmt.Sprintf("%.6f", bloom.falsePositiveRate()))
+
+	if err := p.sweep(bloom); err != nil {
+		return err
+	}
+	// Deleted entries only free up disk space once compacted away. The pruning
+	// itself is done though, so don't fail if the database can't be compacted.
+	start := time.Now()
+	log.Info("Compacting database")
+	if err := p.db.Compact(nil, nil); err != nil {
+		log.Warn("Failed to compact database", "err", err)
+		return nil
+	}
+	log.Info("Compacted database", "elapsed", common.PrettyDuration(time.Since(start)))
+	return nil
+}
+
+// roots returns the state roots to keep: the ones of the last config.Keep blocks
+// which are available on disk, and the genesis one.
+func (p *Pruner) roots() ([]common.Hash, error) {
+	var (
+		roots []common.Hash
+		seen  = make(map[common.Hash]bool)
+	)
+	hash := rawdb.ReadHeadBlockHash(p.db)
+	if hash == (common.Hash{}) {
+		return nil, errors.New("head block missing")
+	}
+	number := rawdb.ReadHeaderNumber(p.db, hash)
+	if number == nil {
+		return nil, fmt.Errorf("head block %x missing", hash)
+	}
+	for i := uint64(0); i < p.config.Keep; i++ {
+		header := rawdb.ReadHeader(p.db, hash, *number)
+		if header == nil {
+			return nil, fmt.Errorf("header #%d [%x] missing", *number, hash)
+		}
+		// Non-archive nodes only flush a state to disk every now and then, only
+		// keep the ones actually available
+		if !seen[header.Root] {
+			if blob, _ := p.db.Get(header.Root[:]); len(blob) > 0 {
+				roots = append(roots, header.Root)
+			}
+			seen[header.Root] = true
+		}
+		if *number == 0 {
+			break
+		}
+		hash, *number = header.ParentHash, *number-1
+	}
+	if len(roots) == 0 {
+		return nil, errNoState
+	}
+	// Always keep the genesis state around, it's needed to reinitialise the chain
+	genesis := rawdb.ReadHeader(p.db, rawdb.ReadCanonicalHash(p.db, 0), 0)
+	if genesis == nil {
+		return nil, errors.New("genesis block missing")
+	}
+	if !seen[genesis.Root] {
+		roots = append(roots, genesis.Root)
+	}
+	return roots, nil
+}
+
+// mark adds all the trie nodes and contract codes of a state to the bloom filter.
+func (p *Pruner) mark(bloom *stateBloom, root common.Hash) error {
+	statedb, err := state.New(root, state.NewDatabase(p.db))
+	if err != nil {
+		return err
+	}
+	var (
+		start  = time.Now()
+		logged = time.Now()
+		nodes  int
+	)
+	it := state.NewNodeIterator(statedb)
+	for it.Next() {
+		// Embedded nodes have no hash and are not stored separately
+		if it.Hash != (common.Hash{}) {
+			bloom.add(it.Hash)
+			nodes++
+		}
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Marking live state nodes", "root", root, "nodes", nodes, "elapsed", common.PrettyDuration(time.Since(start)))
+			logged = time.Now()
+		}
+	}
+	if it.Error != nil {
+		return fmt.Errorf("state %x: %v", root, it.Error)
+	}
+	log.Info("Marked live state", "root", root, "nodes", nodes, "elapsed", common.PrettyDuration(time.Since(start)))
+	return nil
+}
+
+// sweep deletes all the trie nodes and contract codes from the database which
+// are not contained in the bloom filter.
+func (p *Pruner) sweep(bloom *stateBloom) error {
+	var (
+		start   = time.Now()
+		logged  = time.Now()
+		batch   = p.db.NewBatch()
+		checked int
+		deleted int
+		size    common.StorageSize
+	)
+	it := p.db.NewIterator()
+	defer it.Release()
+
+	for it.Next() {
+		// Trie nodes and contract codes are the only entries stored under their
+		// bare 32 byte hash, everything else has a prefix
+		key := it.Key()
+		if len(key) != common.HashLength {
+			continue
+		}
+		checked++
+		if bloom.contains(common.BytesToHash(key)) {
+			continue
+		}
+		size += common.StorageSize(len(key) + len(it.Value()))
+		if err := batch.Delete(key); err != nil {
+			return err
+		}
+		deleted++
+
+		if batch.ValueSize() >= ethdb.IdealBatchSize {
+			if err := batch.Write(); err != nil {
+				return err
+			}
+			batch.Reset()
+		}
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Pruning stale state nodes", "checked", checked, "deleted", deleted, "size", size, "elapsed", common.PrettyDuration(time.Since(start)))
+			logged = time.Now()
+		}
+	}
+	if err := it.Error(); err != nil {
+		return err
+	}
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	log.Info("Pruned stale state nodes", "checked", checked, "deleted", deleted, "size", size, "elapsed", common.PrettyDuration(time.Since(start)))
+	return nil
+}
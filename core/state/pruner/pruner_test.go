@@ -0,0 +1,137 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package pruner
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// countNodes returns the number of trie nodes and contract codes in the database.
+func countNodes(db ethdb.Database) int {
+	it := db.NewIterator()
+	defer it.Release()
+
+	var nodes int
+	for it.Next() {
+		if len(it.Key()) == common.HashLength {
+			nodes++
+		}
+	}
+	return nodes
+}
+
+// checkState verifies that all the nodes of a state are available.
+func checkState(db ethdb.Database, root common.Hash) error {
+	statedb, err := state.New(root, state.NewDatabase(db))
+	if err != nil {
+		return err
+	}
+	it := state.NewNodeIterator(statedb)
+	for it.Next() {
+	}
+	return it.Error
+}
+
+// Tests that pruning keeps the recent and genesis states of an archive chain
+// intact, while deleting the older ones.
+func TestPrune(t *testing.T) {
+	var (
+		key, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		address = crypto.PubkeyToAddress(key.PublicKey)
+		code    = common.Address{0xc0, 0xde}
+		db      = rawdb.NewMemoryDatabase()
+		gspec   = &core.Genesis{
+			Config: params.TestChainConfig,
+			Alloc: core.GenesisAlloc{
+				address: {Balance: big.NewInt(1000000000)},
+				code:    {Balance: big.NewInt(0), Code: []byte{0x60, 0x00}, Storage: map[common.Hash]common.Hash{{0x01}: {0x02}}},
+			},
+		}
+		genesis = gspec.MustCommit(db)
+		signer  = types.HomesteadSigner{}
+	)
+	blocks, _ := core.GenerateChain(gspec.Config, genesis, ethash.NewFaker(), db, 20, func(i int, b *core.BlockGen) {
+		tx, err := types.SignTx(types.NewTransaction(b.TxNonce(address), common.Address{byte(i + 1)}, big.NewInt(1000), params.TxGas, nil, nil), signer, key)
+		if err != nil {
+			panic(err)
+		}
+		b.AddTx(tx)
+	})
+	chain, err := core.NewBlockChain(db, &core.CacheConfig{TrieDirtyDisabled: true}, gspec.Config, ethash.NewFaker(), vm.Config{}, nil)
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	if _, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	chain.Stop()
+
+	before := countNodes(db)
+	if err := NewPruner(db, Config{BloomSize: 1, Keep: 4}).Prune(); err != nil {
+		t.Fatalf("failed to prune: %v", err)
+	}
+	if after := countNodes(db); after >= before {
+		t.Fatalf("nothing pruned: have %d nodes, had %d", after, before)
+	}
+	for _, block := range blocks[len(blocks)-4:] {
+		if err := checkState(db, block.Root()); err != nil {
+			t.Errorf("block #%d: kept state incomplete: %v", block.NumberU64(), err)
+		}
+	}
+	if err := checkState(db, genesis.Root()); err != nil {
+		t.Errorf("genesis state incomplete: %v", err)
+	}
+	if blob, _ := db.Get(blocks[0].Root().Bytes()); len(blob) > 0 {
+		t.Errorf("stale state root not pruned")
+	}
+}
+
+// Tests that pruning is refused if none of the states to keep is available.
+func TestPruneNoState(t *testing.T) {
+	var (
+		db      = rawdb.NewMemoryDatabase()
+		gspec   = &core.Genesis{Config: params.TestChainConfig}
+		genesis = gspec.MustCommit(db)
+	)
+	blocks, _ := core.GenerateChain(gspec.Config, genesis, ethash.NewFaker(), db, 4, nil)
+	for _, block := range blocks {
+		rawdb.WriteBlock(db, block)
+		rawdb.WriteCanonicalHash(db, block.Hash(), block.NumberU64())
+	}
+	rawdb.WriteHeadBlockHash(db, blocks[len(blocks)-1].Hash())
+
+	// Drop the states committed by the block generator, mimicking a non-archive
+	// node which hasn't flushed any of them yet
+	for _, block := range blocks {
+		db.Delete(block.Root().Bytes())
+	}
+	if err := NewPruner(db, Config{BloomSize: 1, Keep: 2}).Prune(); err != errNoState {
+		t.Fatalf("error mismatch: have %v, want %v", err, errNoState)
+	}
+}
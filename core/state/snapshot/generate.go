@@ -0,0 +1,290 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package snapshot
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// generatorStats is a collection of statistics gathered by the snapshot generator
+// for logging purposes.
+type generatorStats struct {
+	start    time.Time // Timestamp when generation started
+	wiped    uint64    // Number of stale snapshot entries deleted
+	accounts uint64    // Number of accounts indexed
+	slots    uint64    // Number of storage slots indexed
+}
+
+// log creates an contextual log with the given message and the context pulled
+// from the internally maintained statistics.
+func (gs *generatorStats) log(msg string, root common.Hash, marker []byte) {
+	ctx := []interface{}{"root", root}
+	if len(marker) > 0 {
+		ctx = append(ctx, "at", common.BytesToHash(marker))
+	}
+	ctx = append(ctx, []interface{}{
+		"wiped", gs.wiped, "accounts", gs.accounts, "slots", gs.slots,
+		"elapsed", common.PrettyDuration(time.Since(gs.start)),
+	}...)
+	log.Info(msg, ctx...)
+}
+
+// generateSnapshot regenerates a brand new snapshot based on an existing state
+// database and head block asynchronously. The snapshot is returned immediately
+// and generation is continued in the background until done.
+func generateSnapshot(diskdb ethdb.KeyValueStore, triedb *trie.Database, root common.Hash) *diskLayer {
+	// Mark the snapshot as empty before touching it, so that an interrupted wipe
+	// or generation is resumed instead of being considered complete
+	batch := diskdb.NewBatch()
+	journalProgress(batch, root, []byte{})
+	if err := batch.Write(); err != nil {
+		log.Crit("Failed to write initialized state marker", "err", err)
+	}
+	base := &diskLayer{
+		diskdb:    diskdb,
+		triedb:    triedb,
+		root:      root,
+		genMarker: []byte{}, // Initialized but empty!
+	}
+	base.startGeneration(&generatorStats{start: time.Now()})
+	return base
+}
+
+// startGeneration launches the background generation of the disk layer from its
+// current marker on.
+func (dl *diskLayer) startGeneration(stats *generatorStats) {
+	if stats == nil {
+		stats = &generatorStats{start: time.Now()}
+	}
+	dl.genPending = make(chan struct{})
+	dl.genAbort = make(chan chan *generatorStats)
+	go dl.generate(stats)
+}
+
+// stopGeneration aborts the background generation of the disk layer, if any,
+// waiting for it to record its progress. The statistics of the generator are
+// returned so they can be carried over to a resumed one.
+func (dl *diskLayer) stopGeneration() *generatorStats {
+	if dl.genAbort == nil {
+		return nil
+	}
+	abort := make(chan *generatorStats)
+	dl.genAbort <- abort
+	stats := <-abort
+
+	dl.genAbort = nil
+	return stats
+}
+
+// generate is a background thread that iterates over the state and storage tries
+// and constructs a state snapshot. All the arguments are purely for statistics
+// gathering and logging, since the method surfs the blocks as they arrive, often
+// being restarted.
+func (dl *diskLayer) generate(stats *generatorStats) {
+	// Wipe any leftovers of an older snapshot before generating from scratch
+	if len(dl.genMarker) == 0 {
+		if abort := dl.wipe(stats); abort != nil {
+			abort <- stats
+			return
+		}
+	}
+	var (
+		marker = dl.genMarker
+		batch  = dl.diskdb.NewBatch()
+		logged = time.Now()
+	)
+	// flush writes out the generated entries along with the generator progress,
+	// and exposes the new entries to the readers
+	flush := func(marker []byte) {
+		journalProgress(batch, dl.root, marker)
+		if err := batch.Write(); err != nil {
+			log.Crit("Failed to write snapshot", "err", err)
+		}
+		batch.Reset()
+
+		dl.lock.Lock()
+		dl.genMarker = marker
+		dl.lock.Unlock()
+	}
+	// fail records the progress made until the last complete account and waits
+	// for the generator to be restarted on a newer root
+	fail := func(err error) {
+		flush(marker)
+		log.Error("Failed to generate state snapshot", "root", dl.root, "err", err)
+
+		abort := <-dl.genAbort
+		abort <- stats
+	}
+	accTrie, err := trie.New(dl.root, dl.triedb)
+	if err != nil {
+		fail(err)
+		return
+	}
+	stats.log("Generating state snapshot", dl.root, marker)
+
+	// A crash in the middle of an account may have left some of its slots on disk.
+	// They'll be regenerated, but might have been deleted in the meantime.
+	resumed := len(marker) > 0
+
+	accIt := trie.NewIterator(accTrie.NodeIterator(marker))
+	for accIt.Next() {
+		// The marker itself was already generated in a previous run
+		if bytes.Equal(accIt.Key, marker) {
+			continue
+		}
+		accountHash := common.BytesToHash(accIt.Key)
+
+		var acc Account
+		if err := rlp.DecodeBytes(accIt.Value, &acc); err != nil {
+			log.Crit("Invalid account encountered during snapshot creation", "err", err)
+		}
+		if resumed {
+			stats.wiped += dl.wipeStorage(batch, accountHash)
+			resumed = false
+		}
+		// Generate the storage slots of the account. The account entry itself is
+		// only written afterwards, so an incomplete account is never exposed.
+		if root := common.BytesToHash(acc.Root); root != emptyRoot {
+			storeTrie, err := trie.New(root, dl.triedb)
+			if err != nil {
+				fail(err)
+				return
+			}
+			storeIt := trie.NewIterator(storeTrie.NodeIterator(nil))
+			for storeIt.Next() {
+				rawdb.WriteStorageSnapshot(batch, accountHash, common.BytesToHash(storeIt.Key), storeIt.Value)
+				stats.slots++
+
+				// Large contracts are flushed in chunks without advancing the marker,
+				// so any partial account on disk is always the one after the marker
+				if batch.ValueSize() > ethdb.IdealBatchSize {
+					flush(marker)
+				}
+				// If the generator was aborted, drop the incomplete account
+				select {
+				case abort := <-dl.genAbort:
+					flush(marker)
+					stats.wiped += dl.wipeStorage(batch, accountHash)
+					flush(marker)
+					abort <- stats
+					return
+				default:
+				}
+			}
+			if storeIt.Err != nil {
+				flush(marker)
+				stats.wiped += dl.wipeStorage(batch, accountHash)
+				fail(storeIt.Err)
+				return
+			}
+		}
+		rawdb.WriteAccountSnapshot(batch, accountHash, SlimAccountRLP(acc.Nonce, acc.Balance, common.BytesToHash(acc.Root), acc.CodeHash))
+		stats.accounts++
+
+		marker = common.CopyBytes(accountHash[:])
+		if batch.ValueSize() > ethdb.IdealBatchSize {
+			flush(marker)
+		}
+		// If the generator was aborted, record the progress and stop
+		select {
+		case abort := <-dl.genAbort:
+			flush(marker)
+			abort <- stats
+			return
+		default:
+		}
+		if time.Since(logged) > 8*time.Second {
+			stats.log("Generating state snapshot", dl.root, marker)
+			logged = time.Now()
+		}
+	}
+	if accIt.Err != nil {
+		fail(accIt.Err)
+		return
+	}
+	// Snapshot fully generated, set the marker to nil
+	flush(nil)
+	stats.log("Generated state snapshot", dl.root, nil)
+	close(dl.genPending)
+
+	// Someone will be looking for us, wait it out
+	abort := <-dl.genAbort
+	abort <- stats
+}
+
+// wipe deletes all the snapshot entries from the database. It returns the abort
+// request if the generator was stopped before finishing.
+func (dl *diskLayer) wipe(stats *generatorStats) chan *generatorStats {
+	for _, wipe := range []struct {
+		prefix []byte
+		keylen int
+	}{
+		{rawdb.SnapshotAccountPrefix, len(rawdb.SnapshotAccountPrefix) + common.HashLength},
+		{rawdb.SnapshotStoragePrefix, len(rawdb.SnapshotStoragePrefix) + 2*common.HashLength},
+	} {
+		batch := dl.diskdb.NewBatch()
+		it := dl.diskdb.NewIteratorWithPrefix(wipe.prefix)
+		for it.Next() {
+			// Trie nodes and codes share the prefixes, skip them by their length
+			if key := it.Key(); len(key) == wipe.keylen {
+				batch.Delete(key)
+				stats.wiped++
+			}
+			if batch.ValueSize() > ethdb.IdealBatchSize {
+				if err := batch.Write(); err != nil {
+					log.Crit("Failed to wipe snapshot", "err", err)
+				}
+				batch.Reset()
+
+				select {
+				case abort := <-dl.genAbort:
+					it.Release()
+					return abort
+				default:
+				}
+			}
+		}
+		it.Release()
+		if err := batch.Write(); err != nil {
+			log.Crit("Failed to wipe snapshot", "err", err)
+		}
+	}
+	return nil
+}
+
+// wipeStorage queues the deletion of all the storage slots of an account found
+// on disk into the batch, returning their number.
+func (dl *diskLayer) wipeStorage(batch ethdb.Batch, accountHash common.Hash) uint64 {
+	var wiped uint64
+
+	it := rawdb.IterateStorageSnapshots(dl.diskdb, accountHash)
+	defer it.Release()
+
+	for it.Next() {
+		batch.Delete(it.Key())
+		wiped++
+	}
+	return wiped
+}
@@ -0,0 +1,209 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package snapshot
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// testState is a small state of accounts with storage, committed into a trie.
+type testState struct {
+	root     common.Hash
+	accounts map[common.Hash]Account
+	storage  map[common.Hash]map[common.Hash][]byte
+}
+
+// makeTestState commits a state of n accounts into the database, every second
+// one of them having a few storage slots.
+func makeTestState(t *testing.T, db ethdb.Database, n int) *testState {
+	var (
+		triedb = trie.NewDatabase(db)
+		state  = &testState{
+			accounts: make(map[common.Hash]Account),
+			storage:  make(map[common.Hash]map[common.Hash][]byte),
+		}
+	)
+	accTrie, _ := trie.New(common.Hash{}, triedb)
+	for i := 0; i < n; i++ {
+		hash := crypto.Keccak256Hash([]byte{byte(i)})
+		acc := Account{Nonce: uint64(i + 1), Balance: big.NewInt(int64(i)), Root: emptyRoot[:], CodeHash: emptyCode[:]}
+
+		if i%2 == 1 {
+			stTrie, _ := trie.New(common.Hash{}, triedb)
+			state.storage[hash] = make(map[common.Hash][]byte)
+			for j := 1; j <= i; j++ {
+				slot := crypto.Keccak256Hash([]byte{byte(i), byte(j)})
+				value, _ := rlp.EncodeToBytes([]byte{byte(j)})
+				stTrie.Update(slot[:], value)
+				state.storage[hash][slot] = value
+			}
+			root, err := stTrie.Commit(nil)
+			if err != nil {
+				t.Fatalf("failed to commit storage trie: %v", err)
+			}
+			if err := triedb.Commit(root, false); err != nil {
+				t.Fatalf("failed to flush storage trie: %v", err)
+			}
+			acc.Root = root[:]
+		}
+		blob, _ := rlp.EncodeToBytes(acc)
+		accTrie.Update(hash[:], blob)
+		state.accounts[hash] = acc
+	}
+	root, err := accTrie.Commit(nil)
+	if err != nil {
+		t.Fatalf("failed to commit account trie: %v", err)
+	}
+	if err := triedb.Commit(root, false); err != nil {
+		t.Fatalf("failed to flush tries: %v", err)
+	}
+	state.root = root
+	return state
+}
+
+// waitGeneration waits until the disk layer of the tree is fully generated.
+func waitGeneration(t *testing.T, snaps *Tree, root common.Hash) *diskLayer {
+	dl := snaps.Snapshot(root).(*diskLayer)
+	select {
+	case <-dl.genPending:
+	case <-time.After(3 * time.Second):
+		t.Fatalf("snapshot generation timed out")
+	}
+	return dl
+}
+
+// checkSnapshot verifies that the snapshot on disk contains exactly the state.
+func checkSnapshot(t *testing.T, db ethdb.Database, state *testState) {
+	t.Helper()
+
+	var accounts, slots int
+	it := db.NewIterator()
+	defer it.Release()
+
+	for it.Next() {
+		key := it.Key()
+		switch {
+		case len(key) == 1+common.HashLength && bytes.HasPrefix(key, rawdb.SnapshotAccountPrefix):
+			acc, ok := state.accounts[common.BytesToHash(key[1:])]
+			if !ok {
+				t.Fatalf("stale account %x", key[1:])
+			}
+			if want := SlimAccountRLP(acc.Nonce, acc.Balance, common.BytesToHash(acc.Root), acc.CodeHash); !bytes.Equal(it.Value(), want) {
+				t.Fatalf("account %x mismatch: have %x, want %x", key[1:], it.Value(), want)
+			}
+			accounts++
+
+		case len(key) == 1+2*common.HashLength && bytes.HasPrefix(key, rawdb.SnapshotStoragePrefix):
+			want, ok := state.storage[common.BytesToHash(key[1:33])][common.BytesToHash(key[33:])]
+			if !ok {
+				t.Fatalf("stale slot %x/%x", key[1:33], key[33:])
+			}
+			if !bytes.Equal(it.Value(), want) {
+				t.Fatalf("slot %x/%x mismatch: have %x, want %x", key[1:33], key[33:], it.Value(), want)
+			}
+			slots++
+		}
+	}
+	if accounts != len(state.accounts) {
+		t.Fatalf("account count mismatch: have %d, want %d", accounts, len(state.accounts))
+	}
+	var want int
+	for _, storage := range state.storage {
+		want += len(storage)
+	}
+	if slots != want {
+		t.Fatalf("slot count mismatch: have %d, want %d", slots, want)
+	}
+}
+
+// Tests that a snapshot is generated from the tries, wiping any stale entries
+// but leaving the trie nodes sharing the prefixes alone.
+func TestGeneration(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	state := makeTestState(t, db, 16)
+
+	stale := common.Hash{0xff}
+	rawdb.WriteAccountSnapshot(db, stale, testAccount(1))
+	rawdb.WriteStorageSnapshot(db, stale, stale, []byte{0x01})
+	for _, prefix := range [][]byte{rawdb.SnapshotAccountPrefix, rawdb.SnapshotStoragePrefix} {
+		db.Put(append(common.CopyBytes(prefix), make([]byte, common.HashLength-1)...), []byte{0x01})
+	}
+	snaps := New(db, trie.NewDatabase(db), state.root)
+	dl := waitGeneration(t, snaps, state.root)
+	checkSnapshot(t, db, state)
+
+	for _, prefix := range [][]byte{rawdb.SnapshotAccountPrefix, rawdb.SnapshotStoragePrefix} {
+		if ok, _ := db.Has(append(common.CopyBytes(prefix), make([]byte, common.HashLength-1)...)); !ok {
+			t.Fatalf("non-snapshot entry with prefix %x wiped", prefix)
+		}
+	}
+	for hash, acc := range state.accounts {
+		checkAccount(t, dl, hash, acc.Nonce)
+	}
+	// Reopening the tree doesn't regenerate the snapshot anymore
+	snaps = New(db, trie.NewDatabase(db), state.root)
+	if dl := snaps.Snapshot(state.root).(*diskLayer); dl.genMarker != nil {
+		t.Fatalf("complete snapshot regenerated")
+	}
+}
+
+// Tests that an interrupted generation is resumed from its marker, dropping the
+// slots left behind by the incomplete account.
+func TestGenerationResume(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	state := makeTestState(t, db, 16)
+
+	// Pretend the generation stopped in the middle of the second account
+	var hashes []common.Hash
+	it := trie.NewIterator(mustTrie(t, db, state.root).NodeIterator(nil))
+	for it.Next() {
+		hashes = append(hashes, common.BytesToHash(it.Key))
+	}
+	acc := state.accounts[hashes[0]]
+	rawdb.WriteAccountSnapshot(db, hashes[0], SlimAccountRLP(acc.Nonce, acc.Balance, common.BytesToHash(acc.Root), acc.CodeHash))
+	for slot, value := range state.storage[hashes[0]] {
+		rawdb.WriteStorageSnapshot(db, hashes[0], slot, value)
+	}
+	rawdb.WriteStorageSnapshot(db, hashes[1], common.Hash{0xff}, []byte{0x01})
+	journalProgress(db, state.root, hashes[0][:])
+
+	snaps := New(db, trie.NewDatabase(db), state.root)
+	if _, err := snaps.Snapshot(state.root).Account(hashes[len(hashes)-1]); err != ErrNotCoveredYet && err != nil {
+		t.Fatalf("unexpected error during generation: %v", err)
+	}
+	waitGeneration(t, snaps, state.root)
+	checkSnapshot(t, db, state)
+}
+
+// mustTrie opens the trie of the given root.
+func mustTrie(t *testing.T, db ethdb.Database, root common.Hash) *trie.Trie {
+	tr, err := trie.New(root, trie.NewDatabase(db))
+	if err != nil {
+		t.Fatalf("failed to open trie: %v", err)
+	}
+	return tr
+}
@@ -0,0 +1,431 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package snapshot implements a flat, layered snapshot of the state tries.
+package snapshot
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+var (
+	// ErrSnapshotStale is returned from data accessors if the underlying snapshot
+	// layer had been invalidated due to the chain progressing forward far enough
+	// to not maintain the layer's original state.
+	ErrSnapshotStale = errors.New("snapshot stale")
+
+	// ErrNotCoveredYet is returned from data accessors if the underlying snapshot
+	// is being generated currently and the requested data item is not yet in the
+	// range of accounts covered.
+	ErrNotCoveredYet = errors.New("not covered yet")
+
+	// errSnapshotCycle is returned if a snapshot is attempted to be inserted
+	// that forms a cycle in the snapshot tree.
+	errSnapshotCycle = errors.New("snapshot cycle")
+)
+
+// Snapshot represents the functionality supported by a snapshot storage layer.
+type Snapshot interface {
+	// Root returns the root hash for which this snapshot was made.
+	Root() common.Hash
+
+	// Account directly retrieves the account associated with a particular hash in
+	// the snapshot slim data format. A nil account means it doesn't exist.
+	Account(hash common.Hash) (*Account, error)
+
+	// AccountRLP directly retrieves the account RLP associated with a particular
+	// hash in the snapshot slim data format.
+	AccountRLP(hash common.Hash) ([]byte, error)
+
+	// Storage directly retrieves the storage data associated with a particular hash,
+	// within a particular account. The data is RLP encoded the same way as in the
+	// storage trie, and empty if the slot doesn't exist.
+	Storage(accountHash, storageHash common.Hash) ([]byte, error)
+}
+
+// snapshot is the internal version of the snapshot data layer that supports some
+// additional methods compared to the public API.
+type snapshot interface {
+	Snapshot
+
+	// Parent returns the subsequent layer of a snapshot, or nil if the base was
+	// reached.
+	Parent() snapshot
+
+	// Update creates a new layer on top of the existing snapshot diff tree with
+	// the specified data items.
+	Update(blockRoot common.Hash, destructs map[common.Hash]struct{}, accounts map[common.Hash][]byte, storage map[common.Hash]map[common.Hash][]byte) *diffLayer
+
+	// Stale return whether this layer has become stale (was flattened across) or
+	// if it's still live.
+	Stale() bool
+}
+
+// Tree is an Ethereum state snapshot tree. It consists of one persistent base
+// layer backed by a key-value store, on top of which arbitrarily many in-memory
+// diff layers are topped. The memory diffs can form a tree with branching, but
+// the disk layer is singleton and common to all. If a reorg goes deeper than the
+// disk layer, everything needs to be deleted.
+//
+// The goal of a state snapshot is to allow direct access to account and storage
+// data to avoid expensive multi-level trie lookups.
+type Tree struct {
+	diskdb ethdb.KeyValueStore      // Persistent database to store the snapshot
+	triedb *trie.Database           // In-memory cache to access the trie through
+	layers map[common.Hash]snapshot // Collection of all known layers
+	lock   sync.RWMutex
+}
+
+// New attempts to load an already existing snapshot from a persistent key-value
+// store, ensuring that the head of the snapshot matches the expected one. The
+// in-memory diff layers are not journalled, so the snapshot needs to be flushed
+// through Persist on shutdown to be reusable.
+//
+// If the snapshot is missing or inconsistent, the entirety is deleted and will
+// be reconstructed from scratch based on the tries in the key-value store, on a
+// background thread. Until then, the snapshot only serves the accounts already
+// generated, reporting ErrNotCoveredYet for the rest.
+func New(diskdb ethdb.KeyValueStore, triedb *trie.Database, root common.Hash) *Tree {
+	snap := &Tree{
+		diskdb: diskdb,
+		triedb: triedb,
+		layers: make(map[common.Hash]snapshot),
+	}
+	base := loadSnapshot(diskdb, triedb, root)
+	if base == nil {
+		log.Warn("Snapshot missing or outdated, regenerating", "root", root)
+		base = generateSnapshot(diskdb, triedb, root)
+	}
+	snap.layers[base.root] = base
+	return snap
+}
+
+// Snapshot retrieves a snapshot belonging to the given block root, or nil if no
+// snapshot is maintained for that block.
+func (t *Tree) Snapshot(blockRoot common.Hash) Snapshot {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	return t.layers[blockRoot]
+}
+
+// Update adds a new snapshot into the tree, if that can be linked to an existing
+// old parent. It is disallowed to insert a disk layer (the origin of all).
+func (t *Tree) Update(blockRoot common.Hash, parentRoot common.Hash, destructs map[common.Hash]struct{}, accounts map[common.Hash][]byte, storage map[common.Hash]map[common.Hash][]byte) error {
+	// Reject noop updates to avoid self-loops in the snapshot tree. This is a
+	// special case that can only happen for Clique networks where empty blocks
+	// don't modify the state (0 block subsidy).
+	if blockRoot == parentRoot {
+		return errSnapshotCycle
+	}
+	// Generate a new snapshot on top of the parent
+	parent, ok := t.Snapshot(parentRoot).(snapshot)
+	if !ok {
+		return fmt.Errorf("parent [%#x] snapshot missing", parentRoot)
+	}
+	snap := parent.Update(blockRoot, destructs, accounts, storage)
+
+	// Save the new snapshot for later. The state is defined by its root, so if
+	// the same state was already committed (e.g. re-executing a block), keep the
+	// original layer which other layers may already be linked to.
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if _, ok := t.layers[snap.root]; !ok {
+		t.layers[snap.root] = snap
+	}
+	return nil
+}
+
+// Cap traverses downwards the snapshot tree from a head block hash until the
+// number of allowed layers are crossed. All layers beyond the permitted number
+// are flattened downwards into the disk layer, and all the layers on other forks
+// which don't link into the new disk layer anymore are dropped.
+func (t *Tree) Cap(root common.Hash, layers int) error {
+	// Retrieve the head snapshot to cap from
+	snap := t.Snapshot(root)
+	if snap == nil {
+		return fmt.Errorf("snapshot [%#x] missing", root)
+	}
+	// Nothing to flatten if the head is the disk layer already
+	diff, ok := snap.(*diffLayer)
+	if !ok {
+		return nil
+	}
+	// Run the internal capping and discard all stale layers
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	// Flattening all the layers requires special casing since there's no child
+	// left to rewire to the new disk layer
+	var base *diskLayer
+	if layers == 0 {
+		base = diffToDisk(diff.flatten().(*diffLayer))
+	} else if base = t.cap(diff, layers); base == nil {
+		return nil
+	}
+	t.layers[base.root] = base
+
+	// Remove any layer that is stale or links into a stale layer
+	children := make(map[common.Hash][]common.Hash)
+	for root, snap := range t.layers {
+		if diff, ok := snap.(*diffLayer); ok {
+			parent := diff.Parent().Root()
+			children[parent] = append(children[parent], root)
+		}
+	}
+	var remove func(root common.Hash)
+	remove = func(root common.Hash) {
+		delete(t.layers, root)
+		for _, child := range children[root] {
+			remove(child)
+		}
+		delete(children, root)
+	}
+	for root, snap := range t.layers {
+		if snap.Stale() {
+			remove(root)
+		}
+	}
+	return nil
+}
+
+// cap traverses downwards the diff tree until the number of allowed layers are
+// crossed. All diffs beyond the permitted number are flattened downwards and
+// written into the disk layer. If no layer was flattened, nil is returned.
+//
+// Note, the function assumes that the tree lock is held.
+func (t *Tree) cap(diff *diffLayer, layers int) *diskLayer {
+	// Dive until we run out of layers or reach the persistent database
+	for ; layers > 1; layers-- {
+		// If we still have diff layers below, continue down
+		if parent, ok := diff.Parent().(*diffLayer); ok {
+			diff = parent
+		} else {
+			// Diff stack too shallow, return without modifications
+			return nil
+		}
+	}
+	// We're out of layers, flatten anything below, stopping if it's the disk
+	parent, ok := diff.Parent().(*diffLayer)
+	if !ok {
+		return nil
+	}
+	base := diffToDisk(parent.flatten().(*diffLayer))
+
+	diff.lock.Lock()
+	diff.parent = base
+	diff.lock.Unlock()
+
+	return base
+}
+
+// Rebuild wipes all available snapshot data from the persistent database and
+// discard all caches and diff layers. Afterwards, it starts a new snapshot
+// generator with the given root hash.
+func (t *Tree) Rebuild(root common.Hash) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	// Iterate over and mark all layers stale
+	for _, layer := range t.layers {
+		switch layer := layer.(type) {
+		case *diskLayer:
+			// If the base layer is generating, abort it and save
+			layer.stopGeneration()
+			layer.lock.Lock()
+			layer.stale = true
+			layer.lock.Unlock()
+
+		case *diffLayer:
+			// If the layer is a simple diff, simply mark as stale
+			layer.lock.Lock()
+			layer.stale = true
+			layer.lock.Unlock()
+
+		default:
+			panic(fmt.Sprintf("unknown layer type: %T", layer))
+		}
+	}
+	// Start generating a new snapshot from scratch on a background thread. The
+	// generator will run a wiper first if there's not a snapshot there.
+	log.Info("Rebuilding state snapshot", "root", root)
+	t.layers = map[common.Hash]snapshot{
+		root: generateSnapshot(t.diskdb, t.triedb, root),
+	}
+}
+
+// Persist flattens all the diff layers below root into the disk layer and stops
+// any background generation, recording its progress so that a subsequent New
+// with the same root resumes it. The tree must not be updated afterwards.
+func (t *Tree) Persist(root common.Hash) error {
+	snap := t.Snapshot(root)
+	if snap == nil {
+		return fmt.Errorf("snapshot [%#x] missing", root)
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	base, ok := snap.(*diskLayer)
+	if !ok {
+		base = diffToDisk(snap.(*diffLayer).flatten().(*diffLayer))
+	}
+	base.stopGeneration()
+	t.layers = map[common.Hash]snapshot{base.root: base}
+	return nil
+}
+
+// diffToDisk merges a bottom-most diff into the persistent disk layer underneath
+// it. The method will panic if called onto a non-bottom-most diff layer.
+func diffToDisk(bottom *diffLayer) *diskLayer {
+	base := bottom.Parent().(*diskLayer)
+
+	// Abort any running generator, it will be resumed on the new root below
+	stats := base.stopGeneration()
+
+	base.lock.Lock()
+	defer base.lock.Unlock()
+
+	// Mark the original base as stale as we're going to create a new wrapper
+	if base.stale {
+		panic("parent disk layer is stale") // we've committed into the same base from two children, boo
+	}
+	base.stale = true
+
+	bottom.lock.Lock()
+	bottom.stale = true
+	bottom.lock.Unlock()
+
+	// The whole diff is written in a single batch, so that a crash can't leave a
+	// partially updated snapshot behind
+	var (
+		batch  = base.diskdb.NewBatch()
+		marker = base.genMarker
+	)
+	for hash := range bottom.destructSet {
+		// Skip any account not covered yet by the snapshot
+		if !covered(marker, hash) {
+			continue
+		}
+		// Remove all storage slots
+		rawdb.DeleteAccountSnapshot(batch, hash)
+
+		it := rawdb.IterateStorageSnapshots(base.diskdb, hash)
+		for it.Next() {
+			batch.Delete(it.Key())
+		}
+		it.Release()
+	}
+	for hash, data := range bottom.accountData {
+		// Skip any account not covered yet by the snapshot
+		if !covered(marker, hash) {
+			continue
+		}
+		rawdb.WriteAccountSnapshot(batch, hash, data)
+	}
+	for accountHash, storage := range bottom.storageData {
+		// Skip any account not covered yet by the snapshot
+		if !covered(marker, accountHash) {
+			continue
+		}
+		for storageHash, data := range storage {
+			if len(data) > 0 {
+				rawdb.WriteStorageSnapshot(batch, accountHash, storageHash, data)
+			} else {
+				rawdb.DeleteStorageSnapshot(batch, accountHash, storageHash)
+			}
+		}
+	}
+	journalProgress(batch, bottom.root, marker)
+	if err := batch.Write(); err != nil {
+		log.Crit("Failed to write leftover snapshot", "err", err)
+	}
+	res := &diskLayer{
+		root:      bottom.root,
+		diskdb:    base.diskdb,
+		triedb:    base.triedb,
+		genMarker: marker,
+	}
+	// If snapshot generation hasn't finished yet, port over all the starts and
+	// continue where the previous round left off.
+	if marker != nil {
+		res.startGeneration(stats)
+	}
+	return res
+}
+
+// journalGenerator is a disk layer entry containing the generator progress marker.
+type journalGenerator struct {
+	Done   bool   // Whether the generator finished creating the snapshot
+	Marker []byte // Hash of the last account generated
+}
+
+// journalProgress persists the root of the disk layer and the progress of its
+// generation, a nil marker meaning a fully generated snapshot.
+func journalProgress(db ethdb.KeyValueWriter, root common.Hash, marker []byte) {
+	blob, err := rlp.EncodeToBytes(journalGenerator{Done: marker == nil, Marker: marker})
+	if err != nil {
+		panic(err) // Cannot happen, here to catch dev errors
+	}
+	rawdb.WriteSnapshotRoot(db, root)
+	rawdb.WriteSnapshotGenerator(db, blob)
+}
+
+// loadSnapshot loads a pre-existing state snapshot backed by a key-value store,
+// resuming its generation if it was interrupted. Nil is returned if the snapshot
+// is missing or doesn't match the expected root.
+func loadSnapshot(diskdb ethdb.KeyValueStore, triedb *trie.Database, root common.Hash) *diskLayer {
+	if rawdb.ReadSnapshotRoot(diskdb) != root {
+		return nil
+	}
+	var generator journalGenerator
+	if err := rlp.DecodeBytes(rawdb.ReadSnapshotGenerator(diskdb), &generator); err != nil {
+		log.Warn("Failed to load snapshot generator", "err", err)
+		return nil
+	}
+	base := &diskLayer{
+		diskdb: diskdb,
+		triedb: triedb,
+		root:   root,
+	}
+	if !generator.Done {
+		base.genMarker = generator.Marker
+		if base.genMarker == nil {
+			base.genMarker = []byte{}
+		}
+		log.Info("Resuming state snapshot generation", "root", root, "at", common.BytesToHash(base.genMarker))
+		base.startGeneration(nil)
+	} else {
+		log.Info("Loaded state snapshot", "root", root)
+	}
+	return base
+}
+
+// covered returns whether an account is already contained in a snapshot whose
+// generation reached the given marker.
+func covered(marker []byte, hash common.Hash) bool {
+	return marker == nil || (len(marker) > 0 && bytes.Compare(hash[:], marker) <= 0)
+}
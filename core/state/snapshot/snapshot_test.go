@@ -0,0 +1,200 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package snapshot
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/ethdb/memorydb"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// testAccount generates a slim account with the given nonce.
+func testAccount(nonce uint64) []byte {
+	return SlimAccountRLP(nonce, big.NewInt(int64(nonce)), emptyRoot, emptyCode[:])
+}
+
+// newTestTree creates a snapshot tree with a fully generated, empty disk layer.
+func newTestTree(root common.Hash) *Tree {
+	diskdb := memorydb.New()
+	journalProgress(diskdb, root, nil)
+	return New(diskdb, trie.NewDatabase(diskdb), root)
+}
+
+// checkAccount verifies that an account is resolved to the expected nonce, or
+// as missing if nonce is zero.
+func checkAccount(t *testing.T, snap Snapshot, hash common.Hash, nonce uint64) {
+	t.Helper()
+
+	acc, err := snap.Account(hash)
+	if err != nil {
+		t.Fatalf("account %x: failed to retrieve: %v", hash, err)
+	}
+	switch {
+	case nonce == 0 && acc != nil:
+		t.Fatalf("account %x: deleted account present: %v", hash, acc)
+	case nonce != 0 && acc == nil:
+		t.Fatalf("account %x: account missing", hash)
+	case nonce != 0 && acc.Nonce != nonce:
+		t.Fatalf("account %x: nonce mismatch: have %d, want %d", hash, acc.Nonce, nonce)
+	}
+}
+
+// checkStorage verifies that a storage slot is resolved to the expected value.
+func checkStorage(t *testing.T, snap Snapshot, account, slot common.Hash, want []byte) {
+	t.Helper()
+
+	have, err := snap.Storage(account, slot)
+	if err != nil {
+		t.Fatalf("slot %x/%x: failed to retrieve: %v", account, slot, err)
+	}
+	if !bytes.Equal(have, want) {
+		t.Fatalf("slot %x/%x: value mismatch: have %x, want %x", account, slot, have, want)
+	}
+}
+
+// Tests that the diff layers resolve accounts and storage slots through their
+// parents, honouring the deletions and destructions on the way.
+func TestDiffLayerLookups(t *testing.T) {
+	snaps := newTestTree(common.Hash{0x01})
+
+	// Layer 2 creates an account with some storage
+	err := snaps.Update(common.Hash{0x02}, common.Hash{0x01}, nil,
+		map[common.Hash][]byte{{0xaa}: testAccount(1), {0xbb}: testAccount(1)},
+		map[common.Hash]map[common.Hash][]byte{{0xaa}: {{0x01}: {0x01}, {0x02}: {0x02}}},
+	)
+	if err != nil {
+		t.Fatalf("failed to create layer 2: %v", err)
+	}
+	// Layer 3 destructs and recreates it with different storage, deleting another
+	err = snaps.Update(common.Hash{0x03}, common.Hash{0x02},
+		map[common.Hash]struct{}{{0xaa}: {}, {0xbb}: {}},
+		map[common.Hash][]byte{{0xaa}: testAccount(2)},
+		map[common.Hash]map[common.Hash][]byte{{0xaa}: {{0x02}: {0x03}}},
+	)
+	if err != nil {
+		t.Fatalf("failed to create layer 3: %v", err)
+	}
+	// Layer 4 deletes a single slot
+	err = snaps.Update(common.Hash{0x04}, common.Hash{0x03}, nil, nil,
+		map[common.Hash]map[common.Hash][]byte{{0xaa}: {{0x02}: nil}},
+	)
+	if err != nil {
+		t.Fatalf("failed to create layer 4: %v", err)
+	}
+	if err := snaps.Update(common.Hash{0x05}, common.Hash{0x05}, nil, nil, nil); err != errSnapshotCycle {
+		t.Fatalf("self loop error mismatch: have %v, want %v", err, errSnapshotCycle)
+	}
+	if err := snaps.Update(common.Hash{0x05}, common.Hash{0xff}, nil, nil, nil); err == nil {
+		t.Fatalf("missing parent accepted")
+	}
+	snap := snaps.Snapshot(common.Hash{0x02})
+	checkAccount(t, snap, common.Hash{0xaa}, 1)
+	checkAccount(t, snap, common.Hash{0xbb}, 1)
+	checkStorage(t, snap, common.Hash{0xaa}, common.Hash{0x01}, []byte{0x01})
+
+	snap = snaps.Snapshot(common.Hash{0x03})
+	checkAccount(t, snap, common.Hash{0xaa}, 2)
+	checkAccount(t, snap, common.Hash{0xbb}, 0)
+	checkStorage(t, snap, common.Hash{0xaa}, common.Hash{0x01}, nil)
+	checkStorage(t, snap, common.Hash{0xaa}, common.Hash{0x02}, []byte{0x03})
+
+	snap = snaps.Snapshot(common.Hash{0x04})
+	checkAccount(t, snap, common.Hash{0xaa}, 2)
+	checkStorage(t, snap, common.Hash{0xaa}, common.Hash{0x02}, nil)
+	checkAccount(t, snap, common.Hash{0xcc}, 0)
+}
+
+// Tests that capping the tree flattens the bottom layers into the disk layer,
+// dropping the forks which don't link into it anymore.
+func TestCap(t *testing.T) {
+	snaps := newTestTree(common.Hash{0x01})
+
+	// Build a chain of layers 0x02..0x05, with a fork 0x12 on top of 0x01 and a
+	// fork 0x14 on top of 0x03
+	chain := []struct {
+		root, parent common.Hash
+		nonce        uint64
+	}{
+		{common.Hash{0x02}, common.Hash{0x01}, 2},
+		{common.Hash{0x03}, common.Hash{0x02}, 3},
+		{common.Hash{0x04}, common.Hash{0x03}, 4},
+		{common.Hash{0x05}, common.Hash{0x04}, 5},
+		{common.Hash{0x12}, common.Hash{0x01}, 12},
+		{common.Hash{0x14}, common.Hash{0x03}, 14},
+	}
+	for _, layer := range chain {
+		accounts := map[common.Hash][]byte{
+			{0xaa}:          testAccount(layer.nonce),
+			{layer.root[0]}: testAccount(layer.nonce),
+		}
+		storage := map[common.Hash]map[common.Hash][]byte{
+			{0xaa}: {{layer.root[0]}: {byte(layer.nonce)}},
+		}
+		if err := snaps.Update(layer.root, layer.parent, nil, accounts, storage); err != nil {
+			t.Fatalf("failed to create layer %x: %v", layer.root, err)
+		}
+	}
+	held := snaps.Snapshot(common.Hash{0x02})
+
+	// Keep two diff layers on top of the disk, flattening 0x02 and 0x03
+	if err := snaps.Cap(common.Hash{0x05}, 2); err != nil {
+		t.Fatalf("failed to cap tree: %v", err)
+	}
+	if len(snaps.layers) != 4 {
+		t.Fatalf("layer count mismatch: have %d, want 4", len(snaps.layers))
+	}
+	for _, root := range []common.Hash{{0x03}, {0x04}, {0x05}, {0x14}} {
+		if snaps.Snapshot(root) == nil {
+			t.Fatalf("layer %x missing", root)
+		}
+	}
+	if _, ok := snaps.Snapshot(common.Hash{0x03}).(*diskLayer); !ok {
+		t.Fatalf("layer 0x03 not flattened into the disk")
+	}
+	if _, err := held.Account(common.Hash{0xaa}); err != ErrSnapshotStale {
+		t.Fatalf("flattened layer error mismatch: have %v, want %v", err, ErrSnapshotStale)
+	}
+	snap := snaps.Snapshot(common.Hash{0x05})
+	checkAccount(t, snap, common.Hash{0xaa}, 5)
+	checkAccount(t, snap, common.Hash{0x02}, 2)
+	checkAccount(t, snap, common.Hash{0x03}, 3)
+	checkAccount(t, snap, common.Hash{0x12}, 0)
+	checkStorage(t, snap, common.Hash{0xaa}, common.Hash{0x02}, []byte{2})
+	checkStorage(t, snap, common.Hash{0xaa}, common.Hash{0x05}, []byte{5})
+
+	// Flatten everything and make sure the disk contains the merged state
+	if err := snaps.Cap(common.Hash{0x05}, 0); err != nil {
+		t.Fatalf("failed to flatten tree: %v", err)
+	}
+	if len(snaps.layers) != 1 {
+		t.Fatalf("layer count mismatch: have %d, want 1", len(snaps.layers))
+	}
+	if root := rawdb.ReadSnapshotRoot(snaps.diskdb); root != (common.Hash{0x05}) {
+		t.Fatalf("persisted root mismatch: have %x, want 05", root)
+	}
+	if blob := rawdb.ReadAccountSnapshot(snaps.diskdb, common.Hash{0xaa}); !bytes.Equal(blob, testAccount(5)) {
+		t.Fatalf("persisted account mismatch: have %x, want %x", blob, testAccount(5))
+	}
+	// Reopening the tree on the same root reuses the snapshot
+	snaps = New(snaps.diskdb, snaps.triedb, common.Hash{0x05})
+	checkAccount(t, snaps.Snapshot(common.Hash{0x05}), common.Hash{0x04}, 4)
+}
@@ -81,6 +81,7 @@ type stateObject struct {
 
 	originStorage Storage // Storage cache of original entries to dedup rewrites
 	dirtyStorage  Storage // Storage entries that need to be flushed to disk
+	fakeStorage   Storage // Storage replacing the committed one, for debugging only
 
 	// Cache flags.
 	// When an object is marked suicided it will be delete from the trie
@@ -174,6 +175,10 @@ func (self *stateObject) GetState(db Database, key common.Hash) common.Hash {
 
 // GetCommittedState retrieves a value from the committed account storage trie.
 func (self *stateObject) GetCommittedState(db Database, key common.Hash) common.Hash {
+	// If the committed storage was replaced, look the entry up there
+	if self.fakeStorage != nil {
+		return self.fakeStorage[key]
+	}
 	// If we have the original value cached, return that
 	value, cached := self.originStorage[key]
 	if cached {
@@ -183,11 +188,26 @@ func (self *stateObject) GetCommittedState(db Database, key common.Hash) common.
 	if metrics.EnabledExpensive {
 		defer func(start time.Time) { self.db.StorageReads += time.Since(start) }(time.Now())
 	}
+	// If no live objects are available, attempt to use the snapshot, falling back
+	// to the trie if the snapshot doesn't cover the account (yet)
+	var (
+		enc []byte
+		err error
+	)
+	if self.db.snap != nil {
+		// If the object was destructed in this block (and potentially resurrected),
+		// the storage has been cleared out, the snapshot must not be consulted
+		if _, destructed := self.db.snapDestructs[self.addrHash]; destructed {
+			return common.Hash{}
+		}
+		enc, err = self.db.snap.Storage(self.addrHash, crypto.Keccak256Hash(key[:]))
+	}
 	// Otherwise load the value from the database
-	enc, err := self.getTrie(db).TryGet(key[:])
-	if err != nil {
-		self.setError(err)
-		return common.Hash{}
+	if self.db.snap == nil || err != nil {
+		if enc, err = self.getTrie(db).TryGet(key[:]); err != nil {
+			self.setError(err)
+			return common.Hash{}
+		}
 	}
 	if len(enc) > 0 {
 		_, content, _, err := rlp.Split(enc)
@@ -220,14 +240,40 @@ func (self *stateObject) setState(key, value common.Hash) {
 	self.dirtyStorage[key] = value
 }
 
+// SetStorage replaces the entire committed storage of the account, discarding
+// any pending changes. It's not journaled and the replacement is never written
+// into the storage trie, so it should only be used for debugging.
+func (self *stateObject) SetStorage(storage map[common.Hash]common.Hash) {
+	self.fakeStorage = make(Storage, len(storage))
+	for key, value := range storage {
+		self.fakeStorage[key] = value
+	}
+	self.dirtyStorage = make(Storage)
+}
+
 // updateTrie writes cached storage modifications into the object's storage trie.
 func (self *stateObject) updateTrie(db Database) Trie {
 	// Track the amount of time wasted on updating the storge trie
 	if metrics.EnabledExpensive {
 		defer func(start time.Time) { self.db.StorageUpdates += time.Since(start) }(time.Now())
 	}
-	// Update all the dirty slots in the trie
+	// Update all the dirty slots in the trie, or in the replaced storage if any
 	tr := self.getTrie(db)
+	if self.fakeStorage != nil {
+		for key, value := range self.dirtyStorage {
+			delete(self.dirtyStorage, key)
+			self.fakeStorage[key] = value
+		}
+		return tr
+	}
+	// Record the slots into the snapshot diff too, deletions as empty values
+	var storage map[common.Hash][]byte
+	if self.db.snap != nil && len(self.dirtyStorage) > 0 {
+		if storage = self.db.snapStorage[self.addrHash]; storage == nil {
+			storage = make(map[common.Hash][]byte)
+			self.db.snapStorage[self.addrHash] = storage
+		}
+	}
 	for key, value := range self.dirtyStorage {
 		delete(self.dirtyStorage, key)
 
@@ -237,13 +283,17 @@ func (self *stateObject) updateTrie(db Database) Trie {
 		}
 		self.originStorage[key] = value
 
+		var v []byte
 		if (value == common.Hash{}) {
 			self.setError(tr.TryDelete(key[:]))
-			continue
+		} else {
+			// Encoding []byte cannot fail, ok to ignore the error.
+			v, _ = rlp.EncodeToBytes(bytes.TrimLeft(value[:], "\x00"))
+			self.setError(tr.TryUpdate(key[:], v))
+		}
+		if storage != nil {
+			storage[crypto.Keccak256Hash(key[:])] = v
 		}
-		// Encoding []byte cannot fail, ok to ignore the error.
-		v, _ := rlp.EncodeToBytes(bytes.TrimLeft(value[:], "\x00"))
-		self.setError(tr.TryUpdate(key[:], v))
 	}
 	return tr
 }
@@ -324,6 +374,9 @@ func (self *stateObject) deepCopy(db *StateDB) *stateObject {
 	stateObject.code = self.code
 	stateObject.dirtyStorage = self.dirtyStorage.Copy()
 	stateObject.originStorage = self.originStorage.Copy()
+	if self.fakeStorage != nil {
+		stateObject.fakeStorage = self.fakeStorage.Copy()
+	}
 	stateObject.suicided = self.suicided
 	stateObject.dirtyCode = self.dirtyCode
 	stateObject.deleted = self.deleted
@@ -18,7 +18,10 @@ package state
 
 import (
 	"bytes"
+	"encoding/json"
+	"fmt"
 	"math/big"
+	"strings"
 	"testing"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -52,7 +55,7 @@ func (s *StateSuite) TestDump(c *checker.C) {
 	s.state.Commit(false)
 
 	// check that dump contains the state objects that are in trie
-	got := string(s.state.Dump())
+	got := string(s.state.Dump(nil))
 	want := `{
     "root": "71edff0130dd2385947095001c73d9e28d862fc286fca2b922ca6f6f3cddfdd2",
     "accounts": {
@@ -244,3 +247,94 @@ func compareStateObjects(so0, so1 *stateObject, t *testing.T) {
 		}
 	}
 }
+
+// Tests that the state can be dumped page by page, each account being returned
+// exactly once, and that the code and storage can be left out.
+func TestIteratorDump(t *testing.T) {
+	state, _ := New(common.Hash{}, NewDatabase(rawdb.NewMemoryDatabase()))
+	for i := byte(1); i <= 10; i++ {
+		state.AddBalance(common.Address{i}, big.NewInt(int64(i)))
+	}
+	state.SetCode(common.Address{0x01}, []byte{0x60, 0x00})
+	state.SetState(common.Address{0x01}, common.Hash{0x01}, common.Hash{0x02})
+	state.Commit(false)
+
+	var (
+		seen = make(map[string]bool)
+		conf = &DumpConfig{Max: 3}
+	)
+	for pages := 1; ; pages++ {
+		dump := state.IteratorDump(conf)
+		if len(dump.Accounts) > 3 {
+			t.Fatalf("page %d: too many accounts: have %d, want at most 3", pages, len(dump.Accounts))
+		}
+		for addr := range dump.Accounts {
+			if seen[addr] {
+				t.Fatalf("page %d: account %s returned twice", pages, addr)
+			}
+			seen[addr] = true
+		}
+		if dump.Next == nil {
+			if pages != 4 {
+				t.Fatalf("page count mismatch: have %d, want 4", pages)
+			}
+			break
+		}
+		conf.Start = dump.Next
+	}
+	if len(seen) != 10 {
+		t.Fatalf("account count mismatch: have %d, want 10", len(seen))
+	}
+	// Ensure the code and storage are only dumped if requested
+	full := state.RawDump(nil).Accounts[common.Bytes2Hex(common.Address{0x01}.Bytes())]
+	if full.Code != "6000" || len(full.Storage) != 1 {
+		t.Errorf("full dump mismatch: code %q, storage %v", full.Code, full.Storage)
+	}
+	bare := state.RawDump(&DumpConfig{SkipCode: true, SkipStorage: true}).Accounts[common.Bytes2Hex(common.Address{0x01}.Bytes())]
+	if bare.Code != "" || bare.Storage != nil {
+		t.Errorf("bare dump mismatch: code %q, storage %v", bare.Code, bare.Storage)
+	}
+	// Ensure the skipped fields are left out of the encoding, but not empty ones
+	for i, conf := range []*DumpConfig{nil, {SkipCode: true, SkipStorage: true}} {
+		blob, _ := json.Marshal(state.RawDump(conf).Accounts[common.Bytes2Hex(common.Address{0x02}.Bytes())])
+		if have := strings.Contains(string(blob), `"code":`); have != (i == 0) {
+			t.Errorf("config %d: code presence mismatch: %s", i, blob)
+		}
+		if have := strings.Contains(string(blob), `"storage":`); have != (i == 0) {
+			t.Errorf("config %d: storage presence mismatch: %s", i, blob)
+		}
+	}
+}
+
+// Tests that the iterative dump writes the root and then one account per line,
+// each carrying its address.
+func TestIterativeDump(t *testing.T) {
+	state, _ := New(common.Hash{}, NewDatabase(rawdb.NewMemoryDatabase()))
+	for i := byte(1); i <= 3; i++ {
+		state.AddBalance(common.Address{i}, big.NewInt(int64(i)))
+	}
+	root, _ := state.Commit(false)
+
+	out := new(bytes.Buffer)
+	state.IterativeDump(nil, json.NewEncoder(out))
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("line count mismatch: have %d, want 4:\n%s", len(lines), out)
+	}
+	if want := fmt.Sprintf(`{"root":"%s"}`, root.Hex()); lines[0] != want {
+		t.Errorf("root line mismatch: have %s, want %s", lines[0], want)
+	}
+	for _, line := range lines[1:] {
+		var account DumpAccount
+		if err := json.Unmarshal([]byte(line), &account); err != nil {
+			t.Fatalf("failed to decode account %s: %v", line, err)
+		}
+		if account.Address == nil {
+			t.Fatalf("account without address: %s", line)
+		}
+		if want := new(big.Int).SetBytes(account.Address[:1]).String(); account.Balance != want {
+			t.Errorf("account %s: balance mismatch: have %s, want %s", account.Address.Hex(), account.Balance, want)
+		}
+	}
+}
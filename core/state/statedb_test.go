@@ -27,12 +27,16 @@ import (
 	"strings"
 	"testing"
 	"testing/quick"
+	"time"
 
 	check "gopkg.in/check.v1"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state/snapshot"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
 )
 
 // Tests that updating a state trie does not leak any database writes prior to
@@ -449,3 +453,138 @@ func TestCopyOfCopy(t *testing.T) {
 		t.Fatalf("2nd copy fail, expected 42, got %v", got)
 	}
 }
+
+// Tests that replacing the storage of an account hides its committed slots, while
+// changes on top of the replacement are still journaled and survive finalisation.
+func TestSetStorage(t *testing.T) {
+	db := NewDatabase(rawdb.NewMemoryDatabase())
+	state, _ := New(common.Hash{}, db)
+
+	addr := common.HexToAddress("0xaa")
+	state.SetState(addr, common.HexToHash("0x01"), common.HexToHash("0x11"))
+	root, _ := state.Commit(false)
+	state, _ = New(root, db)
+
+	state.SetState(addr, common.HexToHash("0x03"), common.HexToHash("0x33"))
+	state.SetStorage(addr, map[common.Hash]common.Hash{common.HexToHash("0x02"): common.HexToHash("0x22")})
+
+	expect := func(key, want string) {
+		t.Helper()
+		if have := state.GetState(addr, common.HexToHash(key)); have != common.HexToHash(want) {
+			t.Errorf("slot %s mismatch: have %x, want %s", key, have, want)
+		}
+	}
+	expect("0x01", "0x")
+	expect("0x02", "0x22")
+	expect("0x03", "0x")
+
+	snapshot := state.Snapshot()
+	state.SetState(addr, common.HexToHash("0x02"), common.HexToHash("0x2222"))
+	expect("0x02", "0x2222")
+	state.RevertToSnapshot(snapshot)
+	expect("0x02", "0x22")
+
+	state.SetState(addr, common.HexToHash("0x04"), common.HexToHash("0x44"))
+	state.IntermediateRoot(false)
+	expect("0x04", "0x44")
+	expect("0x02", "0x22")
+
+	if copy := state.Copy(); copy.GetState(addr, common.HexToHash("0x02")) != common.HexToHash("0x22") {
+		t.Errorf("replaced storage not copied")
+	}
+}
+
+// Tests that a state backed by a snapshot reads the same accounts and storage as
+// one backed by the tries alone, across account destructions and recreations.
+func TestSnapshotReads(t *testing.T) {
+	var (
+		db    = NewDatabase(rawdb.NewMemoryDatabase())
+		addrs = []common.Address{{0x01}, {0x02}, {0x03}, {0x04}}
+		slots = []common.Hash{{0x01}, {0x02}, {0x03}}
+	)
+	state, _ := New(common.Hash{}, db)
+	for i, addr := range addrs {
+		state.SetBalance(addr, big.NewInt(int64(i+1)))
+		state.SetState(addr, slots[0], common.Hash{byte(i + 1)})
+	}
+	root, _ := state.Commit(false)
+	snaps := snapshot.New(db.TrieDB().DiskDB().(ethdb.KeyValueStore), db.TrieDB(), root)
+
+	// wait blocks until the disk layer of the snapshot is generated
+	wait := func(root common.Hash) {
+		t.Helper()
+
+		for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+			if _, err := snaps.Snapshot(root).Account(crypto.Keccak256Hash(addrs[0][:])); err != snapshot.ErrNotCoveredYet {
+				break
+			}
+			if time.Since(start) > 3*time.Second {
+				t.Fatalf("snapshot generation timed out")
+			}
+		}
+	}
+	wait(root)
+
+	check := func(root common.Hash) {
+		t.Helper()
+
+		snapState, _ := NewWithSnapshot(root, db, snaps)
+		trieState, _ := New(root, db)
+		for _, addr := range addrs {
+			if have, want := snapState.GetBalance(addr), trieState.GetBalance(addr); have.Cmp(want) != 0 {
+				t.Fatalf("account %x: balance mismatch: have %v, want %v", addr, have, want)
+			}
+			if have, want := snapState.Exist(addr), trieState.Exist(addr); have != want {
+				t.Fatalf("account %x: existence mismatch: have %v, want %v", addr, have, want)
+			}
+			for _, slot := range slots {
+				if have, want := snapState.GetState(addr, slot), trieState.GetState(addr, slot); have != want {
+					t.Fatalf("account %x: slot %x mismatch: have %x, want %x", addr, slot, have, want)
+				}
+			}
+		}
+	}
+	for i := 0; i < 8; i++ {
+		state, _ := NewWithSnapshot(root, db, snaps)
+
+		state.AddBalance(addrs[0], big.NewInt(1))
+		state.SetState(addrs[0], slots[i%len(slots)], common.Hash{byte(i)})
+		switch i {
+		case 2:
+			// Destruct an account and recreate it within the same block
+			state.Suicide(addrs[1])
+			state.Finalise(true)
+			state.SetState(addrs[1], slots[1], common.Hash{0xff})
+			state.AddBalance(addrs[1], big.NewInt(1))
+			if value := state.GetState(addrs[1], slots[0]); value != (common.Hash{}) {
+				t.Fatalf("destructed slot still present: %x", value)
+			}
+		case 3:
+			// Overwrite an account with a fresh one, dropping its storage
+			state.CreateAccount(addrs[2])
+			state.SetState(addrs[2], slots[2], common.Hash{0xee})
+			if value := state.GetState(addrs[2], slots[0]); value != (common.Hash{}) {
+				t.Fatalf("overwritten slot still present: %x", value)
+			}
+		case 4:
+			// Overwrite an account, but revert it
+			id := state.Snapshot()
+			state.CreateAccount(addrs[3])
+			state.RevertToSnapshot(id)
+		case 5:
+			// Delete an account for good
+			state.Suicide(addrs[3])
+		}
+		root, _ = state.Commit(true)
+		if snaps.Snapshot(root) == nil {
+			t.Fatalf("block %d: snapshot missing", i)
+		}
+		check(root)
+	}
+	// Flatten everything into the disk layer and wait for it to be generated
+	if err := snaps.Cap(root, 0); err != nil {
+		t.Fatalf("failed to flatten snapshot: %v", err)
+	}
+	wait(root)
+	check(root)
+}
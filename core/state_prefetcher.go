@@ -78,8 +78,7 @@ func precacheTransaction(config *params.ChainConfig, bc ChainContext, author *co
 	}
 	// Create the EVM and execute the transaction
 	context := NewEVMContext(msg, header, bc, author)
-	// vm := vm.NewEVM(context, statedb, config, cfg)
-	vm := vm.NewEVMWithFlag(context, statedb, config, cfg, true)
+	vm := vm.NewEVM(context, statedb, config, cfg)
 	_, _, _, err = ApplyMessage(vm, msg, gaspool)
 	return err
 }
@@ -27,17 +27,10 @@ import (
 	"github.com/ethereum/go-ethereum/params"
 
 	// Add
-	"fmt"
-	"github.com/ethereum/go-ethereum/common/hexutil"
-	// "gopkg.in/mgo.v2/bson"
+	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/mongo"
-	// "time"
-	"gopkg.in/mgo.v2"
-	"encoding/json"
 )
 
-
-
 // StateProcessor is a basic Processor, which takes care of transitioning
 // state from one point to another.
 //
@@ -104,37 +97,26 @@ func (p *StateProcessor) Process(block *types.Block, statedb *state.StateDB, cfg
 	return receipts, allLogs, *usedGas, nil
 }
 
-
 // ApplyTransaction attempts to apply a transaction to the given state database
 // and uses the input parameters for its environment. It returns the receipt
 // for the transaction, gas used and an error if the transaction failed,
 // indicating the block was invalid.
 func ApplyTransaction(config *params.ChainConfig, bc ChainContext, author *common.Address, gp *GasPool, statedb *state.StateDB, header *types.Header, tx *types.Transaction, usedGas *uint64, cfg vm.Config) (*types.Receipt, uint64, error) {
-	mongo.TraceGlobal.Reset()
-	mongo.TxVMErr = ""
-
 	msg, err := tx.AsMessage(types.MakeSigner(config, header.Number))
 	if err != nil {
 		return nil, 0, err
 	}
-	
+
 	// Create a new context to be used in the EVM environment
 	context := NewEVMContext(msg, header, bc, author)
-	toaddr := ""
-	if msg.To() == nil {
-		toaddr = "0x0"
-	} else {
-		tempt := *msg.To()
-		toaddr = tempt.String()
+	// Create a new environment which holds all relevant information
+	// about the transaction and calling mechanisms.
+	vmenv := vm.NewEVM(context, statedb, config, cfg)
+	if cfg.TraceSink != nil && cfg.TraceFilter.Match(header.Number.Uint64(), msg.From(), msg.To(), msg.Data()) {
+		vmenv.Recorder = mongo.NewRecorder()
 	}
-
-	vmenv := vm.NewEVMWithFlag(context, statedb, config, cfg, false)
-
-	// Double clean the trace to prevent duplications
-	mongo.TraceGlobal.Reset()
+	// Apply the transaction to the current state (included in the env)
 	_, gas, failed, err := ApplyMessage(vmenv, msg, gp)
-
-
 	if err != nil {
 		return nil, 0, err
 	}
@@ -164,43 +146,33 @@ func ApplyTransaction(config *params.ChainConfig, bc ChainContext, author *commo
 	receipt.BlockNumber = header.Number
 	receipt.TransactionIndex = uint(statedb.TxIndex())
 
-	mongo.BashTxs[mongo.CurrentNum] = mongo.Transac{statedb.BlockHash().Hex(), header.Number.String(), msg.From().String(), fmt.Sprintf("%d", tx.Gas()), 
-			tx.GasPrice().String(), tx.Hash().Hex(), hexutil.Encode(tx.Data()), fmt.Sprintf("0x%x", tx.Nonce()), toaddr,
-			fmt.Sprintf("0x%x", statedb.TxIndex()), msg.Value().String(), mongo.TraceGlobal.String(), receipt.ContractAddress.String(),
-			fmt.Sprintf("%d", receipt.CumulativeGasUsed), fmt.Sprintf("%d", receipt.GasUsed), fmt.Sprintf("0x%d", receipt.Status), mongo.TxVMErr}
-
-	if mongo.CurrentNum != mongo.BashNum - 1 {
-		mongo.CurrentNum = mongo.CurrentNum + 1
-	} else {
-		db_tx := mongo.SessionGlobal.DB("geth").C("transaction")
-		if db_tx == nil {
-			var recon_err error
-            mongo.SessionGlobal, recon_err = mgo.Dial("")
-            if recon_err != nil {
-					print("Error in database")
-                    panic(recon_err)
-            }
-			db_tx = mongo.SessionGlobal.DB("geth").C("transaction")
+	if vmenv.Recorder != nil && cfg.TraceFilter.MatchOutcome(failed) {
+		record := &mongo.Transac{
+			Version:              mongo.SchemaVersion,
+			Tx_BlockHash:         statedb.BlockHash(),
+			Tx_BlockNum:          header.Number.Uint64(),
+			Tx_FromAddr:          msg.From(),
+			Tx_Gas:               tx.Gas(),
+			Tx_GasPrice:          mongo.NewDecimal(tx.GasPrice()),
+			Tx_Hash:              tx.Hash(),
+			Tx_Input:             tx.Data(),
+			Tx_Nonce:             tx.Nonce(),
+			Tx_ToAddr:            msg.To(),
+			Tx_Index:             uint64(statedb.TxIndex()),
+			Tx_Value:             mongo.NewDecimal(msg.Value()),
+			Tx_Trace:             vmenv.Recorder.Trace(),
+			Tx_Calls:             vmenv.Recorder.Calls(),
+			Re_CumulativeGasUsed: receipt.CumulativeGasUsed,
+			Re_GasUsed:           receipt.GasUsed,
+			Re_Status:            receipt.Status,
+			Re_FailReason:        vmenv.Recorder.Error(),
 		}
-
-		session_err := db_tx.Insert(mongo.BashTxs...)
-		if session_err != nil {
-			mongo.SessionGlobal.Refresh()
-			for i := 0; i < mongo.BashNum; i++ {
-				 session_err = db_tx.Insert(&mongo.BashTxs[i]) 
-				 if session_err != nil {
-					json_tx, json_err := json.Marshal(&mongo.BashTxs[i])
-					if json_err != nil {
-						mongo.ErrorFile.WriteString(fmt.Sprintf("Transaction;%s;%s\n", mongo.BashTxs[i].(mongo.Transac).Tx_Hash, json_err))
-					}
-					mongo.ErrorFile.WriteString(fmt.Sprintf("Transaction|%s|%s\n", json_tx, session_err))
-			      }
-			 }
+		if msg.To() == nil {
+			record.Re_contractAddress = &receipt.ContractAddress
+		}
+		if err := cfg.TraceSink.Put(record); err != nil {
+			log.Error("Failed to record transaction trace", "hash", tx.Hash(), "err", err)
 		}
-
-		mongo.CurrentNum = 0
 	}
-
-
 	return receipt, gas, err
 }
@@ -0,0 +1,341 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/mongo"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// testTrace is the opcode trace of calling the test contract at 0xc0de, storing
+// 1 in slot 0.
+var testTrace = []mongo.TraceStep{
+	{PC: 0, Op: "PUSH1", Depth: 1, Address: common.HexToAddress("0xc0de"), Arg: "1"},
+	{PC: 2, Op: "PUSH1", Depth: 1, Address: common.HexToAddress("0xc0de"), Arg: "0"},
+	{PC: 4, Op: "SSTORE", Depth: 1, Address: common.HexToAddress("0xc0de")},
+	{PC: 5, Op: "STOP", Depth: 1, Address: common.HexToAddress("0xc0de")},
+}
+
+var (
+	// testTraceKey is the key of the account sending the transactions of the
+	// trace recording tests.
+	testTraceKey, _ = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+	testTraceAddr   = crypto.PubkeyToAddress(testTraceKey.PublicKey)
+)
+
+// newTraceTestChain creates a chain with the given accounts and the funded test
+// sender in its genesis, and imports n blocks generated by gen with the given
+// VM configuration. The chain must be stopped by the caller.
+func newTraceTestChain(t *testing.T, alloc GenesisAlloc, n int, gen func(int, *BlockGen), vmcfg vm.Config) (*BlockChain, []*types.Block) {
+	genalloc := GenesisAlloc{testTraceAddr: {Balance: big.NewInt(1000000000000000)}}
+	for addr, account := range alloc {
+		genalloc[addr] = account
+	}
+	var (
+		db      = rawdb.NewMemoryDatabase()
+		gspec   = &Genesis{Config: params.TestChainConfig, Alloc: genalloc}
+		genesis = gspec.MustCommit(db)
+	)
+	blocks, _ := GenerateChain(gspec.Config, genesis, ethash.NewFaker(), db, n, gen)
+
+	chain, err := NewBlockChain(db, nil, gspec.Config, ethash.NewFaker(), vmcfg, nil)
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	if _, err := chain.InsertChain(blocks); err != nil {
+		chain.Stop()
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	return chain, blocks
+}
+
+// addTraceTx adds a transaction calling the given account from the test sender
+// to the block being generated.
+func addTraceTx(block *BlockGen, to common.Address) {
+	signer := types.NewEIP155Signer(params.TestChainConfig.ChainID)
+	tx, _ := types.SignTx(types.NewTransaction(block.TxNonce(testTraceAddr), to, big.NewInt(0), 100000, big.NewInt(1), nil), signer, testTraceKey)
+	block.AddTx(tx)
+}
+
+// Tests that the transactions executed during block import are handed over to
+// the configured trace sink, together with their opcode traces.
+func TestTraceRecording(t *testing.T) {
+	var (
+		contract = common.HexToAddress("0xc0de")
+		sink     = mongo.NewMemorySink()
+		alloc    = GenesisAlloc{
+			contract: {Balance: big.NewInt(0), Code: common.FromHex("0x6001600055")}, // PUSH1 1 PUSH1 0 SSTORE
+		}
+	)
+	chain, blocks := newTraceTestChain(t, alloc, 2, func(i int, block *BlockGen) { addTraceTx(block, contract) }, vm.Config{TraceSink: sink})
+	defer chain.Stop()
+
+	records := sink.Records()
+	if len(records) != len(blocks) {
+		t.Fatalf("record count mismatch: have %d, want %d", len(records), len(blocks))
+	}
+	for i, record := range records {
+		tx := blocks[i].Transactions()[0]
+		if record.Tx_Hash != tx.Hash() {
+			t.Errorf("record %d: tx hash mismatch: have %x, want %x", i, record.Tx_Hash, tx.Hash())
+		}
+		if record.Tx_BlockHash != blocks[i].Hash() {
+			t.Errorf("record %d: block hash mismatch: have %x, want %x", i, record.Tx_BlockHash, blocks[i].Hash())
+		}
+		if !reflect.DeepEqual(record.Tx_Trace, testTrace) {
+			t.Errorf("record %d: trace mismatch: have %v, want %v", i, record.Tx_Trace, testTrace)
+		}
+	}
+}
+
+// Tests that transactions executed concurrently each record their own opcode
+// trace, without interleaving with one another.
+func TestConcurrentTraceRecording(t *testing.T) {
+	var (
+		contract = common.HexToAddress("0xc0de")
+		alloc    = GenesisAlloc{
+			contract: {Balance: big.NewInt(0), Code: common.FromHex("0x6001600055")}, // PUSH1 1 PUSH1 0 SSTORE
+		}
+	)
+	chain, blocks := newTraceTestChain(t, alloc, 1, func(i int, block *BlockGen) { addTraceTx(block, contract) }, vm.Config{})
+	defer chain.Stop()
+
+	var (
+		sink    = mongo.NewMemorySink()
+		cfg     = vm.Config{TraceSink: sink}
+		workers = 16
+		errc    = make(chan error, workers)
+	)
+	for i := 0; i < workers; i++ {
+		go func() {
+			statedb, err := state.New(chain.Genesis().Root(), state.NewDatabase(chain.db))
+			if err != nil {
+				errc <- err
+				return
+			}
+			_, _, _, err = chain.Processor().Process(blocks[0], statedb, cfg)
+			errc <- err
+		}()
+	}
+	for i := 0; i < workers; i++ {
+		if err := <-errc; err != nil {
+			t.Fatalf("failed to process block: %v", err)
+		}
+	}
+	records := sink.Records()
+	if len(records) != workers {
+		t.Fatalf("record count mismatch: have %d, want %d", len(records), workers)
+	}
+	for i, record := range records {
+		if !reflect.DeepEqual(record.Tx_Trace, testTrace) {
+			t.Errorf("record %d: trace mismatch: have %v, want %v", i, record.Tx_Trace, testTrace)
+		}
+	}
+}
+
+// Tests that the call frames of a transaction are recorded, and that the steps
+// are attributed to the executed code even when running in a delegate context.
+func TestTraceCallFrames(t *testing.T) {
+	var (
+		callee   = common.HexToAddress("0xbeef")
+		caller   = common.HexToAddress("0xca11")
+		delegate = common.HexToAddress("0xde1e")
+		sink     = mongo.NewMemorySink()
+		alloc    = GenesisAlloc{
+			callee:   {Balance: big.NewInt(0), Code: common.FromHex("0x6001600055")},                       // PUSH1 1 PUSH1 0 SSTORE
+			caller:   {Balance: big.NewInt(0), Code: common.FromHex("0x6000600060006000600061beef5af100")}, // CALL 0xbeef
+			delegate: {Balance: big.NewInt(0), Code: common.FromHex("0x600060006000600061beef5af400")},     // DELEGATECALL 0xbeef
+		}
+	)
+	chain, _ := newTraceTestChain(t, alloc, 1, func(i int, block *BlockGen) {
+		addTraceTx(block, caller)
+		addTraceTx(block, delegate)
+	}, vm.Config{TraceSink: sink})
+	defer chain.Stop()
+
+	records := sink.Records()
+	if len(records) != 2 {
+		t.Fatalf("record count mismatch: have %d, want %d", len(records), 2)
+	}
+	tests := []struct {
+		typ     string
+		context common.Address
+	}{
+		{"CALL", caller},
+		{"DELEGATECALL", delegate},
+	}
+	for i, tt := range tests {
+		calls := records[i].Tx_Calls
+		if len(calls) != 2 {
+			t.Fatalf("record %d: frame count mismatch: have %d, want %d", i, len(calls), 2)
+		}
+		if calls[0].Type != "CALL" || calls[0].Depth != 1 || calls[0].From != testTraceAddr || calls[0].To != tt.context {
+			t.Errorf("record %d: outer frame mismatch: have %+v", i, calls[0])
+		}
+		if calls[1].Type != tt.typ || calls[1].Depth != 2 || calls[1].From != tt.context || calls[1].To != callee {
+			t.Errorf("record %d: inner frame mismatch: have %+v", i, calls[1])
+		}
+		if calls[1].GasUsed == 0 || calls[1].GasUsed > calls[1].Gas || calls[1].Error != "" {
+			t.Errorf("record %d: inner frame outcome mismatch: have %+v", i, calls[1])
+		}
+		for _, step := range records[i].Tx_Trace {
+			want := tt.context
+			if step.Depth == 2 {
+				want = callee
+			}
+			if step.Address != want {
+				t.Errorf("record %d: step %d/%s address mismatch: have %x, want %x", i, step.PC, step.Op, step.Address, want)
+			}
+		}
+	}
+}
+
+// Tests that calls to non-existent accounts, which are skipped without running
+// any code since EIP-158, are still recorded as call frames.
+func TestTraceCallFramesEmptyAccount(t *testing.T) {
+	var (
+		missing = common.HexToAddress("0xdead")
+		caller  = common.HexToAddress("0xca11")
+		sink    = mongo.NewMemorySink()
+		alloc   = GenesisAlloc{
+			caller: {Balance: big.NewInt(0), Code: common.FromHex("0x6000600060006000600061dead5af100")}, // CALL 0xdead
+		}
+	)
+	chain, _ := newTraceTestChain(t, alloc, 1, func(i int, block *BlockGen) {
+		addTraceTx(block, caller)
+		addTraceTx(block, missing)
+	}, vm.Config{TraceSink: sink})
+	defer chain.Stop()
+
+	records := sink.Records()
+	if len(records) != 2 {
+		t.Fatalf("record count mismatch: have %d, want %d", len(records), 2)
+	}
+	// The contract calling the missing account records both frames
+	calls := records[0].Tx_Calls
+	if len(calls) != 2 {
+		t.Fatalf("frame count mismatch: have %d, want %d", len(calls), 2)
+	}
+	if calls[0].Type != "CALL" || calls[0].Depth != 1 || calls[0].From != testTraceAddr || calls[0].To != caller {
+		t.Errorf("outer frame mismatch: have %+v", calls[0])
+	}
+	if calls[1].Type != "CALL" || calls[1].Depth != 2 || calls[1].From != caller || calls[1].To != missing || calls[1].GasUsed != 0 || calls[1].Error != "" {
+		t.Errorf("inner frame mismatch: have %+v", calls[1])
+	}
+	// The transaction sent to the missing account records the outer frame
+	calls = records[1].Tx_Calls
+	if len(calls) != 1 {
+		t.Fatalf("direct call frame count mismatch: have %d, want %d", len(calls), 1)
+	}
+	if calls[0].Type != "CALL" || calls[0].Depth != 1 || calls[0].From != testTraceAddr || calls[0].To != missing || calls[0].GasUsed != 0 {
+		t.Errorf("direct call frame mismatch: have %+v", calls[0])
+	}
+}
+
+// Tests that storage accesses and logs are attached to the trace steps when
+// enabled in the VM configuration.
+func TestTraceStorageAndLogs(t *testing.T) {
+	var (
+		contract = common.HexToAddress("0xc0de")
+		sink     = mongo.NewMemorySink()
+		alloc    = GenesisAlloc{
+			contract: {
+				Balance: big.NewInt(0),
+				Code:    common.FromHex("0x600054506001600055604260005260aa60206000a100"), // SLOAD 0, SSTORE 1 in 0, LOG1 0xaa 0x42
+				Storage: map[common.Hash]common.Hash{{}: common.HexToHash("0x07")},
+			},
+		}
+	)
+	chain, _ := newTraceTestChain(t, alloc, 1, func(i int, block *BlockGen) { addTraceTx(block, contract) }, vm.Config{TraceSink: sink, TraceStorage: true, TraceLogs: true})
+	defer chain.Stop()
+
+	records := sink.Records()
+	if len(records) != 1 {
+		t.Fatalf("record count mismatch: have %d, want %d", len(records), 1)
+	}
+	want := map[string]mongo.TraceStep{
+		"SLOAD":  {Storage: &mongo.StorageAccess{Account: contract, Old: common.HexToHash("0x07"), New: common.HexToHash("0x07")}},
+		"SSTORE": {Storage: &mongo.StorageAccess{Account: contract, Old: common.HexToHash("0x07"), New: common.HexToHash("0x01")}},
+		"LOG1":   {Log: &mongo.LogEntry{Address: contract, Topics: []common.Hash{common.HexToHash("0xaa")}, Data: common.HexToHash("0x42").Bytes()}},
+	}
+	for _, step := range records[0].Tx_Trace {
+		expect := want[step.Op]
+		if !reflect.DeepEqual(step.Storage, expect.Storage) {
+			t.Errorf("step %d/%s: storage mismatch: have %+v, want %+v", step.PC, step.Op, step.Storage, expect.Storage)
+		}
+		if !reflect.DeepEqual(step.Log, expect.Log) {
+			t.Errorf("step %d/%s: log mismatch: have %+v, want %+v", step.PC, step.Op, step.Log, expect.Log)
+		}
+		delete(want, step.Op)
+	}
+	if len(want) != 0 {
+		t.Errorf("missing steps: %v", want)
+	}
+}
+
+// Tests that only the transactions selected by the trace filter are recorded.
+func TestTraceFilter(t *testing.T) {
+	var (
+		good  = common.HexToAddress("0xc0de")
+		bad   = common.HexToAddress("0xbad")
+		alloc = GenesisAlloc{
+			good: {Balance: big.NewInt(0), Code: common.FromHex("0x6001600055")}, // PUSH1 1 PUSH1 0 SSTORE
+			bad:  {Balance: big.NewInt(0), Code: common.FromHex("0xfe")},         // INVALID
+		}
+	)
+	tests := []struct {
+		config mongo.FilterConfig
+		want   []common.Address
+	}{
+		{mongo.FilterConfig{}, []common.Address{good, bad}},
+		{mongo.FilterConfig{Allow: []common.Address{good}}, []common.Address{good}},
+		{mongo.FilterConfig{Deny: []common.Address{good}}, []common.Address{bad}},
+		{mongo.FilterConfig{FailedOnly: true}, []common.Address{bad}},
+		{mongo.FilterConfig{FromBlock: 2}, nil},
+	}
+	for i, tt := range tests {
+		filter, err := mongo.NewFilter(tt.config)
+		if err != nil {
+			t.Fatalf("test %d: failed to create filter: %v", i, err)
+		}
+		sink := mongo.NewMemorySink()
+		chain, _ := newTraceTestChain(t, alloc, 1, func(i int, block *BlockGen) {
+			addTraceTx(block, good)
+			addTraceTx(block, bad)
+		}, vm.Config{TraceSink: sink, TraceFilter: filter})
+		chain.Stop()
+
+		var have []common.Address
+		for _, record := range sink.Records() {
+			have = append(have, *record.Tx_ToAddr)
+		}
+		if !reflect.DeepEqual(have, tt.want) {
+			t.Errorf("test %d: recorded transactions mismatch: have %x, want %x", i, have, tt.want)
+		}
+	}
+}
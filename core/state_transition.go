@@ -25,12 +25,6 @@ import (
 	"github.com/ethereum/go-ethereum/core/vm"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/params"
-
-	// "gopkg.in/mgo.v2"
-	// "gopkg.in/mgo.v2/bson"
-	// "time"
-	// "fmt"
-	"github.com/ethereum/go-ethereum/mongo"
 )
 
 var (
@@ -231,8 +225,9 @@ func (st *StateTransition) TransitionDb() (ret []byte, usedGas uint64, failed bo
 	// start_tempt13 := time.Now()
 
 	if vmerr != nil {
-		mongo.TxVMErr = vmerr.Error()
-
+		if evm.Recorder != nil {
+			evm.Recorder.CaptureError(vmerr)
+		}
 		log.Debug("VM returned with error", "err", vmerr)
 		// The only possible consensus-error would be if there wasn't
 		// sufficient balance to make the transfer happen. The first
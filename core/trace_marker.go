@@ -0,0 +1,134 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/mongo"
+	lru "github.com/hashicorp/golang-lru"
+)
+
+const (
+	// traceMarkerChanSize is the size of the channels listening to the chain and
+	// side events.
+	traceMarkerChanSize = 64
+
+	// traceSideCacheLimit is the number of non-canonical blocks to remember, so
+	// that they can be flipped back if a reorg makes them canonical again.
+	traceSideCacheLimit = 1024
+)
+
+// traceMarker follows the chain and side events of a block chain, flipping the
+// canonical flag of the trace records in the sink accordingly.
+type traceMarker struct {
+	chain *BlockChain
+	sink  mongo.TraceSink
+	side  *lru.Cache // Blocks last marked non-canonical, keyed by hash
+}
+
+// startTraceMarker creates a trace marker for the chain and starts following its
+// events. The marker terminates when the chain is stopped.
+func startTraceMarker(chain *BlockChain, sink mongo.TraceSink) {
+	side, _ := lru.New(traceSideCacheLimit)
+	marker := &traceMarker{
+		chain: chain,
+		sink:  sink,
+		side:  side,
+	}
+	var (
+		chainCh = make(chan ChainEvent, traceMarkerChanSize)
+		sideCh  = make(chan ChainSideEvent, traceMarkerChanSize)
+	)
+	chainSub := chain.SubscribeChainEvent(chainCh)
+	sideSub := chain.SubscribeChainSideEvent(sideCh)
+
+	chain.wg.Add(1)
+	go func() {
+		defer chain.wg.Done()
+		defer chainSub.Unsubscribe()
+		defer sideSub.Unsubscribe()
+
+		marker.loop(chainCh, sideCh, chainSub.Err(), sideSub.Err())
+	}()
+}
+
+// loop processes the chain events until either of the subscriptions ends.
+func (m *traceMarker) loop(chainCh <-chan ChainEvent, sideCh <-chan ChainSideEvent, chainErr, sideErr <-chan error) {
+	for {
+		select {
+		case ev := <-chainCh:
+			m.markCanonical(ev.Block.Header())
+		case ev := <-sideCh:
+			m.markSide(ev.Block.Header())
+		case <-chainErr:
+			return
+		case <-sideErr:
+			return
+		}
+	}
+}
+
+// markCanonical flips the records of a newly canonical block to canonical. As
+// a reorg only announces the blocks it drops, the ancestors previously marked
+// non-canonical are flipped back too.
+//
+// The events of the two feeds may be delivered out of order, so the current
+// canonical chain is consulted before flipping any block.
+func (m *traceMarker) markCanonical(header *types.Header) {
+	var blocks []common.Hash
+	for header != nil && m.isCanonical(header.Hash(), header.Number.Uint64()) {
+		blocks = append(blocks, header.Hash())
+		m.side.Remove(header.Hash())
+
+		if header.Number.Uint64() == 0 || !m.side.Contains(header.ParentHash) {
+			break
+		}
+		header = m.chain.GetHeader(header.ParentHash, header.Number.Uint64()-1)
+	}
+	if len(blocks) == 0 {
+		return
+	}
+	if err := m.sink.MarkCanonical(blocks, true); err != nil {
+		log.Error("Failed to mark traces canonical", "blocks", len(blocks), "err", err)
+	}
+}
+
+// markSide flips the records of a block that isn't canonical (any more) to
+// non-canonical.
+func (m *traceMarker) markSide(header *types.Header) {
+	// The block may have been reorged in by the time the event is processed, in
+	// which case the chain event making it canonical might already be gone
+	hash := header.Hash()
+	if m.isCanonical(hash, header.Number.Uint64()) {
+		m.markCanonical(header)
+		return
+	}
+	m.side.Add(hash, struct{}{})
+
+	if err := m.sink.MarkCanonical([]common.Hash{hash}, false); err != nil {
+		log.Error("Failed to mark traces non-canonical", "hash", hash, "err", err)
+	}
+}
+
+// isCanonical reports whether the block with the given hash and number is part
+// of the current canonical chain.
+func (m *traceMarker) isCanonical(hash common.Hash, number uint64) bool {
+	return rawdb.ReadCanonicalHash(m.chain.db, number) == hash
+}
@@ -0,0 +1,108 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/mongo"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// Tests that the canonical flag of the recorded traces follows the chain through
+// reorgs, including blocks becoming canonical again after having been dropped.
+func TestTraceCanonicalMarking(t *testing.T) {
+	var (
+		key, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		address = crypto.PubkeyToAddress(key.PublicKey)
+		db      = rawdb.NewMemoryDatabase()
+		gspec   = &Genesis{
+			Config: params.TestChainConfig,
+			Alloc:  GenesisAlloc{address: {Balance: big.NewInt(1000000000000000)}},
+		}
+		genesis = gspec.MustCommit(db)
+		signer  = types.NewEIP155Signer(gspec.Config.ChainID)
+	)
+	generate := func(n int, coinbase common.Address) []*types.Block {
+		blocks, _ := GenerateChain(gspec.Config, genesis, ethash.NewFaker(), db, n, func(i int, block *BlockGen) {
+			block.SetCoinbase(coinbase)
+			tx, _ := types.SignTx(types.NewTransaction(block.TxNonce(address), common.Address{}, big.NewInt(1), params.TxGas, big.NewInt(1), nil), signer, key)
+			block.AddTx(tx)
+		})
+		return blocks
+	}
+	var (
+		forkA = generate(4, common.Address{0xa})
+		forkB = generate(3, common.Address{0xb})
+	)
+	sink := mongo.NewMemorySink()
+	chain, _ := NewBlockChain(db, nil, gspec.Config, ethash.NewFaker(), vm.Config{TraceSink: sink}, nil)
+	defer chain.Stop()
+
+	// Import the first fork, then reorg to the longer second one
+	if _, err := chain.InsertChain(forkA[:2]); err != nil {
+		t.Fatalf("failed to insert fork A: %v", err)
+	}
+	checkCanonicalTraces(t, sink, forkA[:2], nil)
+
+	if _, err := chain.InsertChain(forkB); err != nil {
+		t.Fatalf("failed to insert fork B: %v", err)
+	}
+	checkCanonicalTraces(t, sink, forkB, forkA[:2])
+
+	// Extend the first fork past the second and ensure its blocks are flipped back
+	if _, err := chain.InsertChain(forkA[2:]); err != nil {
+		t.Fatalf("failed to extend fork A: %v", err)
+	}
+	checkCanonicalTraces(t, sink, forkA, forkB)
+}
+
+// checkCanonicalTraces waits until the records of the canonical blocks are all
+// flagged canonical and the ones of the side blocks are not.
+func checkCanonicalTraces(t *testing.T, sink *mongo.MemorySink, canonical, side []*types.Block) {
+	t.Helper()
+
+	want := make(map[common.Hash]bool)
+	for _, block := range canonical {
+		want[block.Hash()] = true
+	}
+	for _, block := range side {
+		want[block.Hash()] = false
+	}
+	var mismatch *mongo.Transac
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		mismatch = nil
+		for _, record := range sink.Records() {
+			if status, ok := want[record.Tx_BlockHash]; ok && record.Canonical != status {
+				mismatch = record
+				break
+			}
+		}
+		if mismatch == nil {
+			return
+		}
+	}
+	t.Fatalf("record of block %x: canonical mismatch: have %v, want %v", mismatch.Tx_BlockHash, mismatch.Canonical, want[mismatch.Tx_BlockHash])
+}
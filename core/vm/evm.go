@@ -23,6 +23,7 @@ import (
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/mongo"
 	"github.com/ethereum/go-ethereum/params"
 )
 
@@ -61,8 +62,7 @@ func run(evm *EVM, contract *Contract, input []byte, readOnly bool) ([]byte, err
 				}(evm.interpreter)
 				evm.interpreter = interpreter
 			}
-			return interpreter.Run(contract, input, readOnly, evm.redundency)
-			// return interpreter.Run(contract, input, readOnly)
+			return interpreter.Run(contract, input, readOnly)
 		}
 	}
 	return nil, ErrNoCompatibleInterpreter
@@ -105,6 +105,9 @@ type EVM struct {
 	Context
 	// StateDB gives access to the underlying state
 	StateDB StateDB
+	// Recorder collects the opcode trace of the executed transaction, nil
+	// if trace recording is disabled
+	Recorder *mongo.Recorder
 	// Depth is the current call stack
 	depth int
 
@@ -126,8 +129,6 @@ type EVM struct {
 	// available gas is calculated in gasCall* according to the 63/64 rule and later
 	// applied in opCall*.
 	callGasTemp uint64
-
-	redundency bool
 }
 
 // NewEVM returns a new EVM. The returned EVM is not thread safe and should
@@ -166,40 +167,6 @@ func NewEVM(ctx Context, statedb StateDB, chainConfig *params.ChainConfig, vmCon
 	return evm
 }
 
-func NewEVMWithFlag(ctx Context, statedb StateDB, chainConfig *params.ChainConfig, vmConfig Config, re bool) *EVM {
-	evm := &EVM{
-		Context:      ctx,
-		StateDB:      statedb,
-		vmConfig:     vmConfig,
-		chainConfig:  chainConfig,
-		chainRules:   chainConfig.Rules(ctx.BlockNumber),
-		interpreters: make([]Interpreter, 0, 1),
-		redundency:   re,
-	}
-
-	if chainConfig.IsEWASM(ctx.BlockNumber) {
-		// to be implemented by EVM-C and Wagon PRs.
-		// if vmConfig.EWASMInterpreter != "" {
-		//  extIntOpts := strings.Split(vmConfig.EWASMInterpreter, ":")
-		//  path := extIntOpts[0]
-		//  options := []string{}
-		//  if len(extIntOpts) > 1 {
-		//    options = extIntOpts[1..]
-		//  }
-		//  evm.interpreters = append(evm.interpreters, NewEVMVCInterpreter(evm, vmConfig, options))
-		// } else {
-		// 	evm.interpreters = append(evm.interpreters, NewEWASMInterpreter(evm, vmConfig))
-		// }
-		panic("No supported ewasm interpreter yet.")
-	}
-
-	// vmConfig.EVMInterpreter will be used by EVM-C, it won't be checked here
-	// as we always want to have the built-in EVM as the failover option.
-	evm.interpreters = append(evm.interpreters, NewEVMInterpreter(evm, vmConfig))
-	evm.interpreter = evm.interpreters[0]
-
-	return evm
-}
 // Cancel cancels any running EVM operation. This may be called concurrently and
 // it's safe to be called multiple times.
 func (evm *EVM) Cancel() {
@@ -240,9 +207,18 @@ func (evm *EVM) Call(caller ContractRef, addr common.Address, input []byte, gas
 		}
 		if precompiles[addr] == nil && evm.ChainConfig().IsEIP158(evm.BlockNumber) && value.Sign() == 0 {
 			// Calling a non existing account, don't do anything, but ping the tracer
-			if evm.vmConfig.Debug && evm.depth == 0 {
-				evm.vmConfig.Tracer.CaptureStart(caller.Address(), addr, false, input, gas, value)
-				evm.vmConfig.Tracer.CaptureEnd(ret, 0, 0, nil)
+			if evm.Recorder != nil {
+				evm.Recorder.CaptureEnter(CALL.String(), evm.depth+1, caller.Address(), addr, input, gas, value)
+				evm.Recorder.CaptureExit(nil, 0, nil)
+			}
+			if evm.vmConfig.Debug {
+				if evm.depth == 0 {
+					evm.vmConfig.Tracer.CaptureStart(caller.Address(), addr, false, input, gas, value)
+					evm.vmConfig.Tracer.CaptureEnd(ret, 0, 0, nil)
+				} else {
+					evm.vmConfig.Tracer.CaptureEnter(CALL, caller.Address(), addr, input, gas, value)
+					evm.vmConfig.Tracer.CaptureExit(ret, 0, nil)
+				}
 			}
 			return nil, gas, nil
 		}
@@ -254,6 +230,12 @@ func (evm *EVM) Call(caller ContractRef, addr common.Address, input []byte, gas
 	contract := NewContract(caller, to, value, gas)
 	contract.SetCallCode(&addr, evm.StateDB.GetCodeHash(addr), evm.StateDB.GetCode(addr))
 
+	if evm.Recorder != nil {
+		evm.Recorder.CaptureEnter(CALL.String(), evm.depth+1, caller.Address(), addr, input, gas, value)
+	}
+	if evm.vmConfig.Debug && evm.depth > 0 {
+		evm.vmConfig.Tracer.CaptureEnter(CALL, caller.Address(), addr, input, gas, value)
+	}
 	// Even if the account has no code, we need to continue because it might be a precompile
 	start := time.Now()
 
@@ -276,6 +258,12 @@ func (evm *EVM) Call(caller ContractRef, addr common.Address, input []byte, gas
 			contract.UseGas(contract.Gas)
 		}
 	}
+	if evm.Recorder != nil {
+		evm.Recorder.CaptureExit(ret, gas-contract.Gas, err)
+	}
+	if evm.vmConfig.Debug && evm.depth > 0 {
+		evm.vmConfig.Tracer.CaptureExit(ret, gas-contract.Gas, err)
+	}
 	return ret, contract.Gas, err
 }
 
@@ -309,6 +297,12 @@ func (evm *EVM) CallCode(caller ContractRef, addr common.Address, input []byte,
 	contract := NewContract(caller, to, value, gas)
 	contract.SetCallCode(&addr, evm.StateDB.GetCodeHash(addr), evm.StateDB.GetCode(addr))
 
+	if evm.Recorder != nil {
+		evm.Recorder.CaptureEnter(CALLCODE.String(), evm.depth+1, caller.Address(), addr, input, gas, value)
+	}
+	if evm.vmConfig.Debug && evm.depth > 0 {
+		evm.vmConfig.Tracer.CaptureEnter(CALLCODE, caller.Address(), addr, input, gas, value)
+	}
 	ret, err = run(evm, contract, input, false)
 	if err != nil {
 		evm.StateDB.RevertToSnapshot(snapshot)
@@ -316,6 +310,12 @@ func (evm *EVM) CallCode(caller ContractRef, addr common.Address, input []byte,
 			contract.UseGas(contract.Gas)
 		}
 	}
+	if evm.Recorder != nil {
+		evm.Recorder.CaptureExit(ret, gas-contract.Gas, err)
+	}
+	if evm.vmConfig.Debug && evm.depth > 0 {
+		evm.vmConfig.Tracer.CaptureExit(ret, gas-contract.Gas, err)
+	}
 	return ret, contract.Gas, err
 }
 
@@ -342,6 +342,12 @@ func (evm *EVM) DelegateCall(caller ContractRef, addr common.Address, input []by
 	contract := NewContract(caller, to, nil, gas).AsDelegate()
 	contract.SetCallCode(&addr, evm.StateDB.GetCodeHash(addr), evm.StateDB.GetCode(addr))
 
+	if evm.Recorder != nil {
+		evm.Recorder.CaptureEnter(DELEGATECALL.String(), evm.depth+1, caller.Address(), addr, input, gas, nil)
+	}
+	if evm.vmConfig.Debug && evm.depth > 0 {
+		evm.vmConfig.Tracer.CaptureEnter(DELEGATECALL, caller.Address(), addr, input, gas, nil)
+	}
 	ret, err = run(evm, contract, input, false)
 	if err != nil {
 		evm.StateDB.RevertToSnapshot(snapshot)
@@ -349,6 +355,12 @@ func (evm *EVM) DelegateCall(caller ContractRef, addr common.Address, input []by
 			contract.UseGas(contract.Gas)
 		}
 	}
+	if evm.Recorder != nil {
+		evm.Recorder.CaptureExit(ret, gas-contract.Gas, err)
+	}
+	if evm.vmConfig.Debug && evm.depth > 0 {
+		evm.vmConfig.Tracer.CaptureExit(ret, gas-contract.Gas, err)
+	}
 	return ret, contract.Gas, err
 }
 
@@ -380,6 +392,12 @@ func (evm *EVM) StaticCall(caller ContractRef, addr common.Address, input []byte
 	// future scenarios
 	evm.StateDB.AddBalance(addr, bigZero)
 
+	if evm.Recorder != nil {
+		evm.Recorder.CaptureEnter(STATICCALL.String(), evm.depth+1, caller.Address(), addr, input, gas, nil)
+	}
+	if evm.vmConfig.Debug && evm.depth > 0 {
+		evm.vmConfig.Tracer.CaptureEnter(STATICCALL, caller.Address(), addr, input, gas, nil)
+	}
 	// When an error was returned by the EVM or when setting the creation code
 	// above we revert to the snapshot and consume any gas remaining. Additionally
 	// when we're in Homestead this also counts for code storage gas errors.
@@ -390,6 +408,12 @@ func (evm *EVM) StaticCall(caller ContractRef, addr common.Address, input []byte
 			contract.UseGas(contract.Gas)
 		}
 	}
+	if evm.Recorder != nil {
+		evm.Recorder.CaptureExit(ret, gas-contract.Gas, err)
+	}
+	if evm.vmConfig.Debug && evm.depth > 0 {
+		evm.vmConfig.Tracer.CaptureExit(ret, gas-contract.Gas, err)
+	}
 	return ret, contract.Gas, err
 }
 
@@ -406,7 +430,7 @@ func (c *codeAndHash) Hash() common.Hash {
 }
 
 // create creates a new contract using code as deployment code.
-func (evm *EVM) create(caller ContractRef, codeAndHash *codeAndHash, gas uint64, value *big.Int, address common.Address) ([]byte, common.Address, uint64, error) {
+func (evm *EVM) create(caller ContractRef, codeAndHash *codeAndHash, gas uint64, value *big.Int, address common.Address, typ OpCode) ([]byte, common.Address, uint64, error) {
 	// Depth check execution. Fail if we're trying to execute above the
 	// limit.
 	if evm.depth > int(params.CallCreateDepth) {
@@ -443,6 +467,12 @@ func (evm *EVM) create(caller ContractRef, codeAndHash *codeAndHash, gas uint64,
 	if evm.vmConfig.Debug && evm.depth == 0 {
 		evm.vmConfig.Tracer.CaptureStart(caller.Address(), address, true, codeAndHash.code, gas, value)
 	}
+	if evm.Recorder != nil {
+		evm.Recorder.CaptureEnter(typ.String(), evm.depth+1, caller.Address(), address, codeAndHash.code, gas, value)
+	}
+	if evm.vmConfig.Debug && evm.depth > 0 {
+		evm.vmConfig.Tracer.CaptureEnter(typ, caller.Address(), address, codeAndHash.code, gas, value)
+	}
 	start := time.Now()
 
 	ret, err := run(evm, contract, nil, false)
@@ -478,6 +508,12 @@ func (evm *EVM) create(caller ContractRef, codeAndHash *codeAndHash, gas uint64,
 	if evm.vmConfig.Debug && evm.depth == 0 {
 		evm.vmConfig.Tracer.CaptureEnd(ret, gas-contract.Gas, time.Since(start), err)
 	}
+	if evm.Recorder != nil {
+		evm.Recorder.CaptureExit(ret, gas-contract.Gas, err)
+	}
+	if evm.vmConfig.Debug && evm.depth > 0 {
+		evm.vmConfig.Tracer.CaptureExit(ret, gas-contract.Gas, err)
+	}
 	return ret, address, contract.Gas, err
 
 }
@@ -485,7 +521,7 @@ func (evm *EVM) create(caller ContractRef, codeAndHash *codeAndHash, gas uint64,
 // Create creates a new contract using code as deployment code.
 func (evm *EVM) Create(caller ContractRef, code []byte, gas uint64, value *big.Int) (ret []byte, contractAddr common.Address, leftOverGas uint64, err error) {
 	contractAddr = crypto.CreateAddress(caller.Address(), evm.StateDB.GetNonce(caller.Address()))
-	return evm.create(caller, &codeAndHash{code: code}, gas, value, contractAddr)
+	return evm.create(caller, &codeAndHash{code: code}, gas, value, contractAddr, CREATE)
 }
 
 // Create2 creates a new contract using code as deployment code.
@@ -495,7 +531,7 @@ func (evm *EVM) Create(caller ContractRef, code []byte, gas uint64, value *big.I
 func (evm *EVM) Create2(caller ContractRef, code []byte, gas uint64, endowment *big.Int, salt *big.Int) (ret []byte, contractAddr common.Address, leftOverGas uint64, err error) {
 	codeAndHash := &codeAndHash{code: code}
 	contractAddr = crypto.CreateAddress2(caller.Address(), common.BigToHash(salt), codeAndHash.Hash().Bytes())
-	return evm.create(caller, codeAndHash, gas, endowment, contractAddr)
+	return evm.create(caller, codeAndHash, gas, endowment, contractAddr, CREATE2)
 }
 
 // ChainConfig returns the environment's chain configuration
@@ -25,9 +25,6 @@ import (
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/params"
 	"golang.org/x/crypto/sha3"
-
-	// Add fmt to format the string
-	"fmt"
 )
 
 var (
@@ -411,51 +408,51 @@ func opSha3(pc *uint64, interpreter *EVMInterpreter, contract *Contract, memory
 	stack.push(hash)
 
 	interpreter.intPool.put(offset, size)
-	return nil, hash.String(), nil
+	return nil, interpreter.traceConstant(hash), nil
 }
 
 func opAddress(pc *uint64, interpreter *EVMInterpreter, contract *Contract, memory *Memory, stack *Stack) ([]byte, string, error) {
 	address := interpreter.intPool.get().SetBytes(contract.Address().Bytes())
 	stack.push(address)
-	return nil, address.String(), nil
+	return nil, interpreter.traceConstant(address), nil
 }
 
 func opBalance(pc *uint64, interpreter *EVMInterpreter, contract *Contract, memory *Memory, stack *Stack) ([]byte, string, error) {
 	slot := stack.peek()
 	balance := interpreter.evm.StateDB.GetBalance(common.BigToAddress(slot))
 	slot.Set(balance)
-	return nil, balance.String(), nil
+	return nil, interpreter.traceConstant(balance), nil
 }
 
 func opOrigin(pc *uint64, interpreter *EVMInterpreter, contract *Contract, memory *Memory, stack *Stack) ([]byte, string, error) {
 	origin := interpreter.intPool.get().SetBytes(interpreter.evm.Origin.Bytes())
 	stack.push(origin)
-	return nil, origin.String(), nil
+	return nil, interpreter.traceConstant(origin), nil
 }
 
 func opCaller(pc *uint64, interpreter *EVMInterpreter, contract *Contract, memory *Memory, stack *Stack) ([]byte, string, error) {
 	caller := interpreter.intPool.get().SetBytes(contract.Caller().Bytes())
 	stack.push(caller)
-	return nil, caller.String(), nil
+	return nil, interpreter.traceConstant(caller), nil
 }
 
 func opCallValue(pc *uint64, interpreter *EVMInterpreter, contract *Contract, memory *Memory, stack *Stack) ([]byte, string, error) {
 	value := interpreter.intPool.get().Set(contract.value)
 	stack.push(value)
-	return nil, value.String(), nil
+	return nil, interpreter.traceConstant(value), nil
 }
 
 func opCallDataLoad(pc *uint64, interpreter *EVMInterpreter, contract *Contract, memory *Memory, stack *Stack) ([]byte, string, error) {
 	env := stack.pop()
 	value := interpreter.intPool.get().SetBytes(getDataBig(contract.Input, env, big32))
 	stack.push(value)
-	return nil, value.String(), nil
+	return nil, interpreter.traceConstant(value), nil
 }
 
 func opCallDataSize(pc *uint64, interpreter *EVMInterpreter, contract *Contract, memory *Memory, stack *Stack) ([]byte, string, error) {
 	value := interpreter.intPool.get().SetInt64(int64(len(contract.Input)))
 	stack.push(value)
-	return nil, value.String(), nil
+	return nil, interpreter.traceConstant(value), nil
 }
 
 func opCallDataCopy(pc *uint64, interpreter *EVMInterpreter, contract *Contract, memory *Memory, stack *Stack) ([]byte, string, error) {
@@ -471,13 +468,13 @@ func opCallDataCopy(pc *uint64, interpreter *EVMInterpreter, contract *Contract,
 	memory.Set(memOffset.Uint64(), length.Uint64(), data)
 	interpreter.intPool.put(memOffset, dataOffset, length)
 
-	return nil, datacopy.String(), nil
+	return nil, interpreter.traceConstant(datacopy), nil
 }
 
 func opReturnDataSize(pc *uint64, interpreter *EVMInterpreter, contract *Contract, memory *Memory, stack *Stack) ([]byte, string, error) {
 	value := interpreter.intPool.get().SetUint64(uint64(len(interpreter.returnData)))
 	stack.push(value)
-	return nil, value.String(), nil
+	return nil, interpreter.traceConstant(value), nil
 }
 
 func opReturnDataCopy(pc *uint64, interpreter *EVMInterpreter, contract *Contract, memory *Memory, stack *Stack) ([]byte, string, error) {
@@ -499,7 +496,7 @@ func opReturnDataCopy(pc *uint64, interpreter *EVMInterpreter, contract *Contrac
 
 	memory.Set(memOffset.Uint64(), length.Uint64(), returnDataCopy)
 
-	return nil, returndatacopy.String(), nil
+	return nil, interpreter.traceConstant(returndatacopy), nil
 }
 
 func opExtCodeSize(pc *uint64, interpreter *EVMInterpreter, contract *Contract, memory *Memory, stack *Stack) ([]byte, string, error) {
@@ -509,14 +506,14 @@ func opExtCodeSize(pc *uint64, interpreter *EVMInterpreter, contract *Contract,
 
 	extcodesize := new(big.Int).SetUint64(excodesize)
 
-	return nil, extcodesize.String(), nil
+	return nil, interpreter.traceConstant(extcodesize), nil
 }
 
 func opCodeSize(pc *uint64, interpreter *EVMInterpreter, contract *Contract, memory *Memory, stack *Stack) ([]byte, string, error) {
 	l := interpreter.intPool.get().SetInt64(int64(len(contract.Code)))
 	stack.push(l)
 
-	return nil, l.String(), nil
+	return nil, interpreter.traceConstant(l), nil
 }
 
 func opCodeCopy(pc *uint64, interpreter *EVMInterpreter, contract *Contract, memory *Memory, stack *Stack) ([]byte, string, error) {
@@ -532,7 +529,7 @@ func opCodeCopy(pc *uint64, interpreter *EVMInterpreter, contract *Contract, mem
 	memory.Set(memOffset.Uint64(), length.Uint64(), codeCopy)
 	interpreter.intPool.put(memOffset, codeOffset, length)
 	
-	return nil, codecopy.String(), nil
+	return nil, interpreter.traceConstant(codecopy), nil
 }
 
 func opExtCodeCopy(pc *uint64, interpreter *EVMInterpreter, contract *Contract, memory *Memory, stack *Stack) ([]byte, string, error) {
@@ -549,7 +546,7 @@ func opExtCodeCopy(pc *uint64, interpreter *EVMInterpreter, contract *Contract,
 	memory.Set(memOffset.Uint64(), length.Uint64(), codeCopy)
 
 	interpreter.intPool.put(memOffset, codeOffset, length)
-	return nil, codecopy.String(), nil
+	return nil, interpreter.traceConstant(codecopy), nil
 }
 
 // opExtCodeHash returns the code hash of a specified account.
@@ -592,7 +589,7 @@ func opExtCodeHash(pc *uint64, interpreter *EVMInterpreter, contract *Contract,
 func opGasprice(pc *uint64, interpreter *EVMInterpreter, contract *Contract, memory *Memory, stack *Stack) ([]byte, string, error) {
 	gasprice := interpreter.intPool.get().Set(interpreter.evm.GasPrice)
 	stack.push(gasprice)
-	return nil, gasprice.String(), nil
+	return nil, interpreter.traceConstant(gasprice), nil
 }
 
 func opBlockhash(pc *uint64, interpreter *EVMInterpreter, contract *Contract, memory *Memory, stack *Stack) ([]byte, string, error) {
@@ -609,37 +606,37 @@ func opBlockhash(pc *uint64, interpreter *EVMInterpreter, contract *Contract, me
 		stack.push(hash)
 	}
 	interpreter.intPool.put(num, n)
-	return nil, hash.String(), nil
+	return nil, interpreter.traceConstant(hash), nil
 }
 
 func opCoinbase(pc *uint64, interpreter *EVMInterpreter, contract *Contract, memory *Memory, stack *Stack) ([]byte, string, error) {
 	coinbase := interpreter.intPool.get().SetBytes(interpreter.evm.Coinbase.Bytes())
 	stack.push(coinbase)
-	return nil, coinbase.String(), nil
+	return nil, interpreter.traceConstant(coinbase), nil
 }
 
 func opTimestamp(pc *uint64, interpreter *EVMInterpreter, contract *Contract, memory *Memory, stack *Stack) ([]byte, string, error) {
 	timstamp := math.U256(interpreter.intPool.get().Set(interpreter.evm.Time))
 	stack.push(timstamp)
-	return nil, timstamp.String(), nil
+	return nil, interpreter.traceConstant(timstamp), nil
 }
 
 func opNumber(pc *uint64, interpreter *EVMInterpreter, contract *Contract, memory *Memory, stack *Stack) ([]byte, string, error) {
 	number := math.U256(interpreter.intPool.get().Set(interpreter.evm.BlockNumber))
 	stack.push(number)
-	return nil, number.String(), nil
+	return nil, interpreter.traceConstant(number), nil
 }
 
 func opDifficulty(pc *uint64, interpreter *EVMInterpreter, contract *Contract, memory *Memory, stack *Stack) ([]byte, string, error) {
 	difficulty := math.U256(interpreter.intPool.get().Set(interpreter.evm.Difficulty))
 	stack.push(difficulty)
-	return nil, difficulty.String(), nil
+	return nil, interpreter.traceConstant(difficulty), nil
 }
 
 func opGasLimit(pc *uint64, interpreter *EVMInterpreter, contract *Contract, memory *Memory, stack *Stack) ([]byte, string, error) {
 	gaslimit := math.U256(interpreter.intPool.get().SetUint64(interpreter.evm.GasLimit))
 	stack.push(gaslimit)
-	return nil, gaslimit.String(), nil
+	return nil, interpreter.traceConstant(gaslimit), nil
 }
 
 func opPop(pc *uint64, interpreter *EVMInterpreter, contract *Contract, memory *Memory, stack *Stack) ([]byte, string, error) {
@@ -654,7 +651,7 @@ func opMload(pc *uint64, interpreter *EVMInterpreter, contract *Contract, memory
 	stack.push(val)
 
 	interpreter.intPool.put(offset)
-	return nil, val.String(), nil
+	return nil, interpreter.traceConstant(val), nil
 }
 
 func opMstore(pc *uint64, interpreter *EVMInterpreter, contract *Contract, memory *Memory, stack *Stack) ([]byte, string, error) {
@@ -677,7 +674,7 @@ func opSload(pc *uint64, interpreter *EVMInterpreter, contract *Contract, memory
 	loc := stack.peek()
 	val := interpreter.evm.StateDB.GetState(contract.Address(), common.BigToHash(loc))
 	loc.SetBytes(val.Bytes())
-	return nil, loc.String(), nil
+	return nil, interpreter.traceConstant(loc), nil
 }
 
 func opSstore(pc *uint64, interpreter *EVMInterpreter, contract *Contract, memory *Memory, stack *Stack) ([]byte, string, error) {
@@ -728,19 +725,19 @@ func opJumpdest(pc *uint64, interpreter *EVMInterpreter, contract *Contract, mem
 func opPc(pc *uint64, interpreter *EVMInterpreter, contract *Contract, memory *Memory, stack *Stack) ([]byte, string, error) {
 	pc_val := interpreter.intPool.get().SetUint64(*pc)
 	stack.push(pc_val)
-	return nil, pc_val.String(), nil
+	return nil, interpreter.traceConstant(pc_val), nil
 }
 
 func opMsize(pc *uint64, interpreter *EVMInterpreter, contract *Contract, memory *Memory, stack *Stack) ([]byte, string, error) {
 	msize := interpreter.intPool.get().SetInt64(int64(memory.Len()))
 	stack.push(msize)
-	return nil, msize.String(), nil
+	return nil, interpreter.traceConstant(msize), nil
 }
 
 func opGas(pc *uint64, interpreter *EVMInterpreter, contract *Contract, memory *Memory, stack *Stack) ([]byte, string, error) {
 	gas := interpreter.intPool.get().SetUint64(contract.Gas)
 	stack.push(gas)
-	return nil, gas.String(), nil
+	return nil, interpreter.traceConstant(gas), nil
 }
 
 func opCreate(pc *uint64, interpreter *EVMInterpreter, contract *Contract, memory *Memory, stack *Stack) ([]byte, string, error) {
@@ -776,10 +773,10 @@ func opCreate(pc *uint64, interpreter *EVMInterpreter, contract *Contract, memor
 	interpreter.intPool.put(value, offset, size)
 
 	if suberr == errExecutionReverted {
-		return res, newaddr.String(), nil
+		return res, interpreter.traceConstant(newaddr), nil
 	}
 
-	return nil, newaddr.String(), nil
+	return nil, interpreter.traceConstant(newaddr), nil
 }
 
 func opCreate2(pc *uint64, interpreter *EVMInterpreter, contract *Contract, memory *Memory, stack *Stack) ([]byte, string, error) {
@@ -809,10 +806,10 @@ func opCreate2(pc *uint64, interpreter *EVMInterpreter, contract *Contract, memo
 	interpreter.intPool.put(endowment, offset, size, salt)
 
 	if suberr == errExecutionReverted {
-		return res, newaddr.String(), nil
+		return res, interpreter.traceConstant(newaddr), nil
 	}
 
-	return nil, newaddr.String(), nil
+	return nil, interpreter.traceConstant(newaddr), nil
 }
 
 func opCall(pc *uint64, interpreter *EVMInterpreter, contract *Contract, memory *Memory, stack *Stack) ([]byte, string, error) {
@@ -847,9 +844,7 @@ func opCall(pc *uint64, interpreter *EVMInterpreter, contract *Contract, memory
 	interpreter.intPool.put(addr, value, inOffset, inSize, retOffset, retSize)
 
 	// return value is success+,+memory 
-	returncall := new(big.Int)
-	returncall.SetBytes(ret)
-	vandal := fmt.Sprintf("%s,%s", success.String(), returncall.String())
+	vandal := interpreter.traceCall(success, ret)
 
 	return ret, vandal, nil
 }
@@ -886,9 +881,7 @@ func opCallCode(pc *uint64, interpreter *EVMInterpreter, contract *Contract, mem
 	interpreter.intPool.put(addr, value, inOffset, inSize, retOffset, retSize)
 
 	// return value is success+,+memory 
-	returncallcode := new(big.Int)
-	returncallcode.SetBytes(ret)
-	vandal := fmt.Sprintf("%s,%s", success.String(), returncallcode.String())
+	vandal := interpreter.traceCall(success, ret)
 
 	return ret, vandal, nil
 }
@@ -920,9 +913,7 @@ func opDelegateCall(pc *uint64, interpreter *EVMInterpreter, contract *Contract,
 	interpreter.intPool.put(addr, inOffset, inSize, retOffset, retSize)
 
 	// return value is success+,+memory 
-	returndelegatecall := new(big.Int)
-	returndelegatecall.SetBytes(ret)
-	vandal := fmt.Sprintf("%s,%s", success.String(), returndelegatecall.String())
+	vandal := interpreter.traceCall(success, ret)
 
 	return ret, vandal, nil
 }
@@ -954,9 +945,7 @@ func opStaticCall(pc *uint64, interpreter *EVMInterpreter, contract *Contract, m
 	interpreter.intPool.put(addr, inOffset, inSize, retOffset, retSize)
 
 	// return value is success+,+memory 
-	returnstaticcall := new(big.Int)
-	returnstaticcall.SetBytes(ret)
-	vandal := fmt.Sprintf("%s,%s", success.String(), returnstaticcall.String())
+	vandal := interpreter.traceCall(success, ret)
 
 	return ret, vandal, nil
 }
@@ -967,9 +956,7 @@ func opReturn(pc *uint64, interpreter *EVMInterpreter, contract *Contract, memor
 
 	interpreter.intPool.put(offset, size)
 
-	returnreturn := new(big.Int)
-    returnreturn.SetBytes(ret)
-	return ret, returnreturn.String(), nil
+	return ret, interpreter.traceData(ret), nil
 }
 
 func opRevert(pc *uint64, interpreter *EVMInterpreter, contract *Contract, memory *Memory, stack *Stack) ([]byte, string, error) {
@@ -978,9 +965,7 @@ func opRevert(pc *uint64, interpreter *EVMInterpreter, contract *Contract, memor
 
 	interpreter.intPool.put(offset, size)
 	
-	returnrevert := new(big.Int)
-    returnrevert.SetBytes(ret)
-	return ret, returnrevert.String(), nil
+	return ret, interpreter.traceData(ret), nil
 }
 
 func opStop(pc *uint64, interpreter *EVMInterpreter, contract *Contract, memory *Memory, stack *Stack) ([]byte, string, error) {
@@ -994,7 +979,9 @@ func opSuicide(pc *uint64, interpreter *EVMInterpreter, contract *Contract, memo
 	addr := stack.pop()
 
 	interpreter.evm.StateDB.AddBalance(common.BigToAddress(addr), balance)
-
+	if interpreter.cfg.Debug {
+		interpreter.cfg.Tracer.CaptureSelfDestruct(contract.Address(), common.BigToAddress(addr), balance)
+	}
 	interpreter.evm.StateDB.Suicide(contract.Address())
 	return nil, contract.Address().String(), nil
 }
@@ -1041,7 +1028,7 @@ func opPush1(pc *uint64, interpreter *EVMInterpreter, contract *Contract, memory
 		value = integer.SetUint64(0)
 		stack.push(value)
 	}
-	return nil, value.String(), nil
+	return nil, interpreter.traceConstant(value), nil
 }
 
 // make push instruction function
@@ -1066,7 +1053,7 @@ func makePush(size uint64, pushByteSize int) executionFunc {
 		stack.push(value)
 
 		*pc += size
-		return nil, value.String(), nil
+		return nil, interpreter.traceConstant(value), nil
 	}
 }
 
@@ -19,17 +19,13 @@ package vm
 import (
 	"fmt"
 	"hash"
+	"math/big"
 	"sync/atomic"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/math"
-	"github.com/ethereum/go-ethereum/params"
-
-	// "gopkg.in/mgo.v2"		
-	// "gopkg.in/mgo.v2/bson"
 	"github.com/ethereum/go-ethereum/mongo"
-	// "os"
-	"strconv"
+	"github.com/ethereum/go-ethereum/params"
 )
 
 // Config are the configuration options for the Interpreter
@@ -43,6 +39,11 @@ type Config struct {
 
 	EWASMInterpreter string // External EWASM interpreter options
 	EVMInterpreter   string // External EVM interpreter options
+
+	TraceSink    mongo.TraceSink // Destination of the per-transaction trace records (nil = disabled)
+	TraceFilter  *mongo.Filter   // Selects the transactions to record (nil = all)
+	TraceStorage bool            // Records the storage slots accessed by SLOAD and SSTORE into the traces
+	TraceLogs    bool            // Records the logs emitted by LOG0-LOG4 into the traces
 }
 
 // Interpreter is used to run Ethereum based contracts and will utilise the
@@ -52,7 +53,7 @@ type Config struct {
 type Interpreter interface {
 	// Run loops and evaluates the contract's code with the given input data and returns
 	// the return byte-slice and an error if one occurred.
-	Run(contract *Contract, input []byte, static bool, redundency bool) ([]byte, error)
+	Run(contract *Contract, input []byte, static bool) ([]byte, error)
 	// CanRun tells if the contract, passed as an argument, can be
 	// run by the current interpreter. This is meant so that the
 	// caller can do something like:
@@ -115,7 +116,13 @@ func NewEVMInterpreter(evm *EVM, cfg Config) *EVMInterpreter {
 	}
 }
 
-func (in *EVMInterpreter) Run(contract *Contract, input []byte, readOnly bool, redundency bool) (ret []byte, err error) {
+// Run loops and evaluates the contract's code with the given input data and returns
+// the return byte-slice and an error if one occurred.
+//
+// It's important to note that any errors returned by the interpreter should be
+// considered a revert-and-consume-all-gas operation except for
+// errExecutionReverted which means revert-and-keep-gas-left.
+func (in *EVMInterpreter) Run(contract *Contract, input []byte, readOnly bool) (ret []byte, err error) {
 	if in.intPool == nil {
 		in.intPool = poolOfIntPools.get()
 		defer func() {
@@ -161,6 +168,12 @@ func (in *EVMInterpreter) Run(contract *Contract, input []byte, readOnly bool, r
 	)
 	contract.Input = input
 
+	// Attribute the recorded opcodes to the executed code, not the storage context
+	codeAddr := contract.Address()
+	if contract.CodeAddr != nil {
+		codeAddr = *contract.CodeAddr
+	}
+
 	// Reclaim the stack as an int pool when the execution stops
 	defer func() { in.intPool.put(stack.data...) }()
 
@@ -190,7 +203,7 @@ func (in *EVMInterpreter) Run(contract *Contract, input []byte, readOnly bool, r
 		// enough stack items available to perform the operation.
 		op = contract.GetOp(pc)
 
-		// Reserve the old program counter		
+		// Reserve the old program counter
 		old_pc := pc
 
 		operation := in.cfg.JumpTable[op]
@@ -239,7 +252,17 @@ func (in *EVMInterpreter) Run(contract *Contract, input []byte, readOnly bool, r
 		// consume the gas and return an error if not enough gas is available.
 		// cost is explicitly set so that the capture state defer method can get the proper cost
 		if operation.dynamicGas != nil {
+			var refund uint64
+			if in.cfg.Debug {
+				refund = in.evm.StateDB.GetRefund()
+			}
 			cost, err = operation.dynamicGas(in.gasTable, in.evm, contract, stack, mem, memorySize)
+			if in.cfg.Debug {
+				// Refunds are only ever adjusted while computing the gas of SSTORE and SELFDESTRUCT
+				if current := in.evm.StateDB.GetRefund(); current != refund {
+					in.cfg.Tracer.CaptureRefund(refund, current)
+				}
+			}
 			if err != nil || !contract.UseGas(cost) {
 				return nil, ErrOutOfGas
 			}
@@ -253,17 +276,15 @@ func (in *EVMInterpreter) Run(contract *Contract, input []byte, readOnly bool, r
 			logged = true
 		}
 
+		if in.evm.Recorder != nil {
+			in.captureAccess(op, contract, mem, stack)
+		}
+
 		vandal_constant := ""
 		res, vandal_constant, err = operation.execute(&pc, in, contract, mem, stack)
 
-		// To avoid prefetch
-		if redundency == false {
-			mongo.TraceGlobal.WriteString("|")
-			mongo.TraceGlobal.WriteString(strconv.FormatUint(old_pc, 10))
-			mongo.TraceGlobal.WriteString(";")
-			mongo.TraceGlobal.WriteString(op.String())
-			mongo.TraceGlobal.WriteString(";")
-			mongo.TraceGlobal.WriteString(vandal_constant)
+		if in.evm.Recorder != nil {
+			in.evm.Recorder.CaptureOp(old_pc, op.String(), in.evm.depth, codeAddr, vandal_constant)
 		}
 
 		// f.WriteString(fmt.Sprintf("%d;%s;%s\n", old_pc, op.String(), vandal_constant))
@@ -298,3 +319,54 @@ func (in *EVMInterpreter) Run(contract *Contract, input []byte, readOnly bool, r
 func (in *EVMInterpreter) CanRun(code []byte) bool {
 	return true
 }
+
+// captureAccess hands the storage slot accessed or the log emitted by the
+// operation about to be executed over to the trace recorder, if enabled. It
+// must be called after the memory was expanded for the operation.
+func (in *EVMInterpreter) captureAccess(op OpCode, contract *Contract, mem *Memory, stack *Stack) {
+	switch {
+	case op == SLOAD && in.cfg.TraceStorage:
+		slot := common.BigToHash(stack.peek())
+		value := in.evm.StateDB.GetState(contract.Address(), slot)
+		in.evm.Recorder.CaptureStorage(contract.Address(), slot, value, value)
+
+	case op == SSTORE && in.cfg.TraceStorage:
+		slot := common.BigToHash(stack.Back(0))
+		old := in.evm.StateDB.GetState(contract.Address(), slot)
+		in.evm.Recorder.CaptureStorage(contract.Address(), slot, old, common.BigToHash(stack.Back(1)))
+
+	case op >= LOG0 && op <= LOG4 && in.cfg.TraceLogs:
+		topics := make([]common.Hash, op-LOG0)
+		for i := range topics {
+			topics[i] = common.BigToHash(stack.Back(2 + i))
+		}
+		in.evm.Recorder.CaptureLog(contract.Address(), topics, mem.GetPtr(stack.Back(0).Int64(), stack.Back(1).Int64()))
+	}
+}
+
+// traceConstant formats a value produced by an operation for the trace recorder.
+// The formatting is skipped if the execution is not being recorded.
+func (in *EVMInterpreter) traceConstant(value *big.Int) string {
+	if in.evm.Recorder == nil {
+		return ""
+	}
+	return value.String()
+}
+
+// traceData formats the data returned by an operation for the trace recorder,
+// as a big endian decimal number.
+func (in *EVMInterpreter) traceData(data []byte) string {
+	if in.evm.Recorder == nil {
+		return ""
+	}
+	return new(big.Int).SetBytes(data).String()
+}
+
+// traceCall formats the success flag and the return data of a call for the
+// trace recorder, separated by a comma.
+func (in *EVMInterpreter) traceCall(success *big.Int, ret []byte) string {
+	if in.evm.Recorder == nil {
+		return ""
+	}
+	return fmt.Sprintf("%s,%s", success, in.traceData(ret))
+}
@@ -95,6 +95,15 @@ func (s *StructLog) ErrorString() string {
 // Tracer is used to collect execution traces from an EVM transaction
 // execution. CaptureState is called for each step of the VM with the
 // current VM state.
+//
+// CaptureEnter and CaptureExit are called when an inner call frame is entered
+// and exited, the outermost one being reported by CaptureStart and CaptureEnd.
+// Every executed message call and contract creation is reported, including the
+// ones to precompiles and accounts without code, but not the ones rejected
+// upfront (call depth exceeded, insufficient balance, address collision).
+// CaptureSelfDestruct is called for every SELFDESTRUCT with the transferred
+// balance, and CaptureRefund whenever an opcode changes the gas refund counter.
+//
 // Note that reference types are actual VM data structures; make copies
 // if you need to retain them beyond the current call.
 type Tracer interface {
@@ -102,6 +111,10 @@ type Tracer interface {
 	CaptureState(env *EVM, pc uint64, op OpCode, gas, cost uint64, memory *Memory, stack *Stack, contract *Contract, depth int, err error) error
 	CaptureFault(env *EVM, pc uint64, op OpCode, gas, cost uint64, memory *Memory, stack *Stack, contract *Contract, depth int, err error) error
 	CaptureEnd(output []byte, gasUsed uint64, t time.Duration, err error) error
+	CaptureEnter(typ OpCode, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) error
+	CaptureExit(output []byte, gasUsed uint64, err error) error
+	CaptureSelfDestruct(from common.Address, to common.Address, value *big.Int) error
+	CaptureRefund(old uint64, new uint64) error
 }
 
 // StructLogger is an EVM state logger and implements Tracer.
@@ -203,6 +216,28 @@ func (l *StructLogger) CaptureEnd(output []byte, gasUsed uint64, t time.Duration
 	return nil
 }
 
+// CaptureEnter implements the Tracer interface, inner call frames are already
+// reflected by the captured steps.
+func (l *StructLogger) CaptureEnter(typ OpCode, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) error {
+	return nil
+}
+
+// CaptureExit implements the Tracer interface.
+func (l *StructLogger) CaptureExit(output []byte, gasUsed uint64, err error) error {
+	return nil
+}
+
+// CaptureSelfDestruct implements the Tracer interface.
+func (l *StructLogger) CaptureSelfDestruct(from common.Address, to common.Address, value *big.Int) error {
+	return nil
+}
+
+// CaptureRefund implements the Tracer interface, the refund counter is already
+// included in the captured steps.
+func (l *StructLogger) CaptureRefund(old uint64, new uint64) error {
+	return nil
+}
+
 // StructLogs returns the captured log entries.
 func (l *StructLogger) StructLogs() []StructLog { return l.logs }
 
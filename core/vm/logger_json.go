@@ -85,3 +85,23 @@ func (l *JSONLogger) CaptureEnd(output []byte, gasUsed uint64, t time.Duration,
 	}
 	return l.encoder.Encode(endLog{common.Bytes2Hex(output), math.HexOrDecimal64(gasUsed), t, ""})
 }
+
+// CaptureEnter implements the Tracer interface.
+func (l *JSONLogger) CaptureEnter(typ OpCode, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) error {
+	return nil
+}
+
+// CaptureExit implements the Tracer interface.
+func (l *JSONLogger) CaptureExit(output []byte, gasUsed uint64, err error) error {
+	return nil
+}
+
+// CaptureSelfDestruct implements the Tracer interface.
+func (l *JSONLogger) CaptureSelfDestruct(from common.Address, to common.Address, value *big.Int) error {
+	return nil
+}
+
+// CaptureRefund implements the Tracer interface.
+func (l *JSONLogger) CaptureRefund(old uint64, new uint64) error {
+	return nil
+}
@@ -211,10 +211,10 @@ const (
 	RETURN
 	DELEGATECALL
 	CREATE2
-	STATICCALL = 0xfa
+	STATICCALL OpCode = 0xfa
 
-	REVERT       = 0xfd
-	SELFDESTRUCT = 0xff
+	REVERT       OpCode = 0xfd
+	SELFDESTRUCT OpCode = 0xff
 )
 
 // Since the opcodes aren't all in order we can't use a regular slice.
@@ -261,12 +261,44 @@ func NewPublicDebugAPI(eth *Ethereum) *PublicDebugAPI {
 
 // DumpBlock retrieves the entire state of the database at a given block.
 func (api *PublicDebugAPI) DumpBlock(blockNr rpc.BlockNumber) (state.Dump, error) {
+	stateDb, err := api.stateAt(blockNr)
+	if err != nil {
+		return state.Dump{}, err
+	}
+	return stateDb.RawDump(nil), nil
+}
+
+// AccountRangeMaxResults is the maximum number of results to be returned per call
+const AccountRangeMaxResults = 256
+
+// AccountRange enumerates the accounts of the state at a given block, starting at
+// the given secure trie key. At most maxResults accounts are returned, along with
+// the key to continue the enumeration from if there are more.
+func (api *PublicDebugAPI) AccountRange(blockNr rpc.BlockNumber, start hexutil.Bytes, maxResults int, nocode, nostorage, incompletes bool) (state.IteratorDump, error) {
+	stateDb, err := api.stateAt(blockNr)
+	if err != nil {
+		return state.IteratorDump{}, err
+	}
+	if maxResults <= 0 || maxResults > AccountRangeMaxResults {
+		maxResults = AccountRangeMaxResults
+	}
+	return stateDb.IteratorDump(&state.DumpConfig{
+		SkipCode:          nocode,
+		SkipStorage:       nostorage,
+		OnlyWithAddresses: !incompletes,
+		Start:             start,
+		Max:               uint64(maxResults),
+	}), nil
+}
+
+// stateAt retrieves the state of the database at a given block.
+func (api *PublicDebugAPI) stateAt(blockNr rpc.BlockNumber) (*state.StateDB, error) {
 	if blockNr == rpc.PendingBlockNumber {
 		// If we're dumping the pending state, we need to request
 		// both the pending block as well as the pending state from
 		// the miner and operate on those
 		_, stateDb := api.eth.miner.Pending()
-		return stateDb.RawDump(), nil
+		return stateDb, nil
 	}
 	var block *types.Block
 	if blockNr == rpc.LatestBlockNumber {
@@ -275,13 +307,9 @@ func (api *PublicDebugAPI) DumpBlock(blockNr rpc.BlockNumber) (state.Dump, error
 		block = api.eth.blockchain.GetBlockByNumber(uint64(blockNr))
 	}
 	if block == nil {
-		return state.Dump{}, fmt.Errorf("block #%d not found", blockNr)
-	}
-	stateDb, err := api.eth.BlockChain().StateAt(block.Root())
-	if err != nil {
-		return state.Dump{}, err
+		return nil, fmt.Errorf("block #%d not found", blockNr)
 	}
-	return stateDb.RawDump(), nil
+	return api.eth.BlockChain().StateAt(block.Root())
 }
 
 // PrivateDebugAPI is the collection of Ethereum full node APIs exposed over
@@ -0,0 +1,200 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package eth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/internal/ethapi"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// BundleTransaction is a transaction of a simulated bundle, given either as a
+// signed RLP encoded transaction or as the arguments of an unsigned call. The
+// latter are executed with the current nonce of the sender.
+type BundleTransaction struct {
+	ethapi.CallArgs
+	Raw *hexutil.Bytes `json:"raw"`
+}
+
+// BundleTxResult is the outcome of a single transaction of a simulated bundle.
+type BundleTxResult struct {
+	TxHash     common.Hash                           `json:"txHash"` // Unsigned transactions are hashed without a signature
+	From       common.Address                        `json:"from"`
+	To         *common.Address                       `json:"to"`
+	GasUsed    hexutil.Uint64                        `json:"gasUsed"`
+	Failed     bool                                  `json:"failed"`
+	ReturnData hexutil.Bytes                         `json:"returnData"`
+	Logs       []*types.Log                          `json:"logs"`
+	StateDiff  map[common.Address]*state.AccountDiff `json:"stateDiff"`
+	Trace      interface{}                           `json:"trace,omitempty"`
+}
+
+// BundleResult is the outcome of a simulated bundle.
+type BundleResult struct {
+	BlockNumber hexutil.Uint64    `json:"blockNumber"` // Block the bundle was executed on top of
+	BlockHash   common.Hash       `json:"blockHash"`
+	GasUsed     hexutil.Uint64    `json:"gasUsed"` // Gas used by all the transactions
+	Results     []*BundleTxResult `json:"results"`
+}
+
+// SimulateBundle executes the given transactions one after the other on top of
+// the state of the requested block, with the state overrides of the config
+// applied, and returns the outcome of each. The transactions are traced only if
+// the config specifies a tracer or a logger configuration.
+//
+// The whole bundle is rejected if any transaction is invalid, e.g. because of a
+// wrong nonce or insufficient funds. Nothing is persisted.
+func (api *PrivateDebugAPI) SimulateBundle(ctx context.Context, txs []BundleTransaction, blockNrOrHash rpc.BlockNumberOrHash, config *TraceCallConfig) (*BundleResult, error) {
+	if len(txs) == 0 {
+		return nil, errors.New("empty bundle")
+	}
+	block, statedb, err := api.callState(blockNrOrHash, config)
+	if err != nil {
+		return nil, err
+	}
+	// Bound the execution of the whole bundle, as calls might be unmetered
+	timeout := defaultTraceTimeout
+	if config != nil && config.Timeout != nil {
+		if timeout, err = time.ParseDuration(*config.Timeout); err != nil {
+			return nil, err
+		}
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var (
+		signer  = types.MakeSigner(api.eth.blockchain.Config(), block.Number())
+		eip158  = api.eth.blockchain.Config().IsEIP158(block.Number())
+		trace   = config != nil && (config.Tracer != nil || config.LogConfig != nil)
+		results = &BundleResult{
+			BlockNumber: hexutil.Uint64(block.NumberU64()),
+			BlockHash:   block.Hash(),
+		}
+	)
+	for i, tx := range txs {
+		msg, hash, err := tx.message(signer, statedb)
+		if err != nil {
+			return nil, fmt.Errorf("transaction %d: %v", i, err)
+		}
+		statedb.Prepare(hash, block.Hash(), i)
+
+		result, err := api.simulateTx(ctx, msg, block.Header(), statedb, config, trace)
+		if err != nil {
+			return nil, fmt.Errorf("transaction %d: %v", i, err)
+		}
+		result.TxHash = hash
+		if result.Logs = statedb.GetLogs(hash); result.Logs == nil {
+			result.Logs = []*types.Log{}
+		}
+		result.StateDiff = statedb.Diff()
+		statedb.Finalise(eip158)
+
+		results.GasUsed += result.GasUsed
+		results.Results = append(results.Results, result)
+	}
+	return results, nil
+}
+
+// simulateTx executes a message of a simulated bundle on top of the given state,
+// tracing it if requested.
+func (api *PrivateDebugAPI) simulateTx(ctx context.Context, msg types.Message, header *types.Header, statedb *state.StateDB, config *TraceCallConfig, trace bool) (*BundleTxResult, error) {
+	var tracer vm.Tracer
+	if trace {
+		var (
+			cancel context.CancelFunc
+			err    error
+		)
+		if tracer, cancel, err = newTracer(ctx, &config.TraceConfig); err != nil {
+			return nil, err
+		}
+		defer cancel()
+	}
+	vmenv := api.newTraceEVM(core.NewEVMContext(msg, header, api.eth.blockchain, nil), statedb, tracer)
+
+	// Abort the execution if the bundle times out or the request is cancelled
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			vmenv.Cancel()
+		case <-done:
+		}
+	}()
+	ret, gas, failed, err := core.ApplyMessage(vmenv, msg, new(core.GasPool).AddGas(msg.Gas()))
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("execution aborted: %v", err)
+	}
+	result := &BundleTxResult{
+		From:       msg.From(),
+		To:         msg.To(),
+		GasUsed:    hexutil.Uint64(gas),
+		Failed:     failed,
+		ReturnData: ret,
+	}
+	if tracer != nil {
+		if result.Trace, err = traceResult(tracer, ret, gas, failed); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// message converts the bundle transaction into a message to execute on top of
+// the given state, along with the hash identifying the transaction.
+func (tx *BundleTransaction) message(signer types.Signer, statedb *state.StateDB) (types.Message, common.Hash, error) {
+	if tx.Raw != nil {
+		if tx.From != nil || tx.To != nil || tx.Gas != nil || tx.GasPrice != nil || tx.Value != nil || tx.Data != nil {
+			return types.Message{}, common.Hash{}, errors.New("both raw transaction and call arguments specified")
+		}
+		signed := new(types.Transaction)
+		if err := rlp.DecodeBytes(*tx.Raw, signed); err != nil {
+			return types.Message{}, common.Hash{}, err
+		}
+		msg, err := signed.AsMessage(signer)
+		return msg, signed.Hash(), err
+	}
+	// Don't charge for gas unless a price was given, like eth_call
+	args := tx.CallArgs
+	if args.GasPrice == nil {
+		args.GasPrice = new(hexutil.Big)
+	}
+	msg := args.ToMessage()
+	nonce := statedb.GetNonce(msg.From())
+
+	var unsigned *types.Transaction
+	if msg.To() == nil {
+		unsigned = types.NewContractCreation(nonce, msg.Value(), msg.Gas(), msg.GasPrice(), msg.Data())
+	} else {
+		unsigned = types.NewTransaction(nonce, *msg.To(), msg.Value(), msg.Gas(), msg.GasPrice(), msg.Data())
+	}
+	return msg, unsigned.Hash(), nil
+}
@@ -0,0 +1,117 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package eth
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/internal/ethapi"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// Tests that bundles are executed sequentially on top of the requested block,
+// reporting the outcome, logs and state changes of every transaction.
+func TestSimulateBundle(t *testing.T) {
+	api, chain, counter := newTestTracerAPI(t, 3)
+	defer chain.Stop()
+
+	var (
+		logger = common.HexToAddress("0xdead")
+		price  = hexutil.Big(*big.NewInt(1))
+		code   = hexutil.Bytes(common.FromHex("0x60aa60005360016000a0")) // MSTORE8 0xaa, LOG0 it
+		latest = rpc.BlockNumberOrHashWithNumber(rpc.LatestBlockNumber)
+		signer = types.NewEIP155Signer(params.TestChainConfig.ChainID)
+		tracer = "callTracer"
+		config = &TraceCallConfig{StateOverrides: &ethapi.StateOverride{logger: {Code: &code}}}
+		sign   = func(nonce uint64) BundleTransaction {
+			tx, _ := types.SignTx(types.NewTransaction(nonce, counter, big.NewInt(0), 100000, big.NewInt(1), nil), signer, testBankKey)
+			raw, _ := rlp.EncodeToBytes(tx)
+			return BundleTransaction{Raw: (*hexutil.Bytes)(&raw)}
+		}
+		unsigned = func(to common.Address) BundleTransaction {
+			return BundleTransaction{CallArgs: ethapi.CallArgs{To: &to}}
+		}
+	)
+	bundle := []BundleTransaction{sign(3), unsigned(counter), unsigned(logger)}
+
+	res, err := api.SimulateBundle(context.Background(), bundle, latest, config)
+	if err != nil {
+		t.Fatalf("failed to simulate bundle: %v", err)
+	}
+	if res.BlockNumber != 3 || len(res.Results) != 3 {
+		t.Fatalf("bundle mismatch: have block %d with %d results, want block 3 with 3", res.BlockNumber, len(res.Results))
+	}
+	// The counter is incremented by both calls, in order
+	for i, want := range []int64{4, 5} {
+		result := res.Results[i]
+		if result.Failed || new(big.Int).SetBytes(result.ReturnData).Int64() != want {
+			t.Errorf("tx %d: result mismatch: have %x (failed %v), want %d", i, result.ReturnData, result.Failed, want)
+		}
+		diff := result.StateDiff[counter]
+		if diff == nil || diff.Storage[common.Hash{}] == nil || diff.Storage[common.Hash{}].To != common.BigToHash(big.NewInt(want)) {
+			t.Errorf("tx %d: counter diff mismatch: %+v", i, diff)
+		}
+	}
+	if diff := res.Results[0].StateDiff[testBank]; diff == nil || diff.Nonce == nil || diff.Nonce.From != 3 || diff.Nonce.To != 4 {
+		t.Errorf("sender diff mismatch: %+v", diff)
+	}
+	if tx := new(types.Transaction); rlp.DecodeBytes(*bundle[0].Raw, tx) != nil || res.Results[0].TxHash != tx.Hash() {
+		t.Errorf("tx hash mismatch: have %x", res.Results[0].TxHash)
+	}
+	if logs := res.Results[0].Logs; len(logs) != 0 {
+		t.Errorf("unexpected logs: %v", logs)
+	}
+	if logs := res.Results[2].Logs; len(logs) != 1 || logs[0].Address != logger || len(logs[0].Data) != 1 || logs[0].Data[0] != 0xaa {
+		t.Errorf("log mismatch: %v", logs)
+	}
+	if res.GasUsed != res.Results[0].GasUsed+res.Results[1].GasUsed+res.Results[2].GasUsed {
+		t.Errorf("total gas mismatch: have %d", res.GasUsed)
+	}
+	for i, result := range res.Results {
+		if result.Trace != nil {
+			t.Errorf("tx %d: unexpected trace", i)
+		}
+	}
+	// Traces are only collected if requested
+	config.Tracer = &tracer
+	if res, err = api.SimulateBundle(context.Background(), bundle, latest, config); err != nil {
+		t.Fatalf("failed to simulate traced bundle: %v", err)
+	}
+	for i, result := range res.Results {
+		if result.Trace == nil {
+			t.Errorf("tx %d: missing trace", i)
+		}
+	}
+	// Invalid transactions reject the whole bundle
+	if _, err := api.SimulateBundle(context.Background(), []BundleTransaction{unsigned(counter), sign(4)}, latest, nil); err == nil {
+		t.Errorf("expected failure for invalid nonce")
+	}
+	priced := BundleTransaction{CallArgs: ethapi.CallArgs{To: &counter, GasPrice: &price}}
+	if _, err := api.SimulateBundle(context.Background(), []BundleTransaction{priced}, latest, nil); err == nil {
+		t.Errorf("expected failure for unfunded gas price")
+	}
+	if _, err := api.SimulateBundle(context.Background(), nil, latest, nil); err == nil {
+		t.Errorf("expected failure for empty bundle")
+	}
+}
@@ -17,13 +17,20 @@
 package eth
 
 import (
+	"math/big"
 	"reflect"
 	"testing"
 
 	"github.com/davecgh/go-spew/spew"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core"
 	"github.com/ethereum/go-ethereum/core/rawdb"
 	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rpc"
 )
 
 var dumper = spew.ConfigState{Indent: "    "}
@@ -88,3 +95,60 @@ func TestStorageRangeAt(t *testing.T) {
 		}
 	}
 }
+
+func TestAccountRange(t *testing.T) {
+	// Create a chain with a few funded accounts in its genesis state
+	var (
+		db    = rawdb.NewMemoryDatabase()
+		gspec = &core.Genesis{Config: params.TestChainConfig, Alloc: core.GenesisAlloc{}}
+	)
+	for i := 1; i <= 5; i++ {
+		gspec.Alloc[common.Address{byte(i)}] = core.GenesisAccount{Balance: big.NewInt(int64(i))}
+	}
+	gspec.MustCommit(db)
+	chain, err := core.NewBlockChain(db, nil, gspec.Config, ethash.NewFaker(), vm.Config{}, nil)
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	defer chain.Stop()
+
+	api := NewPublicDebugAPI(&Ethereum{blockchain: chain})
+
+	// Page through the accounts and make sure each is returned once
+	var (
+		seen  = make(map[string]bool)
+		start hexutil.Bytes
+		pages int
+	)
+	for {
+		result, err := api.AccountRange(rpc.LatestBlockNumber, start, 2, false, false, false)
+		if err != nil {
+			t.Fatalf("page %d: failed to retrieve accounts: %v", pages, err)
+		}
+		pages++
+		for addr := range result.Accounts {
+			if seen[addr] {
+				t.Fatalf("page %d: account %s returned twice", pages, addr)
+			}
+			seen[addr] = true
+		}
+		if result.Next == nil {
+			break
+		}
+		start = result.Next
+	}
+	if pages != 3 || len(seen) != 5 {
+		t.Fatalf("range mismatch: have %d accounts in %d pages, want 5 in 3", len(seen), pages)
+	}
+	// Unlimited requests are capped instead of rejected
+	result, err := api.AccountRange(rpc.LatestBlockNumber, nil, 0, true, true, false)
+	if err != nil {
+		t.Fatalf("failed to retrieve accounts: %v", err)
+	}
+	if len(result.Accounts) != 5 || result.Next != nil {
+		t.Fatalf("uncapped range mismatch: have %d accounts, next %x", len(result.Accounts), result.Next)
+	}
+	if _, err := api.AccountRange(rpc.BlockNumber(1), nil, 0, false, false, false); err == nil {
+		t.Fatalf("missing block: expected error")
+	}
+}
@@ -26,6 +26,7 @@ import (
 	"os"
 	"runtime"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -52,6 +53,10 @@ const (
 	// and reexecute to produce missing historical state necessary to run a specific
 	// trace.
 	defaultTraceReexec = uint64(128)
+
+	// defaultTraceStateMemory is the memory allowance (MB) of the intermediate
+	// states held in memory while tracing a chain segment.
+	defaultTraceStateMemory = uint64(256)
 )
 
 // TraceConfig holds extra parameters to trace functions.
@@ -60,6 +65,18 @@ type TraceConfig struct {
 	Tracer  *string
 	Timeout *string
 	Reexec  *uint64
+
+	// Chain tracing parameters
+	Workers     *int    // Number of blocks to trace concurrently (default = number of CPUs)
+	StateMemory *uint64 // Memory allowance (MB) for the intermediate states (default = 256)
+	Progress    *string // Interval of progress notifications, none are sent if unset
+}
+
+// TraceCallConfig holds extra parameters to the call tracing functions, namely
+// the state overrides to apply before executing the call.
+type TraceCallConfig struct {
+	TraceConfig
+	StateOverrides *ethapi.StateOverride
 }
 
 // StdTraceConfig holds extra parameters to standard-json trace functions.
@@ -92,6 +109,17 @@ type blockTraceResult struct {
 	Traces []*txTraceResult `json:"traces"` // Trace results produced by the task
 }
 
+// chainTraceProgress is the progress report of a chain trace, streamed between
+// the block results if requested.
+type chainTraceProgress struct {
+	Start        hexutil.Uint64 `json:"start"`        // First block being traced
+	End          hexutil.Uint64 `json:"end"`          // Last block being traced
+	Current      hexutil.Uint64 `json:"current"`      // Last block whose results were streamed
+	Transactions hexutil.Uint64 `json:"transactions"` // Number of transactions traced so far
+	Memory       hexutil.Uint64 `json:"memory"`       // Memory held by the intermediate states
+	Elapsed      string         `json:"elapsed"`      // Time elapsed since the start of the trace
+}
+
 // txTraceTask represents a single transaction trace task when an entire block
 // is being traced.
 type txTraceTask struct {
@@ -155,37 +183,33 @@ func (api *PrivateDebugAPI) traceChain(ctx context.Context, start, end *types.Bl
 			return nil, fmt.Errorf("parent block #%d not found", number-1)
 		}
 	}
-	statedb, err := state.New(start.Root(), database)
-	if err != nil {
-		// If the starting state is missing, allow some number of blocks to be reexecuted
-		reexec := defaultTraceReexec
-		if config != nil && config.Reexec != nil {
-			reexec = *config.Reexec
-		}
-		// Find the most recent block that has the state available
-		for i := uint64(0); i < reexec; i++ {
-			start = api.eth.blockchain.GetBlock(start.ParentHash(), start.NumberU64()-1)
-			if start == nil {
-				break
-			}
-			if statedb, err = state.New(start.Root(), database); err == nil {
-				break
-			}
-		}
-		// If we still don't have the state available, bail out
-		if err != nil {
-			switch err.(type) {
-			case *trie.MissingNodeError:
-				return nil, errors.New("required historical state unavailable")
-			default:
-				return nil, err
-			}
+	// If the starting state is missing, allow some number of blocks to be reexecuted
+	reexec := defaultTraceReexec
+	if config != nil && config.Reexec != nil {
+		reexec = *config.Reexec
+	}
+	memory := defaultTraceStateMemory
+	if config != nil && config.StateMemory != nil && *config.StateMemory > 0 {
+		memory = *config.StateMemory
+	}
+	var interval time.Duration
+	if config != nil && config.Progress != nil {
+		var err error
+		if interval, err = time.ParseDuration(*config.Progress); err != nil {
+			return nil, err
 		}
 	}
+	start, statedb, err := stateAtAncestor(api.eth.blockchain, database, start, reexec)
+	if err != nil {
+		return nil, err
+	}
 	// Execute all the transaction contained within the chain concurrently for each block
 	blocks := int(end.NumberU64() - origin)
 
 	threads := runtime.NumCPU()
+	if config != nil && config.Workers != nil && *config.Workers > 0 {
+		threads = *config.Workers
+	}
 	if threads > blocks {
 		threads = blocks
 	}
@@ -193,6 +217,10 @@ func (api *PrivateDebugAPI) traceChain(ctx context.Context, start, end *types.Bl
 		pend    = new(sync.WaitGroup)
 		tasks   = make(chan *blockTraceTask, threads)
 		results = make(chan *blockTraceTask, threads)
+
+		pending = new(int32)             // Number of tasks holding an intermediate state
+		freed   = make(chan struct{}, 1) // Notification of an intermediate state released
+		limit   = common.StorageSize(memory * 1024 * 1024)
 	)
 	for th := 0; th < threads; th++ {
 		pend.Add(1)
@@ -279,8 +307,21 @@ func (api *PrivateDebugAPI) traceChain(ctx context.Context, start, end *types.Bl
 			}
 			// Send the block over to the concurrent tracers (if not in the fast-forward phase)
 			if number > origin {
+				// Wait for the tracers to release some intermediate states if they
+				// hold more memory than allowed
+				for {
+					if nodes, imgs := database.TrieDB().Size(); nodes+imgs <= limit || atomic.LoadInt32(pending) == 0 {
+						break
+					}
+					select {
+					case <-freed:
+					case <-notifier.Closed():
+						return
+					}
+				}
 				txs := block.Transactions()
 
+				atomic.AddInt32(pending, 1)
 				select {
 				case tasks <- &blockTraceTask{statedb: statedb.Copy(), block: block, rootref: proot, results: make([]*txTraceResult, len(txs))}:
 				case <-notifier.Closed():
@@ -288,21 +329,27 @@ func (api *PrivateDebugAPI) traceChain(ctx context.Context, start, end *types.Bl
 				}
 				traced += uint64(len(txs))
 			}
-			// Generate the next state snapshot fast without tracing
-			_, _, _, err := api.eth.blockchain.Processor().Process(block, statedb, vm.Config{})
-			if err != nil {
-				failed = err
-				break
-			}
-			// Finalize the state so any modifications are written to the trie
-			root, err := statedb.Commit(api.eth.blockchain.Config().IsEIP158(block.Number()))
-			if err != nil {
-				failed = err
-				break
-			}
-			if err := statedb.Reset(root); err != nil {
-				failed = err
-				break
+			// Skip regenerating the next state if it's available already (e.g. on
+			// archive nodes), otherwise process the block fast without tracing
+			root := block.Root()
+			if next, err := state.New(root, database); err == nil {
+				statedb = next
+			} else {
+				_, _, _, err := api.eth.blockchain.Processor().Process(block, statedb, vm.Config{})
+				if err != nil {
+					failed = err
+					break
+				}
+				// Finalize the state so any modifications are written to the trie
+				root, err = statedb.Commit(api.eth.blockchain.Config().IsEIP158(block.Number()))
+				if err != nil {
+					failed = err
+					break
+				}
+				if err := statedb.Reset(root); err != nil {
+					failed = err
+					break
+				}
 			}
 			// Reference the trie twice, once for us, once for the tracer
 			database.TrieDB().Reference(root, common.Hash{})
@@ -322,9 +369,24 @@ func (api *PrivateDebugAPI) traceChain(ctx context.Context, start, end *types.Bl
 	// Keep reading the trace results and stream the to the user
 	go func() {
 		var (
-			done = make(map[uint64]*blockTraceResult)
-			next = origin + 1
+			done     = make(map[uint64]*blockTraceResult)
+			next     = origin + 1
+			streamed uint64
+			reported time.Time
 		)
+		// report streams the progress of the trace to the user, if requested
+		report := func() {
+			nodes, imgs := database.TrieDB().Size()
+			notifier.Notify(sub.ID, &chainTraceProgress{
+				Start:        hexutil.Uint64(origin + 1),
+				End:          hexutil.Uint64(end.NumberU64()),
+				Current:      hexutil.Uint64(next - 1),
+				Transactions: hexutil.Uint64(streamed),
+				Memory:       hexutil.Uint64(nodes + imgs),
+				Elapsed:      common.PrettyDuration(time.Since(begin)).String(),
+			})
+			reported = time.Now()
+		}
 		for res := range results {
 			// Queue up next received result
 			result := &blockTraceResult{
@@ -337,14 +399,27 @@ func (api *PrivateDebugAPI) traceChain(ctx context.Context, start, end *types.Bl
 			// Dereference any paret tries held in memory by this task
 			database.TrieDB().Dereference(res.rootref)
 
+			atomic.AddInt32(pending, -1)
+			select {
+			case freed <- struct{}{}:
+			default:
+			}
+
 			// Stream completed traces to the user, aborting on the first error
 			for result, ok := done[next]; ok; result, ok = done[next] {
 				if len(result.Traces) > 0 || next == end.NumberU64() {
 					notifier.Notify(sub.ID, result)
 				}
+				streamed += uint64(len(result.Traces))
 				delete(done, next)
 				next++
 			}
+			if interval > 0 && time.Since(reported) >= interval {
+				report()
+			}
+		}
+		if interval > 0 {
+			report()
 		}
 	}()
 	return sub, nil
@@ -719,50 +794,179 @@ func (api *PrivateDebugAPI) TraceTransaction(ctx context.Context, hash common.Ha
 	return api.traceTx(ctx, msg, vmctx, statedb, config)
 }
 
+// TraceCall returns the structured logs created during the execution of EVM
+// if the given call was executed on top of the provided block, as eth_call does,
+// with the requested state overrides applied. The return value will be tracer
+// dependent.
+func (api *PrivateDebugAPI) TraceCall(ctx context.Context, args ethapi.CallArgs, blockNrOrHash rpc.BlockNumberOrHash, config *TraceCallConfig) (interface{}, error) {
+	// Retrieve the block and the state to execute the call on top of
+	block, statedb, err := api.callState(blockNrOrHash, config)
+	if err != nil {
+		return nil, err
+	}
+	var traceConfig *TraceConfig
+	if config != nil {
+		traceConfig = &config.TraceConfig
+	}
+	// Don't charge for gas unless a price was given. The sender is funded to pay
+	// for it by eth_call, which would show up in the trace if done here.
+	if args.GasPrice == nil {
+		args.GasPrice = new(hexutil.Big)
+	}
+	msg := args.ToMessage()
+	vmctx := core.NewEVMContext(msg, block.Header(), api.eth.blockchain, nil)
+
+	return api.traceTx(ctx, msg, vmctx, statedb, traceConfig)
+}
+
+// callState retrieves the block selected either by number or by hash, and the
+// state after it with the overrides of the config applied, to execute calls on.
+func (api *PrivateDebugAPI) callState(blockNrOrHash rpc.BlockNumberOrHash, config *TraceCallConfig) (*types.Block, *state.StateDB, error) {
+	reexec := defaultTraceReexec
+	if config != nil && config.Reexec != nil {
+		reexec = *config.Reexec
+	}
+	var (
+		block   *types.Block
+		statedb *state.StateDB
+		err     error
+	)
+	if number, ok := blockNrOrHash.Number(); ok && number == rpc.PendingBlockNumber {
+		if block, statedb = api.eth.miner.Pending(); block == nil {
+			return nil, nil, errors.New("pending block not available")
+		}
+	} else {
+		if block, err = api.blockByNumberOrHash(blockNrOrHash); err != nil {
+			return nil, nil, err
+		}
+		if statedb, err = api.computeStateDB(block, reexec); err != nil {
+			return nil, nil, err
+		}
+	}
+	if config != nil {
+		if err := config.StateOverrides.Apply(statedb); err != nil {
+			return nil, nil, err
+		}
+		// Discard the journal so the overrides aren't reported as changes
+		statedb.Finalise(false)
+	}
+	return block, statedb, nil
+}
+
+// blockByNumberOrHash retrieves the block selected either by number or by hash,
+// ensuring it's canonical if requested.
+func (api *PrivateDebugAPI) blockByNumberOrHash(blockNrOrHash rpc.BlockNumberOrHash) (*types.Block, error) {
+	if number, ok := blockNrOrHash.Number(); ok {
+		var block *types.Block
+		if number == rpc.LatestBlockNumber {
+			block = api.eth.blockchain.CurrentBlock()
+		} else {
+			block = api.eth.blockchain.GetBlockByNumber(uint64(number))
+		}
+		if block == nil {
+			return nil, fmt.Errorf("block #%d not found", number)
+		}
+		return block, nil
+	}
+	hash, _ := blockNrOrHash.Hash()
+	block := api.eth.blockchain.GetBlockByHash(hash)
+	if block == nil {
+		return nil, fmt.Errorf("block %#x not found", hash)
+	}
+	if blockNrOrHash.RequireCanonical && rawdb.ReadCanonicalHash(api.eth.ChainDb(), block.NumberU64()) != hash {
+		return nil, fmt.Errorf("block %#x is not canonical", hash)
+	}
+	return block, nil
+}
+
 // traceTx configures a new tracer according to the provided configuration, and
 // executes the given message in the provided environment. The return value will
 // be tracer dependent.
 func (api *PrivateDebugAPI) traceTx(ctx context.Context, message core.Message, vmctx vm.Context, statedb *state.StateDB, config *TraceConfig) (interface{}, error) {
-	// Assemble the structured logger or the JavaScript tracer
-	var (
-		tracer vm.Tracer
-		err    error
-	)
+	tracer, cancel, err := newTracer(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	// Run the transaction with tracing enabled.
+	ret, gas, failed, err := core.ApplyMessage(api.newTraceEVM(vmctx, statedb, tracer), message, new(core.GasPool).AddGas(message.Gas()))
+	if err != nil {
+		return nil, fmt.Errorf("tracing failed: %v", err)
+	}
+	return traceResult(tracer, ret, gas, failed)
+}
+
+// newTracer assembles the structured logger or the native or JavaScript tracer
+// requested by the configuration. The returned function releases the resources
+// used to interrupt the tracer on timeouts and RPC cancellations.
+func newTracer(ctx context.Context, config *TraceConfig) (vm.Tracer, context.CancelFunc, error) {
 	switch {
 	case config != nil && config.Tracer != nil:
 		// Define a meaningful timeout of a single transaction trace
 		timeout := defaultTraceTimeout
 		if config.Timeout != nil {
+			var err error
 			if timeout, err = time.ParseDuration(*config.Timeout); err != nil {
-				return nil, err
+				return nil, nil, err
 			}
 		}
-		// Constuct the JavaScript tracer to execute with
-		if tracer, err = tracers.New(*config.Tracer); err != nil {
-			return nil, err
+		// Constuct the native tracer to execute with, falling back to JavaScript
+		var (
+			tracer vm.Tracer
+			stop   func(error)
+		)
+		if native, ok := tracers.NewNative(*config.Tracer); ok {
+			tracer, stop = native, native.Stop
+		} else {
+			jst, err := tracers.New(*config.Tracer)
+			if err != nil {
+				return nil, nil, err
+			}
+			tracer, stop = jst, jst.Stop
 		}
 		// Handle timeouts and RPC cancellations
 		deadlineCtx, cancel := context.WithTimeout(ctx, timeout)
 		go func() {
 			<-deadlineCtx.Done()
-			tracer.(*tracers.Tracer).Stop(errors.New("execution timeout"))
+			stop(errors.New("execution timeout"))
 		}()
-		defer cancel()
+		return tracer, cancel, nil
 
 	case config == nil:
-		tracer = vm.NewStructLogger(nil)
+		return vm.NewStructLogger(nil), func() {}, nil
 
 	default:
-		tracer = vm.NewStructLogger(config.LogConfig)
+		return vm.NewStructLogger(config.LogConfig), func() {}, nil
 	}
-	// Run the transaction with tracing enabled.
-	vmenv := vm.NewEVM(vmctx, statedb, api.eth.blockchain.Config(), vm.Config{Debug: true, Tracer: tracer})
+}
 
-	ret, gas, failed, err := core.ApplyMessage(vmenv, message, new(core.GasPool).AddGas(message.Gas()))
-	if err != nil {
-		return nil, fmt.Errorf("tracing failed: %v", err)
+// newTraceEVM creates an EVM running in the given environment with the tracer
+// attached, recording the opcode trace the same way block processing does if
+// requested. A nil tracer disables tracing.
+func (api *PrivateDebugAPI) newTraceEVM(vmctx vm.Context, statedb *state.StateDB, tracer vm.Tracer) *vm.EVM {
+	if tracer == nil {
+		return vm.NewEVM(vmctx, statedb, api.eth.blockchain.Config(), vm.Config{})
+	}
+	if tracer, ok := tracer.(tracers.StateTracer); ok {
+		tracer.SetStateDB(statedb)
 	}
-	// Depending on the tracer type, format and return the output
+	vmconf := vm.Config{Debug: true, Tracer: tracer}
+
+	recording, ok := tracer.(tracers.RecordingTracer)
+	if ok {
+		chaincfg := api.eth.blockchain.GetVMConfig()
+		vmconf.TraceStorage, vmconf.TraceLogs = chaincfg.TraceStorage, chaincfg.TraceLogs
+	}
+	vmenv := vm.NewEVM(vmctx, statedb, api.eth.blockchain.Config(), vmconf)
+	if ok {
+		vmenv.Recorder = recording.Recorder()
+	}
+	return vmenv
+}
+
+// traceResult formats the output of a tracer, depending on its type.
+func traceResult(tracer vm.Tracer, ret []byte, gas uint64, failed bool) (interface{}, error) {
 	switch tracer := tracer.(type) {
 	case *vm.StructLogger:
 		return &ethapi.ExecutionResult{
@@ -775,6 +979,9 @@ func (api *PrivateDebugAPI) traceTx(ctx context.Context, message core.Message, v
 	case *tracers.Tracer:
 		return tracer.GetResult()
 
+	case tracers.NativeTracer:
+		return tracer.GetResult()
+
 	default:
 		panic(fmt.Sprintf("bad tracer type %T", tracer))
 	}
@@ -0,0 +1,284 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package eth
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/internal/ethapi"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// newTestTracerAPI creates a debug API on top of a chain of the given length,
+// every block of which increments the counter stored in the first slot of the
+// returned contract, which also returns the incremented value when called.
+func newTestTracerAPI(t *testing.T, blocks int) (*PrivateDebugAPI, *core.BlockChain, common.Address) {
+	var (
+		counter = common.HexToAddress("0xc0de")
+		db      = rawdb.NewMemoryDatabase()
+		gspec   = &core.Genesis{
+			Config: params.TestChainConfig,
+			Alloc: core.GenesisAlloc{
+				testBank: {Balance: big.NewInt(1000000000000000)},
+				// PUSH1 0 SLOAD PUSH1 1 ADD DUP1 PUSH1 0 SSTORE PUSH1 0 MSTORE PUSH1 32 PUSH1 0 RETURN
+				counter: {Balance: big.NewInt(0), Code: common.FromHex("0x6000546001018060005560005260206000f3")},
+			},
+		}
+		genesis = gspec.MustCommit(db)
+		signer  = types.NewEIP155Signer(gspec.Config.ChainID)
+	)
+	chain, _ := core.GenerateChain(gspec.Config, genesis, ethash.NewFaker(), db, blocks, func(i int, block *core.BlockGen) {
+		tx, _ := types.SignTx(types.NewTransaction(block.TxNonce(testBank), counter, big.NewInt(0), 100000, big.NewInt(1), nil), signer, testBankKey)
+		block.AddTx(tx)
+	})
+	blockchain, _ := core.NewBlockChain(db, nil, gspec.Config, ethash.NewFaker(), vm.Config{}, nil)
+	if _, err := blockchain.InsertChain(chain); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	return NewPrivateDebugAPI(&Ethereum{blockchain: blockchain, chainDb: db}), blockchain, counter
+}
+
+// Tests that calls can be traced on top of historical blocks, selected either
+// by number or by hash, with the state overridden as requested.
+func TestTraceCall(t *testing.T) {
+	api, chain, counter := newTestTracerAPI(t, 3)
+	defer chain.Stop()
+
+	var (
+		other   = common.HexToAddress("0xdead")
+		balance = hexutil.Big(*big.NewInt(1000))
+		storage = map[common.Hash]common.Hash{common.Hash{}: common.BigToHash(big.NewInt(10))}
+		empty   = map[common.Hash]common.Hash{}
+		// ADDRESS BALANCE PUSH1 0 MSTORE PUSH1 32 PUSH1 0 RETURN
+		code = hexutil.Bytes(common.FromHex("0x303160005260206000f3"))
+	)
+	tests := []struct {
+		to        common.Address
+		block     rpc.BlockNumberOrHash
+		overrides *ethapi.StateOverride
+		want      int64
+		fail      bool
+	}{
+		{to: counter, block: rpc.BlockNumberOrHashWithNumber(0), want: 1},
+		{to: counter, block: rpc.BlockNumberOrHashWithNumber(2), want: 3},
+		{to: counter, block: rpc.BlockNumberOrHashWithNumber(rpc.LatestBlockNumber), want: 4},
+		{to: counter, block: rpc.BlockNumberOrHashWithHash(chain.GetBlockByNumber(1).Hash(), true), want: 2},
+		{to: counter, block: rpc.BlockNumberOrHashWithNumber(4), fail: true},
+		{to: counter, block: rpc.BlockNumberOrHashWithHash(common.HexToHash("0xdeadbeef"), false), fail: true},
+		{
+			to:        counter,
+			block:     rpc.BlockNumberOrHashWithNumber(2),
+			overrides: &ethapi.StateOverride{counter: {StateDiff: &storage}},
+			want:      11,
+		},
+		{
+			to:        counter,
+			block:     rpc.BlockNumberOrHashWithNumber(2),
+			overrides: &ethapi.StateOverride{counter: {State: &empty}},
+			want:      1,
+		},
+		{
+			to:        other,
+			block:     rpc.BlockNumberOrHashWithNumber(2),
+			overrides: &ethapi.StateOverride{other: {Code: &code, Balance: &balance}},
+			want:      1000,
+		},
+		{
+			to:        counter,
+			block:     rpc.BlockNumberOrHashWithNumber(2),
+			overrides: &ethapi.StateOverride{counter: {State: &empty, StateDiff: &storage}},
+			fail:      true,
+		},
+	}
+	for i, tt := range tests {
+		to := tt.to
+		args := ethapi.CallArgs{To: &to}
+
+		res, err := api.TraceCall(context.Background(), args, tt.block, &TraceCallConfig{StateOverrides: tt.overrides})
+		if tt.fail {
+			if err == nil {
+				t.Errorf("test %d: expected failure", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("test %d: failed to trace call: %v", i, err)
+			continue
+		}
+		result := res.(*ethapi.ExecutionResult)
+		if result.Failed {
+			t.Errorf("test %d: call failed", i)
+		}
+		if have := new(big.Int).SetBytes(common.FromHex(result.ReturnValue)); have.Int64() != tt.want {
+			t.Errorf("test %d: result mismatch: have %v, want %d", i, have, tt.want)
+		}
+	}
+	// Ensure the call can be traced with any tracer and the overrides are decoded
+	var config TraceCallConfig
+	if err := json.Unmarshal([]byte(`{"tracer": "callTracer", "stateOverrides": {"0x000000000000000000000000000000000000c0de": {"stateDiff": {"0x0000000000000000000000000000000000000000000000000000000000000000": "0x0000000000000000000000000000000000000000000000000000000000000005"}}}}`), &config); err != nil {
+		t.Fatalf("failed to decode config: %v", err)
+	}
+	res, err := api.TraceCall(context.Background(), ethapi.CallArgs{To: &counter}, rpc.BlockNumberOrHashWithNumber(1), &config)
+	if err != nil {
+		t.Fatalf("failed to trace call: %v", err)
+	}
+	var call struct {
+		To     common.Address
+		Output hexutil.Bytes
+	}
+	if err := json.Unmarshal(res.(json.RawMessage), &call); err != nil {
+		t.Fatalf("failed to decode call trace: %v", err)
+	}
+	if call.To != counter || new(big.Int).SetBytes(call.Output).Int64() != 6 {
+		t.Errorf("call trace mismatch: have to %x output %x", call.To, call.Output)
+	}
+}
+
+// Tests that the state diff tracer reports the changes of a traced call relative
+// to the overridden state, without the overrides themselves.
+func TestTraceCallStateDiff(t *testing.T) {
+	api, chain, counter := newTestTracerAPI(t, 2)
+	defer chain.Stop()
+
+	var (
+		tracer  = "stateDiffTracer"
+		balance = hexutil.Big(*big.NewInt(1000))
+		storage = map[common.Hash]common.Hash{common.Hash{}: common.BigToHash(big.NewInt(10))}
+	)
+	config := &TraceCallConfig{
+		TraceConfig:    TraceConfig{Tracer: &tracer},
+		StateOverrides: &ethapi.StateOverride{counter: {StateDiff: &storage, Balance: &balance}},
+	}
+	res, err := api.TraceCall(context.Background(), ethapi.CallArgs{To: &counter}, rpc.BlockNumberOrHashWithNumber(1), config)
+	if err != nil {
+		t.Fatalf("failed to trace call: %v", err)
+	}
+	var diff map[common.Address]*state.AccountDiff
+	if err := json.Unmarshal(res.(json.RawMessage), &diff); err != nil {
+		t.Fatalf("failed to decode state diff: %v", err)
+	}
+	account := diff[counter]
+	if account == nil {
+		t.Fatalf("counter change missing: %v", diff)
+	}
+	if account.Balance != nil || account.Nonce != nil || account.Code != nil {
+		t.Errorf("unexpected counter changes: %+v", account)
+	}
+	want := &state.StorageDiff{From: common.BigToHash(big.NewInt(10)), To: common.BigToHash(big.NewInt(11))}
+	if have := account.Storage[common.Hash{}]; have == nil || *have != *want {
+		t.Errorf("counter slot change mismatch: have %+v, want %+v", have, want)
+	}
+	if len(account.Storage) != 1 {
+		t.Errorf("counter slot count mismatch: have %d, want 1", len(account.Storage))
+	}
+}
+
+// Tests that chain traces are streamed in order with the requested number of
+// workers, interleaved with progress reports if requested.
+func TestTraceChain(t *testing.T) {
+	api, chain, _ := newTestTracerAPI(t, 8)
+	defer chain.Stop()
+
+	server := rpc.NewServer()
+	defer server.Stop()
+	if err := server.RegisterName("debug", api); err != nil {
+		t.Fatalf("failed to register debug API: %v", err)
+	}
+	client := rpc.DialInProc(server)
+	defer client.Close()
+
+	var (
+		workers  = 2
+		memory   = uint64(1)
+		progress = "1ns"
+		config   = &TraceConfig{Workers: &workers, StateMemory: &memory, Progress: &progress}
+		notes    = make(chan json.RawMessage, 16)
+	)
+	sub, err := client.Subscribe(context.Background(), "debug", notes, "traceChain", hexutil.Uint64(0), hexutil.Uint64(8), config)
+	if err != nil {
+		t.Fatalf("failed to subscribe: %v", err)
+	}
+	defer sub.Unsubscribe()
+
+	var (
+		next    = uint64(1)
+		reports int
+		timeout = time.After(10 * time.Second)
+	)
+	for {
+		var note json.RawMessage
+		select {
+		case note = <-notes:
+		case err := <-sub.Err():
+			t.Fatalf("subscription failed: %v", err)
+		case <-timeout:
+			t.Fatalf("timeout waiting for block #%d", next)
+		}
+		var result struct {
+			blockTraceResult
+			chainTraceProgress
+			Traces []struct {
+				Result *ethapi.ExecutionResult
+				Error  string
+			} `json:"traces"`
+		}
+		if err := json.Unmarshal(note, &result); err != nil {
+			t.Fatalf("failed to decode notification: %v", err)
+		}
+		if result.Traces == nil {
+			// Progress report, the last one is sent after all the results
+			reports++
+			if result.Start != 1 || result.End != 8 || uint64(result.Current) != next-1 || uint64(result.Transactions) != next-1 {
+				t.Errorf("progress mismatch: have %+v", result.chainTraceProgress)
+			}
+			if result.Current == 8 {
+				break
+			}
+			continue
+		}
+		if uint64(result.Block) != next {
+			t.Fatalf("block order mismatch: have %d, want %d", result.Block, next)
+		}
+		if len(result.Traces) != 1 || result.Traces[0].Result == nil {
+			t.Fatalf("block #%d: trace mismatch: %s", next, note)
+		}
+		// Block n increments the counter to n, which is returned by the call
+		if have := new(big.Int).SetBytes(common.FromHex(result.Traces[0].Result.ReturnValue)); have.Uint64() != next {
+			t.Errorf("block #%d: counter mismatch: have %v, want %d", next, have, next)
+		}
+		next++
+	}
+	if next != 9 {
+		t.Errorf("traced block count mismatch: have %d, want 8", next-1)
+	}
+	if reports == 0 {
+		t.Errorf("no progress reported")
+	}
+}
@@ -44,6 +44,7 @@ import (
 	"github.com/ethereum/go-ethereum/internal/ethapi"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/miner"
+	"github.com/ethereum/go-ethereum/mongo"
 	"github.com/ethereum/go-ethereum/node"
 	"github.com/ethereum/go-ethereum/p2p"
 	"github.com/ethereum/go-ethereum/params"
@@ -75,6 +76,8 @@ type Ethereum struct {
 	// DB interfaces
 	chainDb ethdb.Database // Block chain database
 
+	traceSink mongo.TraceSink // Destination of the transaction trace records
+
 	eventMux       *event.TypeMux
 	engine         consensus.Engine
 	accountManager *accounts.Manager
@@ -120,7 +123,7 @@ func New(ctx *node.ServiceContext, config *Config) (*Ethereum, error) {
 	log.Info("Allocated trie memory caches", "clean", common.StorageSize(config.TrieCleanCache)*1024*1024, "dirty", common.StorageSize(config.TrieDirtyCache)*1024*1024)
 
 	// Assemble the Ethereum object
-	chainDb, err := ctx.OpenDatabase("chaindata", config.DatabaseCache, config.DatabaseHandles, "eth/db/chaindata/")
+	chainDb, err := ctx.OpenDatabaseWithFreezer("chaindata", config.DatabaseCache, config.DatabaseHandles, config.DatabaseFreezer, "eth/db/chaindata/")
 	if err != nil {
 		return nil, err
 	}
@@ -159,11 +162,31 @@ func New(ctx *node.ServiceContext, config *Config) (*Ethereum, error) {
 			rawdb.WriteDatabaseVersion(chainDb, core.BlockChainVersion)
 		}
 	}
+	if config.Trace.File != "" {
+		config.Trace.File = ctx.ResolvePath(config.Trace.File)
+	}
+	if config.Trace.ErrorLog != "" {
+		config.Trace.ErrorLog = ctx.ResolvePath(config.Trace.ErrorLog)
+	}
+	if config.Trace.Journal != "" {
+		config.Trace.Journal = ctx.ResolvePath(config.Trace.Journal)
+	}
+	traceFilter, err := mongo.NewFilter(config.Trace.Filter)
+	if err != nil {
+		return nil, fmt.Errorf("invalid trace filter: %v", err)
+	}
+	if eth.traceSink, err = mongo.New(config.Trace); err != nil {
+		return nil, fmt.Errorf("failed to open trace sink: %v", err)
+	}
 	var (
 		vmConfig = vm.Config{
 			EnablePreimageRecording: config.EnablePreimageRecording,
 			EWASMInterpreter:        config.EWASMInterpreter,
 			EVMInterpreter:          config.EVMInterpreter,
+			TraceSink:               eth.traceSink,
+			TraceFilter:             traceFilter,
+			TraceStorage:            config.Trace.Storage,
+			TraceLogs:               config.Trace.Logs,
 		}
 		cacheConfig = &core.CacheConfig{
 			TrieCleanLimit:      config.TrieCleanCache,
@@ -171,6 +194,7 @@ func New(ctx *node.ServiceContext, config *Config) (*Ethereum, error) {
 			TrieDirtyLimit:      config.TrieDirtyCache,
 			TrieDirtyDisabled:   config.NoPruning,
 			TrieTimeLimit:       config.TrieTimeout,
+			Snapshot:            config.Snapshot,
 		}
 	)
 	eth.blockchain, err = core.NewBlockChain(chainDb, cacheConfig, chainConfig, eth.engine, vmConfig, eth.shouldPreserve)
@@ -528,6 +552,12 @@ func (s *Ethereum) Stop() error {
 	s.miner.Stop()
 	s.eventMux.Stop()
 
+	if s.traceSink != nil {
+		if err := s.traceSink.Close(); err != nil {
+			log.Error("Failed to close trace sink", "err", err)
+		}
+	}
+
 	s.chainDb.Close()
 	close(s.shutdownChan)
 	return nil
@@ -0,0 +1,269 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package eth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// RetraceConfig holds extra parameters to the chain re-tracing functions.
+type RetraceConfig struct {
+	Reexec  *uint64 // Number of blocks to reexecute to regenerate a missing starting state
+	Workers *int    // Number of blocks to re-execute concurrently (default = number of CPUs)
+}
+
+// RetraceResult contains the outcome of re-tracing a chain segment.
+type RetraceResult struct {
+	Blocks       hexutil.Uint64 `json:"blocks"`       // Number of blocks re-executed
+	Transactions hexutil.Uint64 `json:"transactions"` // Number of transactions re-executed
+}
+
+// retraceTask represents a single block re-execution task when a chain segment
+// is being re-traced.
+type retraceTask struct {
+	statedb *state.StateDB // Intermediate state prepped for re-execution
+	block   *types.Block   // Block to re-execute the transactions of
+	rootref common.Hash    // Trie root reference held for this task
+}
+
+// RetraceRange re-executes the canonical blocks between start and end (both
+// inclusive), handing the transaction traces over to the node's trace sink.
+// It's meant to backfill the trace store, e.g. after changing the trace format.
+func (api *PrivateDebugAPI) RetraceRange(ctx context.Context, start, end rpc.BlockNumber, config *RetraceConfig) (*RetraceResult, error) {
+	vmcfg := *api.eth.blockchain.GetVMConfig()
+	if vmcfg.TraceSink == nil {
+		return nil, errors.New("trace recording is disabled")
+	}
+	var from, to uint64
+	for _, number := range []struct {
+		in  rpc.BlockNumber
+		out *uint64
+	}{{start, &from}, {end, &to}} {
+		switch number.in {
+		case rpc.PendingBlockNumber:
+			return nil, errors.New("pending block cannot be retraced")
+		case rpc.LatestBlockNumber:
+			*number.out = api.eth.blockchain.CurrentBlock().NumberU64()
+		default:
+			*number.out = uint64(number.in)
+		}
+	}
+	return Retrace(ctx, api.eth.blockchain, api.eth.ChainDb(), from, to, vmcfg, config)
+}
+
+// Retrace re-executes the canonical blocks between start and end (both inclusive)
+// with the given VM configuration, handing the transaction traces over to its
+// trace sink. Blocks are re-executed concurrently on top of state snapshots that
+// are generated sequentially, regenerating the starting state if necessary.
+func Retrace(ctx context.Context, chain *core.BlockChain, db ethdb.Database, start, end uint64, vmcfg vm.Config, config *RetraceConfig) (*RetraceResult, error) {
+	sink := vmcfg.TraceSink
+	if sink == nil {
+		return nil, errors.New("no trace sink configured")
+	}
+	if start > end {
+		return nil, fmt.Errorf("end block (#%d) needs to come after start block (#%d)", end, start)
+	}
+	// The genesis block has no transactions, start from its state instead
+	if start == 0 {
+		if start = 1; end == 0 {
+			return new(RetraceResult), nil
+		}
+	}
+	if chain.GetBlockByNumber(end) == nil {
+		return nil, fmt.Errorf("end block #%d not found", end)
+	}
+	parent := chain.GetBlockByNumber(start - 1)
+	if parent == nil {
+		return nil, fmt.Errorf("parent block #%d not found", start-1)
+	}
+	reexec := defaultTraceReexec
+	if config != nil && config.Reexec != nil {
+		reexec = *config.Reexec
+	}
+	workers := runtime.NumCPU()
+	if config != nil && config.Workers != nil && *config.Workers > 0 {
+		workers = *config.Workers
+	}
+	if blocks := int(end - start + 1); workers > blocks {
+		workers = blocks
+	}
+	// Ensure we have a valid starting state before doing any work
+	database := state.NewDatabaseWithCache(db, 16)
+
+	base, statedb, err := stateAtAncestor(chain, database, parent, reexec)
+	if err != nil {
+		return nil, err
+	}
+	// Re-execute the blocks concurrently, aborting on the first failure
+	var (
+		pend   = new(sync.WaitGroup)
+		tasks  = make(chan *retraceTask, workers)
+		abort  = make(chan struct{})
+		once   sync.Once
+		failed error
+	)
+	fail := func(err error) {
+		once.Do(func() {
+			failed = err
+			close(abort)
+		})
+	}
+	for i := 0; i < workers; i++ {
+		pend.Add(1)
+		go func() {
+			defer pend.Done()
+
+			for task := range tasks {
+				select {
+				case <-abort:
+				default:
+					if _, _, _, err := chain.Processor().Process(task.block, task.statedb, vmcfg); err != nil {
+						fail(fmt.Errorf("processing block %d failed: %v", task.block.NumberU64(), err))
+					} else if err := sink.MarkCanonical([]common.Hash{task.block.Hash()}, true); err != nil {
+						fail(err)
+					}
+				}
+				// Dereference the parent trie held in memory by this task
+				if task.rootref != (common.Hash{}) {
+					database.TrieDB().Dereference(task.rootref)
+				}
+			}
+		}()
+	}
+	// Feed all the blocks into the workers, generating the state snapshots in between
+	var (
+		result = new(RetraceResult)
+		begin  = time.Now()
+		logged time.Time
+		proot  common.Hash
+	)
+feed:
+	for number := base.NumberU64() + 1; number <= end; number++ {
+		// Stop feeding if interruption was requested or a worker failed
+		select {
+		case <-ctx.Done():
+			fail(ctx.Err())
+			break feed
+		case <-abort:
+			break feed
+		default:
+		}
+		// Print progress logs if long enough time elapsed
+		if time.Since(logged) > 8*time.Second {
+			if number >= start {
+				nodes, imgs := database.TrieDB().Size()
+				log.Info("Retracing chain segment", "start", start, "end", end, "current", number, "transactions", result.Transactions, "elapsed", common.PrettyDuration(time.Since(begin)), "memory", nodes+imgs)
+			} else {
+				log.Info("Preparing state for chain retrace", "block", number, "start", start, "elapsed", common.PrettyDuration(time.Since(begin)))
+			}
+			logged = time.Now()
+		}
+		block := chain.GetBlockByNumber(number)
+		if block == nil {
+			fail(fmt.Errorf("block #%d not found", number))
+			break
+		}
+		// Send the block over to the workers (if not in the fast-forward phase)
+		if number >= start {
+			select {
+			case tasks <- &retraceTask{statedb: statedb.Copy(), block: block, rootref: proot}:
+				result.Blocks++
+				result.Transactions += hexutil.Uint64(len(block.Transactions()))
+			case <-abort:
+				break feed
+			}
+		}
+		if number == end {
+			break
+		}
+		// Generate the next state snapshot fast without tracing
+		if _, _, _, err := chain.Processor().Process(block, statedb, vm.Config{}); err != nil {
+			fail(fmt.Errorf("processing block %d failed: %v", number, err))
+			break
+		}
+		root, err := statedb.Commit(chain.Config().IsEIP158(block.Number()))
+		if err != nil {
+			fail(err)
+			break
+		}
+		if err := statedb.Reset(root); err != nil {
+			fail(fmt.Errorf("state reset after block %d failed: %v", number, err))
+			break
+		}
+		// Reference the trie twice, once for us, once for the next task
+		database.TrieDB().Reference(root, common.Hash{})
+		if number+1 >= start {
+			database.TrieDB().Reference(root, common.Hash{})
+		}
+		if proot != (common.Hash{}) {
+			database.TrieDB().Dereference(proot)
+		}
+		proot = root
+	}
+	close(tasks)
+	pend.Wait()
+
+	if failed != nil {
+		log.Warn("Chain retracing failed", "start", start, "end", end, "transactions", result.Transactions, "elapsed", common.PrettyDuration(time.Since(begin)), "err", failed)
+		return result, failed
+	}
+	if err := sink.Flush(); err != nil {
+		return result, err
+	}
+	log.Info("Chain retracing finished", "start", start, "end", end, "transactions", result.Transactions, "elapsed", common.PrettyDuration(time.Since(begin)))
+	return result, nil
+}
+
+// stateAtAncestor opens the state of the given block or, if it's unavailable, of
+// its closest ancestor within reexec blocks that has its state available. The
+// block whose state was opened is returned along with it.
+func stateAtAncestor(chain *core.BlockChain, database state.Database, block *types.Block, reexec uint64) (*types.Block, *state.StateDB, error) {
+	statedb, err := state.New(block.Root(), database)
+	if err == nil {
+		return block, statedb, nil
+	}
+	// Find the most recent block that has the state available
+	for i := uint64(0); i < reexec; i++ {
+		if block = chain.GetBlock(block.ParentHash(), block.NumberU64()-1); block == nil {
+			break
+		}
+		if statedb, err = state.New(block.Root(), database); err == nil {
+			return block, statedb, nil
+		}
+	}
+	// If we still don't have the state available, bail out
+	if _, ok := err.(*trie.MissingNodeError); ok {
+		return nil, nil, errors.New("required historical state unavailable")
+	}
+	return nil, nil, err
+}
@@ -0,0 +1,123 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package eth
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/mongo"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// Tests that re-tracing a chain segment records the transactions of exactly the
+// requested blocks as canonical, regenerating the starting state if needed.
+func TestRetrace(t *testing.T) {
+	var (
+		key, _   = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		address  = crypto.PubkeyToAddress(key.PublicKey)
+		contract = common.HexToAddress("0xc0de")
+		db       = rawdb.NewMemoryDatabase()
+		gspec    = &core.Genesis{
+			Config: params.TestChainConfig,
+			Alloc: core.GenesisAlloc{
+				address:  {Balance: big.NewInt(1000000000000000)},
+				contract: {Balance: big.NewInt(0), Code: common.FromHex("0x6001600055")}, // PUSH1 1 PUSH1 0 SSTORE
+			},
+		}
+		gendb   = rawdb.NewMemoryDatabase()
+		genesis = gspec.MustCommit(gendb)
+		signer  = types.NewEIP155Signer(gspec.Config.ChainID)
+	)
+	gspec.MustCommit(db)
+
+	blocks, _ := core.GenerateChain(gspec.Config, genesis, ethash.NewFaker(), gendb, 10, func(i int, block *core.BlockGen) {
+		for j := 0; j < 2; j++ {
+			tx, _ := types.SignTx(types.NewTransaction(block.TxNonce(address), contract, big.NewInt(0), 100000, big.NewInt(1), nil), signer, key)
+			block.AddTx(tx)
+		}
+	})
+	// Import the chain without recording anything, keeping the recent states in
+	// memory only, so retracing has to regenerate them
+	chain, _ := core.NewBlockChain(db, nil, gspec.Config, ethash.NewFaker(), vm.Config{}, nil)
+	defer chain.Stop()
+
+	if _, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	tests := []struct {
+		start, end uint64
+		reexec     uint64
+		fail       bool
+	}{
+		{0, 10, 0, false},
+		{4, 7, 10, false},
+		{10, 10, 10, false},
+		{4, 7, 1, true}, // state of block 3 unavailable within reach
+		{7, 4, 10, true},
+		{4, 11, 10, true},
+	}
+	for i, tt := range tests {
+		sink := mongo.NewMemorySink()
+		reexec, workers := tt.reexec, 3
+
+		result, err := Retrace(context.Background(), chain, db, tt.start, tt.end, vm.Config{TraceSink: sink}, &RetraceConfig{Reexec: &reexec, Workers: &workers})
+		if tt.fail {
+			if err == nil {
+				t.Errorf("test %d: expected failure", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("test %d: failed to retrace: %v", i, err)
+		}
+		from := tt.start
+		if from == 0 {
+			from = 1
+		}
+		want := 2 * int(tt.end-from+1)
+		if uint64(result.Blocks) != tt.end-from+1 || int(result.Transactions) != want {
+			t.Errorf("test %d: result mismatch: have %d blocks, %d txs, want %d blocks, %d txs", i, result.Blocks, result.Transactions, tt.end-from+1, want)
+		}
+		records := sink.Records()
+		if len(records) != want {
+			t.Fatalf("test %d: record count mismatch: have %d, want %d", i, len(records), want)
+		}
+		for _, record := range records {
+			if record.Tx_BlockNum < from || record.Tx_BlockNum > tt.end {
+				t.Errorf("test %d: record of block %d out of range", i, record.Tx_BlockNum)
+			}
+			if record.Tx_BlockHash != chain.GetBlockByNumber(record.Tx_BlockNum).Hash() {
+				t.Errorf("test %d: record of block %d has non-canonical hash %x", i, record.Tx_BlockNum, record.Tx_BlockHash)
+			}
+			if !record.Canonical {
+				t.Errorf("test %d: record of block %d not marked canonical", i, record.Tx_BlockNum)
+			}
+			if len(record.Tx_Trace) != 4 || record.Re_Status != types.ReceiptStatusSuccessful {
+				t.Errorf("test %d: record of block %d has invalid trace: %+v", i, record.Tx_BlockNum, record.Tx_Trace)
+			}
+		}
+	}
+}
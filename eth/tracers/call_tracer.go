@@ -0,0 +1,187 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"encoding/json"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+// callFrame is a single call reported by the call tracer. The fields are ordered
+// and omitted the same way as in the JavaScript callTracer output.
+type callFrame struct {
+	Type    string      `json:"type"`
+	From    string      `json:"from,omitempty"`
+	To      string      `json:"to,omitempty"`
+	Value   string      `json:"value,omitempty"`
+	Gas     string      `json:"gas,omitempty"`
+	GasUsed string      `json:"gasUsed,omitempty"`
+	Input   string      `json:"input,omitempty"`
+	Output  string      `json:"output,omitempty"`
+	Error   string      `json:"error,omitempty"`
+	Time    string      `json:"time,omitempty"`
+	Calls   []callFrame `json:"calls,omitempty"`
+}
+
+// callTracer is the native implementation of the callTracer, extracting and
+// reporting all the internal calls made by a transaction.
+//
+// Unlike the JavaScript tracer, which reconstructs the calls from the executed
+// opcodes, the call frames are built from the ones reported by the EVM. As such,
+// calls to precompiles and plain value transfers are reported too.
+type callTracer struct {
+	interruptible
+
+	callstack []callFrame // Current recursive call stack of the EVM execution
+
+	ctx callFrame // Outermost call, as reported by the EVM
+	err error     // Error aborting the tracing
+}
+
+// newCallTracer creates a native call tracer.
+func newCallTracer() *callTracer {
+	return &callTracer{callstack: []callFrame{{}}}
+}
+
+// CaptureStart implements the Tracer interface to initialize the tracing operation.
+func (t *callTracer) CaptureStart(from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) error {
+	t.ctx = callFrame{
+		Type:  "CALL",
+		From:  hexutil.Encode(from[:]),
+		To:    hexutil.Encode(to[:]),
+		Value: "0x" + value.Text(16),
+		Gas:   hexutil.EncodeUint64(gas),
+		Input: hexutil.Encode(input),
+	}
+	if create {
+		t.ctx.Type = "CREATE"
+	}
+	return nil
+}
+
+// CaptureState implements the Tracer interface to trace a single step of VM execution.
+func (t *callTracer) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	if t.err == nil && t.interrupted() {
+		t.err = t.reason
+	}
+	// Report reverts the same way as the JavaScript tracer
+	if op == vm.REVERT {
+		t.callstack[len(t.callstack)-1].Error = "execution reverted"
+	}
+	return nil
+}
+
+// CaptureFault implements the Tracer interface to trace an execution fault
+// while running an opcode. The fault is reported when exiting the call.
+func (t *callTracer) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	return nil
+}
+
+// CaptureEnd is called after the call finishes to finalize the tracing.
+func (t *callTracer) CaptureEnd(output []byte, gasUsed uint64, d time.Duration, err error) error {
+	t.ctx.Output = hexutil.Encode(output)
+	t.ctx.GasUsed = hexutil.EncodeUint64(gasUsed)
+	t.ctx.Time = d.String()
+
+	if err != nil {
+		t.ctx.Error = err.Error()
+	}
+	return nil
+}
+
+// CaptureEnter implements the Tracer interface, pushing a new inner call frame
+// onto the call stack.
+func (t *callTracer) CaptureEnter(typ vm.OpCode, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) error {
+	call := callFrame{
+		Type:  typ.String(),
+		From:  hexutil.Encode(from[:]),
+		To:    hexutil.Encode(to[:]),
+		Gas:   hexutil.EncodeUint64(gas),
+		Input: hexutil.Encode(input),
+	}
+	if value != nil {
+		call.Value = "0x" + value.Text(16)
+	}
+	t.callstack = append(t.callstack, call)
+	return nil
+}
+
+// CaptureExit implements the Tracer interface, popping the innermost call frame
+// off the call stack and injecting it into its parent.
+func (t *callTracer) CaptureExit(output []byte, gasUsed uint64, err error) error {
+	if len(t.callstack) < 2 {
+		return nil
+	}
+	call := t.callstack[len(t.callstack)-1]
+	t.callstack = t.callstack[:len(t.callstack)-1]
+
+	call.GasUsed = hexutil.EncodeUint64(gasUsed)
+	if err != nil {
+		if call.Error == "" {
+			call.Error = err.Error()
+		}
+		if call.Type == "CREATE" || call.Type == "CREATE2" {
+			call.To = ""
+		}
+	} else {
+		call.Output = hexutil.Encode(output)
+	}
+	top := &t.callstack[len(t.callstack)-1]
+	top.Calls = append(top.Calls, call)
+	return nil
+}
+
+// CaptureSelfDestruct implements the Tracer interface, reporting the transfer
+// of the remaining balance to the beneficiary as an inner call.
+func (t *callTracer) CaptureSelfDestruct(from common.Address, to common.Address, value *big.Int) error {
+	top := &t.callstack[len(t.callstack)-1]
+	top.Calls = append(top.Calls, callFrame{
+		Type:  vm.SELFDESTRUCT.String(),
+		From:  hexutil.Encode(from[:]),
+		To:    hexutil.Encode(to[:]),
+		Value: "0x" + value.Text(16),
+	})
+	return nil
+}
+
+// CaptureRefund implements the Tracer interface.
+func (t *callTracer) CaptureRefund(old uint64, new uint64) error {
+	return nil
+}
+
+// GetResult returns the JSON encoded outermost call, along with all the inner
+// calls made by it.
+func (t *callTracer) GetResult() (json.RawMessage, error) {
+	result := t.ctx
+	result.Calls = t.callstack[0].Calls
+	if t.callstack[0].Error != "" {
+		result.Error = t.callstack[0].Error
+	}
+	if result.Error != "" {
+		result.Output = ""
+	}
+	res, err := json.Marshal(result)
+	if err != nil {
+		return nil, err
+	}
+	return res, t.err
+}
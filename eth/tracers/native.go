@@ -0,0 +1,87 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"encoding/json"
+	"math/big"
+	"sync/atomic"
+
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+// NativeTracer is a transaction tracer implemented in Go. Native tracers produce
+// the same results as their JavaScript counterparts of the same name, without
+// the overhead of running the JavaScript interpreter for every opcode.
+type NativeTracer interface {
+	vm.Tracer
+
+	// GetResult returns the JSON encoded result of the tracing, or the error
+	// that interrupted it.
+	GetResult() (json.RawMessage, error)
+
+	// Stop terminates the tracing at the next opcode with the given reason.
+	Stop(err error)
+}
+
+// natives contains the constructors of all the native tracers by name.
+var natives = map[string]func() NativeTracer{
+	"callTracer":      func() NativeTracer { return newCallTracer() },
+	"prestateTracer":  func() NativeTracer { return newPrestateTracer() },
+	"vandalTracer":    func() NativeTracer { return newVandalTracer() },
+	"stateDiffTracer": func() NativeTracer { return newStateDiffTracer() },
+}
+
+// NewNative creates the native tracer of the given name, reporting whether one
+// exists.
+func NewNative(name string) (NativeTracer, bool) {
+	constructor, ok := natives[name]
+	if !ok {
+		return nil, false
+	}
+	return constructor(), true
+}
+
+// interruptible implements the Stop method of the native tracers.
+type interruptible struct {
+	interrupt uint32 // Atomic flag to signal execution interruption
+	reason    error  // Textual reason for the interruption
+}
+
+// Stop terminates the tracing at the next opcode with the given reason.
+func (i *interruptible) Stop(err error) {
+	i.reason = err
+	atomic.StoreUint32(&i.interrupt, 1)
+}
+
+// interrupted reports whether the tracing was stopped.
+func (i *interruptible) interrupted() bool {
+	return atomic.LoadUint32(&i.interrupt) > 0
+}
+
+// memorySlice returns a copy of the given memory range, or nil if it's out of
+// bounds, same as the memory accessor of the JavaScript tracers.
+func memorySlice(memory *vm.Memory, offset, size *big.Int) []byte {
+	if !offset.IsInt64() || !size.IsInt64() {
+		return nil
+	}
+	begin, end := offset.Int64(), offset.Int64()+size.Int64()
+	if end < begin || int64(memory.Len()) < end {
+		return nil
+	}
+	return memory.Get(begin, end-begin)
+}
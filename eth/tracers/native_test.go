@@ -0,0 +1,377 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/tests"
+)
+
+// runTracerTest executes the transaction of a tracer test case with the given
+// tracer attached and returns the decoded trace result, without the timing.
+func runTracerTest(test *callTracerTest, tracer interface {
+	vm.Tracer
+	GetResult() (json.RawMessage, error)
+}) (interface{}, error) {
+	tx := new(types.Transaction)
+	if err := rlp.DecodeBytes(common.FromHex(test.Input), tx); err != nil {
+		return nil, err
+	}
+	signer := types.MakeSigner(test.Genesis.Config, new(big.Int).SetUint64(uint64(test.Context.Number)))
+	origin, _ := signer.Sender(tx)
+
+	context := vm.Context{
+		CanTransfer: core.CanTransfer,
+		Transfer:    core.Transfer,
+		Origin:      origin,
+		Coinbase:    test.Context.Miner,
+		BlockNumber: new(big.Int).SetUint64(uint64(test.Context.Number)),
+		Time:        new(big.Int).SetUint64(uint64(test.Context.Time)),
+		Difficulty:  (*big.Int)(test.Context.Difficulty),
+		GasLimit:    uint64(test.Context.GasLimit),
+		GasPrice:    tx.GasPrice(),
+	}
+	statedb := tests.MakePreState(rawdb.NewMemoryDatabase(), test.Genesis.Alloc)
+	evm := vm.NewEVM(context, statedb, test.Genesis.Config, vm.Config{Debug: true, Tracer: tracer})
+	if recording, ok := tracer.(RecordingTracer); ok {
+		evm.Recorder = recording.Recorder()
+	}
+	if tracer, ok := tracer.(StateTracer); ok {
+		tracer.SetStateDB(statedb)
+	}
+
+	msg, err := tx.AsMessage(signer)
+	if err != nil {
+		return nil, err
+	}
+	st := core.NewStateTransition(evm, msg, new(core.GasPool).AddGas(tx.Gas()))
+	if _, _, _, err = st.TransitionDb(); err != nil {
+		return nil, err
+	}
+	res, err := tracer.GetResult()
+	if err != nil {
+		return nil, err
+	}
+	var result interface{}
+	if err := json.Unmarshal(res, &result); err != nil {
+		return nil, err
+	}
+	if call, ok := result.(map[string]interface{}); ok && call["type"] != nil {
+		delete(call, "time")
+	}
+	return result, nil
+}
+
+// Tests that the native tracers produce the same results as their JavaScript
+// counterparts over the tracer test harness.
+func TestNativeTracers(t *testing.T) {
+	files, err := ioutil.ReadDir("testdata")
+	if err != nil {
+		t.Fatalf("failed to retrieve tracer test suite: %v", err)
+	}
+	for _, name := range []string{"callTracer", "prestateTracer"} {
+		for _, file := range files {
+			if !strings.HasPrefix(file.Name(), "call_tracer_") {
+				continue
+			}
+			name, file := name, file // capture range variables
+			t.Run(name+"/"+camel(strings.TrimSuffix(strings.TrimPrefix(file.Name(), "call_tracer_"), ".json")), func(t *testing.T) {
+				t.Parallel()
+
+				blob, err := ioutil.ReadFile(filepath.Join("testdata", file.Name()))
+				if err != nil {
+					t.Fatalf("failed to read testcase: %v", err)
+				}
+				test := new(callTracerTest)
+				if err := json.Unmarshal(blob, test); err != nil {
+					t.Fatalf("failed to parse testcase: %v", err)
+				}
+				jst, err := New(name)
+				if err != nil {
+					t.Fatalf("failed to create JavaScript tracer: %v", err)
+				}
+				want, err := runTracerTest(test, jst)
+				if err != nil {
+					t.Fatalf("failed to run JavaScript tracer: %v", err)
+				}
+				native, ok := NewNative(name)
+				if !ok {
+					t.Fatalf("native tracer not found")
+				}
+				have, err := runTracerTest(test, native)
+				if err != nil {
+					t.Fatalf("failed to run native tracer: %v", err)
+				}
+				if name == "callTracer" {
+					stripInnerCalls(have)
+					stripInnerCalls(want)
+				}
+				if !reflect.DeepEqual(have, want) {
+					haveJSON, _ := json.Marshal(have)
+					wantJSON, _ := json.Marshal(want)
+					t.Fatalf("trace mismatch:\nhave %s\nwant %s", haveJSON, wantJSON)
+				}
+			})
+		}
+	}
+}
+
+// stripInnerCalls removes the gas, output and error details of the inner calls
+// of a decoded call trace, keeping only whether they failed. The native tracer
+// reports them for every call entered by the EVM, whereas the JavaScript tracer
+// can only reconstruct them from the opcodes for calls running code.
+func stripInnerCalls(trace interface{}) {
+	calls, _ := trace.(map[string]interface{})["calls"].([]interface{})
+	for _, call := range calls {
+		call := call.(map[string]interface{})
+		delete(call, "gas")
+		delete(call, "gasUsed")
+		delete(call, "output")
+		if _, ok := call["error"]; ok {
+			call["error"] = "failed"
+		}
+		stripInnerCalls(call)
+	}
+}
+
+// Tests that the native tracers pick up the calls entered by the EVM which run
+// no code, i.e. the calls to precompiles and plain value transfers, along with
+// the beneficiaries of self destructs.
+func TestNativeTracerCallFrames(t *testing.T) {
+	var (
+		sender      = common.HexToAddress("0xaa")
+		contract    = common.HexToAddress("0xc0de")
+		precompile  = common.BytesToAddress([]byte{0x04})
+		account     = common.HexToAddress("0xee")
+		beneficiary = common.HexToAddress("0xbe")
+	)
+	// CALL the identity precompile, CALL the missing account with 1 wei, then
+	// SELFDESTRUCT into the beneficiary
+	code := common.FromHex("0x6000600060006000600060045af150" + "6000600060006000600160ee5af150" + "60beff")
+
+	trace := func(tracer vm.Tracer) {
+		statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()))
+		statedb.SetBalance(sender, big.NewInt(1000))
+		statedb.SetCode(contract, code)
+		statedb.SetBalance(contract, big.NewInt(10))
+		statedb.SetBalance(beneficiary, big.NewInt(5))
+
+		context := vm.Context{
+			CanTransfer: core.CanTransfer,
+			Transfer:    core.Transfer,
+			BlockNumber: big.NewInt(1),
+			Time:        big.NewInt(0),
+			Difficulty:  big.NewInt(0),
+			GasPrice:    big.NewInt(0),
+		}
+		evm := vm.NewEVM(context, statedb, params.TestChainConfig, vm.Config{Debug: true, Tracer: tracer})
+		if _, _, err := evm.Call(vm.AccountRef(sender), contract, nil, 100000, big.NewInt(0)); err != nil {
+			t.Fatalf("failed to execute call: %v", err)
+		}
+	}
+	// Ensure the calls running no code are reported as frames
+	calltracer := newCallTracer()
+	trace(calltracer)
+
+	res, err := calltracer.GetResult()
+	if err != nil {
+		t.Fatalf("failed to retrieve call trace: %v", err)
+	}
+	var call callFrame
+	if err := json.Unmarshal(res, &call); err != nil {
+		t.Fatalf("failed to decode call trace: %v", err)
+	}
+	want := []callFrame{
+		{Type: "CALL", From: hexutil.Encode(contract[:]), To: hexutil.Encode(precompile[:]), Value: "0x0"},
+		{Type: "CALL", From: hexutil.Encode(contract[:]), To: hexutil.Encode(account[:]), Value: "0x1", GasUsed: "0x0", Output: "0x"},
+		{Type: "SELFDESTRUCT", From: hexutil.Encode(contract[:]), To: hexutil.Encode(beneficiary[:]), Value: "0x9"},
+	}
+	if len(call.Calls) != len(want) {
+		t.Fatalf("inner call count mismatch: have %d, want %d: %s", len(call.Calls), len(want), res)
+	}
+	for i, have := range call.Calls {
+		if have.Type != want[i].Type || have.From != want[i].From || have.To != want[i].To || have.Value != want[i].Value || have.Error != "" {
+			t.Errorf("call %d: frame mismatch: have %+v, want %+v", i, have, want[i])
+		}
+	}
+	if have := call.Calls[0]; have.Gas == "" || have.GasUsed == "" || have.GasUsed == "0x0" {
+		t.Errorf("precompile call gas missing: %+v", have)
+	}
+	if have := call.Calls[1]; have.GasUsed != want[1].GasUsed || have.Output != want[1].Output {
+		t.Errorf("value transfer outcome mismatch: have %+v, want %+v", have, want[1])
+	}
+	// Ensure the self destruct beneficiary is part of the prestate
+	prestate := newPrestateTracer()
+	trace(prestate)
+
+	if res, err = prestate.GetResult(); err != nil {
+		t.Fatalf("failed to retrieve prestate: %v", err)
+	}
+	var accounts map[common.Address]*prestateAccount
+	if err := json.Unmarshal(res, &accounts); err != nil {
+		t.Fatalf("failed to decode prestate: %v", err)
+	}
+	for addr, balance := range map[common.Address]int64{contract: 10, account: 0, beneficiary: 5} {
+		if accounts[addr] == nil || accounts[addr].Balance.ToInt().Int64() != balance {
+			t.Errorf("account %x: prestate mismatch: have %+v, want balance %d", addr, accounts[addr], balance)
+		}
+	}
+}
+
+// Tests that native tracers can be interrupted.
+func TestNativeTracerStop(t *testing.T) {
+	blob, err := ioutil.ReadFile(filepath.Join("testdata", "call_tracer_deep_calls.json"))
+	if err != nil {
+		t.Fatalf("failed to read testcase: %v", err)
+	}
+	test := new(callTracerTest)
+	if err := json.Unmarshal(blob, test); err != nil {
+		t.Fatalf("failed to parse testcase: %v", err)
+	}
+	for name := range natives {
+		tracer, _ := NewNative(name)
+		tracer.Stop(errors.New("stopped"))
+
+		if _, err := runTracerTest(test, tracer); err == nil || err.Error() != "stopped" {
+			t.Errorf("%s: error mismatch: have %v, want stopped", name, err)
+		}
+	}
+}
+
+// countCalls returns the number of call frames in a call tracer result.
+func countCalls(call *callTrace) int {
+	count := 1
+	for i := range call.Calls {
+		count += countCalls(&call.Calls[i])
+	}
+	return count
+}
+
+// Tests that the vandal tracer returns the recorded opcode trace, with the same
+// call frames the call tracer reports.
+func TestVandalTracer(t *testing.T) {
+	blob, err := ioutil.ReadFile(filepath.Join("testdata", "call_tracer_deep_calls.json"))
+	if err != nil {
+		t.Fatalf("failed to read testcase: %v", err)
+	}
+	test := new(callTracerTest)
+	if err := json.Unmarshal(blob, test); err != nil {
+		t.Fatalf("failed to parse testcase: %v", err)
+	}
+	tracer, _ := NewNative("vandalTracer")
+	res, err := runTracerTest(test, tracer)
+	if err != nil {
+		t.Fatalf("failed to run vandal tracer: %v", err)
+	}
+	result := res.(map[string]interface{})
+	trace, _ := result["trace"].([]interface{})
+	calls, _ := result["calls"].([]interface{})
+	if len(trace) == 0 {
+		t.Fatalf("no opcodes recorded")
+	}
+	if op := trace[0].(map[string]interface{})["op"]; op != "PUSH1" {
+		t.Errorf("first opcode mismatch: have %v, want PUSH1", op)
+	}
+	if want := countCalls(test.Result); len(calls) != want {
+		t.Errorf("call frame count mismatch: have %d, want %d", len(calls), want)
+	}
+}
+
+// Tests that the state diff tracer reports the changed accounts with their values
+// in the pre-state as the original ones.
+func TestStateDiffTracer(t *testing.T) {
+	blob, err := ioutil.ReadFile(filepath.Join("testdata", "call_tracer_deep_calls.json"))
+	if err != nil {
+		t.Fatalf("failed to read testcase: %v", err)
+	}
+	test := new(callTracerTest)
+	if err := json.Unmarshal(blob, test); err != nil {
+		t.Fatalf("failed to parse testcase: %v", err)
+	}
+	tracer, _ := NewNative("stateDiffTracer")
+	res, err := runTracerTest(test, tracer)
+	if err != nil {
+		t.Fatalf("failed to run state diff tracer: %v", err)
+	}
+	var diff map[common.Address]*state.AccountDiff
+	blob, _ = json.Marshal(res)
+	if err := json.Unmarshal(blob, &diff); err != nil {
+		t.Fatalf("failed to decode result: %v", err)
+	}
+	tx := new(types.Transaction)
+	if err := rlp.DecodeBytes(common.FromHex(test.Input), tx); err != nil {
+		t.Fatalf("failed to decode transaction: %v", err)
+	}
+	signer := types.MakeSigner(test.Genesis.Config, new(big.Int).SetUint64(uint64(test.Context.Number)))
+	from, _ := signer.Sender(tx)
+
+	sender := diff[from]
+	if sender == nil || sender.Nonce == nil || sender.Balance == nil {
+		t.Fatalf("sender change missing: %+v", sender)
+	}
+	if have, want := uint64(sender.Nonce.To), uint64(sender.Nonce.From)+1; have != want {
+		t.Errorf("sender nonce mismatch: have %d, want %d", have, want)
+	}
+	if sender.Balance.To.ToInt().Cmp(sender.Balance.From.ToInt()) >= 0 {
+		t.Errorf("sender balance not decreased: %v -> %v", sender.Balance.From, sender.Balance.To)
+	}
+	var storage int
+	for addr, account := range diff {
+		pre, ok := test.Genesis.Alloc[addr]
+		if !ok {
+			if !account.Created {
+				t.Errorf("%x: changed account missing from pre-state", addr)
+			}
+			continue
+		}
+		if account.Balance != nil && account.Balance.From.ToInt().Cmp(pre.Balance) != 0 {
+			t.Errorf("%x: original balance mismatch: have %v, want %v", addr, account.Balance.From, pre.Balance)
+		}
+		if account.Nonce != nil && uint64(account.Nonce.From) != pre.Nonce {
+			t.Errorf("%x: original nonce mismatch: have %d, want %d", addr, account.Nonce.From, pre.Nonce)
+		}
+		for slot, change := range account.Storage {
+			if change.From != pre.Storage[slot] {
+				t.Errorf("%x: original slot %x mismatch: have %x, want %x", addr, slot, change.From, pre.Storage[slot])
+			}
+			if change.From == change.To {
+				t.Errorf("%x: unchanged slot %x reported", addr, slot)
+			}
+			storage++
+		}
+	}
+	if storage == 0 {
+		t.Errorf("no storage changes reported")
+	}
+}
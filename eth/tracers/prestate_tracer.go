@@ -0,0 +1,203 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// prestateAccount is the state of a single account before the traced execution,
+// in the format of the JavaScript prestateTracer output.
+type prestateAccount struct {
+	Balance *hexutil.Big                `json:"balance"`
+	Nonce   uint64                      `json:"nonce"`
+	Code    hexutil.Bytes               `json:"code"`
+	Storage map[common.Hash]common.Hash `json:"storage"`
+}
+
+// prestateTracer is the native implementation of the prestateTracer, collecting
+// sufficient information to create a local execution of the transaction from a
+// custom assembled genesis block.
+type prestateTracer struct {
+	interruptible
+
+	prestate map[common.Address]*prestateAccount // Genesis that we're building
+	db       vm.StateDB                          // State database of the traced execution
+
+	create bool           // Whether the traced transaction is a contract creation
+	from   common.Address // Sender of the traced transaction
+	to     common.Address // Recipient of the traced transaction
+	value  *big.Int       // Value transferred by the traced transaction
+
+	err error // Error aborting the tracing
+}
+
+// newPrestateTracer creates a native prestate tracer.
+func newPrestateTracer() *prestateTracer {
+	return new(prestateTracer)
+}
+
+// lookupAccount injects the specified account into the prestate.
+func (t *prestateTracer) lookupAccount(addr common.Address) {
+	if _, ok := t.prestate[addr]; ok {
+		return
+	}
+	t.prestate[addr] = &prestateAccount{
+		Balance: (*hexutil.Big)(new(big.Int).Set(t.db.GetBalance(addr))),
+		Nonce:   t.db.GetNonce(addr),
+		Code:    common.CopyBytes(t.db.GetCode(addr)),
+		Storage: make(map[common.Hash]common.Hash),
+	}
+}
+
+// lookupStorage injects the specified storage entry of the given account into
+// the prestate.
+func (t *prestateTracer) lookupStorage(addr common.Address, key common.Hash) {
+	t.lookupAccount(addr)
+	if _, ok := t.prestate[addr].Storage[key]; ok {
+		return
+	}
+	t.prestate[addr].Storage[key] = t.db.GetState(addr, key)
+}
+
+// CaptureStart implements the Tracer interface to initialize the tracing operation.
+func (t *prestateTracer) CaptureStart(from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) error {
+	t.create, t.from, t.to, t.value = create, from, to, value
+	return nil
+}
+
+// CaptureState implements the Tracer interface to trace a single step of VM execution.
+func (t *prestateTracer) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	if t.err != nil {
+		return nil
+	}
+	if t.interrupted() {
+		t.err = t.reason
+		return nil
+	}
+	// Add the current account if we just started tracing. Its balance will be
+	// wrong, since it includes the value sent along, fixed up in GetResult.
+	t.db = env.StateDB
+	if t.prestate == nil {
+		t.prestate = make(map[common.Address]*prestateAccount)
+		t.lookupAccount(contract.Address())
+	}
+	// Whenever new state is accessed, add it to the prestate
+	switch op {
+	case vm.EXTCODECOPY, vm.EXTCODESIZE, vm.BALANCE:
+		t.lookupAccount(common.BigToAddress(stack.Back(0)))
+
+	case vm.CREATE:
+		from := contract.Address()
+		t.lookupAccount(crypto.CreateAddress(from, t.db.GetNonce(from)))
+
+	case vm.CREATE2:
+		code := memorySlice(memory, stack.Back(1), stack.Back(2))
+		salt := common.BigToHash(stack.Back(3))
+		t.lookupAccount(crypto.CreateAddress2(contract.Address(), salt, crypto.Keccak256(code)))
+
+	case vm.CALL, vm.CALLCODE, vm.DELEGATECALL, vm.STATICCALL:
+		t.lookupAccount(common.BigToAddress(stack.Back(1)))
+
+	case vm.SSTORE, vm.SLOAD:
+		t.lookupStorage(contract.Address(), common.BigToHash(stack.Back(0)))
+	}
+	return nil
+}
+
+// CaptureFault implements the Tracer interface to trace an execution fault
+// while running an opcode.
+func (t *prestateTracer) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	return nil
+}
+
+// CaptureEnd is called after the call finishes to finalize the tracing.
+func (t *prestateTracer) CaptureEnd(output []byte, gasUsed uint64, d time.Duration, err error) error {
+	return nil
+}
+
+// CaptureEnter implements the Tracer interface. Inner calls are entered after
+// the value was transferred, so the accounts are looked up from the executed
+// opcodes instead, the same way the JavaScript tracer does.
+func (t *prestateTracer) CaptureEnter(typ vm.OpCode, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) error {
+	return nil
+}
+
+// CaptureExit implements the Tracer interface.
+func (t *prestateTracer) CaptureExit(output []byte, gasUsed uint64, err error) error {
+	return nil
+}
+
+// CaptureSelfDestruct implements the Tracer interface, adding the beneficiary
+// to the prestate. It was credited already, so the value is deducted back.
+func (t *prestateTracer) CaptureSelfDestruct(from common.Address, to common.Address, value *big.Int) error {
+	if t.err != nil || t.prestate == nil {
+		return nil
+	}
+	if _, ok := t.prestate[to]; ok {
+		return nil
+	}
+	t.lookupAccount(to)
+	t.prestate[to].Balance = (*hexutil.Big)(new(big.Int).Sub(t.prestate[to].Balance.ToInt(), value))
+	return nil
+}
+
+// CaptureRefund implements the Tracer interface.
+func (t *prestateTracer) CaptureRefund(old uint64, new uint64) error {
+	return nil
+}
+
+// GetResult returns the JSON encoded prestate of all the accounts touched by
+// the traced execution.
+func (t *prestateTracer) GetResult() (json.RawMessage, error) {
+	if t.prestate == nil {
+		if t.err != nil {
+			return nil, t.err
+		}
+		return nil, errors.New("no code executed, prestate unavailable")
+	}
+	// At this point, we need to deduct the 'value' from the outer transaction,
+	// and move it back to the origin
+	t.lookupAccount(t.from)
+
+	to, from := t.prestate[t.to], t.prestate[t.from]
+	toBal, fromBal := to.Balance.ToInt(), from.Balance.ToInt()
+
+	to.Balance = (*hexutil.Big)(new(big.Int).Sub(toBal, t.value))
+	from.Balance = (*hexutil.Big)(new(big.Int).Add(fromBal, t.value))
+
+	// Decrement the caller's nonce, and remove empty create targets
+	from.Nonce--
+	if t.create {
+		// We can blindly delete the contract prestate, as any existing state would
+		// have caused the transaction to be rejected as invalid in the first place.
+		delete(t.prestate, t.to)
+	}
+	res, err := json.Marshal(t.prestate)
+	if err != nil {
+		return nil, err
+	}
+	return res, t.err
+}
@@ -0,0 +1,114 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+// StateTracer is a native tracer inspecting the state the traced transaction is
+// executed on, which has to be handed to it before the execution.
+type StateTracer interface {
+	NativeTracer
+
+	// SetStateDB sets the state the traced transaction is executed on.
+	SetStateDB(statedb *state.StateDB)
+}
+
+// stateDiffTracer returns the account changes made by the traced transaction,
+// with the values before and after it. The changes are derived from the state
+// journal, so they include the gas payment of the sender and the fee of the
+// miner, but not the block reward.
+type stateDiffTracer struct {
+	interruptible
+	statedb *state.StateDB
+	err     error
+}
+
+// newStateDiffTracer creates a tracer returning the changes of the traced
+// transaction.
+func newStateDiffTracer() *stateDiffTracer {
+	return new(stateDiffTracer)
+}
+
+// SetStateDB sets the state the traced transaction is executed on.
+func (t *stateDiffTracer) SetStateDB(statedb *state.StateDB) {
+	t.statedb = statedb
+}
+
+// CaptureStart implements the Tracer interface, the changes are collected by
+// the state.
+func (t *stateDiffTracer) CaptureStart(from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) error {
+	return nil
+}
+
+// CaptureState implements the Tracer interface, only checking for interruptions.
+func (t *stateDiffTracer) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	if t.err == nil && t.interrupted() {
+		t.err = t.reason
+	}
+	return nil
+}
+
+// CaptureFault implements the Tracer interface.
+func (t *stateDiffTracer) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	return nil
+}
+
+// CaptureEnd implements the Tracer interface.
+func (t *stateDiffTracer) CaptureEnd(output []byte, gasUsed uint64, d time.Duration, err error) error {
+	return nil
+}
+
+// CaptureEnter implements the Tracer interface.
+func (t *stateDiffTracer) CaptureEnter(typ vm.OpCode, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) error {
+	return nil
+}
+
+// CaptureExit implements the Tracer interface.
+func (t *stateDiffTracer) CaptureExit(output []byte, gasUsed uint64, err error) error {
+	return nil
+}
+
+// CaptureSelfDestruct implements the Tracer interface.
+func (t *stateDiffTracer) CaptureSelfDestruct(from common.Address, to common.Address, value *big.Int) error {
+	return nil
+}
+
+// CaptureRefund implements the Tracer interface.
+func (t *stateDiffTracer) CaptureRefund(old uint64, new uint64) error {
+	return nil
+}
+
+// GetResult returns the JSON encoded account changes of the traced transaction,
+// keyed by address. It must be called before the state is finalised.
+func (t *stateDiffTracer) GetResult() (json.RawMessage, error) {
+	if t.err != nil {
+		return nil, t.err
+	}
+	if t.statedb == nil {
+		return nil, errors.New("state unavailable")
+	}
+	return json.Marshal(t.statedb.Diff())
+}
@@ -300,13 +300,16 @@ type Tracer struct {
 	ctx map[string]interface{} // Transaction context gathered throughout execution
 	err error                  // Error, if one has occurred
 
+	hooks map[string]bool // Optional tracer functions implemented by the JavaScript object
+
 	interrupt uint32 // Atomic flag to signal execution interruption
 	reason    error  // Textual reason for the interruption
 }
 
 // New instantiates a new tracer instance. code specifies a Javascript snippet,
 // which must evaluate to an expression returning an object with 'step', 'fault'
-// and 'result' functions.
+// and 'result' functions, and optionally 'enter', 'exit', 'selfdestruct' and
+// 'refund' ones.
 func New(code string) (*Tracer, error) {
 	// Resolve any tracers by name and assemble the tracer object
 	if tracer, ok := tracer(code); ok {
@@ -315,6 +318,7 @@ func New(code string) (*Tracer, error) {
 	tracer := &Tracer{
 		vm:              duktape.New(),
 		ctx:             make(map[string]interface{}),
+		hooks:           make(map[string]bool),
 		opWrapper:       new(opWrapper),
 		stackWrapper:    new(stackWrapper),
 		memoryWrapper:   new(memoryWrapper),
@@ -433,6 +437,12 @@ func New(code string) (*Tracer, error) {
 	}
 	tracer.vm.Pop()
 
+	// Call frame, selfdestruct and refund notifications are only delivered if asked for
+	for _, hook := range []string{"enter", "exit", "selfdestruct", "refund"} {
+		tracer.hooks[hook] = tracer.vm.GetPropString(tracer.tracerObject, hook)
+		tracer.vm.Pop()
+	}
+
 	// Tracer is valid, inject the big int library to access large numbers
 	tracer.vm.EvalString(bigIntegerJS)
 	tracer.vm.PutGlobalString("bigInt")
@@ -597,12 +607,85 @@ func (jst *Tracer) CaptureEnd(output []byte, gasUsed uint64, t time.Duration, er
 	return nil
 }
 
-// GetResult calls the Javascript 'result' function and returns its value, or any accumulated error
-func (jst *Tracer) GetResult() (json.RawMessage, error) {
-	// Transform the context into a JavaScript object and inject into the state
+// CaptureEnter is called when an inner call frame is entered, passing the frame
+// to the JavaScript 'enter' function, if any. The value is omitted for calls not
+// transferring any.
+func (jst *Tracer) CaptureEnter(typ vm.OpCode, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) error {
+	frame := map[string]interface{}{
+		"type":  typ.String(),
+		"from":  from,
+		"to":    to,
+		"input": input,
+		"gas":   gas,
+	}
+	if value != nil {
+		frame["value"] = value
+	}
+	jst.hook("enter", "frame", frame)
+	return nil
+}
+
+// CaptureExit is called when an inner call frame is exited, passing its outcome
+// to the JavaScript 'exit' function, if any.
+func (jst *Tracer) CaptureExit(output []byte, gasUsed uint64, err error) error {
+	result := map[string]interface{}{
+		"output":  output,
+		"gasUsed": gasUsed,
+	}
+	if err != nil {
+		result["error"] = err.Error()
+	}
+	jst.hook("exit", "frameResult", result)
+	return nil
+}
+
+// CaptureSelfDestruct is called when a contract self destructs, passing the
+// beneficiary and the transferred balance to the JavaScript 'selfdestruct'
+// function, if any.
+func (jst *Tracer) CaptureSelfDestruct(from common.Address, to common.Address, value *big.Int) error {
+	jst.hook("selfdestruct", "selfdestruct", map[string]interface{}{
+		"from":  from,
+		"to":    to,
+		"value": value,
+	})
+	return nil
+}
+
+// CaptureRefund is called when the gas refund counter changes, passing the old
+// and new counter values to the JavaScript 'refund' function, if any.
+func (jst *Tracer) CaptureRefund(old uint64, new uint64) error {
+	jst.hook("refund", "refund", map[string]interface{}{
+		"old": old,
+		"new": new,
+	})
+	return nil
+}
+
+// hook calls an optional JavaScript function with the given values injected into
+// the state as its single argument.
+func (jst *Tracer) hook(method string, arg string, values map[string]interface{}) {
+	if jst.err != nil || !jst.hooks[method] {
+		return
+	}
+	// If tracing was interrupted, set the error and stop
+	if atomic.LoadUint32(&jst.interrupt) > 0 {
+		jst.err = jst.reason
+		return
+	}
+	jst.pushObject(values)
+	jst.vm.PutPropString(jst.stateObject, arg)
+
+	if _, err := jst.call(method, arg); err != nil {
+		jst.err = wrapError(method, err)
+	}
+}
+
+// pushObject transforms a set of values into a JavaScript object and pushes it
+// onto the VM stack.
+func (jst *Tracer) pushObject(values map[string]interface{}) {
 	obj := jst.vm.PushObject()
 
-	for key, val := range jst.ctx {
+	for key, val := range values {
 		switch val := val.(type) {
 		case uint64:
 			jst.vm.PushUint(uint(val))
@@ -626,6 +709,12 @@ func (jst *Tracer) GetResult() (json.RawMessage, error) {
 		}
 		jst.vm.PutPropString(obj, key)
 	}
+}
+
+// GetResult calls the Javascript 'result' function and returns its value, or any accumulated error
+func (jst *Tracer) GetResult() (json.RawMessage, error) {
+	// Transform the context into a JavaScript object and inject into the state
+	jst.pushObject(jst.ctx)
 	jst.vm.PutPropString(jst.stateObject, "ctx")
 
 	// Finalize the trace and return the results
@@ -21,12 +21,15 @@ import (
 	"encoding/json"
 	"errors"
 	"math/big"
+	"reflect"
 	"testing"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
 	"github.com/ethereum/go-ethereum/core/state"
 	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/core/vm/runtime"
 	"github.com/ethereum/go-ethereum/params"
 )
 
@@ -145,3 +148,59 @@ func TestHaltBetweenSteps(t *testing.T) {
 		t.Errorf("Expected timeout error, got %v", err)
 	}
 }
+
+// Tests that the optional call frame, selfdestruct and refund hooks of the
+// JavaScript tracers are invoked, including for precompiles and plain transfers.
+func TestFrameHooks(t *testing.T) {
+	tracer, err := New(`{
+		events: [],
+		step: function() {},
+		fault: function() {},
+		enter: function(frame) { this.events.push("enter " + frame.type + " " + toHex(frame.to) + " " + (frame.value === undefined ? "-" : frame.value)); },
+		exit: function(res) { this.events.push("exit " + (res.error === undefined ? "ok" : res.error)); },
+		selfdestruct: function(sd) { this.events.push("selfdestruct " + toHex(sd.to) + " " + sd.value); },
+		refund: function(r) { this.events.push("refund " + r.old + " " + r.new); },
+		result: function() { return this.events; }
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()))
+
+	contract := common.HexToAddress("0xaa")
+	statedb.SetBalance(contract, big.NewInt(10))
+	statedb.SetCode(contract, common.FromHex(
+		"600060006000600060045afa50"+ // STATICCALL to the identity precompile
+			"6000600060006000600160bb5af150"+ // CALL transferring 1 wei to an account without code
+			"6001600055600060005560"+ // SSTORE slot 0 to 1 and back to 0
+			"ccff", // SELFDESTRUCT to 0xcc
+	))
+	_, _, err = runtime.Call(contract, nil, &runtime.Config{
+		ChainConfig: params.TestChainConfig,
+		State:       statedb,
+		EVMConfig:   vm.Config{Debug: true, Tracer: tracer},
+	})
+	if err != nil {
+		t.Fatalf("failed to execute contract: %v", err)
+	}
+	res, err := tracer.GetResult()
+	if err != nil {
+		t.Fatalf("failed to retrieve trace result: %v", err)
+	}
+	var have []string
+	if err := json.Unmarshal(res, &have); err != nil {
+		t.Fatalf("failed to decode trace result: %v", err)
+	}
+	want := []string{
+		"enter STATICCALL 0x0000000000000000000000000000000000000004 -",
+		"exit ok",
+		"enter CALL 0x00000000000000000000000000000000000000bb 1",
+		"exit ok",
+		"refund 0 15000",
+		"refund 15000 39000",
+		"selfdestruct 0x00000000000000000000000000000000000000cc 9",
+	}
+	if !reflect.DeepEqual(have, want) {
+		t.Errorf("event mismatch:\nhave %q\nwant %q", have, want)
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"encoding/json"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/mongo"
+)
+
+// RecordingTracer is a native tracer built on the trace recorder of the EVM,
+// which has to be attached to the EVM running the traced transaction.
+type RecordingTracer interface {
+	NativeTracer
+
+	// Recorder returns the trace recorder to attach to the EVM.
+	Recorder() *mongo.Recorder
+}
+
+// vandalResult is the output of the vandal tracer, matching the trace fields of
+// the records stored into the trace sink.
+type vandalResult struct {
+	Trace []mongo.TraceStep `json:"trace"`           // Same as the Tx_Trace of the record
+	Calls []mongo.CallFrame `json:"calls"`           // Same as the Tx_Calls of the record
+	Error string            `json:"error,omitempty"` // Same as the Re_FailReason of the record
+}
+
+// vandalTracer returns the opcode trace recorded into the trace sink during block
+// processing, allowing a single transaction to be retraced on demand.
+type vandalTracer struct {
+	interruptible
+	recorder *mongo.Recorder
+	err      error
+}
+
+// newVandalTracer creates a tracer returning the recorded opcode trace.
+func newVandalTracer() *vandalTracer {
+	return &vandalTracer{recorder: mongo.NewRecorder()}
+}
+
+// Recorder returns the trace recorder to attach to the EVM.
+func (t *vandalTracer) Recorder() *mongo.Recorder {
+	return t.recorder
+}
+
+// CaptureStart implements the Tracer interface, the trace itself is collected
+// by the recorder.
+func (t *vandalTracer) CaptureStart(from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) error {
+	return nil
+}
+
+// CaptureState implements the Tracer interface, only checking for interruptions.
+func (t *vandalTracer) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	if t.err == nil && t.interrupted() {
+		t.err = t.reason
+	}
+	return nil
+}
+
+// CaptureFault implements the Tracer interface.
+func (t *vandalTracer) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	return nil
+}
+
+// CaptureEnd implements the Tracer interface.
+func (t *vandalTracer) CaptureEnd(output []byte, gasUsed uint64, d time.Duration, err error) error {
+	return nil
+}
+
+// CaptureEnter implements the Tracer interface, call frames are collected by
+// the recorder.
+func (t *vandalTracer) CaptureEnter(typ vm.OpCode, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) error {
+	return nil
+}
+
+// CaptureExit implements the Tracer interface.
+func (t *vandalTracer) CaptureExit(output []byte, gasUsed uint64, err error) error {
+	return nil
+}
+
+// CaptureSelfDestruct implements the Tracer interface.
+func (t *vandalTracer) CaptureSelfDestruct(from common.Address, to common.Address, value *big.Int) error {
+	return nil
+}
+
+// CaptureRefund implements the Tracer interface.
+func (t *vandalTracer) CaptureRefund(old uint64, new uint64) error {
+	return nil
+}
+
+// GetResult returns the recorded trace, or the error that interrupted it.
+func (t *vandalTracer) GetResult() (json.RawMessage, error) {
+	if t.err != nil {
+		return nil, t.err
+	}
+	return json.Marshal(&vandalResult{
+		Trace: t.recorder.Trace(),
+		Calls: t.recorder.Calls(),
+		Error: t.recorder.Error(),
+	})
+}
@@ -0,0 +1,395 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// +build !js
+
+// Package bolt implements the key-value database layer based on bbolt, a pure Go
+// B+tree database stored in a single memory mapped file.
+package bolt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+	"go.etcd.io/bbolt"
+)
+
+const (
+	// FileName is the name of the database file within the database directory.
+	// Its presence marks a directory as holding a bolt database.
+	FileName = "bolt.db"
+
+	// openTimeout is the maximum time to wait for the file lock of a database
+	// held by another process before giving up.
+	openTimeout = time.Second
+
+	// iteratorChunk is the number of items an iterator loads into memory within
+	// a single read transaction.
+	iteratorChunk = 1024
+)
+
+var (
+	// bucket is the name of the single bolt bucket holding all the data.
+	bucket = []byte("ethdb")
+
+	// errNotFound is returned if a key is requested that is not found in the
+	// database.
+	errNotFound = errors.New("not found")
+
+	// errNotInitialized is returned if a read only database is opened before any
+	// data was ever written into it.
+	errNotInitialized = errors.New("database not initialized")
+)
+
+// Database is a persistent key-value store backed by bolt. Apart from basic data
+// storage functionality it also supports batch writes and iterating over the
+// keyspace in binary-alphabetical order.
+type Database struct {
+	fn string    // Directory name for reporting
+	db *bbolt.DB // Bolt instance
+
+	log log.Logger // Contextual logger tracking the database path
+}
+
+// New returns a wrapped bolt object, creating the database file within the given
+// directory if it doesn't exist yet.
+func New(file string) (*Database, error) {
+	return open(file, false)
+}
+
+// NewReadOnly returns a wrapped bolt object opened in read only mode. The write
+// operations of the returned database all fail.
+func NewReadOnly(file string) (*Database, error) {
+	return open(file, true)
+}
+
+// open opens the bolt database within the given directory, in read only mode or
+// not.
+func open(file string, readonly bool) (*Database, error) {
+	logger := log.New("database", file)
+
+	if !readonly {
+		if err := os.MkdirAll(file, 0755); err != nil {
+			return nil, err
+		}
+	}
+	db, err := bbolt.Open(filepath.Join(file, FileName), 0644, &bbolt.Options{
+		Timeout:  openTimeout,
+		ReadOnly: readonly,
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Create the data bucket, or ensure it exists in read only mode
+	if readonly {
+		err = db.View(func(tx *bbolt.Tx) error {
+			if tx.Bucket(bucket) == nil {
+				return errNotInitialized
+			}
+			return nil
+		})
+	} else {
+		err = db.Update(func(tx *bbolt.Tx) error {
+			_, err := tx.CreateBucketIfNotExists(bucket)
+			return err
+		})
+	}
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	logger.Info("Opened bolt database", "readonly", readonly)
+
+	return &Database{
+		fn:  file,
+		db:  db,
+		log: logger,
+	}, nil
+}
+
+// Close flushes any pending data to disk and closes all io accesses to the
+// underlying key-value store.
+func (db *Database) Close() error {
+	return db.db.Close()
+}
+
+// Has retrieves if a key is present in the key-value store.
+func (db *Database) Has(key []byte) (bool, error) {
+	var has bool
+	err := db.db.View(func(tx *bbolt.Tx) error {
+		k, _ := tx.Bucket(bucket).Cursor().Seek(key)
+		has = k != nil && bytes.Equal(k, key)
+		return nil
+	})
+	return has, err
+}
+
+// Get retrieves the given key if it's present in the key-value store.
+func (db *Database) Get(key []byte) ([]byte, error) {
+	var dat []byte
+	err := db.db.View(func(tx *bbolt.Tx) error {
+		// Seek instead of a plain get, the latter doesn't differentiate between
+		// missing and empty values
+		k, v := tx.Bucket(bucket).Cursor().Seek(key)
+		if k == nil || !bytes.Equal(k, key) {
+			return errNotFound
+		}
+		dat = make([]byte, len(v))
+		copy(dat, v)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return dat, nil
+}
+
+// Put inserts the given value into the key-value store. Every call commits its
+// own transaction, synced to disk, so bulk writes should go through a batch.
+func (db *Database) Put(key []byte, value []byte) error {
+	return db.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucket).Put(key, value)
+	})
+}
+
+// Delete removes the key from the key-value store. Every call commits its own
+// transaction, synced to disk, so bulk deletions should go through a batch.
+func (db *Database) Delete(key []byte) error {
+	return db.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucket).Delete(key)
+	})
+}
+
+// NewBatch creates a write-only key-value store that buffers changes to its host
+// database until a final write is called.
+func (db *Database) NewBatch() ethdb.Batch {
+	return &batch{
+		db: db.db,
+	}
+}
+
+// NewIterator creates a binary-alphabetical iterator over the entire keyspace
+// contained within the bolt database.
+func (db *Database) NewIterator() ethdb.Iterator {
+	return db.NewIteratorWithPrefix(nil)
+}
+
+// NewIteratorWithPrefix creates a binary-alphabetical iterator over a subset
+// of database content with a particular key prefix.
+func (db *Database) NewIteratorWithPrefix(prefix []byte) ethdb.Iterator {
+	return &iterator{
+		db:     db.db,
+		prefix: common.CopyBytes(prefix),
+		next:   common.CopyBytes(prefix),
+	}
+}
+
+// Stat returns a particular internal stat of the database. The only supported
+// property is "bolt.stats".
+func (db *Database) Stat(property string) (string, error) {
+	if property != "bolt.stats" {
+		return "", fmt.Errorf("unknown property: %s", property)
+	}
+	stats := db.db.Stats()
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "Free pages:      %d (%d pending)\n", stats.FreePageN, stats.PendingPageN)
+	fmt.Fprintf(&buf, "Free pages size: %v\n", common.StorageSize(stats.FreeAlloc))
+	fmt.Fprintf(&buf, "Transactions:    %d (%d open)\n", stats.TxN, stats.OpenTxN)
+	fmt.Fprintf(&buf, "Pages written:   %d in %v\n", stats.TxStats.Write, stats.TxStats.WriteTime)
+	fmt.Fprintf(&buf, "Pages spilled:   %d in %v\n", stats.TxStats.Spill, stats.TxStats.SpillTime)
+	return buf.String(), nil
+}
+
+// Compact is a noop on a bolt database. Deleted and overwritten data is freed
+// when a write transaction commits and the pages are reused by later writes,
+// but the database file itself never shrinks.
+func (db *Database) Compact(start []byte, limit []byte) error {
+	return nil
+}
+
+// Path returns the path to the database directory.
+func (db *Database) Path() string {
+	return db.fn
+}
+
+// keyvalue is a key-value tuple tagged with a deletion field to allow creating
+// bolt write batches.
+type keyvalue struct {
+	key    []byte
+	value  []byte
+	delete bool
+}
+
+// batch is a write-only bolt batch that commits changes to its host database
+// when Write is called, within a single transaction. A batch cannot be used
+// concurrently.
+type batch struct {
+	db     *bbolt.DB
+	writes []keyvalue
+	size   int
+}
+
+// Put inserts the given value into the batch for later committing.
+func (b *batch) Put(key, value []byte) error {
+	b.writes = append(b.writes, keyvalue{common.CopyBytes(key), common.CopyBytes(value), false})
+	b.size += len(value)
+	return nil
+}
+
+// Delete inserts the a key removal into the batch for later committing.
+func (b *batch) Delete(key []byte) error {
+	b.writes = append(b.writes, keyvalue{common.CopyBytes(key), nil, true})
+	b.size++
+	return nil
+}
+
+// ValueSize retrieves the amount of data queued up for writing.
+func (b *batch) ValueSize() int {
+	return b.size
+}
+
+// Write flushes any accumulated data to disk.
+func (b *batch) Write() error {
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(bucket)
+		for _, keyvalue := range b.writes {
+			if keyvalue.delete {
+				if err := bucket.Delete(keyvalue.key); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := bucket.Put(keyvalue.key, keyvalue.value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Reset resets the batch for reuse.
+func (b *batch) Reset() {
+	b.writes = b.writes[:0]
+	b.size = 0
+}
+
+// Replay replays the batch contents.
+func (b *batch) Replay(w ethdb.KeyValueWriter) error {
+	for _, keyvalue := range b.writes {
+		if keyvalue.delete {
+			if err := w.Delete(keyvalue.key); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := w.Put(keyvalue.key, keyvalue.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// iterator can walk over the (potentially partial) keyspace of a bolt database.
+//
+// Bolt data is only accessible within a transaction, and an open read transaction
+// blocks writers from growing the database file. To allow modifying the database
+// while iterating, the iterator loads the items in chunks, each in its own short
+// read transaction. As such, the iterator doesn't see a consistent snapshot of
+// the database: writes made during iteration ahead of it may be picked up.
+type iterator struct {
+	db     *bbolt.DB
+	prefix []byte // Prefix of the keys to iterate over
+	next   []byte // Key to load the next chunk from
+	done   bool   // Whether all the items were loaded
+
+	keys   [][]byte // Keys of the loaded chunk not iterated over yet
+	values [][]byte // Values of the loaded chunk not iterated over yet
+	key    []byte   // Key of the current item
+	value  []byte   // Value of the current item
+	err    error    // Error encountered while loading a chunk
+}
+
+// Next moves the iterator to the next key/value pair. It returns whether the
+// iterator is exhausted.
+func (it *iterator) Next() bool {
+	if len(it.keys) == 0 && !it.done && it.err == nil {
+		it.load()
+	}
+	if len(it.keys) == 0 {
+		it.key, it.value = nil, nil
+		return false
+	}
+	it.key, it.value = it.keys[0], it.values[0]
+	it.keys, it.values = it.keys[1:], it.values[1:]
+	return true
+}
+
+// load retrieves the next chunk of items, starting at the next key.
+func (it *iterator) load() {
+	it.err = it.db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(bucket).Cursor()
+
+		k, v := c.First()
+		if len(it.next) > 0 {
+			k, v = c.Seek(it.next)
+		}
+		for ; k != nil && bytes.HasPrefix(k, it.prefix); k, v = c.Next() {
+			if len(it.keys) == iteratorChunk {
+				it.next = common.CopyBytes(k)
+				return nil
+			}
+			it.keys = append(it.keys, common.CopyBytes(k))
+			it.values = append(it.values, common.CopyBytes(v))
+		}
+		it.done = true
+		return nil
+	})
+}
+
+// Error returns any accumulated error. Exhausting all the key/value pairs
+// is not considered to be an error.
+func (it *iterator) Error() error {
+	return it.err
+}
+
+// Key returns the key of the current key/value pair, or nil if done. The caller
+// should not modify the contents of the returned slice, and its contents may
+// change on the next call to Next.
+func (it *iterator) Key() []byte {
+	return it.key
+}
+
+// Value returns the value of the current key/value pair, or nil if done. The
+// caller should not modify the contents of the returned slice, and its contents
+// may change on the next call to Next.
+func (it *iterator) Value() []byte {
+	return it.value
+}
+
+// Release releases associated resources. Release should always succeed and can
+// be called multiple times without causing error.
+func (it *iterator) Release() {
+	it.keys, it.values, it.done = nil, nil, true
+	it.key, it.value = nil, nil
+}
@@ -0,0 +1,89 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// +build !js
+
+package bolt
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/ethdb/dbtest"
+)
+
+func TestBoltDB(t *testing.T) {
+	t.Run("DatabaseSuite", func(t *testing.T) {
+		dbtest.TestDatabaseSuite(t, func() ethdb.KeyValueStore {
+			dir, err := ioutil.TempDir("", "bolt")
+			if err != nil {
+				t.Fatal(err)
+			}
+			db, err := New(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return &cleanupDatabase{Database: db, dir: dir}
+		})
+	})
+}
+
+// cleanupDatabase is a bolt database removing its directory when closed.
+type cleanupDatabase struct {
+	*Database
+	dir string
+}
+
+func (db *cleanupDatabase) Close() error {
+	defer os.RemoveAll(db.dir)
+	return db.Database.Close()
+}
+
+// Tests that a read only database refuses writes but serves the data persisted
+// by an earlier writable instance.
+func TestBoltReadOnly(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bolt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if _, err := NewReadOnly(dir); err == nil {
+		t.Fatalf("missing database opened in read only mode")
+	}
+	db, err := New(dir)
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	if err := db.Put([]byte("key"), []byte("value")); err != nil {
+		t.Fatalf("failed to insert item: %v", err)
+	}
+	db.Close()
+
+	if db, err = NewReadOnly(dir); err != nil {
+		t.Fatalf("failed to open database in read only mode: %v", err)
+	}
+	defer db.Close()
+
+	if val, err := db.Get([]byte("key")); err != nil || string(val) != "value" {
+		t.Fatalf("value mismatch: have %q (%v), want %q", val, err, "value")
+	}
+	if err := db.Put([]byte("key"), []byte("other")); err == nil {
+		t.Fatalf("write succeeded in read only mode")
+	}
+}
@@ -0,0 +1,361 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package dbtest contains the conformance test suite every key-value data store
+// backing the high level database must pass.
+package dbtest
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+)
+
+// TestDatabaseSuite runs a suite of tests against a KeyValueStore database
+// implementation. The constructor is invoked once per test and must return a
+// fresh, empty database.
+func TestDatabaseSuite(t *testing.T, New func() ethdb.KeyValueStore) {
+	t.Run("Iterator", func(t *testing.T) { testIterator(t, New) })
+	t.Run("KeyValueOperations", func(t *testing.T) { testKeyValueOperations(t, New) })
+	t.Run("Batch", func(t *testing.T) { testBatch(t, New) })
+	t.Run("BatchReplay", func(t *testing.T) { testBatchReplay(t, New) })
+	t.Run("IteratorMutation", func(t *testing.T) { testIteratorMutation(t, New) })
+	t.Run("LargeIteration", func(t *testing.T) { testLargeIteration(t, New) })
+}
+
+// testIterator tests that key-value iteration, with and without a prefix, walks
+// the requested part of the keyspace in binary-alphabetical order.
+func testIterator(t *testing.T, New func() ethdb.KeyValueStore) {
+	tests := []struct {
+		content map[string]string
+		prefix  string
+		order   []string
+	}{
+		// Empty databases should be iterable
+		{map[string]string{}, "", nil},
+		{map[string]string{}, "non-existent-prefix", nil},
+
+		// Single-item databases should be iterable
+		{map[string]string{"key": "val"}, "", []string{"key"}},
+		{map[string]string{"key": "val"}, "k", []string{"key"}},
+		{map[string]string{"key": "val"}, "l", nil},
+
+		// Multi-item databases should be fully iterable
+		{
+			map[string]string{"k1": "v1", "k5": "v5", "k2": "v2", "k4": "v4", "k3": "v3"},
+			"",
+			[]string{"k1", "k2", "k3", "k4", "k5"},
+		},
+		{
+			map[string]string{"k1": "v1", "k5": "v5", "k2": "v2", "k4": "v4", "k3": "v3"},
+			"k",
+			[]string{"k1", "k2", "k3", "k4", "k5"},
+		},
+		{
+			map[string]string{"k1": "v1", "k5": "v5", "k2": "v2", "k4": "v4", "k3": "v3"},
+			"l",
+			nil,
+		},
+		// Multi-item databases should be prefix-iterable
+		{
+			map[string]string{
+				"ka1": "va1", "ka5": "va5", "ka2": "va2", "ka4": "va4", "ka3": "va3",
+				"kb1": "vb1", "kb5": "vb5", "kb2": "vb2", "kb4": "vb4", "kb3": "vb3",
+			},
+			"ka",
+			[]string{"ka1", "ka2", "ka3", "ka4", "ka5"},
+		},
+		{
+			map[string]string{
+				"ka1": "va1", "ka5": "va5", "ka2": "va2", "ka4": "va4", "ka3": "va3",
+				"kb1": "vb1", "kb5": "vb5", "kb2": "vb2", "kb4": "vb4", "kb3": "vb3",
+			},
+			"kc",
+			nil,
+		},
+		// Prefixes should be compared bytewise, not as strings
+		{
+			map[string]string{"\x00\xff": "v1", "\x01": "v2", "\x01\x00": "v3", "\xff": "v4", "\xff\xff": "v5"},
+			"\xff",
+			[]string{"\xff", "\xff\xff"},
+		},
+	}
+	for i, tt := range tests {
+		// Create the key-value data store
+		db := New()
+		for key, val := range tt.content {
+			if err := db.Put([]byte(key), []byte(val)); err != nil {
+				t.Fatalf("test %d: failed to insert item %s:%s into database: %v", i, key, val, err)
+			}
+		}
+		// Iterate over the database with the given configs and verify the results
+		it, idx := db.NewIteratorWithPrefix([]byte(tt.prefix)), 0
+		for it.Next() {
+			if idx >= len(tt.order) {
+				t.Errorf("test %d: iteration returned extra item %x", i, it.Key())
+				break
+			}
+			if !bytes.Equal(it.Key(), []byte(tt.order[idx])) {
+				t.Errorf("test %d: item %d: key mismatch: have %s, want %s", i, idx, string(it.Key()), tt.order[idx])
+			}
+			if !bytes.Equal(it.Value(), []byte(tt.content[tt.order[idx]])) {
+				t.Errorf("test %d: item %d: value mismatch: have %s, want %s", i, idx, string(it.Value()), tt.content[tt.order[idx]])
+			}
+			idx++
+		}
+		if err := it.Error(); err != nil {
+			t.Errorf("test %d: iteration failed: %v", i, err)
+		}
+		if idx != len(tt.order) {
+			t.Errorf("test %d: iteration terminated prematurely: have %d, want %d", i, idx, len(tt.order))
+		}
+		it.Release()
+		db.Close()
+	}
+}
+
+// testKeyValueOperations tests the single item insertion, retrieval, overwrite
+// and deletion operations.
+func testKeyValueOperations(t *testing.T, New func() ethdb.KeyValueStore) {
+	db := New()
+	defer db.Close()
+
+	key := []byte("foo")
+	if has, err := db.Has(key); err != nil || has {
+		t.Fatalf("missing key reported present: %v (%v)", has, err)
+	}
+	if _, err := db.Get(key); err == nil {
+		t.Fatalf("missing key retrieved without error")
+	}
+	// Insert a value and modify the input afterwards, the store must not alias it
+	value := []byte("hello world")
+	if err := db.Put(key, value); err != nil {
+		t.Fatalf("failed to insert item: %v", err)
+	}
+	value[0] = 'H'
+
+	if has, err := db.Has(key); err != nil || !has {
+		t.Fatalf("inserted key reported missing: %v (%v)", has, err)
+	}
+	got, err := db.Get(key)
+	if err != nil {
+		t.Fatalf("failed to retrieve inserted item: %v", err)
+	}
+	if !bytes.Equal(got, []byte("hello world")) {
+		t.Fatalf("value mismatch: have %q, want %q", got, "hello world")
+	}
+	// Modifying the retrieved value must not alter the stored one
+	got[0] = 'J'
+	if got, _ := db.Get(key); !bytes.Equal(got, []byte("hello world")) {
+		t.Fatalf("value mismatch after modifying retrieved copy: have %q, want %q", got, "hello world")
+	}
+	// Overwrite the value and ensure the new one is returned
+	if err := db.Put(key, []byte("bar")); err != nil {
+		t.Fatalf("failed to overwrite item: %v", err)
+	}
+	if got, err := db.Get(key); err != nil || !bytes.Equal(got, []byte("bar")) {
+		t.Fatalf("value mismatch after overwrite: have %q (%v), want %q", got, err, "bar")
+	}
+	// Empty values are legal and must be distinguishable from missing keys
+	if err := db.Put([]byte("empty"), nil); err != nil {
+		t.Fatalf("failed to insert empty item: %v", err)
+	}
+	if has, err := db.Has([]byte("empty")); err != nil || !has {
+		t.Fatalf("empty item reported missing: %v (%v)", has, err)
+	}
+	if got, err := db.Get([]byte("empty")); err != nil || len(got) != 0 {
+		t.Fatalf("empty item mismatch: have %x (%v), want empty", got, err)
+	}
+	// Delete the value and ensure it's gone, deleting again must not fail
+	if err := db.Delete(key); err != nil {
+		t.Fatalf("failed to delete item: %v", err)
+	}
+	if has, err := db.Has(key); err != nil || has {
+		t.Fatalf("deleted key reported present: %v (%v)", has, err)
+	}
+	if _, err := db.Get(key); err == nil {
+		t.Fatalf("deleted key retrieved without error")
+	}
+	if err := db.Delete(key); err != nil {
+		t.Fatalf("failed to delete missing item: %v", err)
+	}
+}
+
+// testBatch tests that batched writes are only visible after being written, and
+// that a batch can be reset and reused.
+func testBatch(t *testing.T, New func() ethdb.KeyValueStore) {
+	db := New()
+	defer db.Close()
+
+	if err := db.Put([]byte("4"), []byte("d")); err != nil {
+		t.Fatalf("failed to insert item: %v", err)
+	}
+	b := db.NewBatch()
+	for _, k := range []string{"1", "2", "3"} {
+		if err := b.Put([]byte(k), []byte(k+k)); err != nil {
+			t.Fatalf("failed to batch item %s: %v", k, err)
+		}
+	}
+	if err := b.Delete([]byte("4")); err != nil {
+		t.Fatalf("failed to batch deletion: %v", err)
+	}
+	if b.ValueSize() == 0 {
+		t.Fatalf("batch size not tracked")
+	}
+	if has, _ := db.Has([]byte("1")); has {
+		t.Fatalf("batched item visible before write")
+	}
+	if err := b.Write(); err != nil {
+		t.Fatalf("failed to write batch: %v", err)
+	}
+	checkContent(t, db, map[string]string{"1": "11", "2": "22", "3": "33"})
+
+	// Reset the batch and reuse it, the old operations must not be replayed
+	b.Reset()
+	if b.ValueSize() != 0 {
+		t.Fatalf("batch size mismatch after reset: have %d, want 0", b.ValueSize())
+	}
+	if err := b.Delete([]byte("2")); err != nil {
+		t.Fatalf("failed to batch deletion: %v", err)
+	}
+	if err := db.Put([]byte("1"), []byte("x")); err != nil {
+		t.Fatalf("failed to insert item: %v", err)
+	}
+	if err := b.Write(); err != nil {
+		t.Fatalf("failed to write batch: %v", err)
+	}
+	checkContent(t, db, map[string]string{"1": "x", "3": "33"})
+}
+
+// testBatchReplay tests that a batch can be replayed into another writer.
+func testBatchReplay(t *testing.T, New func() ethdb.KeyValueStore) {
+	db := New()
+	defer db.Close()
+
+	if err := db.Put([]byte("1"), []byte("stale")); err != nil {
+		t.Fatalf("failed to insert item: %v", err)
+	}
+	b := db.NewBatch()
+	b.Put([]byte("2"), []byte("22"))
+	b.Put([]byte("1"), []byte("11"))
+	b.Delete([]byte("1"))
+	b.Put([]byte("3"), []byte("33"))
+
+	if err := b.Replay(db); err != nil {
+		t.Fatalf("failed to replay batch: %v", err)
+	}
+	checkContent(t, db, map[string]string{"2": "22", "3": "33"})
+}
+
+// testIteratorMutation tests that the database can be modified while an iterator
+// is open, and that the iterator still yields every key untouched by the writes.
+func testIteratorMutation(t *testing.T, New func() ethdb.KeyValueStore) {
+	db := New()
+	defer db.Close()
+
+	for i := 0; i < 100; i++ {
+		if err := db.Put([]byte(fmt.Sprintf("key-%03d", i)), []byte{byte(i)}); err != nil {
+			t.Fatalf("failed to insert item %d: %v", i, err)
+		}
+	}
+	it := db.NewIterator()
+	defer it.Release()
+
+	// Delete every iterated key and insert unrelated ones behind the iterator
+	var seen int
+	for it.Next() {
+		if want := fmt.Sprintf("key-%03d", seen); string(it.Key()) != want {
+			t.Fatalf("item %d: key mismatch: have %s, want %s", seen, it.Key(), want)
+		}
+		if err := db.Delete(it.Key()); err != nil {
+			t.Fatalf("failed to delete item %d: %v", seen, err)
+		}
+		if err := db.Put([]byte(fmt.Sprintf("a-%03d", seen)), nil); err != nil {
+			t.Fatalf("failed to insert item %d: %v", seen, err)
+		}
+		seen++
+	}
+	if err := it.Error(); err != nil {
+		t.Fatalf("iteration failed: %v", err)
+	}
+	if seen != 100 {
+		t.Fatalf("iterated item count mismatch: have %d, want 100", seen)
+	}
+	if has, _ := db.Has([]byte("key-050")); has {
+		t.Fatalf("deleted item still present")
+	}
+}
+
+// testLargeIteration tests that iterating over many more items than fit into a
+// single internal chunk of the backend returns all of them, in order.
+func testLargeIteration(t *testing.T, New func() ethdb.KeyValueStore) {
+	db := New()
+	defer db.Close()
+
+	var keys []string
+	b := db.NewBatch()
+	for i := 0; i < 5000; i++ {
+		key := fmt.Sprintf("%x", []byte{byte(i >> 8), byte(i)})
+		keys = append(keys, key)
+		b.Put([]byte(key), []byte(key))
+	}
+	if err := b.Write(); err != nil {
+		t.Fatalf("failed to write batch: %v", err)
+	}
+	sort.Strings(keys)
+
+	it, idx := db.NewIterator(), 0
+	defer it.Release()
+	for it.Next() {
+		if idx >= len(keys) || string(it.Key()) != keys[idx] || string(it.Value()) != keys[idx] {
+			t.Fatalf("item %d: mismatch: have %s:%s", idx, it.Key(), it.Value())
+		}
+		idx++
+	}
+	if err := it.Error(); err != nil {
+		t.Fatalf("iteration failed: %v", err)
+	}
+	if idx != len(keys) {
+		t.Fatalf("iterated item count mismatch: have %d, want %d", idx, len(keys))
+	}
+}
+
+// checkContent verifies that the database contains exactly the given items.
+func checkContent(t *testing.T, db ethdb.KeyValueStore, content map[string]string) {
+	t.Helper()
+
+	it := db.NewIterator()
+	defer it.Release()
+
+	found := make(map[string]string)
+	for it.Next() {
+		found[string(it.Key())] = string(it.Value())
+	}
+	if err := it.Error(); err != nil {
+		t.Fatalf("iteration failed: %v", err)
+	}
+	if len(found) != len(content) {
+		t.Fatalf("item count mismatch: have %d, want %d (%v)", len(found), len(content), found)
+	}
+	for key, val := range content {
+		if found[key] != val {
+			t.Fatalf("item %s mismatch: have %q, want %q", key, found[key], val)
+		}
+	}
+}
@@ -79,6 +79,17 @@ type Database struct {
 // New returns a wrapped LevelDB object. The namespace is the prefix that the
 // metrics reporting should use for surfacing internal stats.
 func New(file string, cache int, handles int, namespace string) (*Database, error) {
+	return open(file, cache, handles, namespace, false)
+}
+
+// NewReadOnly returns a wrapped LevelDB object opened in read only mode, failing
+// all writes. Corrupted databases are not recovered, as that would need writing.
+func NewReadOnly(file string, cache int, handles int, namespace string) (*Database, error) {
+	return open(file, cache, handles, namespace, true)
+}
+
+// open opens a wrapped LevelDB object, optionally in read only mode.
+func open(file string, cache int, handles int, namespace string, readonly bool) (*Database, error) {
 	// Ensure we have some minimal caching and file guarantees
 	if cache < minCache {
 		cache = minCache
@@ -95,8 +106,9 @@ func New(file string, cache int, handles int, namespace string) (*Database, erro
 		BlockCacheCapacity:     cache / 2 * opt.MiB,
 		WriteBuffer:            cache / 4 * opt.MiB, // Two of these are used internally
 		Filter:                 filter.NewBloomFilter(10),
+		ReadOnly:               readonly,
 	})
-	if _, corrupted := err.(*errors.ErrCorrupted); corrupted {
+	if _, corrupted := err.(*errors.ErrCorrupted); corrupted && !readonly {
 		db, err = leveldb.RecoverFile(file, nil)
 	}
 	if err != nil {
@@ -418,13 +430,13 @@ func (b *batch) Reset() {
 }
 
 // Replay replays the batch contents.
-func (b *batch) Replay(w ethdb.Writer) error {
+func (b *batch) Replay(w ethdb.KeyValueWriter) error {
 	return b.b.Replay(&replayer{writer: w})
 }
 
 // replayer is a small wrapper to implement the correct replay methods.
 type replayer struct {
-	writer  ethdb.Writer
+	writer  ethdb.KeyValueWriter
 	failure error
 }
 
@@ -696,7 +696,11 @@ func (w *worker) updateSnapshot() {
 func (w *worker) commitTransaction(tx *types.Transaction, coinbase common.Address) ([]*types.Log, error) {
 	snap := w.current.state.Snapshot()
 
-	receipt, _, err := core.ApplyTransaction(w.config, w.chain, &coinbase, w.current.gasPool, w.current.state, w.current.header, tx, &w.current.header.GasUsed, *w.chain.GetVMConfig())
+	// Pending blocks have no hash yet, so their transactions can't be recorded
+	vmConfig := *w.chain.GetVMConfig()
+	vmConfig.TraceSink = nil
+
+	receipt, _, err := core.ApplyTransaction(w.config, w.chain, &coinbase, w.current.gasPool, w.current.state, w.current.header, tx, &w.current.header.GasUsed, vmConfig)
 	if err != nil {
 		w.current.state.RevertToSnapshot(snap)
 		return nil, err
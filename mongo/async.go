@@ -0,0 +1,354 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package mongo
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+const (
+	// writeRetries is the number of times a failing batch write is retried before
+	// the batch is spilled into the journal.
+	writeRetries = 5
+
+	// writeBackoffMin is the delay before the first retry of a failing write. It
+	// is doubled after every subsequent failure, up to writeBackoffMax.
+	writeBackoffMin = 100 * time.Millisecond
+
+	// writeBackoffMax is the maximum delay between two retries of a failing write.
+	writeBackoffMax = 5 * time.Second
+
+	// replayInterval is the time interval to attempt to replay the journal at if
+	// the flush interval is disabled.
+	replayInterval = time.Minute
+)
+
+// errSinkClosed is returned if records are attempted to be put into a sink that
+// was already closed.
+var errSinkClosed = errors.New("trace sink closed")
+
+// BatchWriter is a storage backend persisting batches of trace records.
+type BatchWriter interface {
+	// WriteBatch stores the given records. An error means the backend was
+	// unavailable and that the write should be retried later. The writer must
+	// not retain the records slice.
+	WriteBatch(records []*Transac) error
+
+	// MarkCanonical sets the canonical flag of all the stored records belonging
+	// to the given blocks. An error has the same meaning as for WriteBatch.
+	MarkCanonical(blocks []common.Hash, canonical bool) error
+
+	// Close releases all resources held by the writer.
+	Close() error
+}
+
+// traceOp is a single operation queued for the backend, either a record to be
+// stored or a change of the canonical status of some blocks' records. Queueing
+// both through the same channel keeps status changes ordered after the records
+// they refer to.
+type traceOp struct {
+	Record    *Transac      `json:",omitempty"` // Record to store (nil for status changes)
+	Blocks    []common.Hash `json:",omitempty"` // Hashes of the blocks to change the status of
+	Canonical bool          `json:",omitempty"` // Canonical status to set for the blocks
+}
+
+// applyOps writes a batch of operations into the backend, coalescing runs of
+// records into a single write and runs of status changes into a single mark.
+// As both operations are idempotent, a partially applied batch may be retried.
+func applyOps(writer BatchWriter, ops []traceOp) error {
+	for i := 0; i < len(ops); {
+		j := i + 1
+		if ops[i].Record != nil {
+			for j < len(ops) && ops[j].Record != nil {
+				j++
+			}
+			records := make([]*Transac, 0, j-i)
+			for _, op := range ops[i:j] {
+				records = append(records, op.Record)
+			}
+			if err := writer.WriteBatch(records); err != nil {
+				return err
+			}
+		} else {
+			blocks := append([]common.Hash{}, ops[i].Blocks...)
+			for j < len(ops) && ops[j].Record == nil && ops[j].Canonical == ops[i].Canonical {
+				blocks = append(blocks, ops[j].Blocks...)
+				j++
+			}
+			if err := writer.MarkCanonical(blocks, ops[i].Canonical); err != nil {
+				return err
+			}
+		}
+		i = j
+	}
+	return nil
+}
+
+// AsyncSink is a trace sink handing the records over to a background writer
+// goroutine through a bounded queue, blocking the producers if the writer can't
+// keep up. Failing writes are retried with exponential backoff, after which the
+// records are spilled into an on-disk journal. The journal is replayed when the
+// backend recovers, or when the sink is reopened after a restart.
+type AsyncSink struct {
+	writer    BatchWriter   // Storage backend to write the records into
+	journal   *traceJournal // Spill journal for records the backend failed to store
+	batchSize int           // Number of operations to accumulate before writing
+	interval  time.Duration // Maximum time operations may linger in the batch
+	healthy   bool          // Whether the last write to the backend succeeded
+
+	queue   chan traceOp    // Bounded queue of operations waiting to be written
+	flushCh chan chan error // Channel to request the pending operations to be written
+	quit    chan struct{}   // Channel to signal the writer goroutine to terminate
+	term    chan struct{}   // Channel closed when the writer goroutine terminated
+	once    sync.Once
+
+	closed bool         // Whether the sink was closed, rejecting new operations
+	lock   sync.RWMutex // Lock protecting the closed flag, held by enqueuers while sending
+}
+
+// NewAsyncSink creates an asynchronous trace sink on top of the given writer,
+// replaying any records spilled into the journal by a previous run.
+func NewAsyncSink(writer BatchWriter, config Config) (*AsyncSink, error) {
+	if config.BatchSize < 1 {
+		return nil, fmt.Errorf("invalid trace batch size %d", config.BatchSize)
+	}
+	if config.QueueSize < 0 {
+		return nil, fmt.Errorf("invalid trace queue size %d", config.QueueSize)
+	}
+	sink := &AsyncSink{
+		writer:    writer,
+		batchSize: config.BatchSize,
+		interval:  config.FlushInterval,
+		healthy:   true,
+		queue:     make(chan traceOp, config.QueueSize),
+		flushCh:   make(chan chan error),
+		quit:      make(chan struct{}),
+		term:      make(chan struct{}),
+	}
+
+	if config.Journal != "" {
+		journal, err := newTraceJournal(config.Journal)
+		if err != nil {
+			return nil, err
+		}
+		sink.journal = journal
+		if journal.count > 0 {
+			log.Info("Replaying trace journal", "path", config.Journal, "ops", journal.count)
+			if err := journal.replay(sink.batchSize, sink.apply); err != nil {
+				log.Warn("Failed to replay trace journal", "err", err)
+				sink.healthy = false
+			}
+		}
+	}
+	go sink.loop()
+	return sink, nil
+}
+
+// Put implements TraceSink, queueing the record for the background writer. The
+// method blocks if the queue is full.
+func (s *AsyncSink) Put(tx *Transac) error {
+	return s.enqueue(traceOp{Record: tx})
+}
+
+// MarkCanonical implements TraceSink, queueing the status change for the
+// background writer behind all the records put previously.
+func (s *AsyncSink) MarkCanonical(blocks []common.Hash, canonical bool) error {
+	return s.enqueue(traceOp{Blocks: blocks, Canonical: canonical})
+}
+
+// enqueue hands an operation over to the background writer, blocking if the
+// queue is full. The read lock is held while sending, so that Close can wait for
+// all the operations in flight to be queued before the writer drains the queue.
+func (s *AsyncSink) enqueue(op traceOp) error {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if s.closed {
+		return errSinkClosed
+	}
+	s.queue <- op
+	return nil
+}
+
+// Flush implements TraceSink, blocking until all the records queued so far are
+// either written to the backend or spilled into the journal.
+func (s *AsyncSink) Flush() error {
+	errc := make(chan error, 1)
+	select {
+	case s.flushCh <- errc:
+		return <-errc
+	case <-s.quit:
+		return errSinkClosed
+	}
+}
+
+// Close implements TraceSink, writing out all the queued records, terminating
+// the background writer and closing the backend.
+func (s *AsyncSink) Close() error {
+	s.once.Do(func() {
+		// Reject new operations before terminating the writer, which keeps
+		// consuming the queue until then, unblocking the operations in flight
+		s.lock.Lock()
+		s.closed = true
+		s.lock.Unlock()
+
+		close(s.quit)
+	})
+	<-s.term
+
+	var err error
+	if s.journal != nil {
+		err = s.journal.close()
+	}
+	if cerr := s.writer.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// loop is the background writer goroutine, accumulating the queued operations into
+// batches and writing them into the backend.
+func (s *AsyncSink) loop() {
+	defer close(s.term)
+
+	interval := s.interval
+	if interval <= 0 {
+		interval = replayInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	batch := make([]traceOp, 0, s.batchSize)
+	for {
+		select {
+		case op := <-s.queue:
+			if batch = append(batch, op); len(batch) >= s.batchSize {
+				s.commit(batch)
+				batch = batch[:0]
+			}
+
+		case <-ticker.C:
+			if s.interval > 0 && len(batch) > 0 {
+				s.commit(batch)
+				batch = batch[:0]
+			}
+			s.replay()
+
+		case errc := <-s.flushCh:
+			batch = s.drain(batch)
+			errc <- s.commit(batch)
+			batch = batch[:0]
+
+		case <-s.quit:
+			s.commit(s.drain(batch))
+			return
+		}
+	}
+}
+
+// drain moves all the operations currently waiting in the queue into the batch.
+func (s *AsyncSink) drain(batch []traceOp) []traceOp {
+	for {
+		select {
+		case op := <-s.queue:
+			batch = append(batch, op)
+		default:
+			return batch
+		}
+	}
+}
+
+// commit writes the batch into the backend, retrying with backoff if it fails.
+// If the backend remains unavailable, the batch is spilled into the journal and
+// subsequent batches are spilled directly until a journal replay succeeds.
+func (s *AsyncSink) commit(batch []traceOp) error {
+	if len(batch) == 0 {
+		return nil
+	}
+	// Avoid reordering operations while the journal is still pending replay
+	if s.healthy && s.journal != nil && s.journal.count > 0 {
+		s.replay()
+	}
+	if s.healthy {
+		err := s.apply(batch)
+		for retry := 0; err != nil && retry < writeRetries; retry++ {
+			log.Debug("Failed to write trace records", "ops", len(batch), "retry", retry, "err", err)
+			if !s.wait(retry) {
+				break
+			}
+			err = s.apply(batch)
+		}
+		if err == nil {
+			return nil
+		}
+		log.Warn("Trace backend unavailable, spilling records", "ops", len(batch), "err", err)
+		s.healthy = false
+	}
+	if s.journal == nil {
+		log.Error("Dropped trace records, no journal configured", "ops", len(batch))
+		return errNoActiveJournal
+	}
+	if err := s.journal.insert(batch); err != nil {
+		log.Error("Failed to spill trace records", "ops", len(batch), "err", err)
+		return err
+	}
+	return nil
+}
+
+// apply writes a batch of operations into the backend.
+func (s *AsyncSink) apply(batch []traceOp) error {
+	return applyOps(s.writer, batch)
+}
+
+// replay attempts to write the operations spilled into the journal into the
+// backend, marking the backend healthy again if all of them succeed.
+func (s *AsyncSink) replay() {
+	if s.journal == nil || s.journal.count == 0 {
+		s.healthy = true
+		return
+	}
+	if err := s.journal.replay(s.batchSize, s.apply); err != nil {
+		log.Debug("Failed to replay trace journal", "remaining", s.journal.count, "err", err)
+		s.healthy = false
+		return
+	}
+	s.healthy = true
+}
+
+// wait sleeps before the given retry of a failing write, returning false if the
+// sink is being closed in the meantime.
+func (s *AsyncSink) wait(retry int) bool {
+	delay := writeBackoffMin << uint(retry)
+	if delay > writeBackoffMax {
+		delay = writeBackoffMax
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-s.quit:
+		return false
+	}
+}
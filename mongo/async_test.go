@@ -0,0 +1,344 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package mongo
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// testWriter is a batch writer retaining the written records in memory, which
+// can be switched into failure mode to simulate an unavailable backend.
+type testWriter struct {
+	records []*Transac
+	failing bool
+	lock    sync.Mutex
+}
+
+func (w *testWriter) WriteBatch(records []*Transac) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.failing {
+		return errors.New("backend unavailable")
+	}
+	w.records = append(w.records, records...)
+	return nil
+}
+
+func (w *testWriter) MarkCanonical(blocks []common.Hash, canonical bool) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.failing {
+		return errors.New("backend unavailable")
+	}
+	for _, record := range w.records {
+		for _, block := range blocks {
+			if record.Tx_BlockHash == block {
+				record.Canonical = canonical
+			}
+		}
+	}
+	return nil
+}
+
+func (w *testWriter) Close() error { return nil }
+
+func (w *testWriter) canonical() []common.Hash {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	var hashes []common.Hash
+	for _, record := range w.records {
+		if record.Canonical {
+			hashes = append(hashes, record.Tx_Hash)
+		}
+	}
+	return hashes
+}
+
+func (w *testWriter) hashes() []common.Hash {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	return makeTestHashes(w.records)
+}
+
+func makeTestRecords(from, to int) []*Transac {
+	var records []*Transac
+	for i := from; i < to; i++ {
+		records = append(records, &Transac{
+			Tx_BlockHash: testBlockHash(i / 2),
+			Tx_Hash:      common.BytesToHash([]byte{byte(i)}),
+			Tx_Index:     uint64(i % 2),
+		})
+	}
+	return records
+}
+
+// Tests that records put into the asynchronous sink are written out in order,
+// both in full batches and on explicit flushes.
+func TestAsyncSinkOrdering(t *testing.T) {
+	writer := new(testWriter)
+	sink, err := NewAsyncSink(writer, Config{BatchSize: 4, QueueSize: 2})
+	if err != nil {
+		t.Fatalf("failed to create sink: %v", err)
+	}
+	defer sink.Close()
+
+	records := makeTestRecords(0, 10)
+	for _, record := range records {
+		if err := sink.Put(record); err != nil {
+			t.Fatalf("failed to put record: %v", err)
+		}
+	}
+	if err := sink.Flush(); err != nil {
+		t.Fatalf("failed to flush sink: %v", err)
+	}
+	have, want := writer.hashes(), makeTestHashes(records)
+	if fmt.Sprint(have) != fmt.Sprint(want) {
+		t.Errorf("written records mismatch: have %v, want %v", have, want)
+	}
+}
+
+// Tests that racing puts against closing the sink never loses records: every put
+// either succeeds and gets written, or fails with errSinkClosed.
+func TestAsyncSinkCloseRace(t *testing.T) {
+	for i := 0; i < 50; i++ {
+		writer := new(testWriter)
+		sink, err := NewAsyncSink(writer, Config{BatchSize: 4, QueueSize: 64})
+		if err != nil {
+			t.Fatalf("failed to create sink: %v", err)
+		}
+		var (
+			accepted int32
+			pend     sync.WaitGroup
+			errc     = make(chan error, 4)
+		)
+		for j := 0; j < 4; j++ {
+			pend.Add(1)
+			go func(j int) {
+				defer pend.Done()
+				for _, record := range makeTestRecords(j*64, j*64+64) {
+					if err := sink.Put(record); err != nil {
+						if err != errSinkClosed {
+							errc <- err
+						}
+						return
+					}
+					atomic.AddInt32(&accepted, 1)
+				}
+			}(j)
+		}
+		if err := sink.Close(); err != nil {
+			t.Fatalf("failed to close sink: %v", err)
+		}
+		pend.Wait()
+		close(errc)
+
+		if err := <-errc; err != nil {
+			t.Fatalf("run %d: unexpected put error: %v", i, err)
+		}
+		if have, want := len(writer.hashes()), int(atomic.LoadInt32(&accepted)); have != want {
+			t.Fatalf("run %d: written records mismatch: have %d, want %d", i, have, want)
+		}
+		if err := sink.Put(makeTestRecords(0, 1)[0]); err != errSinkClosed {
+			t.Fatalf("run %d: put after close error mismatch: have %v, want %v", i, err, errSinkClosed)
+		}
+	}
+}
+
+// Tests that records failing to be written are spilled into the journal, which
+// is replayed into the backend when the sink is reopened.
+func TestAsyncSinkJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tracejournal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := Config{BatchSize: 2, QueueSize: 16, Journal: filepath.Join(dir, "traces.journal")}
+
+	// Write a few records into an unavailable backend and ensure they're spilled
+	writer := &testWriter{failing: true}
+	sink, err := NewAsyncSink(writer, config)
+	if err != nil {
+		t.Fatalf("failed to create sink: %v", err)
+	}
+	records := makeTestRecords(0, 5)
+	for _, record := range records {
+		if err := sink.Put(record); err != nil {
+			t.Fatalf("failed to put record: %v", err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("failed to close sink: %v", err)
+	}
+	if len(writer.hashes()) != 0 {
+		t.Fatalf("records written into failing backend: %v", writer.hashes())
+	}
+	// Reopen the sink with a recovered backend and ensure the journal's replayed
+	writer = new(testWriter)
+	if sink, err = NewAsyncSink(writer, config); err != nil {
+		t.Fatalf("failed to reopen sink: %v", err)
+	}
+	more := makeTestRecords(5, 7)
+	for _, record := range more {
+		if err := sink.Put(record); err != nil {
+			t.Fatalf("failed to put record: %v", err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("failed to close sink: %v", err)
+	}
+	have, want := writer.hashes(), makeTestHashes(append(records, more...))
+	if fmt.Sprint(have) != fmt.Sprint(want) {
+		t.Errorf("written records mismatch: have %v, want %v", have, want)
+	}
+	if blob, err := ioutil.ReadFile(config.Journal); err != nil || len(blob) != 0 {
+		t.Errorf("journal not emptied after replay: %q, %v", blob, err)
+	}
+}
+
+// Tests that a journal with a partially written operation at its end, left over
+// by a crash during spilling, is truncated to the last complete operation and
+// the preceding ones are replayed.
+func TestAsyncSinkCorruptJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tracejournal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := Config{BatchSize: 2, QueueSize: 16, Journal: filepath.Join(dir, "traces.journal")}
+
+	// Spill a few records into the journal and append a partial operation
+	writer := &testWriter{failing: true}
+	sink, err := NewAsyncSink(writer, config)
+	if err != nil {
+		t.Fatalf("failed to create sink: %v", err)
+	}
+	records := makeTestRecords(0, 5)
+	for _, record := range records {
+		if err := sink.Put(record); err != nil {
+			t.Fatalf("failed to put record: %v", err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("failed to close sink: %v", err)
+	}
+	journal, err := os.OpenFile(config.Journal, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("failed to open journal: %v", err)
+	}
+	if _, err := journal.WriteString(`{"Record":{"Version":4,"Tx_BlockHash":"0x`); err != nil {
+		t.Fatalf("failed to corrupt journal: %v", err)
+	}
+	journal.Close()
+
+	// Reopen the sink and ensure the complete operations are replayed
+	writer = new(testWriter)
+	if sink, err = NewAsyncSink(writer, config); err != nil {
+		t.Fatalf("failed to reopen sink with corrupt journal: %v", err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("failed to close sink: %v", err)
+	}
+	have, want := writer.hashes(), makeTestHashes(records)
+	if fmt.Sprint(have) != fmt.Sprint(want) {
+		t.Errorf("written records mismatch: have %v, want %v", have, want)
+	}
+	if blob, err := ioutil.ReadFile(config.Journal); err != nil || len(blob) != 0 {
+		t.Errorf("journal not emptied after replay: %q, %v", blob, err)
+	}
+}
+
+// Tests that canonical status changes are applied after the records queued
+// before them, even if they have to be spilled into and replayed from the
+// journal in the meantime.
+func TestAsyncSinkCanonical(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tracejournal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := Config{BatchSize: 3, QueueSize: 16, Journal: filepath.Join(dir, "traces.journal")}
+
+	// Queue records and status changes into an unavailable backend
+	writer := &testWriter{failing: true}
+	sink, err := NewAsyncSink(writer, config)
+	if err != nil {
+		t.Fatalf("failed to create sink: %v", err)
+	}
+	records := makeTestRecords(0, 6)
+	for _, record := range records[:4] {
+		if err := sink.Put(record); err != nil {
+			t.Fatalf("failed to put record: %v", err)
+		}
+	}
+	if err := sink.MarkCanonical([]common.Hash{testBlockHash(0), testBlockHash(1)}, true); err != nil {
+		t.Fatalf("failed to mark blocks canonical: %v", err)
+	}
+	for _, record := range records[4:] {
+		if err := sink.Put(record); err != nil {
+			t.Fatalf("failed to put record: %v", err)
+		}
+	}
+	if err := sink.MarkCanonical([]common.Hash{testBlockHash(1)}, false); err != nil {
+		t.Fatalf("failed to mark block non-canonical: %v", err)
+	}
+	if err := sink.MarkCanonical([]common.Hash{testBlockHash(2)}, true); err != nil {
+		t.Fatalf("failed to mark block canonical: %v", err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("failed to close sink: %v", err)
+	}
+	// Reopen the sink with a recovered backend and check the final status
+	writer = new(testWriter)
+	if sink, err = NewAsyncSink(writer, config); err != nil {
+		t.Fatalf("failed to reopen sink: %v", err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("failed to close sink: %v", err)
+	}
+	have, want := writer.canonical(), makeTestHashes(append(records[:2:2], records[4:]...))
+	if fmt.Sprint(have) != fmt.Sprint(want) {
+		t.Errorf("canonical records mismatch: have %v, want %v", have, want)
+	}
+}
+
+func makeTestHashes(records []*Transac) []common.Hash {
+	hashes := make([]common.Hash, len(records))
+	for i, record := range records {
+		hashes[i] = record.Tx_Hash
+	}
+	return hashes
+}
+
+func testBlockHash(n int) common.Hash {
+	return common.BytesToHash([]byte{0xb0, byte(n)})
+}
@@ -1,29 +1,86 @@
 package mongo
 
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// SchemaVersion is the version of the transaction record layout written by this
+// package. Records without a version are in the legacy string encoded layout,
+// see Migrate. Version 3 added the call frames and the code address of steps,
+// version 4 the optional storage accesses and logs of steps.
+const SchemaVersion = 4
+
 // Databse 1, store the basic transaction metadata
 type Transac struct {
-	// Transaction 
-	Tx_BlockHash string
-	Tx_BlockNum string 
-	Tx_FromAddr string
-	Tx_Gas string
-	Tx_GasPrice string
-	Tx_Hash string 
-	Tx_Input string 
-	Tx_Nonce string
-	Tx_ToAddr string
-	Tx_Index string
-	Tx_Value string
-
-	Tx_Trace string
-
-	Re_contractAddress string
-	Re_CumulativeGasUsed string
-	Re_GasUsed string
-	Re_Status  string
-	Re_FailReason string
+	Version int // Schema version of the record
+
+	// Transaction
+	Tx_BlockHash common.Hash
+	Tx_BlockNum  uint64
+	Tx_FromAddr  common.Address
+	Tx_Gas       uint64
+	Tx_GasPrice  *Decimal
+	Tx_Hash      common.Hash
+	Tx_Input     hexutil.Bytes
+	Tx_Nonce     uint64
+	Tx_ToAddr    *common.Address // nil for contract creations
+	Tx_Index     uint64
+	Tx_Value     *Decimal
+
+	Tx_Trace []TraceStep
+	Tx_Calls []CallFrame
+
+	Re_contractAddress   *common.Address // nil unless a contract was created
+	Re_CumulativeGasUsed uint64
+	Re_GasUsed           uint64
+	Re_Status            uint64
+	Re_FailReason        string
+
+	// Canonical reports whether the record's block is part of the canonical
+	// chain. Records are stored as non-canonical and flipped by the chain.
+	Canonical bool
+}
+
+// TraceStep is a single opcode executed by a transaction.
+type TraceStep struct {
+	PC      uint64         `json:"pc"      bson:"pc"`      // Program counter of the opcode
+	Op      string         `json:"op"      bson:"op"`      // Name of the opcode
+	Depth   int            `json:"depth"   bson:"depth"`   // Call depth, starting at 1 (0 if unknown)
+	Address common.Address `json:"address" bson:"address"` // Address of the code being executed (zero if unknown)
+	Arg     string         `json:"arg"     bson:"arg"`     // Constant produced by the opcode, if any
+
+	Storage *StorageAccess `json:"storage,omitempty" bson:"storage,omitempty"` // Slot accessed by SLOAD and SSTORE, if enabled
+	Log     *LogEntry      `json:"log,omitempty"     bson:"log,omitempty"`     // Log emitted by LOG0-LOG4, if enabled
+}
+
+// StorageAccess is a storage slot read or written by a trace step.
+type StorageAccess struct {
+	Account common.Address `json:"account" bson:"account"` // Owner of the storage, differs from the code address for DELEGATECALL and CALLCODE
+	Slot    common.Hash    `json:"slot"    bson:"slot"`    // Storage slot accessed
+	Old     common.Hash    `json:"old"     bson:"old"`     // Value of the slot before the step
+	New     common.Hash    `json:"new"     bson:"new"`     // Value of the slot after the step (same as Old for reads)
 }
 
-var BashNum int = 50
-var BashTxs = make([]interface{}, BashNum)
-var CurrentNum int = 0
+// LogEntry is a log emitted by a trace step.
+type LogEntry struct {
+	Address common.Address `json:"address" bson:"address"` // Account emitting the log
+	Topics  []common.Hash  `json:"topics"  bson:"topics"`  // Indexed topics of the log
+	Data    hexutil.Bytes  `json:"data"    bson:"data"`    // Unindexed data of the log
+}
+
+// CallFrame is a single message call or contract creation made by a transaction,
+// including the outermost one. Frames are listed in the order they are entered,
+// so the call tree is described by their order and depths.
+type CallFrame struct {
+	Type    string         `json:"type"    bson:"type"`    // CALL, CALLCODE, DELEGATECALL, STATICCALL, CREATE or CREATE2
+	Depth   int            `json:"depth"   bson:"depth"`   // Call depth of the code executed by the frame, starting at 1
+	From    common.Address `json:"from"    bson:"from"`    // Address of the caller
+	To      common.Address `json:"to"      bson:"to"`      // Address of the callee, or of the created contract
+	Value   *Decimal       `json:"value"   bson:"value"`   // Value transferred, nil for DELEGATECALL and STATICCALL
+	Gas     uint64         `json:"gas"     bson:"gas"`     // Gas made available to the frame
+	GasUsed uint64         `json:"gasUsed" bson:"gasUsed"` // Gas consumed by the frame
+	Input   hexutil.Bytes  `json:"input"   bson:"input"`   // Call data, or creation code
+	Output  hexutil.Bytes  `json:"output"  bson:"output"`  // Return data, or deployed code
+	Error   string         `json:"error"   bson:"error"`   // Error the frame failed with, if any
+}
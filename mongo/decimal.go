@@ -0,0 +1,87 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package mongo
+
+import (
+	"fmt"
+	"math/big"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Decimal is an arbitrary precision integer, stored as a decimal128 number in
+// MongoDB so it can be compared and aggregated server side, and marshaled as a
+// decimal string in JSON. Values not fitting into a decimal128 (more than 34
+// digits, which no ether amount does) are stored as decimal strings instead.
+type Decimal big.Int
+
+// NewDecimal creates a decimal holding a copy of x.
+func NewDecimal(x *big.Int) *Decimal {
+	return (*Decimal)(new(big.Int).Set(x))
+}
+
+// ToInt converts d to a big.Int.
+func (d *Decimal) ToInt() *big.Int {
+	return (*big.Int)(d)
+}
+
+// String returns the decimal representation of d.
+func (d *Decimal) String() string {
+	return d.ToInt().String()
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (d Decimal) MarshalText() ([]byte, error) {
+	return []byte((*big.Int)(&d).String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (d *Decimal) UnmarshalText(input []byte) error {
+	if _, ok := d.ToInt().SetString(string(input), 10); !ok {
+		return fmt.Errorf("invalid decimal %q", input)
+	}
+	return nil
+}
+
+// GetBSON implements bson.Getter.
+func (d *Decimal) GetBSON() (interface{}, error) {
+	if d == nil {
+		return nil, nil
+	}
+	if len(d.String()) > 34 {
+		return d.String(), nil
+	}
+	return bson.ParseDecimal128(d.String())
+}
+
+// SetBSON implements bson.Setter, accepting both decimal128 numbers and strings.
+func (d *Decimal) SetBSON(raw bson.Raw) error {
+	var value interface{}
+	if err := raw.Unmarshal(&value); err != nil {
+		return err
+	}
+	switch value := value.(type) {
+	case nil:
+		return bson.SetZero
+	case bson.Decimal128:
+		return d.UnmarshalText([]byte(value.String()))
+	case string:
+		return d.UnmarshalText([]byte(value))
+	default:
+		return fmt.Errorf("invalid decimal type %T", value)
+	}
+}
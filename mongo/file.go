@@ -0,0 +1,84 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package mongo
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// FileSink is a trace sink appending the records as JSON lines to a file.
+type FileSink struct {
+	file   *os.File
+	writer *bufio.Writer
+	enc    *json.Encoder
+
+	lock sync.Mutex
+}
+
+// NewFileSink opens (or creates) the file at path and returns a trace sink
+// appending the records to it.
+func NewFileSink(path string) (*FileSink, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	writer := bufio.NewWriter(file)
+	return &FileSink{
+		file:   file,
+		writer: writer,
+		enc:    json.NewEncoder(writer),
+	}, nil
+}
+
+// Put implements TraceSink, encoding the record as a single line of JSON.
+func (s *FileSink) Put(tx *Transac) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.enc.Encode(tx)
+}
+
+// MarkCanonical implements TraceSink. It's a noop as the file is append-only,
+// so consumers need to resolve the canonical chain themselves.
+func (s *FileSink) MarkCanonical(blocks []common.Hash, canonical bool) error {
+	return nil
+}
+
+// Flush implements TraceSink, writing any buffered lines out to the file.
+func (s *FileSink) Flush() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.writer.Flush()
+}
+
+// Close implements TraceSink, flushing the buffered lines and closing the file.
+func (s *FileSink) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	err := s.writer.Flush()
+	if cerr := s.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,130 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package mongo
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// FilterConfig contains the options selecting the transactions to record. The
+// zero value records every transaction.
+type FilterConfig struct {
+	Allow       []common.Address `toml:",omitempty"` // Record only transactions from or to these accounts (empty = any)
+	Deny        []common.Address `toml:",omitempty"` // Never record transactions from or to these accounts
+	Selectors   []hexutil.Bytes  `toml:",omitempty"` // Record only calls to these 4-byte method selectors (empty = any)
+	CreatesOnly bool             // Record only contract creations
+	FailedOnly  bool             // Record only transactions failing execution
+	FromBlock   uint64           // First block to record transactions of
+	ToBlock     uint64           // Last block to record transactions of (0 = no limit)
+}
+
+// Filter decides which transactions are recorded. Everything but the outcome of
+// the execution is checked upfront by Match, so that transactions filtered out
+// are executed without recording anything. A nil filter matches everything.
+type Filter struct {
+	config    FilterConfig
+	allow     map[common.Address]struct{}
+	deny      map[common.Address]struct{}
+	selectors map[[4]byte]struct{}
+}
+
+// NewFilter creates a transaction filter from the given configuration, or nil
+// if the configuration doesn't filter anything.
+func NewFilter(config FilterConfig) (*Filter, error) {
+	if len(config.Allow) == 0 && len(config.Deny) == 0 && len(config.Selectors) == 0 &&
+		!config.CreatesOnly && !config.FailedOnly && config.FromBlock == 0 && config.ToBlock == 0 {
+		return nil, nil
+	}
+	if config.ToBlock != 0 && config.ToBlock < config.FromBlock {
+		return nil, fmt.Errorf("invalid block range %d-%d", config.FromBlock, config.ToBlock)
+	}
+	f := &Filter{
+		config: config,
+		allow:  make(map[common.Address]struct{}),
+		deny:   make(map[common.Address]struct{}),
+	}
+	for _, addr := range config.Allow {
+		f.allow[addr] = struct{}{}
+	}
+	for _, addr := range config.Deny {
+		f.deny[addr] = struct{}{}
+	}
+	if len(config.Selectors) > 0 {
+		f.selectors = make(map[[4]byte]struct{})
+		for _, selector := range config.Selectors {
+			if len(selector) != 4 {
+				return nil, fmt.Errorf("invalid method selector %s: want 4 bytes", selector)
+			}
+			var id [4]byte
+			copy(id[:], selector)
+			f.selectors[id] = struct{}{}
+		}
+	}
+	return f, nil
+}
+
+// Match reports whether a transaction included in the given block should be
+// recorded, based on everything known before executing it. The recipient is
+// nil for contract creations, which never match a selector.
+func (f *Filter) Match(number uint64, from common.Address, to *common.Address, input []byte) bool {
+	if f == nil {
+		return true
+	}
+	if number < f.config.FromBlock || (f.config.ToBlock != 0 && number > f.config.ToBlock) {
+		return false
+	}
+	if f.config.CreatesOnly && to != nil {
+		return false
+	}
+	if _, ok := f.deny[from]; ok {
+		return false
+	}
+	if to != nil {
+		if _, ok := f.deny[*to]; ok {
+			return false
+		}
+	}
+	if len(f.allow) > 0 {
+		_, ok := f.allow[from]
+		if !ok && to != nil {
+			_, ok = f.allow[*to]
+		}
+		if !ok {
+			return false
+		}
+	}
+	if f.selectors != nil {
+		if to == nil || len(input) < 4 {
+			return false
+		}
+		var id [4]byte
+		copy(id[:], input)
+		if _, ok := f.selectors[id]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// MatchOutcome reports whether a transaction already accepted by Match should be
+// recorded given the outcome of its execution.
+func (f *Filter) MatchOutcome(failed bool) bool {
+	return f == nil || !f.config.FailedOnly || failed
+}
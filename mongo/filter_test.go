@@ -0,0 +1,104 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package mongo
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// Tests that transactions are matched against all the configured criteria.
+func TestFilterMatch(t *testing.T) {
+	var (
+		alice    = common.HexToAddress("0xa11ce")
+		bob      = common.HexToAddress("0xb0b")
+		token    = common.HexToAddress("0x7043")
+		transfer = hexutil.MustDecode("0xa9059cbb")
+		input    = append(hexutil.MustDecode("0xa9059cbb"), make([]byte, 64)...)
+	)
+	tests := []struct {
+		config FilterConfig
+		number uint64
+		from   common.Address
+		to     *common.Address
+		input  []byte
+		match  bool
+	}{
+		// Unfiltered, everything matches
+		{FilterConfig{}, 1, alice, &bob, nil, true},
+		{FilterConfig{}, 1, alice, nil, nil, true},
+
+		// Block range
+		{FilterConfig{FromBlock: 10}, 9, alice, &bob, nil, false},
+		{FilterConfig{FromBlock: 10}, 10, alice, &bob, nil, true},
+		{FilterConfig{FromBlock: 10, ToBlock: 20}, 20, alice, &bob, nil, true},
+		{FilterConfig{FromBlock: 10, ToBlock: 20}, 21, alice, &bob, nil, false},
+
+		// Allow and deny lists, matching either end
+		{FilterConfig{Allow: []common.Address{token}}, 1, alice, &token, nil, true},
+		{FilterConfig{Allow: []common.Address{alice}}, 1, alice, &token, nil, true},
+		{FilterConfig{Allow: []common.Address{token}}, 1, alice, &bob, nil, false},
+		{FilterConfig{Allow: []common.Address{token}}, 1, alice, nil, nil, false},
+		{FilterConfig{Deny: []common.Address{bob}}, 1, alice, &bob, nil, false},
+		{FilterConfig{Deny: []common.Address{bob}}, 1, bob, &alice, nil, false},
+		{FilterConfig{Deny: []common.Address{bob}}, 1, alice, &token, nil, true},
+		{FilterConfig{Allow: []common.Address{token}, Deny: []common.Address{alice}}, 1, alice, &token, nil, false},
+
+		// Method selectors, never matching creations
+		{FilterConfig{Selectors: []hexutil.Bytes{transfer}}, 1, alice, &token, input, true},
+		{FilterConfig{Selectors: []hexutil.Bytes{transfer}}, 1, alice, &token, input[:3], false},
+		{FilterConfig{Selectors: []hexutil.Bytes{transfer}}, 1, alice, &token, []byte{0xde, 0xad, 0xbe, 0xef}, false},
+		{FilterConfig{Selectors: []hexutil.Bytes{transfer}}, 1, alice, nil, input, false},
+
+		// Contract creations only
+		{FilterConfig{CreatesOnly: true}, 1, alice, nil, input, true},
+		{FilterConfig{CreatesOnly: true}, 1, alice, &token, input, false},
+	}
+	for i, tt := range tests {
+		filter, err := NewFilter(tt.config)
+		if err != nil {
+			t.Fatalf("test %d: failed to create filter: %v", i, err)
+		}
+		if match := filter.Match(tt.number, tt.from, tt.to, tt.input); match != tt.match {
+			t.Errorf("test %d: match mismatch: have %v, want %v", i, match, tt.match)
+		}
+	}
+}
+
+// Tests that the execution outcome is only checked for failed-only filters.
+func TestFilterMatchOutcome(t *testing.T) {
+	var unfiltered *Filter
+	if !unfiltered.MatchOutcome(false) || !unfiltered.MatchOutcome(true) {
+		t.Errorf("unfiltered outcome mismatch")
+	}
+	filter, _ := NewFilter(FilterConfig{FailedOnly: true})
+	if filter.MatchOutcome(false) || !filter.MatchOutcome(true) {
+		t.Errorf("failed-only outcome mismatch")
+	}
+}
+
+// Tests that invalid filter configurations are rejected.
+func TestFilterInvalid(t *testing.T) {
+	if _, err := NewFilter(FilterConfig{Selectors: []hexutil.Bytes{{0xa9, 0x05, 0x9c}}}); err == nil {
+		t.Errorf("short selector: expected error")
+	}
+	if _, err := NewFilter(FilterConfig{FromBlock: 10, ToBlock: 5}); err == nil {
+		t.Errorf("inverted block range: expected error")
+	}
+}
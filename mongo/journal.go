@@ -0,0 +1,224 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package mongo
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// errNoActiveJournal is returned if records are attempted to be spilled into
+// the journal, but no such file is currently open.
+var errNoActiveJournal = errors.New("no active journal")
+
+// traceJournal is an append-only log of the trace operations that could not be
+// applied to the backend, with the aim of allowing them to survive backend
+// outages and node restarts.
+type traceJournal struct {
+	path   string   // Filesystem path to store the operations at
+	writer *os.File // Output stream to write new operations into
+	count  int      // Number of operations currently in the journal
+}
+
+// newTraceJournal opens (or creates) the trace journal at the given path.
+func newTraceJournal(path string) (*traceJournal, error) {
+	journal := &traceJournal{path: path}
+	if err := journal.open(); err != nil {
+		return nil, err
+	}
+	return journal, nil
+}
+
+// open counts the operations already contained in the journal and opens it for
+// appending new ones. A corrupt tail left behind by a crash in the middle of an
+// insertion is truncated off, retaining the operations preceding it.
+func (journal *traceJournal) open() error {
+	count := 0
+	valid, err := journal.iterate(func(traceOp) bool { count++; return true })
+	if err != nil {
+		return err
+	}
+	if stat, err := os.Stat(journal.path); err == nil && stat.Size() > valid {
+		log.Warn("Truncating corrupt trace journal", "path", journal.path, "ops", count, "dropped", common.StorageSize(stat.Size()-valid))
+		if err := os.Truncate(journal.path, valid); err != nil {
+			return err
+		}
+	}
+	writer, err := os.OpenFile(journal.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	journal.writer, journal.count = writer, count
+	return nil
+}
+
+// iterate decodes the operations contained in the journal one by one, feeding
+// them into the callback until it returns false. It returns the file offset up
+// to which the journal was successfully decoded, stopping without an error at
+// the first corrupt operation.
+func (journal *traceJournal) iterate(fn func(op traceOp) bool) (int64, error) {
+	// Skip the parsing if the journal file doesn't exist at all
+	input, err := os.Open(journal.path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer input.Close()
+
+	// Operations are encoded one per line, a partial last line or one failing to
+	// decode can only be the remnant of an interrupted insertion
+	var (
+		reader = bufio.NewReader(input)
+		offset int64
+	)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF && len(line) == 0 {
+			return offset, nil
+		}
+		if err != nil && err != io.EOF {
+			return offset, err
+		}
+		var op traceOp
+		if err == io.EOF || json.Unmarshal(line, &op) != nil {
+			log.Warn("Dropping corrupt trace journal tail", "path", journal.path, "offset", offset)
+			return offset, nil
+		}
+		offset += int64(len(line))
+		if !fn(op) {
+			return offset, nil
+		}
+	}
+}
+
+// insert appends the specified operations to the journal, syncing them to disk
+// before returning.
+func (journal *traceJournal) insert(ops []traceOp) error {
+	if journal.writer == nil {
+		return errNoActiveJournal
+	}
+	enc := json.NewEncoder(journal.writer)
+	for _, op := range ops {
+		if err := enc.Encode(op); err != nil {
+			return err
+		}
+		journal.count++
+	}
+	return journal.writer.Sync()
+}
+
+// replay feeds the journaled operations in batches into the write callback. The
+// operations successfully written are removed from the journal, while the ones
+// following the first failing batch are retained for a later attempt.
+func (journal *traceJournal) replay(batchSize int, write func([]traceOp) error) error {
+	if journal.count == 0 {
+		return nil
+	}
+	var (
+		batch   = make([]traceOp, 0, batchSize)
+		failure error
+		written int
+	)
+	_, err := journal.iterate(func(op traceOp) bool {
+		if batch = append(batch, op); len(batch) < batchSize {
+			return true
+		}
+		if failure = write(batch); failure != nil {
+			return false
+		}
+		written, batch = written+len(batch), batch[:0]
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if failure == nil && len(batch) > 0 {
+		if failure = write(batch); failure == nil {
+			written += len(batch)
+		}
+	}
+	if written > 0 {
+		if err := journal.rotate(written); err != nil {
+			return err
+		}
+		log.Info("Replayed trace journal", "ops", written, "remaining", journal.count)
+	}
+	return failure
+}
+
+// rotate regenerates the journal, dropping the given number of leading operations.
+func (journal *traceJournal) rotate(drop int) error {
+	// Close the current journal (if any is open)
+	if journal.writer != nil {
+		if err := journal.writer.Close(); err != nil {
+			return err
+		}
+		journal.writer = nil
+	}
+	// Generate a new journal with the retained operations
+	replacement, err := os.OpenFile(journal.path+".new", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	var (
+		enc     = json.NewEncoder(replacement)
+		skipped int
+		failure error
+	)
+	_, err = journal.iterate(func(op traceOp) bool {
+		if skipped < drop {
+			skipped++
+			return true
+		}
+		failure = enc.Encode(op)
+		return failure == nil
+	})
+	if failure == nil {
+		failure = replacement.Sync()
+	}
+	replacement.Close()
+	if err != nil {
+		return err
+	}
+	if failure != nil {
+		return failure
+	}
+	// Replace the live journal with the newly generated one
+	if err = os.Rename(journal.path+".new", journal.path); err != nil {
+		return err
+	}
+	return journal.open()
+}
+
+// close flushes the journal contents to disk and closes the file.
+func (journal *traceJournal) close() error {
+	var err error
+
+	if journal.writer != nil {
+		err = journal.writer.Close()
+		journal.writer = nil
+	}
+	return err
+}
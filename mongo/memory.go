@@ -0,0 +1,79 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package mongo
+
+import (
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// MemorySink is a trace sink retaining all records in memory. It is mostly
+// useful for tests wishing to assert on the produced records.
+type MemorySink struct {
+	records []*Transac
+	lock    sync.RWMutex
+}
+
+// NewMemorySink creates an empty in-memory trace sink.
+func NewMemorySink() *MemorySink {
+	return new(MemorySink)
+}
+
+// Put implements TraceSink, appending the record to the retained ones.
+func (s *MemorySink) Put(tx *Transac) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.records = append(s.records, tx)
+	return nil
+}
+
+// MarkCanonical implements TraceSink, updating the retained records in place.
+func (s *MemorySink) MarkCanonical(blocks []common.Hash, canonical bool) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, record := range s.records {
+		for _, block := range blocks {
+			if record.Tx_BlockHash == block {
+				record.Canonical = canonical
+			}
+		}
+	}
+	return nil
+}
+
+// Flush implements TraceSink. It's a noop as nothing is buffered.
+func (s *MemorySink) Flush() error { return nil }
+
+// Close implements TraceSink. It's a noop as no resources are held.
+func (s *MemorySink) Close() error { return nil }
+
+// Records returns a copy of all the records put into the sink so far, in
+// insertion order.
+func (s *MemorySink) Records() []*Transac {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	records := make([]*Transac, len(s.records))
+	for i, record := range s.records {
+		cpy := *record
+		records[i] = &cpy
+	}
+	return records
+}
@@ -0,0 +1,238 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package mongo
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/log"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// legacyTransac is the unversioned record layout, with every field encoded as a
+// string and the trace concatenated into "|pc;OP;const" steps.
+type legacyTransac struct {
+	ID bson.ObjectId `bson:"_id,omitempty" json:"-"`
+
+	Tx_BlockHash string
+	Tx_BlockNum  string
+	Tx_FromAddr  string
+	Tx_Gas       string
+	Tx_GasPrice  string
+	Tx_Hash      string
+	Tx_Input     string
+	Tx_Nonce     string
+	Tx_ToAddr    string
+	Tx_Index     string
+	Tx_Value     string
+
+	Tx_Trace string
+
+	Re_contractAddress   string
+	Re_CumulativeGasUsed string
+	Re_GasUsed           string
+	Re_Status            string
+	Re_FailReason        string
+
+	Canonical bool
+}
+
+// MigrationStats contains the outcome of a schema migration.
+type MigrationStats struct {
+	Migrated int // Records converted to the current schema
+	Merged   int // Legacy duplicates dropped in favour of an existing record
+	Failed   int // Records that could not be converted and were left in place
+}
+
+// Migrate converts all the legacy records in the collection to the current
+// schema in place. Duplicates of an already converted transaction are removed,
+// while records failing to convert are dumped into the error log and left
+// untouched. The migration can be interrupted and rerun at any time.
+func (w *MongoWriter) Migrate() (MigrationStats, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	var (
+		stats  MigrationStats
+		legacy legacyTransac
+		start  = time.Now()
+		logged = time.Now()
+	)
+	iter := w.coll.Find(bson.M{"version": bson.M{"$exists": false}}).Batch(1000).Iter()
+	for iter.Next(&legacy) {
+		record, err := legacy.upgrade()
+		if err != nil {
+			stats.Failed++
+			if err := w.dumpLegacy(&legacy, err); err != nil {
+				iter.Close()
+				return stats, err
+			}
+			continue
+		}
+		// Drop the legacy record if the transaction was already converted or
+		// recorded anew, otherwise replace it with the converted one
+		dups, err := w.coll.Find(recordKey(record)).Count()
+		if err == nil {
+			if dups > 0 {
+				err = w.coll.RemoveId(legacy.ID)
+				stats.Merged++
+			} else {
+				err = w.coll.UpdateId(legacy.ID, record)
+				stats.Migrated++
+			}
+		}
+		if err != nil {
+			iter.Close()
+			return stats, err
+		}
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Migrating trace records", "migrated", stats.Migrated, "merged", stats.Merged, "failed", stats.Failed, "elapsed", common.PrettyDuration(time.Since(start)))
+			logged = time.Now()
+		}
+		legacy = legacyTransac{}
+	}
+	if err := iter.Close(); err != nil {
+		return stats, err
+	}
+	// With the duplicates gone, the unique index can be created if it failed before
+	ensureIndex(w.coll)
+	return stats, nil
+}
+
+// dumpLegacy writes a legacy record that failed to convert into the error log.
+func (w *MongoWriter) dumpLegacy(tx *legacyTransac, failure error) error {
+	blob, err := json.Marshal(tx)
+	if err != nil {
+		_, err = fmt.Fprintf(w.errlog, "Legacy;%s;%s\n", tx.Tx_Hash, err)
+		return err
+	}
+	_, err = fmt.Fprintf(w.errlog, "Legacy|%s|%s\n", blob, failure)
+	return err
+}
+
+// upgrade converts a legacy record into the current schema.
+func (tx *legacyTransac) upgrade() (*Transac, error) {
+	record := &Transac{
+		Version:       SchemaVersion,
+		Re_FailReason: tx.Re_FailReason,
+		Canonical:     tx.Canonical,
+	}
+	var err error
+	if record.Tx_BlockHash, err = parseLegacyHash("block hash", tx.Tx_BlockHash); err != nil {
+		return nil, err
+	}
+	if record.Tx_Hash, err = parseLegacyHash("hash", tx.Tx_Hash); err != nil {
+		return nil, err
+	}
+	if !common.IsHexAddress(tx.Tx_FromAddr) {
+		return nil, fmt.Errorf("invalid sender %q", tx.Tx_FromAddr)
+	}
+	record.Tx_FromAddr = common.HexToAddress(tx.Tx_FromAddr)
+
+	// Contract creations were recorded with a "0x0" recipient and non-creations
+	// with a zero contract address
+	if tx.Tx_ToAddr != "0x0" {
+		if !common.IsHexAddress(tx.Tx_ToAddr) {
+			return nil, fmt.Errorf("invalid recipient %q", tx.Tx_ToAddr)
+		}
+		to := common.HexToAddress(tx.Tx_ToAddr)
+		record.Tx_ToAddr = &to
+	}
+	if contract := common.HexToAddress(tx.Re_contractAddress); contract != (common.Address{}) {
+		record.Re_contractAddress = &contract
+	}
+	if record.Tx_Input, err = hexutil.Decode(tx.Tx_Input); err != nil {
+		return nil, fmt.Errorf("invalid input %q: %v", tx.Tx_Input, err)
+	}
+	for _, field := range []struct {
+		name  string
+		value string
+		out   *uint64
+	}{
+		{"block number", tx.Tx_BlockNum, &record.Tx_BlockNum},
+		{"gas", tx.Tx_Gas, &record.Tx_Gas},
+		{"nonce", tx.Tx_Nonce, &record.Tx_Nonce},
+		{"index", tx.Tx_Index, &record.Tx_Index},
+		{"cumulative gas used", tx.Re_CumulativeGasUsed, &record.Re_CumulativeGasUsed},
+		{"gas used", tx.Re_GasUsed, &record.Re_GasUsed},
+		{"status", tx.Re_Status, &record.Re_Status},
+	} {
+		value, ok := math.ParseUint64(field.value)
+		if !ok {
+			return nil, fmt.Errorf("invalid %s %q", field.name, field.value)
+		}
+		*field.out = value
+	}
+	for _, field := range []struct {
+		name  string
+		value string
+		out   **Decimal
+	}{
+		{"gas price", tx.Tx_GasPrice, &record.Tx_GasPrice},
+		{"value", tx.Tx_Value, &record.Tx_Value},
+	} {
+		value, ok := math.ParseBig256(field.value)
+		if !ok {
+			return nil, fmt.Errorf("invalid %s %q", field.name, field.value)
+		}
+		*field.out = (*Decimal)(value)
+	}
+	if record.Tx_Trace, err = parseLegacyTrace(tx.Tx_Trace); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// parseLegacyHash decodes a hex encoded hash of a legacy record.
+func parseLegacyHash(name string, value string) (common.Hash, error) {
+	blob, err := hexutil.Decode(value)
+	if err != nil || len(blob) != common.HashLength {
+		return common.Hash{}, fmt.Errorf("invalid %s %q", name, value)
+	}
+	return common.BytesToHash(blob), nil
+}
+
+// parseLegacyTrace splits a legacy "|pc;OP;const" trace into its steps. The
+// call depth was not recorded, so it's left unknown.
+func parseLegacyTrace(trace string) ([]TraceStep, error) {
+	if trace == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(trace, "|") {
+		return nil, fmt.Errorf("invalid trace prefix %q", trace[:1])
+	}
+	var steps []TraceStep
+	for i, step := range strings.Split(trace[1:], "|") {
+		parts := strings.SplitN(step, ";", 3)
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("invalid trace step %d: %q", i, step)
+		}
+		pc, err := strconv.ParseUint(parts[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trace step %d pc %q", i, parts[0])
+		}
+		steps = append(steps, TraceStep{PC: pc, Op: parts[1], Arg: parts[2]})
+	}
+	return steps, nil
+}
@@ -0,0 +1,157 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package mongo
+
+import (
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Tests that legacy string encoded records are converted into the current
+// schema, both for calls and contract creations.
+func TestLegacyUpgrade(t *testing.T) {
+	call := &legacyTransac{
+		Tx_BlockHash:         "0x8e38b4dbf6b11fcc3b9dee84fb7986e29ca0a02cecd8977c161ff7333329681e",
+		Tx_BlockNum:          "46147",
+		Tx_FromAddr:          "0xA1E4380A3B1f749673E270229993eE55F35663b4",
+		Tx_Gas:               "21000",
+		Tx_GasPrice:          "50000000000000",
+		Tx_Hash:              "0x5c504ed432cb51138bcf09aa5e8a410dd4a1e204ef84bfed1be16dfba1b22060",
+		Tx_Input:             "0xcafe",
+		Tx_Nonce:             "0x0",
+		Tx_ToAddr:            "0x5DF9B87991262F6BA471F09758CDE1c0FC1De734",
+		Tx_Index:             "0x1",
+		Tx_Value:             "31337",
+		Tx_Trace:             "|0;PUSH1;128|2;CALL;success,0x|3;STOP;",
+		Re_contractAddress:   "0x0000000000000000000000000000000000000000",
+		Re_CumulativeGasUsed: "42000",
+		Re_GasUsed:           "21000",
+		Re_Status:            "0x1",
+		Canonical:            true,
+	}
+	record, err := call.upgrade()
+	if err != nil {
+		t.Fatalf("failed to upgrade call: %v", err)
+	}
+	to := common.HexToAddress("0x5DF9B87991262F6BA471F09758CDE1c0FC1De734")
+	want := &Transac{
+		Version:              SchemaVersion,
+		Tx_BlockHash:         common.HexToHash("0x8e38b4dbf6b11fcc3b9dee84fb7986e29ca0a02cecd8977c161ff7333329681e"),
+		Tx_BlockNum:          46147,
+		Tx_FromAddr:          common.HexToAddress("0xA1E4380A3B1f749673E270229993eE55F35663b4"),
+		Tx_Gas:               21000,
+		Tx_GasPrice:          NewDecimal(big.NewInt(50000000000000)),
+		Tx_Hash:              common.HexToHash("0x5c504ed432cb51138bcf09aa5e8a410dd4a1e204ef84bfed1be16dfba1b22060"),
+		Tx_Input:             []byte{0xca, 0xfe},
+		Tx_ToAddr:            &to,
+		Tx_Index:             1,
+		Tx_Value:             NewDecimal(big.NewInt(31337)),
+		Tx_Trace:             []TraceStep{{PC: 0, Op: "PUSH1", Arg: "128"}, {PC: 2, Op: "CALL", Arg: "success,0x"}, {PC: 3, Op: "STOP"}},
+		Re_CumulativeGasUsed: 42000,
+		Re_GasUsed:           21000,
+		Re_Status:            1,
+		Canonical:            true,
+	}
+	if !reflect.DeepEqual(record, want) {
+		t.Errorf("call mismatch:\nhave %+v\nwant %+v", record, want)
+	}
+	// Contract creations have a "0x0" recipient and a set contract address
+	create := *call
+	create.Tx_ToAddr = "0x0"
+	create.Re_contractAddress = "0x5DF9B87991262F6BA471F09758CDE1c0FC1De734"
+
+	if record, err = create.upgrade(); err != nil {
+		t.Fatalf("failed to upgrade creation: %v", err)
+	}
+	if record.Tx_ToAddr != nil {
+		t.Errorf("creation recipient mismatch: have %x, want nil", record.Tx_ToAddr)
+	}
+	if record.Re_contractAddress == nil || *record.Re_contractAddress != to {
+		t.Errorf("creation contract mismatch: have %x, want %x", record.Re_contractAddress, to)
+	}
+	// Malformed fields are rejected instead of being silently zeroed
+	broken := *call
+	broken.Tx_Trace = "|0;PUSH1"
+	if _, err := broken.upgrade(); err == nil {
+		t.Errorf("malformed trace: expected error")
+	}
+	broken = *call
+	broken.Tx_Gas = "lots"
+	if _, err := broken.upgrade(); err == nil {
+		t.Errorf("malformed gas: expected error")
+	}
+}
+
+// Tests that records survive a round trip through the BSON encoding, with the
+// numeric fields stored as numbers.
+func TestRecordBSON(t *testing.T) {
+	to := common.HexToAddress("0xc0de")
+	record := &Transac{
+		Version:      SchemaVersion,
+		Tx_BlockHash: common.HexToHash("0xbb"),
+		Tx_BlockNum:  7,
+		Tx_GasPrice:  NewDecimal(big.NewInt(20000000000)),
+		Tx_Hash:      common.HexToHash("0x01"),
+		Tx_Input:     []byte{0xca, 0xfe},
+		Tx_ToAddr:    &to,
+		Tx_Value:     NewDecimal(new(big.Int).Exp(big.NewInt(10), big.NewInt(24), nil)),
+		Tx_Trace:     []TraceStep{{PC: 0, Op: "PUSH1", Depth: 1, Address: to, Arg: "1"}},
+		Tx_Calls: []CallFrame{{
+			Type:    "STATICCALL",
+			Depth:   2,
+			From:    to,
+			To:      common.HexToAddress("0xbeef"),
+			Gas:     1000,
+			GasUsed: 100,
+			Input:   []byte{0x01},
+			Output:  []byte{0x02},
+			Error:   "execution reverted",
+		}},
+	}
+	blob, err := bson.Marshal(record)
+	if err != nil {
+		t.Fatalf("failed to encode record: %v", err)
+	}
+	var raw bson.M
+	if err := bson.Unmarshal(blob, &raw); err != nil {
+		t.Fatalf("failed to decode raw record: %v", err)
+	}
+	if _, ok := raw["tx_value"].(bson.Decimal128); !ok {
+		t.Errorf("value type mismatch: have %T, want decimal128", raw["tx_value"])
+	}
+	if _, ok := raw["tx_blocknum"].(int64); !ok {
+		t.Errorf("block number type mismatch: have %T, want int64", raw["tx_blocknum"])
+	}
+	if raw["re_contractaddress"] != nil {
+		t.Errorf("contract address mismatch: have %v, want nil", raw["re_contractaddress"])
+	}
+	decoded := new(Transac)
+	if err := bson.Unmarshal(blob, decoded); err != nil {
+		t.Fatalf("failed to decode record: %v", err)
+	}
+	if decoded.Tx_Value.String() != record.Tx_Value.String() || decoded.Tx_GasPrice.String() != record.Tx_GasPrice.String() {
+		t.Errorf("decimal mismatch: have %v/%v, want %v/%v", decoded.Tx_Value, decoded.Tx_GasPrice, record.Tx_Value, record.Tx_GasPrice)
+	}
+	decoded.Tx_Value, decoded.Tx_GasPrice = record.Tx_Value, record.Tx_GasPrice
+	if !reflect.DeepEqual(decoded, record) {
+		t.Errorf("record mismatch:\nhave %+v\nwant %+v", decoded, record)
+	}
+}
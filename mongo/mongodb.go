@@ -1,26 +1,165 @@
 package mongo
 
 import (
-	"bytes"
+	"encoding/json"
+	"fmt"
 	"os"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
 	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
 )
 
-var SessionGlobal *mgo.Session
-var TraceGlobal = bytes.NewBuffer(make([]byte, 0, 10485760))
-var CurrentTx string
-var CurrentBlockNum uint64
-var TxVMErr string
-var ErrorFile *os.File
+// mongoDialTimeout is the time allowed for establishing the initial connection
+// to the MongoDB deployment.
+const mongoDialTimeout = 10 * time.Second
+
+// MongoWriter is a batch writer upserting the records into a MongoDB collection,
+// keyed by their block hash and transaction index. Records rejected by the
+// server are dumped into an error log.
+type MongoWriter struct {
+	session *mgo.Session    // Connection to the MongoDB server
+	coll    *mgo.Collection // Collection the records are inserted into
+	errlog  *os.File        // Log file for records rejected by the server
+
+	lock sync.Mutex
+}
+
+// NewMongoWriter connects to the MongoDB deployment described by the config and
+// creates a batch writer inserting into it.
+func NewMongoWriter(config Config) (*MongoWriter, error) {
+	info, err := mgo.ParseURL(config.URL)
+	if err != nil {
+		return nil, err
+	}
+	info.Timeout = mongoDialTimeout
+	if config.Username != "" {
+		info.Username, info.Password = config.Username, config.Password
+	}
+	if config.AuthSource != "" {
+		info.Source = config.AuthSource
+	}
+	session, err := mgo.DialWithInfo(info)
+	if err != nil {
+		return nil, err
+	}
+	errlog, err := os.OpenFile(config.ErrorLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	coll := session.DB(config.Database).C(config.Collection)
+	ensureIndex(coll)
+
+	return &MongoWriter{
+		session: session,
+		coll:    coll,
+		errlog:  errlog,
+	}, nil
+}
+
+// NewMongoSink connects to the MongoDB deployment described by the config and
+// creates an asynchronous trace sink writing into it.
+func NewMongoSink(config Config) (*AsyncSink, error) {
+	if config.BatchSize < 1 {
+		return nil, fmt.Errorf("invalid trace batch size %d", config.BatchSize)
+	}
+	writer, err := NewMongoWriter(config)
+	if err != nil {
+		return nil, err
+	}
+	sink, err := NewAsyncSink(writer, config)
+	if err != nil {
+		writer.Close()
+		return nil, err
+	}
+	return sink, nil
+}
+
+// WriteBatch implements BatchWriter, bulk upserting the records into the
+// database, replacing any previous record of the same transaction. If the bulk
+// upsert fails while the server is reachable, the records are retried one by
+// one and the ones rejected are dumped into the error log. An error is only
+// returned if the server cannot be reached.
+func (w *MongoWriter) WriteBatch(records []*Transac) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	bulk := w.coll.Bulk()
+	bulk.Unordered()
+	for _, record := range records {
+		bulk.Upsert(recordKey(record), record)
+	}
+	if _, err := bulk.Run(); err == nil {
+		return nil
+	}
+	w.session.Refresh()
+	if err := w.session.Ping(); err != nil {
+		return err
+	}
+	for _, record := range records {
+		if _, err := w.coll.Upsert(recordKey(record), record); err != nil {
+			if err := w.dump(record, err); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// MarkCanonical implements BatchWriter, updating the canonical flag of all the
+// records belonging to the given blocks.
+func (w *MongoWriter) MarkCanonical(blocks []common.Hash, canonical bool) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
 
-func InitMongoDb() {
-	var err error
-    	if SessionGlobal, err = mgo.Dial(""); err != nil {
-        	panic(err)
-   	}
+	selector := bson.M{"tx_blockhash": bson.M{"$in": blocks}}
+	update := bson.M{"$set": bson.M{"canonical": canonical}}
+	_, err := w.coll.UpdateAll(selector, update)
+	return err
+}
+
+// Close implements BatchWriter, disconnecting from the database.
+func (w *MongoWriter) Close() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
 
-	ErrorFile, err = os.OpenFile("db_error.log", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	w.session.Close()
+	return w.errlog.Close()
+}
+
+// dump writes a record that was rejected by the server into the error log.
+func (w *MongoWriter) dump(tx *Transac, failure error) error {
+	blob, err := json.Marshal(tx)
 	if err != nil {
-		panic(err)
+		_, err = fmt.Fprintf(w.errlog, "Transaction;%s;%s\n", tx.Tx_Hash, err)
+		return err
+	}
+	_, err = fmt.Fprintf(w.errlog, "Transaction|%s|%s\n", blob, failure)
+	return err
+}
+
+// ensureIndex indexes the upsert key of the records, tolerating duplicates that
+// were inserted by older versions.
+func ensureIndex(coll *mgo.Collection) {
+	index := mgo.Index{
+		Key:        []string{"tx_blockhash", "tx_index"},
+		Unique:     true,
+		Background: true,
+	}
+	if err := coll.EnsureIndex(index); err != nil {
+		log.Warn("Failed to create unique trace index", "collection", coll.FullName, "err", err)
+		index.Unique = false
+		if err := coll.EnsureIndex(index); err != nil {
+			log.Warn("Failed to create trace index", "collection", coll.FullName, "err", err)
+		}
 	}
 }
+
+// recordKey returns the selector uniquely identifying the record's document.
+func recordKey(tx *Transac) bson.M {
+	return bson.M{"tx_blockhash": tx.Tx_BlockHash, "tx_index": tx.Tx_Index}
+}
@@ -0,0 +1,126 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package mongo
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Recorder accumulates the opcode trace, the call frames and the VM error of a
+// single transaction execution. Every EVM instance owns a separate recorder, so
+// concurrent executions (block import, mining, RPC calls) never interleave
+// their traces.
+//
+// A Recorder is not safe for concurrent use, same as the EVM owning it.
+type Recorder struct {
+	trace  []TraceStep // Opcodes executed so far
+	frames []CallFrame // Call frames entered so far, in entry order
+	open   []int       // Indexes of the frames not yet exited, innermost last
+	err    string      // Error the outermost VM execution failed with
+
+	storage *StorageAccess // Storage access to attach to the next step
+	log     *LogEntry      // Log to attach to the next step
+}
+
+// NewRecorder creates an empty trace recorder.
+func NewRecorder() *Recorder {
+	return new(Recorder)
+}
+
+// CaptureOp appends an executed opcode to the trace, along with the call depth
+// and code address it ran at and the constant produced by it (e.g. the success
+// flag and return data of calls).
+//
+// Any storage access or log captured since the previous step is attached to it.
+func (r *Recorder) CaptureOp(pc uint64, op string, depth int, address common.Address, constant string) {
+	r.trace = append(r.trace, TraceStep{PC: pc, Op: op, Depth: depth, Address: address, Arg: constant, Storage: r.storage, Log: r.log})
+	r.storage, r.log = nil, nil
+}
+
+// CaptureStorage records a storage slot accessed by the step about to be
+// captured. Reads should report the same old and new values.
+func (r *Recorder) CaptureStorage(account common.Address, slot common.Hash, old common.Hash, new common.Hash) {
+	r.storage = &StorageAccess{Account: account, Slot: slot, Old: old, New: new}
+}
+
+// CaptureLog records a log emitted by the step about to be captured.
+func (r *Recorder) CaptureLog(address common.Address, topics []common.Hash, data []byte) {
+	r.log = &LogEntry{Address: address, Topics: topics, Data: common.CopyBytes(data)}
+}
+
+// CaptureEnter opens a new call frame at the given depth. The value is nil for
+// calls that don't transfer any (DELEGATECALL and STATICCALL).
+func (r *Recorder) CaptureEnter(typ string, depth int, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) {
+	frame := CallFrame{
+		Type:  typ,
+		Depth: depth,
+		From:  from,
+		To:    to,
+		Gas:   gas,
+		Input: common.CopyBytes(input),
+	}
+	if value != nil {
+		frame.Value = NewDecimal(value)
+	}
+	r.open = append(r.open, len(r.frames))
+	r.frames = append(r.frames, frame)
+}
+
+// CaptureExit closes the innermost open call frame with its outcome.
+func (r *Recorder) CaptureExit(output []byte, gasUsed uint64, err error) {
+	if len(r.open) == 0 {
+		return
+	}
+	frame := &r.frames[r.open[len(r.open)-1]]
+	r.open = r.open[:len(r.open)-1]
+
+	frame.Output = common.CopyBytes(output)
+	frame.GasUsed = gasUsed
+	if err != nil {
+		frame.Error = err.Error()
+	}
+}
+
+// CaptureError records the error the VM execution terminated with.
+func (r *Recorder) CaptureError(err error) {
+	r.err = err.Error()
+}
+
+// Trace returns the opcode trace recorded so far.
+func (r *Recorder) Trace() []TraceStep {
+	return r.trace
+}
+
+// Calls returns the call frames recorded so far, in the order they were entered.
+func (r *Recorder) Calls() []CallFrame {
+	return r.frames
+}
+
+// Error returns the recorded VM error, or an empty string if the execution
+// didn't fail.
+func (r *Recorder) Error() string {
+	return r.err
+}
+
+// Reset discards everything recorded so far, allowing the recorder to be reused.
+func (r *Recorder) Reset() {
+	r.trace, r.frames, r.open = nil, nil, nil
+	r.storage, r.log = nil, nil
+	r.err = ""
+}
@@ -0,0 +1,99 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package mongo
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// TraceSink is the destination of the per-transaction trace records produced
+// while processing blocks. Implementations must be safe for concurrent use as
+// the chain importer and the miner may both be executing transactions.
+type TraceSink interface {
+	// Put hands a single transaction record over to the sink. The sink is free
+	// to buffer the record internally until the next Flush.
+	Put(tx *Transac) error
+
+	// MarkCanonical sets the canonical flag of all the records belonging to the
+	// given blocks. It must be ordered after all the records put previously.
+	MarkCanonical(blocks []common.Hash, canonical bool) error
+
+	// Flush writes out any records buffered by the sink.
+	Flush() error
+
+	// Close flushes any buffered records and releases all resources held by
+	// the sink. The sink must not be used after it was closed.
+	Close() error
+}
+
+// Config contains the options selecting and configuring the trace sink.
+type Config struct {
+	Sink string // Destination of the trace records (mongo, file, memory or none)
+	File string // JSON-lines file to write the records into for the file sink
+
+	Storage bool // Record the storage slots accessed by SLOAD and SSTORE steps
+	Logs    bool // Record the logs emitted by LOG0-LOG4 steps
+
+	Filter FilterConfig // Selection of the transactions to record
+
+	// MongoDB sink options
+	URL           string        // Connection string of the MongoDB deployment
+	Username      string        // Username to authenticate with (overrides the URL)
+	Password      string        `toml:",omitempty"` // Password to authenticate with (overrides the URL)
+	AuthSource    string        // Database holding the user's credentials (overrides the URL)
+	Database      string        // Database to store the trace records in
+	Collection    string        // Collection to store the trace records in
+	BatchSize     int           // Number of records to accumulate before inserting them
+	FlushInterval time.Duration // Maximum time records may linger before being inserted (0 = no limit)
+	QueueSize     int           // Number of records allowed to wait for the background writer
+	Journal       string        // Journal to spill the records into while the database is unavailable
+	ErrorLog      string        // File to dump the records into that were rejected by the database
+}
+
+// DefaultConfig contains the default trace sink settings.
+var DefaultConfig = Config{
+	Sink:          "none",
+	File:          "traces.jsonl",
+	URL:           "localhost",
+	Database:      "geth",
+	Collection:    "transaction",
+	BatchSize:     50,
+	FlushInterval: 10 * time.Second,
+	QueueSize:     4096,
+	Journal:       "traces.journal",
+	ErrorLog:      "db_error.log",
+}
+
+// New creates the trace sink selected by the given configuration. A nil sink
+// is returned if trace recording is disabled.
+func New(config Config) (TraceSink, error) {
+	switch config.Sink {
+	case "mongo":
+		return NewMongoSink(config)
+	case "file":
+		return NewFileSink(config.File)
+	case "memory":
+		return NewMemorySink(), nil
+	case "", "none":
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown trace sink %q", config.Sink)
+	}
+}
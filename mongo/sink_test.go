@@ -0,0 +1,149 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package mongo
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Tests that the file sink writes one JSON encoded record per line, and that
+// reopening it appends instead of truncating.
+func TestFileSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tracesink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "traces.jsonl")
+	to := common.HexToAddress("0xc0de")
+	records := []*Transac{
+		{
+			Version:     SchemaVersion,
+			Tx_Hash:     common.HexToHash("0x01"),
+			Tx_ToAddr:   &to,
+			Tx_Value:    NewDecimal(big.NewInt(1000000000000000000)),
+			Tx_GasPrice: NewDecimal(big.NewInt(1)),
+			Tx_Input:    []byte{0xca, 0xfe},
+			Tx_Trace:    []TraceStep{{PC: 0, Op: "PUSH1", Depth: 1, Arg: "1"}},
+		},
+		{
+			Version:            SchemaVersion,
+			Tx_Hash:            common.HexToHash("0x02"),
+			Re_contractAddress: &to,
+			Tx_Trace:           []TraceStep{{PC: 0, Op: "STOP", Depth: 1}},
+		},
+		{
+			Version:       SchemaVersion,
+			Tx_Hash:       common.HexToHash("0x03"),
+			Re_FailReason: "out of gas",
+		},
+	}
+	for i := 0; i < len(records); i += 2 {
+		sink, err := New(Config{Sink: "file", File: path})
+		if err != nil {
+			t.Fatalf("failed to open file sink: %v", err)
+		}
+		for j := i; j < i+2 && j < len(records); j++ {
+			if err := sink.Put(records[j]); err != nil {
+				t.Fatalf("failed to put record %d: %v", j, err)
+			}
+		}
+		if err := sink.Close(); err != nil {
+			t.Fatalf("failed to close file sink: %v", err)
+		}
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var stored []*Transac
+	for scanner := bufio.NewScanner(file); scanner.Scan(); {
+		record := new(Transac)
+		if err := json.Unmarshal(scanner.Bytes(), record); err != nil {
+			t.Fatalf("failed to decode line %d: %v", len(stored), err)
+		}
+		stored = append(stored, record)
+	}
+	have, _ := json.Marshal(stored)
+	want, _ := json.Marshal(records)
+	if !bytes.Equal(have, want) {
+		t.Errorf("stored records mismatch:\nhave %s\nwant %s", have, want)
+	}
+}
+
+// Tests the selection of the trace sink based on the configuration.
+func TestNewSink(t *testing.T) {
+	if sink, err := New(Config{Sink: "none"}); sink != nil || err != nil {
+		t.Errorf("disabled sink: have %v/%v, want nil/nil", sink, err)
+	}
+	if sink, err := New(DefaultConfig); sink != nil || err != nil {
+		t.Errorf("default sink: have %v/%v, want nil/nil", sink, err)
+	}
+	if sink, err := New(Config{Sink: "memory"}); err != nil {
+		t.Errorf("memory sink: failed to create: %v", err)
+	} else if _, ok := sink.(*MemorySink); !ok {
+		t.Errorf("memory sink: type mismatch: have %T", sink)
+	}
+	if _, err := New(Config{Sink: "postgres"}); err == nil {
+		t.Errorf("unknown sink: expected error")
+	}
+	if _, err := New(Config{Sink: "mongo", URL: "localhost", BatchSize: 0}); err == nil {
+		t.Errorf("mongo sink: expected error for invalid batch size")
+	}
+}
+
+// Tests that the memory sink flips the canonical flag of the records belonging
+// to the marked blocks only.
+func TestMemorySinkCanonical(t *testing.T) {
+	sink := NewMemorySink()
+	var (
+		blockA = common.HexToHash("0xaa")
+		blockB = common.HexToHash("0xbb")
+	)
+	for _, record := range []*Transac{
+		{Tx_BlockHash: blockA, Tx_Index: 0},
+		{Tx_BlockHash: blockB, Tx_Index: 0},
+		{Tx_BlockHash: blockA, Tx_Index: 1},
+	} {
+		sink.Put(record)
+	}
+	sink.MarkCanonical([]common.Hash{blockA}, true)
+
+	for i, record := range sink.Records() {
+		if want := record.Tx_BlockHash == blockA; record.Canonical != want {
+			t.Errorf("record %d: canonical mismatch: have %v, want %v", i, record.Canonical, want)
+		}
+	}
+	sink.MarkCanonical([]common.Hash{blockA, blockB}, false)
+	for i, record := range sink.Records() {
+		if record.Canonical {
+			t.Errorf("record %d: still canonical", i)
+		}
+	}
+}
@@ -69,6 +69,11 @@ type Config struct {
 	// in memory.
 	DataDir string
 
+	// DBEngine is the backend of the persistent databases, "leveldb" or "bolt". If
+	// empty, existing databases are opened with the backend they were created with
+	// and new ones are created with LevelDB.
+	DBEngine string `toml:",omitempty"`
+
 	// Configuration of peer-to-peer networking.
 	P2P p2p.Config
 
@@ -51,4 +51,9 @@ const (
 	// HelperTrieProcessConfirmations is the number of confirmations before a HelperTrie
 	// is generated
 	HelperTrieProcessConfirmations = 256
+
+	// ImmutabilityThreshold is the number of blocks after which a chain segment is
+	// considered immutable (i.e. soft finality). It is used by the blockchain as the
+	// cutoff for moving old blocks out of the key-value store into the ancient one.
+	ImmutabilityThreshold = 90000
 )
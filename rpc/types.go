@@ -18,10 +18,12 @@ package rpc
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"math"
 	"strings"
 
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
 )
 
@@ -105,3 +107,84 @@ func (bn *BlockNumber) UnmarshalJSON(data []byte) error {
 func (bn BlockNumber) Int64() int64 {
 	return (int64)(bn)
 }
+
+// BlockNumberOrHash selects a block either by number (or tag) or by hash.
+type BlockNumberOrHash struct {
+	BlockNumber      *BlockNumber `json:"blockNumber,omitempty"`
+	BlockHash        *common.Hash `json:"blockHash,omitempty"`
+	RequireCanonical bool         `json:"requireCanonical,omitempty"`
+}
+
+// UnmarshalJSON parses the given JSON fragment into a BlockNumberOrHash. It
+// supports:
+//   - everything accepted by BlockNumber
+//   - a 32 byte block hash as a hex string
+//   - an object with either a "blockNumber" or a "blockHash" field, along with
+//     an optional "requireCanonical" flag for hashes
+func (bnh *BlockNumberOrHash) UnmarshalJSON(data []byte) error {
+	type object BlockNumberOrHash
+	var obj object
+	if err := json.Unmarshal(data, &obj); err == nil {
+		if (obj.BlockNumber == nil) == (obj.BlockHash == nil) {
+			return fmt.Errorf("exactly one of blockNumber and blockHash must be specified")
+		}
+		*bnh = BlockNumberOrHash(obj)
+		return nil
+	}
+	var input string
+	if err := json.Unmarshal(data, &input); err != nil {
+		return err
+	}
+	if len(input) == 2+2*common.HashLength {
+		var hash common.Hash
+		if err := hash.UnmarshalText([]byte(input)); err != nil {
+			return err
+		}
+		*bnh = BlockNumberOrHashWithHash(hash, false)
+		return nil
+	}
+	var number BlockNumber
+	if err := number.UnmarshalJSON(data); err != nil {
+		return err
+	}
+	*bnh = BlockNumberOrHashWithNumber(number)
+	return nil
+}
+
+// Number returns the selected block number, if selected by number.
+func (bnh *BlockNumberOrHash) Number() (BlockNumber, bool) {
+	if bnh.BlockNumber != nil {
+		return *bnh.BlockNumber, true
+	}
+	return BlockNumber(0), false
+}
+
+// Hash returns the selected block hash, if selected by hash.
+func (bnh *BlockNumberOrHash) Hash() (common.Hash, bool) {
+	if bnh.BlockHash != nil {
+		return *bnh.BlockHash, true
+	}
+	return common.Hash{}, false
+}
+
+// String implements fmt.Stringer.
+func (bnh BlockNumberOrHash) String() string {
+	if bnh.BlockHash != nil {
+		return bnh.BlockHash.Hex()
+	}
+	if bnh.BlockNumber != nil {
+		return fmt.Sprintf("#%d", *bnh.BlockNumber)
+	}
+	return "nil"
+}
+
+// BlockNumberOrHashWithNumber selects a block by number or tag.
+func BlockNumberOrHashWithNumber(number BlockNumber) BlockNumberOrHash {
+	return BlockNumberOrHash{BlockNumber: &number}
+}
+
+// BlockNumberOrHashWithHash selects a block by hash, optionally requiring it to
+// be canonical.
+func BlockNumberOrHashWithHash(hash common.Hash, canonical bool) BlockNumberOrHash {
+	return BlockNumberOrHash{BlockHash: &hash, RequireCanonical: canonical}
+}
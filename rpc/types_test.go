@@ -20,6 +20,7 @@ import (
 	"encoding/json"
 	"testing"
 
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/math"
 )
 
@@ -64,3 +65,47 @@ func TestBlockNumberJSONUnmarshal(t *testing.T) {
 		}
 	}
 }
+
+func TestBlockNumberOrHashJSONUnmarshal(t *testing.T) {
+	hash := common.HexToHash("0x0102030405060708091011121314151617181920212223242526272829303132")
+	tests := []struct {
+		input    string
+		mustFail bool
+		expected BlockNumberOrHash
+	}{
+		0:  {`"0x"`, true, BlockNumberOrHash{}},
+		1:  {`"0x0"`, false, BlockNumberOrHashWithNumber(0)},
+		2:  {`"0x12"`, false, BlockNumberOrHashWithNumber(18)},
+		3:  {`"pending"`, false, BlockNumberOrHashWithNumber(PendingBlockNumber)},
+		4:  {`"latest"`, false, BlockNumberOrHashWithNumber(LatestBlockNumber)},
+		5:  {`"earliest"`, false, BlockNumberOrHashWithNumber(EarliestBlockNumber)},
+		6:  {`"` + hash.Hex() + `"`, false, BlockNumberOrHashWithHash(hash, false)},
+		7:  {`"0x01020304050607080910111213141516171819202122232425262728293031zz"`, true, BlockNumberOrHash{}},
+		8:  {`{"blockNumber":"0x1"}`, false, BlockNumberOrHashWithNumber(1)},
+		9:  {`{"blockNumber":"latest"}`, false, BlockNumberOrHashWithNumber(LatestBlockNumber)},
+		10: {`{"blockHash":"` + hash.Hex() + `"}`, false, BlockNumberOrHashWithHash(hash, false)},
+		11: {`{"blockHash":"` + hash.Hex() + `","requireCanonical":true}`, false, BlockNumberOrHashWithHash(hash, true)},
+		12: {`{"blockNumber":"0x1","blockHash":"` + hash.Hex() + `"}`, true, BlockNumberOrHash{}},
+		13: {`{}`, true, BlockNumberOrHash{}},
+		14: {`someString`, true, BlockNumberOrHash{}},
+		15: {`""`, true, BlockNumberOrHash{}},
+	}
+	for i, test := range tests {
+		var bnh BlockNumberOrHash
+		err := json.Unmarshal([]byte(test.input), &bnh)
+		if test.mustFail && err == nil {
+			t.Errorf("Test %d should fail", i)
+			continue
+		}
+		if !test.mustFail && err != nil {
+			t.Errorf("Test %d should pass but got err: %v", i, err)
+			continue
+		}
+		if test.mustFail {
+			continue
+		}
+		if have, want := bnh.String(), test.expected.String(); have != want || bnh.RequireCanonical != test.expected.RequireCanonical {
+			t.Errorf("Test %d got unexpected value, want %s (canonical %v), got %s (canonical %v)", i, want, test.expected.RequireCanonical, have, bnh.RequireCanonical)
+		}
+	}
+}